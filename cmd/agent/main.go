@@ -25,7 +25,39 @@ func main() {
 		logger.Fatalf("Failed to build agent config: %v", err)
 	}
 
-	agent, err := agent.NewAgent(*config, logger, agent.WithCommonMonitor, agent.WithPsMonitor)
+	opts := []agent.Option{agent.WithCommonMonitor, agent.WithPsMonitor}
+	if config.DiskMonitor.Enabled {
+		opts = append(opts, agent.WithDiskMonitor)
+	}
+	if config.NetworkMonitor.Enabled {
+		opts = append(opts, agent.WithNetworkMonitor)
+	}
+	if config.ProcessMonitor.Enabled {
+		opts = append(opts, agent.WithProcessMonitor)
+	}
+	if config.CgroupMonitor.Enabled {
+		opts = append(opts, agent.WithCgroupMonitor)
+	}
+	if config.SystemMonitor {
+		opts = append(opts, agent.WithSystemMonitor)
+	}
+	if config.ExecMonitor.Enabled {
+		opts = append(opts, agent.WithExecMonitor)
+	}
+	if config.HTTPMonitor.Enabled {
+		opts = append(opts, agent.WithHTTPMonitor)
+	}
+	if config.PrometheusMonitor.Enabled {
+		opts = append(opts, agent.WithPrometheusMonitor)
+	}
+	if config.LogMonitor.Enabled {
+		opts = append(opts, agent.WithLogMonitor)
+	}
+	if config.PushReceiver.Enabled {
+		opts = append(opts, agent.WithPushReceiver)
+	}
+
+	agent, err := agent.NewAgent(*config, logger, opts...)
 	if err != nil {
 		logger.Fatalf("Failed to create agent: %v", err)
 	}
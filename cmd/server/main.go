@@ -1,6 +1,8 @@
 package main
 
 import (
+	"os"
+
 	"github.com/fuzzy-toozy/metrics-service/internal/log"
 	"github.com/fuzzy-toozy/metrics-service/internal/server"
 )
@@ -20,6 +22,15 @@ func main() {
 	logger.Infof("    Date: %v", buildDate)
 	logger.Infof("    Commit: %v", buildCommit)
 
+	if len(os.Args) > 1 && os.Args[1] == server.MigrateCommand {
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+		if err := server.RunMigrate(logger); err != nil {
+			logger.Errorf("Migration failed: %v", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	server, err := server.NewServer(logger)
 
 	if err != nil {
@@ -6,12 +6,17 @@ require (
 	github.com/beevik/guid v1.0.0
 	github.com/caarlos0/env v3.5.0+incompatible
 	github.com/go-chi/chi v1.5.5
+	github.com/golang/snappy v0.0.4
 	github.com/jackc/pgx v3.6.2+incompatible
+	github.com/julz/importas v0.1.0
 	github.com/shirou/gopsutil v3.21.11+incompatible
 	github.com/stretchr/testify v1.8.4
+	github.com/tommy-muehle/go-mnd/v2 v2.5.1
+	go.etcd.io/bbolt v1.3.9
 	go.uber.org/zap v1.26.0
 	golang.org/x/sync v0.7.0
 	golang.org/x/tools v0.20.0
+	google.golang.org/protobuf v1.33.0
 	honnef.co/go/tools v0.4.7
 )
 
@@ -22,7 +27,6 @@ require (
 	github.com/go-ole/go-ole v1.2.6 // indirect
 	github.com/gofrs/uuid v4.4.0+incompatible // indirect
 	github.com/jackc/fake v0.0.0-20150926172116-812a484cc733 // indirect
-	github.com/julz/importas v0.1.0 // indirect
 	github.com/kyoh86/nolint v0.0.1 // indirect
 	github.com/lib/pq v1.10.9 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
@@ -30,7 +34,6 @@ require (
 	github.com/shopspring/decimal v1.3.1 // indirect
 	github.com/tklauser/go-sysconf v0.3.12 // indirect
 	github.com/tklauser/numcpus v0.6.1 // indirect
-	github.com/tommy-muehle/go-mnd/v2 v2.5.1 // indirect
 	github.com/yusufpapurcu/wmi v1.2.3 // indirect
 	go.uber.org/multierr v1.11.0 // indirect
 	golang.org/x/crypto v0.14.0 // indirect
@@ -7,6 +7,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -16,32 +17,144 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/beevik/guid"
+	"github.com/fuzzy-toozy/metrics-service/internal/agent/buffer"
 	"github.com/fuzzy-toozy/metrics-service/internal/agent/config"
 	monitorHttp "github.com/fuzzy-toozy/metrics-service/internal/agent/http"
 	"github.com/fuzzy-toozy/metrics-service/internal/agent/monitor"
 	"github.com/fuzzy-toozy/metrics-service/internal/agent/monitor/storage"
+	"github.com/fuzzy-toozy/metrics-service/internal/agent/push"
 	"github.com/fuzzy-toozy/metrics-service/internal/common"
 	"github.com/fuzzy-toozy/metrics-service/internal/compression"
 	"github.com/fuzzy-toozy/metrics-service/internal/encryption"
 	"github.com/fuzzy-toozy/metrics-service/internal/log"
+	"github.com/fuzzy-toozy/metrics-service/internal/metrics"
 )
 
+// registeredMonitor monitor configured for agent.
+type registeredMonitor struct {
+	monitor.Monitor
+	// accumulate monitor reports counters as increments since previous gather,
+	// so they're summed over all gathers between reports.
+	// Other monitors are reported with metrics of their last gather.
+	accumulate bool
+}
+
 type Agent struct {
-	monitors []monitor.Monitor
+	monitors []registeredMonitor
 	log      log.Logger
-	config   config.Config
+	buffer   *buffer.DiskQueue
+	push     *push.Server
+	// optErr errors of options failed to configure agent.
+	optErr error
+	config config.Config
+}
+
+// Option configures agent created by NewAgent.
+type Option func(agent *Agent)
+
+func (a *Agent) addMonitor(m monitor.Monitor) {
+	a.monitors = append(a.monitors, registeredMonitor{Monitor: m})
 }
 
-type configOption func(agent *Agent)
+func (a *Agent) addAccumulatingMonitor(m monitor.Monitor) {
+	a.monitors = append(a.monitors, registeredMonitor{Monitor: m, accumulate: true})
+}
 
 // WithPsMonitor option to create agent with PsMontior
 func WithPsMonitor(a *Agent) {
-	a.monitors = append(a.monitors, monitor.NewPsMonitor(storage.NewCommonMetricsStorage(), a.log))
+	a.addMonitor(monitor.NewPsMonitor(storage.NewCommonMetricsStorage(), a.log))
 }
 
 // WithCommonMonitor option to create agent with CommonMonitor
 func WithCommonMonitor(a *Agent) {
-	a.monitors = append(a.monitors, monitor.NewMetricsMonitor(storage.NewCommonMetricsStorage(), a.log))
+	a.addMonitor(monitor.NewMetricsMonitor(storage.NewCommonMetricsStorage(), a.log))
+}
+
+// WithDiskMonitor option to create agent with DiskMonitor configured by agent's config.
+func WithDiskMonitor(a *Agent) {
+	a.addAccumulatingMonitor(monitor.NewDiskMonitor(storage.NewCommonMetricsStorage(), a.log,
+		monitor.PsDiskStatsSource{}, a.config.DiskMonitor))
+}
+
+// WithNetworkMonitor option to create agent with NetworkMonitor configured by agent's config.
+func WithNetworkMonitor(a *Agent) {
+	a.addAccumulatingMonitor(monitor.NewNetworkMonitor(storage.NewCommonMetricsStorage(), a.log,
+		a.config.ProcRoot, a.config.NetworkMonitor))
+}
+
+// WithProcessMonitor option to create agent with ProcessMonitor configured by agent's config.
+func WithProcessMonitor(a *Agent) {
+	m, err := monitor.NewProcessMonitor(storage.NewCommonMetricsStorage(), a.log, a.config.ProcRoot, a.config.ProcessMonitor)
+	if err != nil {
+		a.optErr = errors.Join(a.optErr, fmt.Errorf("failed to create process monitor: %w", err))
+		return
+	}
+	a.addAccumulatingMonitor(m)
+}
+
+// WithCgroupMonitor option to create agent with CgroupMonitor configured by agent's config.
+func WithCgroupMonitor(a *Agent) {
+	a.addAccumulatingMonitor(monitor.NewCgroupMonitor(storage.NewCommonMetricsStorage(), a.log,
+		a.config.ProcRoot, a.config.CgroupMonitor))
+}
+
+// WithSystemMonitor option to create agent with SystemMonitor.
+func WithSystemMonitor(a *Agent) {
+	a.addAccumulatingMonitor(monitor.NewSystemMonitor(storage.NewCommonMetricsStorage(), a.log, a.config.ProcRoot))
+}
+
+// WithExecMonitor option to create agent with ExecMonitor configured by agent's config.
+func WithExecMonitor(a *Agent) {
+	m, err := monitor.NewExecMonitor(storage.NewCommonMetricsStorage(), a.log, a.config.ExecMonitor)
+	if err != nil {
+		a.optErr = errors.Join(a.optErr, fmt.Errorf("failed to create exec monitor: %w", err))
+		return
+	}
+	a.addAccumulatingMonitor(m)
+}
+
+// WithHTTPMonitor option to create agent with HTTPMonitor configured by agent's config.
+func WithHTTPMonitor(a *Agent) {
+	m, err := monitor.NewHTTPMonitor(storage.NewCommonMetricsStorage(), a.log, a.config.HTTPMonitor)
+	if err != nil {
+		a.optErr = errors.Join(a.optErr, fmt.Errorf("failed to create HTTP monitor: %w", err))
+		return
+	}
+	a.addAccumulatingMonitor(m)
+}
+
+// WithPrometheusMonitor option to create agent with PrometheusMonitor configured by agent's config.
+func WithPrometheusMonitor(a *Agent) {
+	m, err := monitor.NewPrometheusMonitor(storage.NewCommonMetricsStorage(), a.log, a.config.PrometheusMonitor)
+	if err != nil {
+		a.optErr = errors.Join(a.optErr, fmt.Errorf("failed to create prometheus monitor: %w", err))
+		return
+	}
+	a.addAccumulatingMonitor(m)
+}
+
+// WithLogMonitor option to create agent with LogMonitor configured by agent's config.
+func WithLogMonitor(a *Agent) {
+	m, err := monitor.NewLogMonitor(storage.NewCommonMetricsStorage(), a.log, a.config.LogMonitor)
+	if err != nil {
+		a.optErr = errors.Join(a.optErr, fmt.Errorf("failed to create log monitor: %w", err))
+		return
+	}
+	a.addAccumulatingMonitor(m)
+}
+
+// WithPushReceiver option to create agent receiving metrics pushed by local applications configured by agent's config.
+// Pushed metrics are aggregated and reported as gathered by monitor.
+func WithPushReceiver(a *Agent) {
+	agg := push.NewAggregator(storage.NewCommonMetricsStorage())
+	srv, err := push.Listen(a.config.PushReceiver, agg, a.log)
+	if err != nil {
+		a.optErr = errors.Join(a.optErr, fmt.Errorf("failed to create push receiver: %w", err))
+		return
+	}
+	a.push = srv
+	a.addAccumulatingMonitor(agg)
 }
 
 type buffers struct {
@@ -66,9 +179,11 @@ const (
 type reportData struct {
 	data  json.Marshaler
 	dType dataType
+	// requestID unique ID of report, retries of report are sent with the same ID.
+	requestID string
 }
 
-func NewAgent(config config.Config, logger log.Logger, opts ...configOption) (*Agent, error) {
+func NewAgent(config config.Config, logger log.Logger, opts ...Option) (*Agent, error) {
 	a := Agent{config: config,
 		log: logger}
 
@@ -76,10 +191,23 @@ func NewAgent(config config.Config, logger log.Logger, opts ...configOption) (*A
 		opt(&a)
 	}
 
+	if a.optErr != nil {
+		return nil, a.optErr
+	}
+
 	if len(a.monitors) == 0 {
 		return nil, fmt.Errorf("can't create agent without monitors")
 	}
 
+	if len(config.BufferDir) > 0 {
+		queue, err := buffer.NewDiskQueue(config.BufferDir, int(config.BufferMaxBatches))
+		if err != nil {
+			return nil, fmt.Errorf("failed to open reports buffer: %w", err)
+		}
+		a.buffer = queue
+		a.addMonitor(&bufferMonitor{queue: queue, storage: storage.NewCommonMetricsStorage()})
+	}
+
 	return &a, nil
 }
 
@@ -178,6 +306,11 @@ func (w *worker) reportDataJSON(data reportData) error {
 		req.Header.Set("HashSHA256", sigHash)
 	}
 
+	if len(data.requestID) != 0 {
+		req.Header.Set(common.RequestIDHeader, data.requestID)
+		req.Header.Set(common.AgentIDHeader, w.config.AgentID)
+	}
+
 	resp, err := w.httpClient.Send(req)
 
 	if resp != nil {
@@ -192,7 +325,9 @@ func (w *worker) reportDataJSON(data reportData) error {
 			}
 		}()
 
-		if resp.StatusCode != http.StatusOK {
+		if isRetryableStatus(resp.StatusCode) {
+			err = fmt.Errorf("%w: failed to send metrics. Status code: %v", errServerUnavailable, resp.StatusCode)
+		} else if resp.StatusCode != http.StatusOK {
 			err = fmt.Errorf("failed to send metrics. Status code: %v", resp.StatusCode)
 		}
 	}
@@ -200,9 +335,7 @@ func (w *worker) reportDataJSON(data reportData) error {
 	return err
 }
 
-func (a *Agent) reportMetrics(ctx context.Context, mstorage storage.MetricsStorage, gatherChan chan<- reportData) {
-	allMetrics := mstorage.GetAllMetrics()
-
+func (a *Agent) reportMetrics(ctx context.Context, allMetrics storage.StorageMetrics, gatherChan chan<- reportData) {
 	if len(allMetrics) == 0 {
 		return
 	}
@@ -210,6 +343,7 @@ func (a *Agent) reportMetrics(ctx context.Context, mstorage storage.MetricsStora
 	rData := reportData{}
 	rData.data = allMetrics
 	rData.dType = tBULK
+	rData.requestID = guid.NewString()
 
 	select {
 	case gatherChan <- rData:
@@ -218,7 +352,7 @@ func (a *Agent) reportMetrics(ctx context.Context, mstorage storage.MetricsStora
 	}
 
 	for _, m := range allMetrics {
-		rData := reportData{dType: tSINGLE, data: storage.StorageMetric(m)}
+		rData := reportData{dType: tSINGLE, data: storage.StorageMetric(m), requestID: guid.NewString()}
 		select {
 		case gatherChan <- rData:
 		case <-ctx.Done():
@@ -227,6 +361,39 @@ func (a *Agent) reportMetrics(ctx context.Context, mstorage storage.MetricsStora
 	}
 }
 
+// pendingMetrics metrics of accumulating monitor gathered since last report.
+type pendingMetrics struct {
+	gathered storage.StorageMetrics
+	// gauges reported last time, they're reported again if nothing is gathered till next report.
+	gauges storage.StorageMetrics
+	polled bool
+}
+
+// add merges metrics of gather, counters are summed and gauges are replaced.
+func (p *pendingMetrics) add(gathered storage.StorageMetrics) {
+	p.gathered = buffer.Coalesce(p.gathered, gathered)
+	p.polled = true
+}
+
+// take returns metrics to report and starts accumulating anew.
+func (p *pendingMetrics) take() storage.StorageMetrics {
+	if !p.polled {
+		return p.gauges
+	}
+
+	res := p.gathered
+	p.gauges = make(storage.StorageMetrics, 0, len(res))
+	for _, m := range res {
+		if m.MType == metrics.GaugeMetricType {
+			p.gauges = append(p.gauges, m)
+		}
+	}
+	p.gathered = nil
+	p.polled = false
+
+	return res
+}
+
 // Run starts agent's metric gathering with all configured monitors.
 // Also starts report thread to send gathererd data to server.
 func (a *Agent) Run() {
@@ -253,6 +420,7 @@ func (a *Agent) Run() {
 		go func() {
 			defer wg.Done()
 			reportTicker := time.NewTicker(a.config.ReportInterval.D)
+			pending := pendingMetrics{}
 			for {
 				select {
 				case <-time.After(a.config.PollInterval.D):
@@ -260,8 +428,15 @@ func (a *Agent) Run() {
 					if err != nil {
 						a.log.Warnf("Failed to gather app metrics. %v", err)
 					}
+					if currentMonitor.accumulate {
+						pending.add(currentMonitor.GetMetricsStorage().GetAllMetrics())
+					}
 				case <-reportTicker.C:
-					a.reportMetrics(ctx, currentMonitor.GetMetricsStorage(), gatherChan)
+					if currentMonitor.accumulate {
+						a.reportMetrics(ctx, pending.take(), gatherChan)
+					} else {
+						a.reportMetrics(ctx, currentMonitor.GetMetricsStorage().GetAllMetrics(), gatherChan)
+					}
 				case <-ctx.Done():
 					a.log.Infof("App metrics monitor worker exited. Reason: %v", ctx.Err())
 					return
@@ -282,12 +457,7 @@ func (a *Agent) Run() {
 			for {
 				select {
 				case data := <-gatherChan:
-					err := retryExecutor.RetryOnError(func() error {
-						return w.reportDataJSON(data)
-					})
-					if err != nil {
-						a.log.Errorf("failed to report metrics: %v", err)
-					}
+					a.sendReport(w, retryExecutor, data)
 				case <-ctx.Done():
 					a.log.Infof("Sender worker %v exited. Reason: %v", i, ctx.Err())
 					return
@@ -296,5 +466,32 @@ func (a *Agent) Run() {
 		}()
 	}
 
+	if a.push != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.push.Serve(ctx)
+		}()
+	}
+
+	if a.buffer != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := newWorker(&a.config, a.log, monitorHttp.NewDefaultHTTPClient())
+			replayTicker := time.NewTicker(a.config.ReportInterval.D)
+			defer replayTicker.Stop()
+			for {
+				a.replayBuffered(ctx, w)
+				select {
+				case <-replayTicker.C:
+				case <-ctx.Done():
+					a.log.Infof("Buffer replay worker exited. Reason: %v", ctx.Err())
+					return
+				}
+			}
+		}()
+	}
+
 	wg.Wait()
 }
@@ -0,0 +1,26 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/fuzzy-toozy/metrics-service/internal/agent/monitor/storage"
+	"github.com/fuzzy-toozy/metrics-service/internal/metrics"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_PendingMetrics(t *testing.T) {
+	p := pendingMetrics{}
+	require.Empty(t, p.take())
+
+	// Counters of all gathers are summed, gauges have values of the last one.
+	p.add(storage.StorageMetrics{metrics.NewCounterMetric("c", 1), metrics.NewGaugeMetric("g", 1)})
+	p.add(storage.StorageMetrics{metrics.NewCounterMetric("c", 2), metrics.NewGaugeMetric("g", 2)})
+	require.Equal(t, storage.StorageMetrics{metrics.NewCounterMetric("c", 3), metrics.NewGaugeMetric("g", 2)}, p.take())
+
+	// Without gathers since last report only gauges are reported again.
+	require.Equal(t, storage.StorageMetrics{metrics.NewGaugeMetric("g", 2)}, p.take())
+
+	p.add(storage.StorageMetrics{metrics.NewCounterMetric("c", 4)})
+	require.Equal(t, storage.StorageMetrics{metrics.NewCounterMetric("c", 4)}, p.take())
+	require.Empty(t, p.take())
+}
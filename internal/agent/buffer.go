@@ -0,0 +1,128 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/fuzzy-toozy/metrics-service/internal/agent/buffer"
+	"github.com/fuzzy-toozy/metrics-service/internal/agent/monitor/storage"
+	"github.com/fuzzy-toozy/metrics-service/internal/common"
+	"github.com/fuzzy-toozy/metrics-service/internal/metrics"
+)
+
+// Buffer self-metrics names.
+const (
+	BufferedBatchesMetricName = "AgentBufferedBatches"
+	DroppedBatchesMetricName  = "AgentDroppedBatches"
+)
+
+// errServerUnavailable report wasn't accepted by server, but can be sent later.
+var errServerUnavailable = errors.New("server unavailable")
+
+func isRetryableStatus(code int) bool {
+	return code >= http.StatusInternalServerError ||
+		code == http.StatusTooManyRequests ||
+		code == http.StatusConflict
+}
+
+// canResend checks if report failed with err can be sent later.
+func canResend(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, errServerUnavailable) ||
+		errors.Is(err, context.Canceled) ||
+		errors.As(err, &netErr)
+}
+
+// bufferMonitor reports buffer queue depth and amount of dropped batches.
+type bufferMonitor struct {
+	queue   *buffer.DiskQueue
+	storage storage.MetricsStorage
+}
+
+func (m *bufferMonitor) GatherMetrics() error {
+	m.storage.Clear()
+
+	err := m.storage.AddOrUpdate(metrics.NewGaugeMetric(BufferedBatchesMetricName, float64(m.queue.Len())))
+	if err != nil {
+		return err
+	}
+
+	return m.storage.AddOrUpdate(metrics.NewGaugeMetric(DroppedBatchesMetricName, float64(m.queue.Dropped())))
+}
+
+func (m *bufferMonitor) GetMetricsStorage() storage.MetricsStorage {
+	return m.storage
+}
+
+// sendReport sends report to server. If buffering is enabled bulk reports server failed to receive are buffered.
+// While buffer isn't empty new bulk reports are buffered without sending, so they're replayed in order.
+// Single metric reports repeat metrics of bulk report they're sent with, so they're never buffered:
+// the bulk report delivers their metrics once server is available.
+func (a *Agent) sendReport(w *worker, retryExecutor common.RetryExecutor, data reportData) {
+	batch, ok := data.data.(storage.StorageMetrics)
+	if a.buffer == nil || data.dType != tBULK || !ok {
+		err := retryExecutor.RetryOnError(func() error {
+			return w.reportDataJSON(data)
+		})
+		if err != nil {
+			a.log.Errorf("failed to report metrics: %v", err)
+		}
+		return
+	}
+
+	if a.buffer.Len() == 0 {
+		err := retryExecutor.RetryOnError(func() error {
+			return w.reportDataJSON(data)
+		})
+		if err == nil {
+			return
+		}
+
+		if !canResend(err) {
+			a.log.Errorf("failed to report metrics: %v", err)
+			return
+		}
+		a.log.Warnf("Failed to report metrics, buffering report: %v", err)
+	}
+
+	if err := a.buffer.Push(buffer.Batch{RequestID: data.requestID, Metrics: batch}); err != nil {
+		a.log.Errorf("Failed to buffer report: %v", err)
+	}
+}
+
+// replayBuffered sends buffered reports oldest first until buffer is empty or server is unavailable.
+// Reports rejected by server are dropped.
+func (a *Agent) replayBuffered(ctx context.Context, w *worker) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		batch, seq, ok := a.buffer.Peek()
+		if !ok {
+			return
+		}
+
+		err := w.reportDataJSON(reportData{
+			data:      storage.StorageMetrics(batch.Metrics),
+			dType:     tBULK,
+			requestID: batch.RequestID,
+		})
+		if err != nil {
+			if canResend(err) {
+				a.log.Debugf("Failed to replay buffered report: %v", err)
+				return
+			}
+			a.log.Errorf("Buffered report rejected: %v", err)
+		}
+
+		if err = a.buffer.Remove(seq); err != nil {
+			a.log.Errorf("Failed to remove replayed report: %v", err)
+			return
+		}
+	}
+}
@@ -0,0 +1,286 @@
+// Package buffer On-disk buffer of metric batches agent failed to report.
+package buffer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/beevik/guid"
+	"github.com/fuzzy-toozy/metrics-service/internal/metrics"
+)
+
+const batchFileExt = ".batch"
+
+// Batch metrics reported in single request.
+type Batch struct {
+	// RequestID ID of report request, replays of batch are sent with the same ID.
+	RequestID string           `json:"request_id"`
+	Metrics   []metrics.Metric `json:"metrics"`
+	// Attempted batch was sent at least once and might be applied by server already,
+	// so it's never merged with other batches.
+	Attempted bool `json:"attempted,omitempty"`
+}
+
+// DiskQueue bounded persistent FIFO queue of unsent batches.
+// Each batch is stored in a separate file named by its sequence number,
+// so buffered batches survive agent restarts.
+// When queue is full the oldest batch which wasn't sent yet is merged into the next one,
+// so counter totals aren't lost.
+type DiskQueue struct {
+	dir        string
+	seqs       []uint64
+	attempted  map[uint64]struct{}
+	next       uint64
+	dropped    uint64
+	inflight   uint64
+	isInflight bool
+	maxBatches int
+	lock       sync.Mutex
+}
+
+// NewDiskQueue opens queue in directory dir keeping up to maxBatches batches (at least two),
+// the directory is created if needed. Batches left from previous runs are picked up in order.
+func NewDiskQueue(dir string, maxBatches int) (*DiskQueue, error) {
+	const minBatches = 2
+	if maxBatches < minBatches {
+		return nil, fmt.Errorf("queue must hold at least %v batches, got %v", minBatches, maxBatches)
+	}
+
+	const perms = 0755
+	if err := os.MkdirAll(dir, perms); err != nil {
+		return nil, fmt.Errorf("failed to create buffer dir: %w", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read buffer dir: %w", err)
+	}
+
+	q := DiskQueue{dir: dir, maxBatches: maxBatches, attempted: make(map[uint64]struct{})}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, batchFileExt) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, batchFileExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		q.seqs = append(q.seqs, seq)
+	}
+
+	sort.Slice(q.seqs, func(i, j int) bool { return q.seqs[i] < q.seqs[j] })
+	if len(q.seqs) > 0 {
+		q.next = q.seqs[len(q.seqs)-1] + 1
+	}
+
+	return &q, nil
+}
+
+func (q *DiskQueue) batchPath(seq uint64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%020d%v", seq, batchFileExt))
+}
+
+func (q *DiskQueue) writeBatch(seq uint64, batch Batch) error {
+	data, err := json.Marshal(batch)
+	if err != nil {
+		return fmt.Errorf("failed to encode batch: %w", err)
+	}
+
+	path := q.batchPath(seq)
+	tmpPath := path + ".tmp"
+
+	const perms = 0644
+	if err = os.WriteFile(tmpPath, data, perms); err != nil {
+		return fmt.Errorf("failed to write batch: %w", err)
+	}
+
+	if err = os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to commit batch: %w", err)
+	}
+
+	return nil
+}
+
+func (q *DiskQueue) readBatch(seq uint64) (Batch, error) {
+	batch := Batch{}
+	data, err := os.ReadFile(q.batchPath(seq))
+	if err != nil {
+		return batch, err
+	}
+
+	err = json.Unmarshal(data, &batch)
+	return batch, err
+}
+
+// Push appends batch to the end of the queue.
+func (q *DiskQueue) Push(batch Batch) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	seq := q.next
+	if err := q.writeBatch(seq, batch); err != nil {
+		return err
+	}
+
+	q.next++
+	q.seqs = append(q.seqs, seq)
+
+	for len(q.seqs) > q.maxBatches {
+		q.dropOldest()
+	}
+
+	return nil
+}
+
+// dropOldest merges the oldest batch which wasn't sent yet into the next one, which gets new request ID.
+// Attempted batches are never merged since server might have applied them under their request ID.
+// If there are no batches to merge the oldest batch which isn't being sent is dropped.
+// Must be called with lock held.
+func (q *DiskQueue) dropOldest() {
+	for i := 0; i+1 < len(q.seqs); i++ {
+		older, err := q.readBatch(q.seqs[i])
+		if err != nil {
+			// Metrics of unreadable batch are lost.
+			q.removeAt(i)
+			return
+		}
+
+		if q.isAttempted(q.seqs[i], older) {
+			continue
+		}
+
+		newer, err := q.readBatch(q.seqs[i+1])
+		if err != nil || q.isAttempted(q.seqs[i+1], newer) {
+			continue
+		}
+
+		merged := Batch{RequestID: guid.NewString(), Metrics: Coalesce(older.Metrics, newer.Metrics)}
+		if err = q.writeBatch(q.seqs[i+1], merged); err != nil {
+			continue
+		}
+
+		q.removeAt(i)
+		return
+	}
+
+	idx := 0
+	if q.isInflight && q.seqs[0] == q.inflight {
+		idx = 1
+	}
+	q.removeAt(idx)
+}
+
+// isAttempted checks whether batch seq was sent during this or previous runs.
+func (q *DiskQueue) isAttempted(seq uint64, batch Batch) bool {
+	_, ok := q.attempted[seq]
+	return ok || batch.Attempted
+}
+
+// removeAt drops batch at index idx, must be called with lock held.
+func (q *DiskQueue) removeAt(idx int) {
+	seq := q.seqs[idx]
+	_ = os.Remove(q.batchPath(seq))
+	delete(q.attempted, seq)
+	q.seqs = append(q.seqs[:idx], q.seqs[idx+1:]...)
+	q.dropped++
+}
+
+// Peek returns the oldest batch and its sequence number without removing it.
+// Returned batch is considered being sent and isn't dropped on overflow until it's removed,
+// it's marked attempted, so it's never merged with other batches.
+// Unreadable batches are dropped.
+func (q *DiskQueue) Peek() (Batch, uint64, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for len(q.seqs) > 0 {
+		seq := q.seqs[0]
+		batch, err := q.readBatch(seq)
+		if err == nil {
+			q.inflight = seq
+			q.isInflight = true
+			if !batch.Attempted {
+				// Mark is kept in memory if it can't be stored, so batch isn't merged during this run at least.
+				q.attempted[seq] = struct{}{}
+				batch.Attempted = true
+				_ = q.writeBatch(seq, batch)
+			}
+			return batch, seq, true
+		}
+
+		q.removeAt(0)
+	}
+
+	return Batch{}, 0, false
+}
+
+// Remove removes batch with sequence number seq if it is the oldest one.
+func (q *DiskQueue) Remove(seq uint64) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.isInflight && q.inflight == seq {
+		q.isInflight = false
+	}
+
+	if len(q.seqs) == 0 || q.seqs[0] != seq {
+		return nil
+	}
+
+	err := os.Remove(q.batchPath(seq))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove batch: %w", err)
+	}
+	delete(q.attempted, seq)
+	q.seqs = q.seqs[1:]
+
+	return nil
+}
+
+// Len returns amount of buffered batches.
+func (q *DiskQueue) Len() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return len(q.seqs)
+}
+
+// Dropped returns amount of batches dropped due to overflow or corruption.
+func (q *DiskQueue) Dropped() uint64 {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.dropped
+}
+
+// Coalesce merges batches of metrics into one.
+// Counters with the same ID are summed, gauges of newer metrics replace older ones.
+func Coalesce(older, newer []metrics.Metric) []metrics.Metric {
+	index := make(map[string]int, len(older)+len(newer))
+	res := make([]metrics.Metric, 0, len(older)+len(newer))
+	for _, batch := range [][]metrics.Metric{older, newer} {
+		for _, m := range batch {
+			key := m.MType + ":" + m.ID
+			i, ok := index[key]
+			if !ok {
+				index[key] = len(res)
+				res = append(res, m)
+				continue
+			}
+
+			if m.MType == metrics.CounterMetricType && res[i].Delta != nil && m.Delta != nil {
+				sum := *res[i].Delta + *m.Delta
+				m.Delta = &sum
+			}
+			res[i] = m
+		}
+	}
+
+	return res
+}
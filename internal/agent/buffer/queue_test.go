@@ -0,0 +1,154 @@
+package buffer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fuzzy-toozy/metrics-service/internal/metrics"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_DiskQueue(t *testing.T) {
+	dir := t.TempDir()
+	q, err := NewDiskQueue(dir, 3)
+	require.NoError(t, err)
+
+	for _, id := range []string{"1", "2"} {
+		require.NoError(t, q.Push(Batch{RequestID: id, Metrics: []metrics.Metric{metrics.NewCounterMetric("c", 1)}}))
+	}
+	require.Equal(t, 2, q.Len())
+
+	// Reopened queue keeps order of batches.
+	q, err = NewDiskQueue(dir, 3)
+	require.NoError(t, err)
+	require.Equal(t, 2, q.Len())
+
+	for _, expected := range []string{"1", "2"} {
+		batch, seq, ok := q.Peek()
+		require.True(t, ok)
+		require.Equal(t, expected, batch.RequestID)
+		require.NoError(t, q.Remove(seq))
+	}
+
+	_, _, ok := q.Peek()
+	require.False(t, ok)
+	require.Equal(t, uint64(0), q.Dropped())
+
+	_, err = NewDiskQueue(dir, 1)
+	require.Error(t, err)
+}
+
+func Test_DiskQueueOverflow(t *testing.T) {
+	q, err := NewDiskQueue(t.TempDir(), 2)
+	require.NoError(t, err)
+
+	push := func(id string, delta int64, gauge float64) {
+		require.NoError(t, q.Push(Batch{RequestID: id, Metrics: []metrics.Metric{
+			metrics.NewCounterMetric("c", delta),
+			metrics.NewGaugeMetric("g", gauge),
+		}}))
+	}
+
+	push("1", 1, 1)
+	push("2", 2, 2)
+	push("3", 4, 3)
+	require.Equal(t, 2, q.Len())
+	require.Equal(t, uint64(1), q.Dropped())
+
+	// Counters of dropped batch are added to the next one, which gets new request ID.
+	batch, _, ok := q.Peek()
+	require.True(t, ok)
+	merged := batch.RequestID
+	require.NotContains(t, []string{"", "1", "2"}, merged)
+	require.ElementsMatch(t, []metrics.Metric{
+		metrics.NewCounterMetric("c", 3),
+		metrics.NewGaugeMetric("g", 2),
+	}, batch.Metrics)
+
+	// Batch being sent isn't dropped.
+	push("4", 8, 4)
+	require.Equal(t, 2, q.Len())
+	batch, seq, ok := q.Peek()
+	require.True(t, ok)
+	require.Equal(t, merged, batch.RequestID)
+	require.NoError(t, q.Remove(seq))
+
+	batch, _, ok = q.Peek()
+	require.True(t, ok)
+	require.NotContains(t, []string{"", merged, "3", "4"}, batch.RequestID)
+	require.ElementsMatch(t, []metrics.Metric{
+		metrics.NewCounterMetric("c", 12),
+		metrics.NewGaugeMetric("g", 4),
+	}, batch.Metrics)
+	require.Equal(t, uint64(2), q.Dropped())
+}
+
+func Test_DiskQueueAttempted(t *testing.T) {
+	dir := t.TempDir()
+	q, err := NewDiskQueue(dir, 2)
+	require.NoError(t, err)
+
+	push := func(id string, delta int64) {
+		require.NoError(t, q.Push(Batch{RequestID: id, Metrics: []metrics.Metric{metrics.NewCounterMetric("c", delta)}}))
+	}
+
+	push("1", 1)
+	push("2", 2)
+	batch, _, ok := q.Peek()
+	require.True(t, ok)
+	require.True(t, batch.Attempted)
+
+	// Attempted batch is neither merged nor merged into after restart.
+	q, err = NewDiskQueue(dir, 2)
+	require.NoError(t, err)
+	push("3", 4)
+	push("4", 8)
+	require.Equal(t, 2, q.Len())
+	require.Equal(t, uint64(2), q.Dropped())
+
+	batch, seq, ok := q.Peek()
+	require.True(t, ok)
+	require.Equal(t, Batch{RequestID: "1", Metrics: []metrics.Metric{metrics.NewCounterMetric("c", 1)}, Attempted: true}, batch)
+	require.NoError(t, q.Remove(seq))
+
+	batch, _, ok = q.Peek()
+	require.True(t, ok)
+	require.Equal(t, []metrics.Metric{metrics.NewCounterMetric("c", 14)}, batch.Metrics)
+}
+
+func Test_DiskQueueCorruption(t *testing.T) {
+	dir := t.TempDir()
+	q, err := NewDiskQueue(dir, 2)
+	require.NoError(t, err)
+
+	require.NoError(t, q.Push(Batch{RequestID: "1"}))
+	require.NoError(t, q.Push(Batch{RequestID: "2"}))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "00000000000000000000.batch"), []byte("{"), 0644))
+
+	batch, _, ok := q.Peek()
+	require.True(t, ok)
+	require.Equal(t, "2", batch.RequestID)
+	require.Equal(t, 1, q.Len())
+	require.Equal(t, uint64(1), q.Dropped())
+}
+
+func Test_Coalesce(t *testing.T) {
+	res := Coalesce([]metrics.Metric{
+		metrics.NewCounterMetric("c", 1),
+		metrics.NewGaugeMetric("g", 1),
+		metrics.NewGaugeMetric("old", 1),
+		metrics.NewCounterMetric("c", 2),
+	}, []metrics.Metric{
+		metrics.NewGaugeMetric("g", 2),
+		metrics.NewCounterMetric("c", 4),
+		metrics.NewGaugeMetric("c", 0.5),
+	})
+
+	require.Equal(t, []metrics.Metric{
+		metrics.NewCounterMetric("c", 7),
+		metrics.NewGaugeMetric("g", 2),
+		metrics.NewGaugeMetric("old", 1),
+		metrics.NewGaugeMetric("c", 0.5),
+	}, res)
+}
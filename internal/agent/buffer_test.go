@@ -0,0 +1,121 @@
+package agent
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/fuzzy-toozy/metrics-service/internal/agent/config"
+	"github.com/fuzzy-toozy/metrics-service/internal/agent/monitor/storage"
+	"github.com/fuzzy-toozy/metrics-service/internal/common"
+	"github.com/fuzzy-toozy/metrics-service/internal/log"
+	"github.com/fuzzy-toozy/metrics-service/internal/metrics"
+	"github.com/stretchr/testify/require"
+)
+
+type receivedReport struct {
+	requestID string
+	metrics   []metrics.Metric
+}
+
+// switchableClient fails requests with status code while it's set, records received reports otherwise.
+type switchableClient struct {
+	t        *testing.T
+	received []receivedReport
+	status   int
+	lock     sync.Mutex
+}
+
+func (c *switchableClient) setStatus(status int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.status = status
+}
+
+func (c *switchableClient) Send(r *http.Request) (*http.Response, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	status := http.StatusOK
+	if c.status != 0 {
+		status = c.status
+	} else {
+		reader, err := gzip.NewReader(r.Body)
+		require.NoError(c.t, err)
+		data := make([]metrics.Metric, 0)
+		require.NoError(c.t, json.NewDecoder(reader).Decode(&data))
+		c.received = append(c.received, receivedReport{requestID: r.Header.Get(common.RequestIDHeader), metrics: data})
+	}
+
+	return &http.Response{StatusCode: status, Body: io.NopCloser(bytes.NewBuffer(nil))}, nil
+}
+
+func TestReportBuffering(t *testing.T) {
+	cfg := config.Config{
+		CompressAlgo:     "gzip",
+		BufferDir:        t.TempDir(),
+		BufferMaxBatches: 10,
+		AgentID:          "agent",
+	}
+	a, err := NewAgent(cfg, log.NewDummyLogger(), WithCommonMonitor)
+	require.NoError(t, err)
+
+	client := &switchableClient{t: t, status: http.StatusServiceUnavailable}
+	w := newWorker(&a.config, a.log, client)
+	retry := common.NewCommonRetryExecutor(context.Background(), 0, 0, nil)
+
+	report := func(id string, delta int64) {
+		a.sendReport(w, retry, reportData{
+			data:      storage.StorageMetrics{metrics.NewCounterMetric("c", delta)},
+			dType:     tBULK,
+			requestID: id,
+		})
+	}
+
+	report("1", 1)
+	report("2", 2)
+	require.Equal(t, 2, a.buffer.Len())
+
+	// Buffer self-metrics are reported by monitor.
+	mon := a.monitors[len(a.monitors)-1]
+	require.NoError(t, mon.GatherMetrics())
+	require.ElementsMatch(t, []metrics.Metric{
+		metrics.NewGaugeMetric(BufferedBatchesMetricName, 2),
+		metrics.NewGaugeMetric(DroppedBatchesMetricName, 0),
+	}, []metrics.Metric(mon.GetMetricsStorage().GetAllMetrics()))
+
+	a.replayBuffered(context.Background(), w)
+	require.Equal(t, 2, a.buffer.Len())
+
+	// New reports are buffered until older ones are replayed.
+	client.setStatus(0)
+	report("3", 4)
+	require.Empty(t, client.received)
+
+	a.replayBuffered(context.Background(), w)
+	require.Equal(t, 0, a.buffer.Len())
+	require.Equal(t, []receivedReport{
+		{requestID: "1", metrics: []metrics.Metric{metrics.NewCounterMetric("c", 1)}},
+		{requestID: "2", metrics: []metrics.Metric{metrics.NewCounterMetric("c", 2)}},
+		{requestID: "3", metrics: []metrics.Metric{metrics.NewCounterMetric("c", 4)}},
+	}, client.received)
+
+	// Reports rejected by server aren't buffered.
+	client.setStatus(http.StatusBadRequest)
+	report("4", 1)
+	require.Equal(t, 0, a.buffer.Len())
+
+	// Single metric reports are delivered by bulk ones, so they aren't buffered.
+	client.setStatus(http.StatusServiceUnavailable)
+	a.sendReport(w, retry, reportData{
+		data:      storage.StorageMetric(metrics.NewCounterMetric("c", 1)),
+		dType:     tSINGLE,
+		requestID: "5",
+	})
+	require.Equal(t, 0, a.buffer.Len())
+}
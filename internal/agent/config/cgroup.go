@@ -0,0 +1,22 @@
+package config
+
+import (
+	"github.com/fuzzy-toozy/metrics-service/internal/log"
+)
+
+// CgroupMonitorConfig configuration of cgroups v2 monitor.
+type CgroupMonitorConfig struct {
+	// Root cgroupfs mountpoint.
+	Root string `json:"root"`
+	// Paths cgroups to monitor relative to Root, agent's own cgroup is monitored if empty.
+	Paths []string `json:"paths"`
+	// Enabled enables cgroups monitor.
+	Enabled bool `json:"enabled"`
+}
+
+// Print prints cgroups monitor configuration to log.
+func (c *CgroupMonitorConfig) Print(log log.Logger) {
+	log.Infof("Cgroup monitor enabled: %v", c.Enabled)
+	log.Infof("Cgroup monitor root: %v", c.Root)
+	log.Infof("Cgroup monitor paths: %v", c.Paths)
+}
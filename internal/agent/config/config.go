@@ -8,10 +8,13 @@ import (
 	"io"
 	"os"
 	"path"
+	"path/filepath"
 	"strings"
 	"time"
 
+	"github.com/beevik/guid"
 	"github.com/caarlos0/env"
+	"github.com/fuzzy-toozy/metrics-service/internal/common"
 	"github.com/fuzzy-toozy/metrics-service/internal/config"
 	"github.com/fuzzy-toozy/metrics-service/internal/encryption"
 	"github.com/fuzzy-toozy/metrics-service/internal/log"
@@ -43,6 +46,35 @@ type Config struct {
 	ReportInterval config.DurationOption `json:"report_interval"`
 	// RateLimit max amount of concurrent connections to server.
 	RateLimit uint `json:"concurrent_connections"`
+	// AgentID identifies agent on server, retried requests are detected by agent and request IDs.
+	// Random ID is generated if not set, it is kept in buffer dir if buffering is enabled.
+	AgentID string `json:"agent_id"`
+	// BufferDir directory to buffer bulk reports server failed to receive, buffering is disabled if empty.
+	BufferDir string `json:"buffer_dir"`
+	// BufferMaxBatches max amount of buffered reports, the oldest ones are coalesced with newer when exceeded.
+	BufferMaxBatches uint `json:"buffer_max_batches"`
+	// DiskMonitor configuration of disk monitor.
+	DiskMonitor DiskMonitorConfig `json:"disk_monitor"`
+	// NetworkMonitor configuration of network monitor.
+	NetworkMonitor NetworkMonitorConfig `json:"network_monitor"`
+	// ProcessMonitor configuration of processes monitor.
+	ProcessMonitor ProcessMonitorConfig `json:"process_monitor"`
+	// CgroupMonitor configuration of cgroups monitor.
+	CgroupMonitor CgroupMonitorConfig `json:"cgroup_monitor"`
+	// ExecMonitor configuration of external commands monitor.
+	ExecMonitor ExecMonitorConfig `json:"exec_monitor"`
+	// HTTPMonitor configuration of HTTP endpoints monitor.
+	HTTPMonitor HTTPMonitorConfig `json:"http_monitor"`
+	// PrometheusMonitor configuration of Prometheus endpoints monitor.
+	PrometheusMonitor PrometheusMonitorConfig `json:"prometheus_monitor"`
+	// LogMonitor configuration of log files monitor.
+	LogMonitor LogMonitorConfig `json:"log_monitor"`
+	// PushReceiver configuration of endpoint local applications push metrics to.
+	PushReceiver PushReceiverConfig `json:"push_receiver"`
+	// SystemMonitor enables monitor of load averages, uptime, context switches, interrupts and pressure stall information.
+	SystemMonitor bool `json:"system_monitor"`
+	// ProcRoot procfs mountpoint monitors read system statistics from.
+	ProcRoot string `json:"proc_root"`
 }
 
 func getEndpoint(address, url string) string {
@@ -56,6 +88,7 @@ func getEndpoint(address, url string) string {
 // Print prints config values to stdout.
 func (c *Config) Print(log log.Logger) {
 	log.Infof("Agent running with config:")
+	log.Infof("Agent ID: %v", c.AgentID)
 	log.Infof("Server address: %v", c.ServerAddress)
 	log.Infof("Report URL: %v", c.ReportURL)
 	log.Infof("Report bulk URL: %v", c.ReportBulkURL)
@@ -65,6 +98,19 @@ func (c *Config) Print(log log.Logger) {
 	log.Infof("Rate limit: %v", c.RateLimit)
 	log.Infof("Poll interval: %v", c.PollInterval.D)
 	log.Infof("Report interval: %v", c.ReportInterval.D)
+	log.Infof("Buffer dir: %v", c.BufferDir)
+	log.Infof("Buffer max batches: %v", c.BufferMaxBatches)
+	log.Infof("Proc root: %v", c.ProcRoot)
+	c.DiskMonitor.Print(log)
+	c.NetworkMonitor.Print(log)
+	c.ProcessMonitor.Print(log)
+	log.Infof("System monitor enabled: %v", c.SystemMonitor)
+	c.CgroupMonitor.Print(log)
+	c.ExecMonitor.Print(log)
+	c.HTTPMonitor.Print(log)
+	c.PrometheusMonitor.Print(log)
+	c.LogMonitor.Print(log)
+	c.PushReceiver.Print(log)
 }
 
 func parseEncKey(path string) (*rsa.PublicKey, error) {
@@ -98,6 +144,10 @@ func (c *Config) setDefaultValues() {
 	const defaultReportBulkURL = "/updates"
 	const defaultServerAddress = "localhost:8080"
 	const defaultCompressAlgo = "gzip"
+	const defaultBufferMaxBatches = 1000
+	const defaultProcRoot = "/proc"
+	const defaultCgroupRoot = "/sys/fs/cgroup"
+	const defaultPushAddress = "localhost:8090"
 
 	if c.RateLimit == 0 {
 		c.RateLimit = defaultConcurentConnections
@@ -126,6 +176,42 @@ func (c *Config) setDefaultValues() {
 	if len(c.CompressAlgo) == 0 {
 		c.CompressAlgo = defaultCompressAlgo
 	}
+
+	if c.BufferMaxBatches == 0 {
+		c.BufferMaxBatches = defaultBufferMaxBatches
+	}
+
+	if len(c.ProcRoot) == 0 {
+		c.ProcRoot = defaultProcRoot
+	}
+
+	if len(c.CgroupMonitor.Root) == 0 {
+		c.CgroupMonitor.Root = defaultCgroupRoot
+	}
+
+	if len(c.PushReceiver.Address) == 0 {
+		c.PushReceiver.Address = defaultPushAddress
+	}
+
+	if c.DiskMonitor.ExcludeFSTypes == nil {
+		c.DiskMonitor.ExcludeFSTypes = defaultExcludeFSTypes
+	}
+}
+
+// generateAgentID generates agent ID, it is stored in buffer dir if buffering is enabled,
+// so buffered reports are resent with the same ID after restart.
+func generateAgentID(bufferDir string) (string, error) {
+	const agentIDFile = "agent_id"
+	if len(bufferDir) == 0 {
+		return guid.NewString(), nil
+	}
+
+	id, err := common.PersistentID(filepath.Join(bufferDir, agentIDFile))
+	if err != nil {
+		return "", fmt.Errorf("failed to get agent ID: %w", err)
+	}
+
+	return id, nil
 }
 
 // BuildConfig parses environment varialbes, command line parameters and builds agent's config.
@@ -137,7 +223,29 @@ func BuildConfig() (*Config, error) {
 		reportURL      string
 		reportBulkURL  string
 		configFilePath string
+		agentID        string
+		bufferDir      string
 		rateLimit      uint
+		bufferMax      uint
+		diskMonitor    bool
+		diskIncludeFS  string
+		diskExcludeFS  string
+		netMonitor     bool
+		netIncludeIfs  string
+		netExcludeIfs  string
+		procRoot       string
+		procMonitor    bool
+		cgroupMonitor  bool
+		cgroupRoot     string
+		cgroupPaths    string
+		systemMonitor  bool
+		execMonitor    bool
+		httpMonitor    bool
+		promMonitor    bool
+		logMonitor     bool
+		pushReceiver   bool
+		pushAddress    string
+		pushUDPAddress string
 		pollInterval   config.DurationOption
 		reportInterval config.DurationOption
 	)
@@ -152,6 +260,28 @@ func BuildConfig() (*Config, error) {
 	flag.StringVar(&reportURL, "u", "", "Server endpoint path")
 	flag.StringVar(&configFilePath, "c", "", "Config file path")
 	flag.StringVar(&configFilePath, "config", "", "Config file path")
+	flag.StringVar(&agentID, "id", "", "Agent ID")
+	flag.StringVar(&bufferDir, "buffer-dir", "", "Directory to buffer unsent reports")
+	flag.UintVar(&bufferMax, "buffer-max-batches", 0, "Max amount of buffered reports")
+	flag.BoolVar(&diskMonitor, "disk-monitor", false, "Enable disk monitor")
+	flag.StringVar(&diskIncludeFS, "disk-include-fs", "", "Comma separated filesystem types to monitor")
+	flag.StringVar(&diskExcludeFS, "disk-exclude-fs", "", "Comma separated filesystem types not to monitor")
+	flag.BoolVar(&netMonitor, "net-monitor", false, "Enable network monitor")
+	flag.StringVar(&netIncludeIfs, "net-include-ifaces", "", "Comma separated glob patterns of interfaces to monitor")
+	flag.StringVar(&netExcludeIfs, "net-exclude-ifaces", "", "Comma separated glob patterns of interfaces not to monitor")
+	flag.StringVar(&procRoot, "proc-root", "", "Procfs mountpoint")
+	flag.BoolVar(&procMonitor, "process-monitor", false, "Enable process monitor")
+	flag.BoolVar(&cgroupMonitor, "cgroup-monitor", false, "Enable cgroup monitor")
+	flag.StringVar(&cgroupRoot, "cgroup-root", "", "Cgroupfs mountpoint")
+	flag.StringVar(&cgroupPaths, "cgroup-paths", "", "Comma separated cgroups to monitor")
+	flag.BoolVar(&systemMonitor, "system-monitor", false, "Enable load average, uptime and pressure monitor")
+	flag.BoolVar(&execMonitor, "exec-monitor", false, "Enable external commands monitor")
+	flag.BoolVar(&httpMonitor, "http-monitor", false, "Enable HTTP endpoints monitor")
+	flag.BoolVar(&promMonitor, "prometheus-monitor", false, "Enable Prometheus endpoints monitor")
+	flag.BoolVar(&logMonitor, "log-monitor", false, "Enable log files monitor")
+	flag.BoolVar(&pushReceiver, "push-receiver", false, "Enable receiving metrics pushed by local applications")
+	flag.StringVar(&pushAddress, "push-address", "", "Push receiver HTTP address")
+	flag.StringVar(&pushUDPAddress, "push-udp-address", "", "Push receiver UDP address")
 
 	flag.StringVar(&reportBulkURL, "ub", "", "Server endpoint path")
 	flag.UintVar(&rateLimit, "l", 0, "Max concurent connections")
@@ -195,6 +325,94 @@ func BuildConfig() (*Config, error) {
 		c.RateLimit = rateLimit
 	}
 
+	if len(agentID) > 0 {
+		c.AgentID = agentID
+	}
+
+	if len(bufferDir) > 0 {
+		c.BufferDir = bufferDir
+	}
+
+	if bufferMax > 0 {
+		c.BufferMaxBatches = bufferMax
+	}
+
+	if diskMonitor {
+		c.DiskMonitor.Enabled = true
+	}
+
+	if len(diskIncludeFS) > 0 {
+		c.DiskMonitor.IncludeFSTypes = splitList(diskIncludeFS)
+	}
+
+	if len(diskExcludeFS) > 0 {
+		c.DiskMonitor.ExcludeFSTypes = splitList(diskExcludeFS)
+	}
+
+	if netMonitor {
+		c.NetworkMonitor.Enabled = true
+	}
+
+	if len(netIncludeIfs) > 0 {
+		c.NetworkMonitor.IncludeInterfaces = splitList(netIncludeIfs)
+	}
+
+	if len(netExcludeIfs) > 0 {
+		c.NetworkMonitor.ExcludeInterfaces = splitList(netExcludeIfs)
+	}
+
+	if len(procRoot) > 0 {
+		c.ProcRoot = procRoot
+	}
+
+	if procMonitor {
+		c.ProcessMonitor.Enabled = true
+	}
+
+	if cgroupMonitor {
+		c.CgroupMonitor.Enabled = true
+	}
+
+	if systemMonitor {
+		c.SystemMonitor = true
+	}
+
+	if execMonitor {
+		c.ExecMonitor.Enabled = true
+	}
+
+	if httpMonitor {
+		c.HTTPMonitor.Enabled = true
+	}
+
+	if promMonitor {
+		c.PrometheusMonitor.Enabled = true
+	}
+
+	if logMonitor {
+		c.LogMonitor.Enabled = true
+	}
+
+	if pushReceiver {
+		c.PushReceiver.Enabled = true
+	}
+
+	if len(pushAddress) > 0 {
+		c.PushReceiver.Address = pushAddress
+	}
+
+	if len(pushUDPAddress) > 0 {
+		c.PushReceiver.UDPAddress = pushUDPAddress
+	}
+
+	if len(cgroupRoot) > 0 {
+		c.CgroupMonitor.Root = cgroupRoot
+	}
+
+	if len(cgroupPaths) > 0 {
+		c.CgroupMonitor.Paths = splitList(cgroupPaths)
+	}
+
 	if pollInterval.D > 0 {
 		c.PollInterval = pollInterval
 	}
@@ -212,6 +430,33 @@ func BuildConfig() (*Config, error) {
 		return nil, err
 	}
 
+	if len(c.AgentID) == 0 {
+		c.AgentID, err = generateAgentID(c.BufferDir)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err = c.ProcessMonitor.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid process monitor config: %w", err)
+	}
+
+	if err = c.ExecMonitor.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid exec monitor config: %w", err)
+	}
+
+	if err = c.HTTPMonitor.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid HTTP monitor config: %w", err)
+	}
+
+	if err = c.PrometheusMonitor.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid prometheus monitor config: %w", err)
+	}
+
+	if err = c.LogMonitor.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid log monitor config: %w", err)
+	}
+
 	c.ReportEndpoint = getEndpoint(c.ServerAddress, c.ReportURL)
 	c.ReportBulkEndpoint = getEndpoint(c.ServerAddress, c.ReportBulkURL)
 
@@ -233,6 +478,28 @@ func (c *Config) parseEnvVariables() error {
 		ReportInterval int    `env:"REPORT_INTERVAL"`
 		PollInterval   int    `env:"POLL_INTERVAL"`
 		RateLimit      uint   `env:"RATE_LIMIT"`
+		AgentID        string `env:"AGENT_ID"`
+		BufferDir      string `env:"BUFFER_DIR"`
+		BufferMax      uint   `env:"BUFFER_MAX_BATCHES"`
+		DiskMonitor    bool   `env:"DISK_MONITOR"`
+		DiskIncludeFS  string `env:"DISK_INCLUDE_FS"`
+		DiskExcludeFS  string `env:"DISK_EXCLUDE_FS"`
+		NetMonitor     bool   `env:"NET_MONITOR"`
+		NetIncludeIfs  string `env:"NET_INCLUDE_IFACES"`
+		NetExcludeIfs  string `env:"NET_EXCLUDE_IFACES"`
+		ProcRoot       string `env:"PROC_ROOT"`
+		ProcMonitor    bool   `env:"PROCESS_MONITOR"`
+		CgroupMonitor  bool   `env:"CGROUP_MONITOR"`
+		CgroupRoot     string `env:"CGROUP_ROOT"`
+		CgroupPaths    string `env:"CGROUP_PATHS"`
+		SystemMonitor  bool   `env:"SYSTEM_MONITOR"`
+		ExecMonitor    bool   `env:"EXEC_MONITOR"`
+		HTTPMonitor    bool   `env:"HTTP_MONITOR"`
+		PromMonitor    bool   `env:"PROMETHEUS_MONITOR"`
+		LogMonitor     bool   `env:"LOG_MONITOR"`
+		PushReceiver   bool   `env:"PUSH_RECEIVER"`
+		PushAddress    string `env:"PUSH_ADDRESS"`
+		PushUDPAddress string `env:"PUSH_UDP_ADDRESS"`
 	}
 	ecfg := EnvConfig{}
 	err := env.Parse(&ecfg)
@@ -264,5 +531,93 @@ func (c *Config) parseEnvVariables() error {
 		c.RateLimit = ecfg.RateLimit
 	}
 
+	if len(ecfg.AgentID) > 0 {
+		c.AgentID = ecfg.AgentID
+	}
+
+	if len(ecfg.BufferDir) > 0 {
+		c.BufferDir = ecfg.BufferDir
+	}
+
+	if ecfg.BufferMax > 0 {
+		c.BufferMaxBatches = ecfg.BufferMax
+	}
+
+	if ecfg.DiskMonitor {
+		c.DiskMonitor.Enabled = true
+	}
+
+	if len(ecfg.DiskIncludeFS) > 0 {
+		c.DiskMonitor.IncludeFSTypes = splitList(ecfg.DiskIncludeFS)
+	}
+
+	if len(ecfg.DiskExcludeFS) > 0 {
+		c.DiskMonitor.ExcludeFSTypes = splitList(ecfg.DiskExcludeFS)
+	}
+
+	if ecfg.NetMonitor {
+		c.NetworkMonitor.Enabled = true
+	}
+
+	if len(ecfg.NetIncludeIfs) > 0 {
+		c.NetworkMonitor.IncludeInterfaces = splitList(ecfg.NetIncludeIfs)
+	}
+
+	if len(ecfg.NetExcludeIfs) > 0 {
+		c.NetworkMonitor.ExcludeInterfaces = splitList(ecfg.NetExcludeIfs)
+	}
+
+	if len(ecfg.ProcRoot) > 0 {
+		c.ProcRoot = ecfg.ProcRoot
+	}
+
+	if ecfg.ProcMonitor {
+		c.ProcessMonitor.Enabled = true
+	}
+
+	if ecfg.CgroupMonitor {
+		c.CgroupMonitor.Enabled = true
+	}
+
+	if ecfg.SystemMonitor {
+		c.SystemMonitor = true
+	}
+
+	if ecfg.ExecMonitor {
+		c.ExecMonitor.Enabled = true
+	}
+
+	if ecfg.HTTPMonitor {
+		c.HTTPMonitor.Enabled = true
+	}
+
+	if ecfg.PromMonitor {
+		c.PrometheusMonitor.Enabled = true
+	}
+
+	if ecfg.LogMonitor {
+		c.LogMonitor.Enabled = true
+	}
+
+	if ecfg.PushReceiver {
+		c.PushReceiver.Enabled = true
+	}
+
+	if len(ecfg.PushAddress) > 0 {
+		c.PushReceiver.Address = ecfg.PushAddress
+	}
+
+	if len(ecfg.PushUDPAddress) > 0 {
+		c.PushReceiver.UDPAddress = ecfg.PushUDPAddress
+	}
+
+	if len(ecfg.CgroupRoot) > 0 {
+		c.CgroupMonitor.Root = ecfg.CgroupRoot
+	}
+
+	if len(ecfg.CgroupPaths) > 0 {
+		c.CgroupMonitor.Paths = splitList(ecfg.CgroupPaths)
+	}
+
 	return nil
 }
@@ -0,0 +1,42 @@
+package config
+
+import (
+	"strings"
+
+	"github.com/fuzzy-toozy/metrics-service/internal/log"
+)
+
+// DiskMonitorConfig configuration of filesystems and block devices monitor.
+type DiskMonitorConfig struct {
+	// IncludeFSTypes filesystem types to monitor, all types not excluded are monitored if empty.
+	IncludeFSTypes []string `json:"include_fs_types"`
+	// ExcludeFSTypes filesystem types not to monitor.
+	ExcludeFSTypes []string `json:"exclude_fs_types"`
+	// Enabled enables disk monitor.
+	Enabled bool `json:"enabled"`
+}
+
+// defaultExcludeFSTypes pseudo filesystems not backed by storage devices.
+var defaultExcludeFSTypes = []string{
+	"autofs", "binfmt_misc", "bpf", "cgroup", "cgroup2", "configfs", "debugfs", "devpts", "devtmpfs",
+	"fusectl", "hugetlbfs", "mqueue", "nsfs", "proc", "pstore", "rpc_pipefs", "securityfs", "sysfs", "tracefs",
+}
+
+// Print prints disk monitor configuration to log.
+func (c *DiskMonitorConfig) Print(log log.Logger) {
+	log.Infof("Disk monitor enabled: %v", c.Enabled)
+	log.Infof("Disk monitor include fs types: %v", c.IncludeFSTypes)
+	log.Infof("Disk monitor exclude fs types: %v", c.ExcludeFSTypes)
+}
+
+// splitList splits comma separated list skipping empty items.
+func splitList(list string) []string {
+	res := make([]string, 0)
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if len(item) > 0 {
+			res = append(res, item)
+		}
+	}
+	return res
+}
@@ -0,0 +1,76 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/fuzzy-toozy/metrics-service/internal/config"
+	"github.com/fuzzy-toozy/metrics-service/internal/log"
+)
+
+// Exec command output formats.
+const (
+	// ExecFormatText lines of "name type value", empty lines and lines starting with # are skipped.
+	ExecFormatText = "text"
+	// ExecFormatJSON JSON array of metrics in the same format server accepts.
+	ExecFormatJSON = "json"
+)
+
+// ExecCommandConfig external command producing metrics.
+type ExecCommandConfig struct {
+	// Name command name used in self-metrics names.
+	Name string `json:"name"`
+	// Command executable and its arguments, it isn't run in shell.
+	Command []string `json:"command"`
+	// Interval interval between command runs, command is run on every poll if not set.
+	Interval config.DurationOption `json:"interval"`
+	// Timeout max duration of command run, command is killed when exceeded, 10 seconds if not set.
+	Timeout config.DurationOption `json:"timeout"`
+	// Format format of command output, text if not set.
+	Format string `json:"format"`
+}
+
+// ExecMonitorConfig configuration of external commands monitor.
+type ExecMonitorConfig struct {
+	// Commands commands to run, commands are configured in config file only.
+	Commands []ExecCommandConfig `json:"commands"`
+	// Enabled enables external commands monitor.
+	Enabled bool `json:"enabled"`
+}
+
+// Print prints external commands monitor configuration to log.
+func (c *ExecMonitorConfig) Print(log log.Logger) {
+	log.Infof("Exec monitor enabled: %v", c.Enabled)
+	for _, cmd := range c.Commands {
+		log.Infof("Exec command %v: %v, interval %v, timeout %v, format %v",
+			cmd.Name, cmd.Command, cmd.Interval.D, cmd.Timeout.D, cmd.Format)
+	}
+}
+
+// Validate checks commands configuration.
+func (c *ExecMonitorConfig) Validate() error {
+	names := make(map[string]struct{}, len(c.Commands))
+	for _, cmd := range c.Commands {
+		if len(cmd.Name) == 0 {
+			return fmt.Errorf("exec command name is empty")
+		}
+
+		if _, ok := names[cmd.Name]; ok {
+			return fmt.Errorf("duplicate exec command '%v'", cmd.Name)
+		}
+		names[cmd.Name] = struct{}{}
+
+		if len(cmd.Command) == 0 || len(cmd.Command[0]) == 0 {
+			return fmt.Errorf("exec command '%v' has no executable", cmd.Name)
+		}
+
+		if len(cmd.Format) > 0 && cmd.Format != ExecFormatText && cmd.Format != ExecFormatJSON {
+			return fmt.Errorf("invalid output format '%v' of exec command '%v'", cmd.Format, cmd.Name)
+		}
+
+		if cmd.Timeout.D < 0 || cmd.Interval.D < 0 {
+			return fmt.Errorf("negative timeout or interval of exec command '%v'", cmd.Name)
+		}
+	}
+
+	return nil
+}
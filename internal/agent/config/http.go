@@ -0,0 +1,73 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/fuzzy-toozy/metrics-service/internal/config"
+	"github.com/fuzzy-toozy/metrics-service/internal/log"
+)
+
+// HTTPProbeConfig HTTP endpoint probed by agent.
+type HTTPProbeConfig struct {
+	// Name target name used in metric names.
+	Name string `json:"name"`
+	// URL endpoint to probe.
+	URL string `json:"url"`
+	// Method HTTP method of probe request, GET if not set.
+	Method string `json:"method"`
+	// Interval interval between probes, target is probed on every poll if not set.
+	Interval config.DurationOption `json:"interval"`
+	// Timeout max duration of probe including reading response body, 5 seconds if not set.
+	Timeout config.DurationOption `json:"timeout"`
+	// ExpectedStatus status codes considered up, any 2xx or 3xx status is considered up if empty.
+	ExpectedStatus []int `json:"expected_status"`
+	// InsecureSkipVerify disables verification of target's TLS certificate.
+	InsecureSkipVerify bool `json:"insecure_skip_verify"`
+}
+
+// HTTPMonitorConfig configuration of HTTP endpoints monitor.
+type HTTPMonitorConfig struct {
+	// Targets endpoints to probe, targets are configured in config file only.
+	Targets []HTTPProbeConfig `json:"targets"`
+	// Enabled enables HTTP endpoints monitor.
+	Enabled bool `json:"enabled"`
+}
+
+// Print prints HTTP endpoints monitor configuration to log.
+func (c *HTTPMonitorConfig) Print(log log.Logger) {
+	log.Infof("HTTP monitor enabled: %v", c.Enabled)
+	for _, t := range c.Targets {
+		log.Infof("HTTP target %v: %v %v, interval %v, timeout %v, expected status %v",
+			t.Name, t.Method, t.URL, t.Interval.D, t.Timeout.D, t.ExpectedStatus)
+	}
+}
+
+// Validate checks HTTP targets configuration.
+func (c *HTTPMonitorConfig) Validate() error {
+	names := make(map[string]struct{}, len(c.Targets))
+	for _, t := range c.Targets {
+		if len(t.Name) == 0 {
+			return fmt.Errorf("HTTP target name is empty")
+		}
+
+		if _, ok := names[t.Name]; ok {
+			return fmt.Errorf("duplicate HTTP target '%v'", t.Name)
+		}
+		names[t.Name] = struct{}{}
+
+		u, err := url.Parse(t.URL)
+		if err != nil {
+			return fmt.Errorf("invalid URL of HTTP target '%v': %w", t.Name, err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return fmt.Errorf("unsupported URL scheme '%v' of HTTP target '%v'", u.Scheme, t.Name)
+		}
+
+		if t.Timeout.D < 0 || t.Interval.D < 0 {
+			return fmt.Errorf("negative timeout or interval of HTTP target '%v'", t.Name)
+		}
+	}
+
+	return nil
+}
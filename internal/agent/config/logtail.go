@@ -0,0 +1,94 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/fuzzy-toozy/metrics-service/internal/log"
+)
+
+// LogRuleConfig rule matching log lines.
+// Lines matched are counted, numeric values of named captures are aggregated.
+type LogRuleConfig struct {
+	// Name rule name used in metric names.
+	Name string `json:"name"`
+	// Regex regular expression matching lines, named captures with numeric values are reported.
+	Regex string `json:"regex"`
+	// Buckets upper bounds of histogram buckets of captured values, histogram isn't reported if empty.
+	Buckets []float64 `json:"buckets"`
+}
+
+// LogFileConfig log file tailed by agent.
+type LogFileConfig struct {
+	// Path path to log file, file is reopened when rotated.
+	Path string `json:"path"`
+	// Rules rules applied to every new line of file.
+	Rules []LogRuleConfig `json:"rules"`
+}
+
+// LogMonitorConfig configuration of log files monitor.
+type LogMonitorConfig struct {
+	// Files log files to tail, files are configured in config file only.
+	Files []LogFileConfig `json:"files"`
+	// OffsetsFile file to persist read offsets in, offsets aren't persisted if empty.
+	OffsetsFile string `json:"offsets_file"`
+	// FromBeginning read files without persisted offset found at startup from the beginning instead of the end.
+	FromBeginning bool `json:"from_beginning"`
+	// Enabled enables log files monitor.
+	Enabled bool `json:"enabled"`
+}
+
+// Print prints log files monitor configuration to log.
+func (c *LogMonitorConfig) Print(log log.Logger) {
+	log.Infof("Log monitor enabled: %v", c.Enabled)
+	log.Infof("Log monitor offsets file: %v", c.OffsetsFile)
+	log.Infof("Log monitor from beginning: %v", c.FromBeginning)
+	for _, f := range c.Files {
+		for _, r := range f.Rules {
+			log.Infof("Log rule %v of %v: '%v', buckets %v", r.Name, f.Path, r.Regex, r.Buckets)
+		}
+	}
+}
+
+// Validate checks log files and rules configuration.
+func (c *LogMonitorConfig) Validate() error {
+	paths := make(map[string]struct{}, len(c.Files))
+	names := make(map[string]struct{})
+	for _, f := range c.Files {
+		if len(f.Path) == 0 {
+			return fmt.Errorf("log file path is empty")
+		}
+
+		if _, ok := paths[f.Path]; ok {
+			return fmt.Errorf("duplicate log file '%v'", f.Path)
+		}
+		paths[f.Path] = struct{}{}
+
+		if len(f.Rules) == 0 {
+			return fmt.Errorf("log file '%v' has no rules", f.Path)
+		}
+
+		for _, r := range f.Rules {
+			if len(r.Name) == 0 {
+				return fmt.Errorf("name of log rule of '%v' is empty", f.Path)
+			}
+
+			// Rule names are unique across files as they are used in metric names.
+			if _, ok := names[r.Name]; ok {
+				return fmt.Errorf("duplicate log rule '%v'", r.Name)
+			}
+			names[r.Name] = struct{}{}
+
+			if _, err := regexp.Compile(r.Regex); err != nil {
+				return fmt.Errorf("invalid regex of log rule '%v': %w", r.Name, err)
+			}
+
+			if !sort.Float64sAreSorted(r.Buckets) {
+				return fmt.Errorf("buckets of log rule '%v' aren't sorted", r.Name)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,22 @@
+package config
+
+import (
+	"github.com/fuzzy-toozy/metrics-service/internal/log"
+)
+
+// NetworkMonitorConfig configuration of network interfaces monitor.
+type NetworkMonitorConfig struct {
+	// IncludeInterfaces glob patterns of interfaces to monitor, all interfaces not excluded are monitored if empty.
+	IncludeInterfaces []string `json:"include_interfaces"`
+	// ExcludeInterfaces glob patterns of interfaces not to monitor.
+	ExcludeInterfaces []string `json:"exclude_interfaces"`
+	// Enabled enables network monitor.
+	Enabled bool `json:"enabled"`
+}
+
+// Print prints network monitor configuration to log.
+func (c *NetworkMonitorConfig) Print(log log.Logger) {
+	log.Infof("Network monitor enabled: %v", c.Enabled)
+	log.Infof("Network monitor include interfaces: %v", c.IncludeInterfaces)
+	log.Infof("Network monitor exclude interfaces: %v", c.ExcludeInterfaces)
+}
@@ -0,0 +1,62 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/fuzzy-toozy/metrics-service/internal/log"
+)
+
+// ProcessGroupConfig rule matching processes reported as a group.
+// Process must match all rules set.
+type ProcessGroupConfig struct {
+	// Name group name used in metric names.
+	Name string `json:"name"`
+	// ProcessName exact process name (comm).
+	ProcessName string `json:"process_name"`
+	// CmdlineRegex regular expression matching process command line with arguments separated by spaces.
+	CmdlineRegex string `json:"cmdline_regex"`
+	// PidFile path to file containing pid of process.
+	PidFile string `json:"pidfile"`
+}
+
+// ProcessMonitorConfig configuration of processes monitor.
+type ProcessMonitorConfig struct {
+	// Groups rules of matching processes, groups are configured in config file only.
+	Groups []ProcessGroupConfig `json:"groups"`
+	// Enabled enables processes monitor.
+	Enabled bool `json:"enabled"`
+}
+
+// Print prints processes monitor configuration to log.
+func (c *ProcessMonitorConfig) Print(log log.Logger) {
+	log.Infof("Process monitor enabled: %v", c.Enabled)
+	for _, g := range c.Groups {
+		log.Infof("Process group %v: name '%v', cmdline '%v', pidfile '%v'", g.Name, g.ProcessName, g.CmdlineRegex, g.PidFile)
+	}
+}
+
+// Validate checks process groups rules.
+func (c *ProcessMonitorConfig) Validate() error {
+	names := make(map[string]struct{}, len(c.Groups))
+	for _, g := range c.Groups {
+		if len(g.Name) == 0 {
+			return fmt.Errorf("process group name is empty")
+		}
+
+		if _, ok := names[g.Name]; ok {
+			return fmt.Errorf("duplicate process group '%v'", g.Name)
+		}
+		names[g.Name] = struct{}{}
+
+		if len(g.ProcessName) == 0 && len(g.CmdlineRegex) == 0 && len(g.PidFile) == 0 {
+			return fmt.Errorf("process group '%v' has no matching rules", g.Name)
+		}
+
+		if _, err := regexp.Compile(g.CmdlineRegex); err != nil {
+			return fmt.Errorf("invalid cmdline regex of process group '%v': %w", g.Name, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,125 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+
+	"github.com/fuzzy-toozy/metrics-service/internal/config"
+	"github.com/fuzzy-toozy/metrics-service/internal/log"
+)
+
+// Relabel rule actions.
+const (
+	// RelabelReplace sets target label to replacement if regex matches source labels.
+	RelabelReplace = "replace"
+	// RelabelKeep drops samples which source labels don't match regex.
+	RelabelKeep = "keep"
+	// RelabelDrop drops samples which source labels match regex.
+	RelabelDrop = "drop"
+	// RelabelLabelDrop removes labels which names match regex.
+	RelabelLabelDrop = "labeldrop"
+)
+
+// RelabelRuleConfig rule rewriting labels of scraped samples, subset of Prometheus metric relabeling.
+// Metric name is available as label __name__.
+type RelabelRuleConfig struct {
+	// SourceLabels labels which values are joined by separator and matched by regex, __name__ if empty.
+	SourceLabels []string `json:"source_labels"`
+	// Separator separator of source labels values, ";" if not set.
+	Separator string `json:"separator"`
+	// Regex regular expression matching whole joined value, "(.*)" if not set.
+	Regex string `json:"regex"`
+	// Action action of rule, replace if not set.
+	Action string `json:"action"`
+	// TargetLabel label set by replace action, label is removed if replacement is expanded to empty string.
+	TargetLabel string `json:"target_label"`
+	// Replacement value of target label with regex groups references, "$1" if not set.
+	Replacement string `json:"replacement"`
+}
+
+// PrometheusTargetConfig endpoint exposing metrics in Prometheus text format.
+type PrometheusTargetConfig struct {
+	// Name target name used in self-metrics names.
+	Name string `json:"name"`
+	// URL endpoint to scrape.
+	URL string `json:"url"`
+	// Interval interval between scrapes, target is scraped on every poll if not set.
+	Interval config.DurationOption `json:"interval"`
+	// Timeout max duration of scrape, 10 seconds if not set.
+	Timeout config.DurationOption `json:"timeout"`
+	// Prefix prefix added to names of scraped metrics.
+	Prefix string `json:"prefix"`
+	// Relabel rules applied to scraped samples in order.
+	Relabel []RelabelRuleConfig `json:"relabel"`
+}
+
+// PrometheusMonitorConfig configuration of Prometheus endpoints monitor.
+type PrometheusMonitorConfig struct {
+	// Targets endpoints to scrape, targets are configured in config file only.
+	Targets []PrometheusTargetConfig `json:"targets"`
+	// Enabled enables Prometheus endpoints monitor.
+	Enabled bool `json:"enabled"`
+}
+
+// Print prints Prometheus endpoints monitor configuration to log.
+func (c *PrometheusMonitorConfig) Print(log log.Logger) {
+	log.Infof("Prometheus monitor enabled: %v", c.Enabled)
+	for _, t := range c.Targets {
+		log.Infof("Prometheus target %v: %v, interval %v, timeout %v, prefix '%v', relabel rules %v",
+			t.Name, t.URL, t.Interval.D, t.Timeout.D, t.Prefix, len(t.Relabel))
+	}
+}
+
+func (r *RelabelRuleConfig) validate() error {
+	if _, err := regexp.Compile(r.Regex); err != nil {
+		return fmt.Errorf("invalid regex: %w", err)
+	}
+
+	switch r.Action {
+	case "", RelabelReplace:
+		if len(r.TargetLabel) == 0 {
+			return fmt.Errorf("target label of replace action is empty")
+		}
+	case RelabelKeep, RelabelDrop, RelabelLabelDrop:
+	default:
+		return fmt.Errorf("unsupported action '%v'", r.Action)
+	}
+
+	return nil
+}
+
+// Validate checks Prometheus targets configuration.
+func (c *PrometheusMonitorConfig) Validate() error {
+	names := make(map[string]struct{}, len(c.Targets))
+	for _, t := range c.Targets {
+		if len(t.Name) == 0 {
+			return fmt.Errorf("prometheus target name is empty")
+		}
+
+		if _, ok := names[t.Name]; ok {
+			return fmt.Errorf("duplicate prometheus target '%v'", t.Name)
+		}
+		names[t.Name] = struct{}{}
+
+		u, err := url.Parse(t.URL)
+		if err != nil {
+			return fmt.Errorf("invalid URL of prometheus target '%v': %w", t.Name, err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return fmt.Errorf("unsupported URL scheme '%v' of prometheus target '%v'", u.Scheme, t.Name)
+		}
+
+		if t.Timeout.D < 0 || t.Interval.D < 0 {
+			return fmt.Errorf("negative timeout or interval of prometheus target '%v'", t.Name)
+		}
+
+		for i := range t.Relabel {
+			if err := t.Relabel[i].validate(); err != nil {
+				return fmt.Errorf("invalid relabel rule %v of prometheus target '%v': %w", i, t.Name, err)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,22 @@
+package config
+
+import (
+	"github.com/fuzzy-toozy/metrics-service/internal/log"
+)
+
+// PushReceiverConfig configuration of endpoint local applications push metrics to.
+type PushReceiverConfig struct {
+	// Address HTTP address accepting metrics in the same JSON format as server's /update and /updates.
+	Address string `json:"address"`
+	// UDPAddress UDP address accepting metric or array of metrics in JSON per datagram, disabled if empty.
+	UDPAddress string `json:"udp_address"`
+	// Enabled enables push receiver.
+	Enabled bool `json:"enabled"`
+}
+
+// Print prints push receiver configuration to log.
+func (c *PushReceiverConfig) Print(log log.Logger) {
+	log.Infof("Push receiver enabled: %v", c.Enabled)
+	log.Infof("Push receiver address: %v", c.Address)
+	log.Infof("Push receiver UDP address: %v", c.UDPAddress)
+}
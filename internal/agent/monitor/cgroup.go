@@ -0,0 +1,255 @@
+package monitor
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/fuzzy-toozy/metrics-service/internal/agent/config"
+	"github.com/fuzzy-toozy/metrics-service/internal/agent/monitor/storage"
+	"github.com/fuzzy-toozy/metrics-service/internal/log"
+	"github.com/fuzzy-toozy/metrics-service/internal/metrics"
+)
+
+// Cgroup metrics names prefixes, suffix is cgroup path or "self" for agent's own cgroup.
+const (
+	CgroupMemoryCurrentMetric    = "CgroupMemoryCurrent_"
+	CgroupMemoryMaxMetric        = "CgroupMemoryMax_"
+	CgroupPidsMetric             = "CgroupPids_"
+	CgroupCPUUsageMetric         = "CgroupCPUUsageUsec_"
+	CgroupCPUUserMetric          = "CgroupCPUUserUsec_"
+	CgroupCPUSystemMetric        = "CgroupCPUSystemUsec_"
+	CgroupCPUThrottledMetric     = "CgroupCPUThrottledPeriods_"
+	CgroupCPUThrottledTimeMetric = "CgroupCPUThrottledUsec_"
+	CgroupIOReadBytesMetric      = "CgroupIOReadBytes_"
+	CgroupIOWriteBytesMetric     = "CgroupIOWriteBytes_"
+	CgroupIOReadsMetric          = "CgroupIOReads_"
+	CgroupIOWritesMetric         = "CgroupIOWrites_"
+)
+
+// selfCgroupName metric names suffix of agent's own cgroup.
+const selfCgroupName = "self"
+
+// cgroupCPUStatKeys counters of cpu.stat reported by monitor.
+var cgroupCPUStatKeys = map[string]string{
+	"usage_usec":     CgroupCPUUsageMetric,
+	"user_usec":      CgroupCPUUserMetric,
+	"system_usec":    CgroupCPUSystemMetric,
+	"nr_throttled":   CgroupCPUThrottledMetric,
+	"throttled_usec": CgroupCPUThrottledTimeMetric,
+}
+
+// cgroupIOStatKeys counters of io.stat reported by monitor, values are summed over devices.
+var cgroupIOStatKeys = map[string]string{
+	"rbytes": CgroupIOReadBytesMetric,
+	"wbytes": CgroupIOWriteBytesMetric,
+	"rios":   CgroupIOReadsMetric,
+	"wios":   CgroupIOWritesMetric,
+}
+
+// CgroupMonitor memory, CPU, IO and pids usage of cgroups v2 read from cgroupfs.
+// Agent's own cgroup is monitored if no cgroups are configured.
+// Cumulative CPU and IO statistics are reported as counters of increase since previous gather,
+// statistics of controllers not enabled in cgroup are skipped.
+type CgroupMonitor struct {
+	storage  storage.MetricsStorage
+	log      log.Logger
+	counters *counterTracker
+	root     string
+	procRoot string
+	paths    []string
+}
+
+func NewCgroupMonitor(s storage.MetricsStorage, l log.Logger, procRoot string, cfg config.CgroupMonitorConfig) *CgroupMonitor {
+	return &CgroupMonitor{
+		storage:  s,
+		log:      l,
+		counters: newCounterTracker(),
+		root:     cfg.Root,
+		procRoot: procRoot,
+		paths:    cfg.Paths,
+	}
+}
+
+// GetMetricsStorage return underlying metrics storage.
+func (m *CgroupMonitor) GetMetricsStorage() storage.MetricsStorage {
+	return m.storage
+}
+
+// selfCgroup returns path of agent's cgroup v2 relative to cgroupfs root.
+func (m *CgroupMonitor) selfCgroup() (string, error) {
+	f, err := os.Open(filepath.Join(m.procRoot, "self", "cgroup"))
+	if err != nil {
+		return "", fmt.Errorf("failed to open own cgroup: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Unified hierarchy is listed as "0::<path>".
+		if path, ok := strings.CutPrefix(scanner.Text(), "0::"); ok {
+			return path, nil
+		}
+	}
+
+	if err = scanner.Err(); err != nil {
+		return "", fmt.Errorf("failed to read own cgroup: %w", err)
+	}
+
+	return "", fmt.Errorf("cgroup v2 isn't used")
+}
+
+// GatherMetrics reads statistics of cgroups and add them to storage.
+func (m *CgroupMonitor) GatherMetrics() error {
+	m.storage.Clear()
+
+	type cgroup struct {
+		path string
+		name string
+	}
+
+	cgroups := make([]cgroup, 0, len(m.paths))
+	for _, path := range m.paths {
+		cgroups = append(cgroups, cgroup{path: path, name: metricSuffix(path)})
+	}
+
+	if len(cgroups) == 0 {
+		path, err := m.selfCgroup()
+		if err != nil {
+			return err
+		}
+		cgroups = append(cgroups, cgroup{path: path, name: selfCgroupName})
+	}
+
+	// Failing cgroup doesn't prevent others from being gathered.
+	var errs []error
+	for _, cg := range cgroups {
+		if err := m.gatherCgroup(filepath.Join(m.root, cg.path), cg.name); err != nil {
+			errs = append(errs, fmt.Errorf("failed to gather metrics of cgroup %v: %w", cg.path, err))
+		}
+	}
+	m.counters.commit()
+
+	return errors.Join(errs...)
+}
+
+// readCgroupValue reads single value file, returns false if file is missing or value is "max".
+func readCgroupValue(path string) (uint64, bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+
+	val := strings.TrimSpace(string(data))
+	if val == "max" {
+		return 0, false, nil
+	}
+
+	res, err := strconv.ParseUint(val, 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid value in %v: %w", filepath.Base(path), err)
+	}
+
+	return res, true, nil
+}
+
+// readCgroupStat sums values of keys found in flat keyed or nested keyed file.
+// Returns nil if file is missing.
+func readCgroupStat(path string, keys map[string]string) (map[string]uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	res := make(map[string]uint64)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		// Flat keyed line "key value".
+		if len(fields) == 2 && !strings.Contains(fields[1], "=") {
+			fields = []string{fields[0] + "=" + fields[1]}
+		}
+
+		for _, field := range fields {
+			key, val, ok := strings.Cut(field, "=")
+			if _, known := keys[key]; !ok || !known {
+				continue
+			}
+
+			v, err := strconv.ParseUint(val, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value of %v in %v: %w", key, filepath.Base(path), err)
+			}
+			res[key] += v
+		}
+	}
+
+	return res, scanner.Err()
+}
+
+func (m *CgroupMonitor) gatherCgroup(dir string, name string) error {
+	if _, err := os.Stat(dir); err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, g := range []struct {
+		file   string
+		metric string
+	}{
+		{"memory.current", CgroupMemoryCurrentMetric},
+		{"memory.max", CgroupMemoryMaxMetric},
+		{"pids.current", CgroupPidsMetric},
+	} {
+		val, ok, err := readCgroupValue(filepath.Join(dir, g.file))
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if err = m.storage.AddOrUpdate(metrics.NewGaugeMetric(g.metric+name, float64(val))); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	for _, s := range []struct {
+		keys map[string]string
+		file string
+	}{
+		{cgroupCPUStatKeys, "cpu.stat"},
+		{cgroupIOStatKeys, "io.stat"},
+	} {
+		stat, err := readCgroupStat(filepath.Join(dir, s.file), s.keys)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		for key, total := range stat {
+			id := s.keys[key] + name
+			delta, ok := m.counters.delta(id, total)
+			if !ok {
+				continue
+			}
+			if err = m.storage.AddOrUpdate(metrics.NewCounterMetric(id, delta)); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
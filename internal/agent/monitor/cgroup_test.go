@@ -0,0 +1,111 @@
+package monitor
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fuzzy-toozy/metrics-service/internal/agent/config"
+	"github.com/fuzzy-toozy/metrics-service/internal/agent/monitor/storage"
+	"github.com/fuzzy-toozy/metrics-service/internal/log"
+	"github.com/fuzzy-toozy/metrics-service/internal/metrics"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_CgroupMonitorSelf(t *testing.T) {
+	root := copyFixture(t, "cgroup")
+	cfg := config.CgroupMonitorConfig{Root: root}
+	m := NewCgroupMonitor(storage.NewCommonMetricsStorage(), log.NewDummyLogger(), filepath.Join("testdata", "proc"), cfg)
+
+	// Counters are reported starting from the second gather.
+	require.NoError(t, m.GatherMetrics())
+	require.Empty(t, metricsByType(m.GetMetricsStorage(), metrics.CounterMetricType))
+
+	gauges := metricsByType(m.GetMetricsStorage(), metrics.GaugeMetricType)
+	require.Len(t, gauges, 3)
+	require.Equal(t, float64(52428800), *gauges[CgroupMemoryCurrentMetric+"self"].Value)
+	require.Equal(t, float64(104857600), *gauges[CgroupMemoryMaxMetric+"self"].Value)
+	require.Equal(t, float64(7), *gauges[CgroupPidsMetric+"self"].Value)
+
+	dir := filepath.Join(root, "system.slice", "app.service")
+	cpuStat := "usage_usec 1500000\nuser_usec 1000000\nsystem_usec 500000\nnr_periods 110\nnr_throttled 7\nthrottled_usec 30000\n"
+	ioStat := "8:0 rbytes=8192 wbytes=8192 rios=2 wios=2 dbytes=0 dios=0\n8:16 rbytes=1024 wbytes=4096 rios=1 wios=1 dbytes=0 dios=0\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "cpu.stat"), []byte(cpuStat), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "io.stat"), []byte(ioStat), 0644))
+
+	require.NoError(t, m.GatherMetrics())
+	counters := metricsByType(m.GetMetricsStorage(), metrics.CounterMetricType)
+	expected := map[string]int64{
+		CgroupCPUUsageMetric + "self":         500000,
+		CgroupCPUUserMetric + "self":          300000,
+		CgroupCPUSystemMetric + "self":        200000,
+		CgroupCPUThrottledMetric + "self":     2,
+		CgroupCPUThrottledTimeMetric + "self": 10000,
+		CgroupIOReadBytesMetric + "self":      4096,
+		CgroupIOWriteBytesMetric + "self":     4096,
+		CgroupIOReadsMetric + "self":          1,
+		CgroupIOWritesMetric + "self":         1,
+	}
+	require.Len(t, counters, len(expected))
+	for id, delta := range expected {
+		require.Equal(t, delta, *counters[id].Delta, id)
+	}
+}
+
+func Test_CgroupMonitorPaths(t *testing.T) {
+	cfg := config.CgroupMonitorConfig{
+		Root:  filepath.Join("testdata", "cgroup"),
+		Paths: []string{"system.slice/app.service", "/system.slice/db.service"},
+	}
+	m := NewCgroupMonitor(storage.NewCommonMetricsStorage(), log.NewDummyLogger(), t.TempDir(), cfg)
+
+	require.NoError(t, m.GatherMetrics())
+	gauges := metricsByType(m.GetMetricsStorage(), metrics.GaugeMetricType)
+	require.Equal(t, float64(7), *gauges[CgroupPidsMetric+"system_slice_app_service"].Value)
+	require.Equal(t, float64(1048576), *gauges[CgroupMemoryCurrentMetric+"system_slice_db_service"].Value)
+	require.Equal(t, float64(2), *gauges[CgroupPidsMetric+"system_slice_db_service"].Value)
+
+	// Unlimited memory isn't reported.
+	_, ok := gauges[CgroupMemoryMaxMetric+"system_slice_db_service"]
+	require.False(t, ok)
+
+	cfg.Paths = []string{"missing.slice"}
+	m = NewCgroupMonitor(storage.NewCommonMetricsStorage(), log.NewDummyLogger(), t.TempDir(), cfg)
+	require.Error(t, m.GatherMetrics())
+
+	// Own cgroup can't be found without procfs.
+	cfg.Paths = nil
+	m = NewCgroupMonitor(storage.NewCommonMetricsStorage(), log.NewDummyLogger(), t.TempDir(), cfg)
+	require.Error(t, m.GatherMetrics())
+}
+
+func Test_CgroupMonitorSkipsFailingCgroup(t *testing.T) {
+	root := copyFixture(t, "cgroup")
+	cfg := config.CgroupMonitorConfig{
+		Root:  root,
+		Paths: []string{"missing.slice", "system.slice/app.service"},
+	}
+	m := NewCgroupMonitor(storage.NewCommonMetricsStorage(), log.NewDummyLogger(), t.TempDir(), cfg)
+
+	// Missing cgroup is reported as error, others are still gathered.
+	require.Error(t, m.GatherMetrics())
+	gauges := metricsByType(m.GetMetricsStorage(), metrics.GaugeMetricType)
+	require.Equal(t, float64(7), *gauges[CgroupPidsMetric+"system_slice_app_service"].Value)
+
+	cpuStat := "usage_usec 1500000\nuser_usec 1000000\nsystem_usec 500000\n"
+	require.NoError(t, os.WriteFile(filepath.Join(root, "system.slice", "app.service", "cpu.stat"), []byte(cpuStat), 0644))
+
+	// Invalid file of cgroup doesn't prevent its other files from being gathered.
+	require.NoError(t, os.WriteFile(filepath.Join(root, "system.slice", "app.service", "memory.current"), []byte("invalid"), 0644))
+
+	require.Error(t, m.GatherMetrics())
+	counters := metricsByType(m.GetMetricsStorage(), metrics.CounterMetricType)
+	require.Equal(t, int64(500000), *counters[CgroupCPUUsageMetric+"system_slice_app_service"].Delta)
+	gauges = metricsByType(m.GetMetricsStorage(), metrics.GaugeMetricType)
+	require.Equal(t, float64(7), *gauges[CgroupPidsMetric+"system_slice_app_service"].Value)
+
+	// Increase is not counted again by the next gather.
+	require.Error(t, m.GatherMetrics())
+	counters = metricsByType(m.GetMetricsStorage(), metrics.CounterMetricType)
+	require.Equal(t, int64(0), *counters[CgroupCPUUsageMetric+"system_slice_app_service"].Delta)
+}
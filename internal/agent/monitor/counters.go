@@ -0,0 +1,112 @@
+package monitor
+
+import (
+	"path"
+	"strings"
+	"time"
+)
+
+// namedValue value of metric named by name.
+type namedValue struct {
+	name string
+	val  uint64
+}
+
+// counterTracker converts cumulative totals read from system to deltas reported as counters.
+// Totals are remembered as soon as their deltas are taken, so failed gathers never count increase twice.
+type counterTracker struct {
+	prev map[string]uint64
+	seen map[string]struct{}
+}
+
+func newCounterTracker() *counterTracker {
+	return &counterTracker{prev: make(map[string]uint64), seen: make(map[string]struct{})}
+}
+
+// delta returns increase of total since it was observed last time.
+// Nothing is reported for the first observation, total is reported if it was reset.
+func (c *counterTracker) delta(key string, total uint64) (int64, bool) {
+	c.seen[key] = struct{}{}
+	prev, ok := c.prev[key]
+	c.prev[key] = total
+	if !ok {
+		return 0, false
+	}
+
+	if total < prev {
+		return int64(total), true
+	}
+
+	return int64(total - prev), true
+}
+
+// commit finishes gather, totals not observed since previous commit are forgotten.
+func (c *counterTracker) commit() {
+	for key := range c.prev {
+		if _, ok := c.seen[key]; !ok {
+			delete(c.prev, key)
+		}
+	}
+	c.seen = make(map[string]struct{}, len(c.prev))
+}
+
+// metricSuffix converts name of mountpoint, device, etc. to metric name part.
+func metricSuffix(name string) string {
+	res := strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, name)
+
+	res = strings.Trim(res, "_")
+	if len(res) == 0 {
+		return "root"
+	}
+
+	return res
+}
+
+// stringFilter matches values by include and exclude lists of glob patterns.
+// Empty include list matches all values not excluded.
+type stringFilter struct {
+	include []string
+	exclude []string
+}
+
+func newStringFilter(include, exclude []string) stringFilter {
+	return stringFilter{include: include, exclude: exclude}
+}
+
+func matchesAny(patterns []string, val string) bool {
+	for _, p := range patterns {
+		if ok, err := path.Match(p, val); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (f stringFilter) match(val string) bool {
+	if matchesAny(f.exclude, val) {
+		return false
+	}
+
+	return len(f.include) == 0 || matchesAny(f.include, val)
+}
+
+// intervalSchedule schedules task with its own interval checked on every gather.
+// Task with zero interval is run on every gather.
+type intervalSchedule struct {
+	interval time.Duration
+	lastRun  time.Time
+}
+
+// due reports whether task should run at now and if so marks it run.
+func (s *intervalSchedule) due(now time.Time) bool {
+	if !s.lastRun.IsZero() && now.Sub(s.lastRun) < s.interval {
+		return false
+	}
+	s.lastRun = now
+	return true
+}
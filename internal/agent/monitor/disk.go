@@ -0,0 +1,155 @@
+package monitor
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/fuzzy-toozy/metrics-service/internal/agent/config"
+	"github.com/fuzzy-toozy/metrics-service/internal/agent/monitor/storage"
+	"github.com/fuzzy-toozy/metrics-service/internal/log"
+	"github.com/fuzzy-toozy/metrics-service/internal/metrics"
+	"github.com/shirou/gopsutil/disk"
+)
+
+// Disk metrics names prefixes, suffix is mountpoint or device name.
+const (
+	DiskTotalMetric      = "DiskTotal_"
+	DiskUsedMetric       = "DiskUsed_"
+	DiskFreeMetric       = "DiskFree_"
+	DiskInodesUsedMetric = "DiskInodesUsed_"
+	DiskInodesFreeMetric = "DiskInodesFree_"
+	DiskReadBytesMetric  = "DiskReadBytes_"
+	DiskWriteBytesMetric = "DiskWriteBytes_"
+	DiskReadOpsMetric    = "DiskReadOps_"
+	DiskWriteOpsMetric   = "DiskWriteOps_"
+)
+
+// DiskStatsSource provides filesystems usage and block devices IO statistics.
+type DiskStatsSource interface {
+	Partitions() ([]disk.PartitionStat, error)
+	Usage(mountpoint string) (*disk.UsageStat, error)
+	IOCounters() (map[string]disk.IOCountersStat, error)
+}
+
+// PsDiskStatsSource reads statistics of local system.
+type PsDiskStatsSource struct{}
+
+func (PsDiskStatsSource) Partitions() ([]disk.PartitionStat, error) {
+	return disk.Partitions(true)
+}
+
+func (PsDiskStatsSource) Usage(mountpoint string) (*disk.UsageStat, error) {
+	return disk.Usage(mountpoint)
+}
+
+func (PsDiskStatsSource) IOCounters() (map[string]disk.IOCountersStat, error) {
+	return disk.IOCounters()
+}
+
+// DiskMonitor per-mountpoint usage and per-device IO metrics of filesystems passing type filters.
+// IO totals are reported as counters of increase since previous gather.
+type DiskMonitor struct {
+	storage  storage.MetricsStorage
+	log      log.Logger
+	source   DiskStatsSource
+	counters *counterTracker
+	fsFilter stringFilter
+}
+
+func NewDiskMonitor(s storage.MetricsStorage, l log.Logger, source DiskStatsSource, cfg config.DiskMonitorConfig) *DiskMonitor {
+	return &DiskMonitor{
+		storage:  s,
+		log:      l,
+		source:   source,
+		counters: newCounterTracker(),
+		fsFilter: newStringFilter(cfg.IncludeFSTypes, cfg.ExcludeFSTypes),
+	}
+}
+
+// GetMetricsStorage return underlying metrics storage.
+func (m *DiskMonitor) GetMetricsStorage() storage.MetricsStorage {
+	return m.storage
+}
+
+// deviceName returns name of partition's device as it's named in IO statistics.
+func deviceName(device string) string {
+	if path, err := filepath.EvalSymlinks(device); err == nil {
+		device = path
+	}
+	return filepath.Base(device)
+}
+
+// GatherMetrics fetches filesystems usage and devices IO statistics and add them to storage.
+func (m *DiskMonitor) GatherMetrics() error {
+	m.storage.Clear()
+
+	partitions, err := m.source.Partitions()
+	if err != nil {
+		return fmt.Errorf("failed to get partitions: %w", err)
+	}
+
+	mounts := make(map[string]struct{})
+	devices := make(map[string]struct{})
+	for _, p := range partitions {
+		if !m.fsFilter.match(p.Fstype) {
+			continue
+		}
+
+		if _, ok := mounts[p.Mountpoint]; ok {
+			continue
+		}
+		mounts[p.Mountpoint] = struct{}{}
+		devices[deviceName(p.Device)] = struct{}{}
+
+		usage, err := m.source.Usage(p.Mountpoint)
+		if err != nil {
+			m.log.Debugf("Failed to get usage of %v: %v", p.Mountpoint, err)
+			continue
+		}
+
+		suffix := metricSuffix(p.Mountpoint)
+		values := []namedValue{
+			{DiskTotalMetric, usage.Total},
+			{DiskUsedMetric, usage.Used},
+			{DiskFreeMetric, usage.Free},
+			{DiskInodesUsedMetric, usage.InodesUsed},
+			{DiskInodesFreeMetric, usage.InodesFree},
+		}
+		for _, v := range values {
+			if err = m.storage.AddOrUpdate(metrics.NewGaugeMetric(v.name+suffix, float64(v.val))); err != nil {
+				return err
+			}
+		}
+	}
+
+	ioStats, err := m.source.IOCounters()
+	if err != nil {
+		return fmt.Errorf("failed to get IO counters: %w", err)
+	}
+
+	for name, stat := range ioStats {
+		if _, ok := devices[name]; !ok {
+			continue
+		}
+
+		suffix := metricSuffix(name)
+		values := []namedValue{
+			{DiskReadBytesMetric, stat.ReadBytes},
+			{DiskWriteBytesMetric, stat.WriteBytes},
+			{DiskReadOpsMetric, stat.ReadCount},
+			{DiskWriteOpsMetric, stat.WriteCount},
+		}
+		for _, v := range values {
+			delta, ok := m.counters.delta(v.name+suffix, v.val)
+			if !ok {
+				continue
+			}
+			if err = m.storage.AddOrUpdate(metrics.NewCounterMetric(v.name+suffix, delta)); err != nil {
+				return err
+			}
+		}
+	}
+	m.counters.commit()
+
+	return nil
+}
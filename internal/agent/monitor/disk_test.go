@@ -0,0 +1,106 @@
+package monitor
+
+import (
+	"testing"
+
+	"github.com/fuzzy-toozy/metrics-service/internal/agent/config"
+	"github.com/fuzzy-toozy/metrics-service/internal/agent/monitor/storage"
+	"github.com/fuzzy-toozy/metrics-service/internal/log"
+	"github.com/fuzzy-toozy/metrics-service/internal/metrics"
+	"github.com/shirou/gopsutil/disk"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeDiskStatsSource struct {
+	partitions []disk.PartitionStat
+	usage      map[string]disk.UsageStat
+	io         map[string]disk.IOCountersStat
+}
+
+func (s *fakeDiskStatsSource) Partitions() ([]disk.PartitionStat, error) {
+	return s.partitions, nil
+}
+
+func (s *fakeDiskStatsSource) Usage(mountpoint string) (*disk.UsageStat, error) {
+	u := s.usage[mountpoint]
+	return &u, nil
+}
+
+func (s *fakeDiskStatsSource) IOCounters() (map[string]disk.IOCountersStat, error) {
+	return s.io, nil
+}
+
+func Test_DiskMonitor(t *testing.T) {
+	source := &fakeDiskStatsSource{
+		partitions: []disk.PartitionStat{
+			{Device: "/dev/sda1", Mountpoint: "/", Fstype: "ext4"},
+			{Device: "/dev/sdb1", Mountpoint: "/var/lib/data", Fstype: "xfs"},
+			{Device: "tmpfs", Mountpoint: "/run", Fstype: "tmpfs"},
+			{Device: "proc", Mountpoint: "/proc", Fstype: "proc"},
+		},
+		usage: map[string]disk.UsageStat{
+			"/":             {Total: 100, Used: 60, Free: 40, InodesUsed: 10, InodesFree: 90},
+			"/var/lib/data": {Total: 200, Used: 50, Free: 150, InodesUsed: 5, InodesFree: 195},
+			"/run":          {Total: 10, Used: 1, Free: 9},
+		},
+		io: map[string]disk.IOCountersStat{
+			"sda1":  {ReadBytes: 1000, WriteBytes: 2000, ReadCount: 10, WriteCount: 20},
+			"sdb1":  {ReadBytes: 500, WriteBytes: 500, ReadCount: 5, WriteCount: 5},
+			"loop0": {ReadBytes: 1},
+		},
+	}
+
+	cfg := config.DiskMonitorConfig{ExcludeFSTypes: []string{"tmpfs", "proc"}}
+	m := NewDiskMonitor(storage.NewCommonMetricsStorage(), log.NewDummyLogger(), source, cfg)
+
+	// IO counters are reported starting from the second gather.
+	require.NoError(t, m.GatherMetrics())
+	require.ElementsMatch(t, []metrics.Metric{
+		metrics.NewGaugeMetric(DiskTotalMetric+"root", 100),
+		metrics.NewGaugeMetric(DiskUsedMetric+"root", 60),
+		metrics.NewGaugeMetric(DiskFreeMetric+"root", 40),
+		metrics.NewGaugeMetric(DiskInodesUsedMetric+"root", 10),
+		metrics.NewGaugeMetric(DiskInodesFreeMetric+"root", 90),
+		metrics.NewGaugeMetric(DiskTotalMetric+"var_lib_data", 200),
+		metrics.NewGaugeMetric(DiskUsedMetric+"var_lib_data", 50),
+		metrics.NewGaugeMetric(DiskFreeMetric+"var_lib_data", 150),
+		metrics.NewGaugeMetric(DiskInodesUsedMetric+"var_lib_data", 5),
+		metrics.NewGaugeMetric(DiskInodesFreeMetric+"var_lib_data", 195),
+	}, []metrics.Metric(m.GetMetricsStorage().GetAllMetrics()))
+
+	source.io["sda1"] = disk.IOCountersStat{ReadBytes: 1500, WriteBytes: 2000, ReadCount: 15, WriteCount: 20}
+	// Reset counter is reported from zero.
+	source.io["sdb1"] = disk.IOCountersStat{ReadBytes: 100, WriteBytes: 600, ReadCount: 1, WriteCount: 6}
+
+	require.NoError(t, m.GatherMetrics())
+	counters := make([]metrics.Metric, 0)
+	for _, met := range m.GetMetricsStorage().GetAllMetrics() {
+		if met.MType == metrics.CounterMetricType {
+			counters = append(counters, met)
+		}
+	}
+	require.ElementsMatch(t, []metrics.Metric{
+		metrics.NewCounterMetric(DiskReadBytesMetric+"sda1", 500),
+		metrics.NewCounterMetric(DiskWriteBytesMetric+"sda1", 0),
+		metrics.NewCounterMetric(DiskReadOpsMetric+"sda1", 5),
+		metrics.NewCounterMetric(DiskWriteOpsMetric+"sda1", 0),
+		metrics.NewCounterMetric(DiskReadBytesMetric+"sdb1", 100),
+		metrics.NewCounterMetric(DiskWriteBytesMetric+"sdb1", 100),
+		metrics.NewCounterMetric(DiskReadOpsMetric+"sdb1", 1),
+		metrics.NewCounterMetric(DiskWriteOpsMetric+"sdb1", 1),
+	}, counters)
+
+	// Only included filesystem types are monitored.
+	cfg = config.DiskMonitorConfig{IncludeFSTypes: []string{"tmpfs"}}
+	m = NewDiskMonitor(storage.NewCommonMetricsStorage(), log.NewDummyLogger(), source, cfg)
+	require.NoError(t, m.GatherMetrics())
+	for _, met := range m.GetMetricsStorage().GetAllMetrics() {
+		require.Contains(t, met.ID, "run")
+	}
+	require.Len(t, m.GetMetricsStorage().GetAllMetrics(), 5)
+}
+
+func Test_DiskMonitorLocal(t *testing.T) {
+	m := NewDiskMonitor(storage.NewCommonMetricsStorage(), log.NewDummyLogger(), PsDiskStatsSource{}, config.DiskMonitorConfig{})
+	require.NoError(t, m.GatherMetrics())
+}
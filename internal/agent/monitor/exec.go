@@ -0,0 +1,220 @@
+package monitor
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fuzzy-toozy/metrics-service/internal/agent/config"
+	"github.com/fuzzy-toozy/metrics-service/internal/agent/monitor/storage"
+	"github.com/fuzzy-toozy/metrics-service/internal/log"
+	"github.com/fuzzy-toozy/metrics-service/internal/metrics"
+)
+
+// Exec self-metrics names prefixes, suffix is command name.
+const (
+	ExecDurationMetric = "ExecDurationSeconds_"
+	ExecFailuresMetric = "ExecFailures_"
+)
+
+const (
+	defaultExecTimeout = 10 * time.Second
+	// execWaitDelay time to wait for output of processes started by killed command.
+	execWaitDelay = time.Second
+	// execMaxStderr max length of command stderr included in error.
+	execMaxStderr = 256
+)
+
+type execCommand struct {
+	cfg      config.ExecCommandConfig
+	suffix   string
+	schedule intervalSchedule
+}
+
+type execResult struct {
+	cmd      *execCommand
+	metrics  []metrics.Metric
+	duration time.Duration
+	err      error
+}
+
+// ExecMonitor metrics produced by external commands run on their own intervals.
+// Command output is either "name type value" lines or JSON array of metrics.
+// Duration of every run is reported as gauge and failed runs as counter,
+// metrics of failed or timed out runs are discarded.
+type ExecMonitor struct {
+	storage  storage.MetricsStorage
+	log      log.Logger
+	now      func() time.Time
+	commands []execCommand
+}
+
+func NewExecMonitor(s storage.MetricsStorage, l log.Logger, cfg config.ExecMonitorConfig) (*ExecMonitor, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	m := ExecMonitor{storage: s, log: l, now: time.Now}
+	for _, c := range cfg.Commands {
+		if c.Timeout.D == 0 {
+			c.Timeout.D = defaultExecTimeout
+		}
+		if len(c.Format) == 0 {
+			c.Format = config.ExecFormatText
+		}
+		m.commands = append(m.commands, execCommand{cfg: c, suffix: metricSuffix(c.Name),
+			schedule: intervalSchedule{interval: c.Interval.D}})
+	}
+
+	return &m, nil
+}
+
+// GetMetricsStorage return underlying metrics storage.
+func (m *ExecMonitor) GetMetricsStorage() storage.MetricsStorage {
+	return m.storage
+}
+
+// GatherMetrics concurrently runs commands which interval has elapsed and adds their metrics to storage.
+func (m *ExecMonitor) GatherMetrics() error {
+	m.storage.Clear()
+
+	now := m.now()
+	due := make([]*execCommand, 0, len(m.commands))
+	for i := range m.commands {
+		if c := &m.commands[i]; c.schedule.due(now) {
+			due = append(due, c)
+		}
+	}
+
+	results := make([]execResult, len(due))
+	wg := sync.WaitGroup{}
+	wg.Add(len(due))
+	for i, c := range due {
+		i, c := i, c
+		go func() {
+			defer wg.Done()
+			results[i] = c.run()
+		}()
+	}
+	wg.Wait()
+
+	for _, r := range results {
+		var failures int64
+		if r.err != nil {
+			m.log.Warnf("Exec command %v failed: %v", r.cmd.cfg.Name, r.err)
+			failures = 1
+		}
+
+		values := append(r.metrics,
+			metrics.NewGaugeMetric(ExecDurationMetric+r.cmd.suffix, r.duration.Seconds()),
+			metrics.NewCounterMetric(ExecFailuresMetric+r.cmd.suffix, failures))
+		for _, met := range values {
+			if err := m.storage.AddOrUpdate(met); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (c *execCommand) run() execResult {
+	res := execResult{cmd: c}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.cfg.Timeout.D)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, c.cfg.Command[0], c.cfg.Command[1:]...)
+	cmd.WaitDelay = execWaitDelay
+
+	start := time.Now()
+	out, err := cmd.Output()
+	res.duration = time.Since(start)
+
+	if ctx.Err() != nil {
+		res.err = fmt.Errorf("timed out after %v", c.cfg.Timeout.D)
+		return res
+	}
+
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			stderr := strings.TrimSpace(string(exitErr.Stderr))
+			if len(stderr) > execMaxStderr {
+				stderr = stderr[:execMaxStderr]
+			}
+			err = fmt.Errorf("%w: %v", err, stderr)
+		}
+		res.err = err
+		return res
+	}
+
+	if c.cfg.Format == config.ExecFormatJSON {
+		res.metrics, res.err = parseExecJSON(out)
+	} else {
+		res.metrics, res.err = parseExecText(out)
+	}
+	if res.err != nil {
+		res.metrics = nil
+	}
+
+	return res
+}
+
+// parseExecText parses "name type value" lines, empty lines and lines starting with # are skipped.
+func parseExecText(data []byte) ([]metrics.Metric, error) {
+	var res []metrics.Metric
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if len(text) == 0 || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("invalid output line %v: expected 'name type value'", line)
+		}
+
+		met, err := metrics.NewMetric(fields[0], fields[2], strings.ToLower(fields[1]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid output line %v: %w", line, err)
+		}
+		res = append(res, met)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read output: %w", err)
+	}
+
+	return res, nil
+}
+
+// parseExecJSON parses JSON array of metrics.
+func parseExecJSON(data []byte) ([]metrics.Metric, error) {
+	var res []metrics.Metric
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil, fmt.Errorf("invalid JSON output: %w", err)
+	}
+
+	for i := range res {
+		met := &res[i]
+		met.MType = strings.ToLower(met.MType)
+		if len(met.ID) == 0 {
+			return nil, fmt.Errorf("invalid JSON output: metric without id")
+		}
+		if _, err := met.GetData(); err != nil {
+			return nil, fmt.Errorf("invalid JSON output: %w", err)
+		}
+	}
+
+	return res, nil
+}
@@ -0,0 +1,104 @@
+package monitor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fuzzy-toozy/metrics-service/internal/agent/config"
+	"github.com/fuzzy-toozy/metrics-service/internal/agent/monitor/storage"
+	"github.com/fuzzy-toozy/metrics-service/internal/log"
+	"github.com/fuzzy-toozy/metrics-service/internal/metrics"
+	"github.com/stretchr/testify/require"
+)
+
+func shellCommand(name string, script string) config.ExecCommandConfig {
+	return config.ExecCommandConfig{Name: name, Command: []string{"sh", "-c", script}}
+}
+
+func Test_ExecMonitor(t *testing.T) {
+	text := shellCommand("text", "echo '# comment'; echo; echo 'queue_size gauge 12.5'; echo 'jobs_done Counter 3'")
+	jsonCmd := shellCommand("json", `echo '[{"id":"backups","type":"gauge","value":2},{"id":"errors","type":"counter","delta":5}]'`)
+	jsonCmd.Format = config.ExecFormatJSON
+	failed := shellCommand("failed", "echo 'partial gauge 1'; echo 'broken' >&2; exit 2")
+	invalid := shellCommand("invalid", "echo 'good gauge 1'; echo 'bad gauge abc'")
+	slow := shellCommand("slow", "sleep 5; echo 'late gauge 1'")
+	slow.Timeout.D = 100 * time.Millisecond
+
+	cfg := config.ExecMonitorConfig{Commands: []config.ExecCommandConfig{text, jsonCmd, failed, invalid, slow}}
+	m, err := NewExecMonitor(storage.NewCommonMetricsStorage(), log.NewDummyLogger(), cfg)
+	require.NoError(t, err)
+
+	start := time.Now()
+	require.NoError(t, m.GatherMetrics())
+	require.Less(t, time.Since(start), 3*time.Second)
+
+	gauges := metricsByType(m.GetMetricsStorage(), metrics.GaugeMetricType)
+	counters := metricsByType(m.GetMetricsStorage(), metrics.CounterMetricType)
+
+	require.Equal(t, 12.5, *gauges["queue_size"].Value)
+	require.Equal(t, int64(3), *counters["jobs_done"].Delta)
+	require.Equal(t, 2.0, *gauges["backups"].Value)
+	require.Equal(t, int64(5), *counters["errors"].Delta)
+
+	// Output of failed runs is discarded.
+	require.NotContains(t, gauges, "partial")
+	require.NotContains(t, gauges, "good")
+	require.NotContains(t, gauges, "late")
+
+	expectedFailures := map[string]int64{"text": 0, "json": 0, "failed": 1, "invalid": 1, "slow": 1}
+	for name, failures := range expectedFailures {
+		require.Equal(t, failures, *counters[ExecFailuresMetric+name].Delta, name)
+		require.Contains(t, gauges, ExecDurationMetric+name)
+	}
+	require.GreaterOrEqual(t, *gauges[ExecDurationMetric+"slow"].Value, 0.1)
+}
+
+func Test_ExecMonitorInterval(t *testing.T) {
+	every := shellCommand("every", "echo 'every gauge 1'")
+	rare := shellCommand("rare", "echo 'rare gauge 1'")
+	rare.Interval.D = time.Minute
+
+	cfg := config.ExecMonitorConfig{Commands: []config.ExecCommandConfig{every, rare}}
+	m, err := NewExecMonitor(storage.NewCommonMetricsStorage(), log.NewDummyLogger(), cfg)
+	require.NoError(t, err)
+
+	now := time.Now()
+	m.now = func() time.Time { return now }
+
+	require.NoError(t, m.GatherMetrics())
+	gauges := metricsByType(m.GetMetricsStorage(), metrics.GaugeMetricType)
+	require.Contains(t, gauges, "every")
+	require.Contains(t, gauges, "rare")
+
+	now = now.Add(30 * time.Second)
+	require.NoError(t, m.GatherMetrics())
+	gauges = metricsByType(m.GetMetricsStorage(), metrics.GaugeMetricType)
+	require.Contains(t, gauges, "every")
+	require.NotContains(t, gauges, "rare")
+	require.NotContains(t, gauges, ExecDurationMetric+"rare")
+
+	now = now.Add(30 * time.Second)
+	require.NoError(t, m.GatherMetrics())
+	gauges = metricsByType(m.GetMetricsStorage(), metrics.GaugeMetricType)
+	require.Contains(t, gauges, "rare")
+}
+
+func Test_ExecMonitorConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		commands []config.ExecCommandConfig
+	}{
+		{"empty name", []config.ExecCommandConfig{{Command: []string{"true"}}}},
+		{"duplicate", []config.ExecCommandConfig{shellCommand("a", "true"), shellCommand("a", "true")}},
+		{"no command", []config.ExecCommandConfig{{Name: "a"}}},
+		{"invalid format", []config.ExecCommandConfig{{Name: "a", Command: []string{"true"}, Format: "xml"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewExecMonitor(storage.NewCommonMetricsStorage(), log.NewDummyLogger(),
+				config.ExecMonitorConfig{Commands: tt.commands})
+			require.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package monitor
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/fuzzy-toozy/metrics-service/internal/agent/config"
+	"github.com/fuzzy-toozy/metrics-service/internal/agent/monitor/storage"
+	"github.com/fuzzy-toozy/metrics-service/internal/log"
+	"github.com/fuzzy-toozy/metrics-service/internal/metrics"
+)
+
+// HTTP probe metrics names prefixes, suffix is target name.
+const (
+	HTTPUpMetric             = "HTTPUp_"
+	HTTPStatusCodeMetric     = "HTTPStatusCode_"
+	HTTPLatencyMetric        = "HTTPLatencySeconds_"
+	HTTPResponseSizeMetric   = "HTTPResponseSize_"
+	HTTPCertExpiryDaysMetric = "HTTPCertExpiryDays_"
+)
+
+const defaultHTTPProbeTimeout = 5 * time.Second
+
+type httpTarget struct {
+	cfg      config.HTTPProbeConfig
+	suffix   string
+	client   *http.Client
+	schedule intervalSchedule
+}
+
+type httpProbeResult struct {
+	target *httpTarget
+	// latency time until response headers received.
+	latency time.Duration
+	// certExpiry expiry of server's certificate, zero if TLS isn't used.
+	certExpiry time.Time
+	size       int64
+	status     int
+	err        error
+}
+
+// HTTPMonitor availability of HTTP endpoints probed on their own intervals.
+// Target is up if it responded with expected status,
+// status code and response size are reported only if response was received.
+type HTTPMonitor struct {
+	storage storage.MetricsStorage
+	log     log.Logger
+	now     func() time.Time
+	targets []httpTarget
+}
+
+func NewHTTPMonitor(s storage.MetricsStorage, l log.Logger, cfg config.HTTPMonitorConfig) (*HTTPMonitor, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	m := HTTPMonitor{storage: s, log: l, now: time.Now}
+	for _, t := range cfg.Targets {
+		if t.Timeout.D == 0 {
+			t.Timeout.D = defaultHTTPProbeTimeout
+		}
+		if len(t.Method) == 0 {
+			t.Method = http.MethodGet
+		}
+
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		// Connections aren't reused so every probe measures connection establishment.
+		transport.DisableKeepAlives = true
+		if t.InsecureSkipVerify {
+			transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		}
+
+		client := &http.Client{
+			Transport: transport,
+			Timeout:   t.Timeout.D,
+			// Redirects aren't followed, so status, latency and certificate are of probed URL itself.
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+
+		m.targets = append(m.targets, httpTarget{
+			cfg:      t,
+			suffix:   metricSuffix(t.Name),
+			client:   client,
+			schedule: intervalSchedule{interval: t.Interval.D},
+		})
+	}
+
+	return &m, nil
+}
+
+// GetMetricsStorage return underlying metrics storage.
+func (m *HTTPMonitor) GetMetricsStorage() storage.MetricsStorage {
+	return m.storage
+}
+
+// GatherMetrics concurrently probes targets which interval has elapsed and adds results to storage.
+func (m *HTTPMonitor) GatherMetrics() error {
+	m.storage.Clear()
+
+	now := m.now()
+	due := make([]*httpTarget, 0, len(m.targets))
+	for i := range m.targets {
+		if t := &m.targets[i]; t.schedule.due(now) {
+			due = append(due, t)
+		}
+	}
+
+	results := make([]httpProbeResult, len(due))
+	wg := sync.WaitGroup{}
+	wg.Add(len(due))
+	for i, t := range due {
+		i, t := i, t
+		go func() {
+			defer wg.Done()
+			results[i] = t.probe()
+		}()
+	}
+	wg.Wait()
+
+	for _, r := range results {
+		suffix := r.target.suffix
+		up := 0.0
+		if r.err != nil {
+			m.log.Debugf("HTTP target %v is down: %v", r.target.cfg.Name, r.err)
+		} else if r.target.expected(r.status) {
+			up = 1
+		}
+
+		values := []metrics.Metric{
+			metrics.NewGaugeMetric(HTTPUpMetric+suffix, up),
+			metrics.NewGaugeMetric(HTTPLatencyMetric+suffix, r.latency.Seconds()),
+		}
+
+		if r.status != 0 {
+			values = append(values,
+				metrics.NewGaugeMetric(HTTPStatusCodeMetric+suffix, float64(r.status)),
+				metrics.NewGaugeMetric(HTTPResponseSizeMetric+suffix, float64(r.size)))
+		}
+
+		if !r.certExpiry.IsZero() {
+			const day = 24 * time.Hour
+			days := r.certExpiry.Sub(now).Hours() / day.Hours()
+			values = append(values, metrics.NewGaugeMetric(HTTPCertExpiryDaysMetric+suffix, days))
+		}
+
+		for _, met := range values {
+			if err := m.storage.AddOrUpdate(met); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// expected checks whether status means target is up.
+func (t *httpTarget) expected(status int) bool {
+	if len(t.cfg.ExpectedStatus) == 0 {
+		return status >= http.StatusOK && status < http.StatusBadRequest
+	}
+
+	for _, s := range t.cfg.ExpectedStatus {
+		if s == status {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (t *httpTarget) probe() httpProbeResult {
+	res := httpProbeResult{target: t}
+
+	req, err := http.NewRequest(t.cfg.Method, t.cfg.URL, nil)
+	if err != nil {
+		res.err = fmt.Errorf("failed to create request: %w", err)
+		return res
+	}
+
+	start := time.Now()
+	resp, err := t.client.Do(req)
+	res.latency = time.Since(start)
+	if err != nil {
+		res.err = err
+		return res
+	}
+	defer resp.Body.Close()
+
+	if resp.TLS != nil && len(resp.TLS.PeerCertificates) > 0 {
+		// The first certificate is the server's one.
+		res.certExpiry = resp.TLS.PeerCertificates[0].NotAfter
+	}
+
+	res.size, err = io.Copy(io.Discard, resp.Body)
+	if err != nil {
+		res.err = fmt.Errorf("failed to read response: %w", err)
+		return res
+	}
+	res.status = resp.StatusCode
+
+	return res
+}
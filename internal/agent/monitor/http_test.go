@@ -0,0 +1,135 @@
+package monitor
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fuzzy-toozy/metrics-service/internal/agent/config"
+	"github.com/fuzzy-toozy/metrics-service/internal/agent/monitor/storage"
+	commonConfig "github.com/fuzzy-toozy/metrics-service/internal/config"
+	"github.com/fuzzy-toozy/metrics-service/internal/log"
+	"github.com/fuzzy-toozy/metrics-service/internal/metrics"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_HTTPMonitor(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("hello"))
+	})
+	mux.HandleFunc("/fail", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "fail", http.StatusServiceUnavailable)
+	})
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/fail", http.StatusFound)
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(5 * time.Second):
+		case <-r.Context().Done():
+		}
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	tlsSrv := httptest.NewTLSServer(mux)
+	defer tlsSrv.Close()
+
+	// Listener closed to get address nothing listens on.
+	closed := httptest.NewServer(mux)
+	closed.Close()
+
+	cfg := config.HTTPMonitorConfig{Targets: []config.HTTPProbeConfig{
+		{Name: "ok", URL: srv.URL + "/ok"},
+		{Name: "fail", URL: srv.URL + "/fail"},
+		{Name: "expected", URL: srv.URL + "/fail", ExpectedStatus: []int{http.StatusServiceUnavailable}},
+		{Name: "redirect", URL: srv.URL + "/redirect"},
+		{Name: "slow", URL: srv.URL + "/slow", Timeout: commonConfig.DurationOption{D: 100 * time.Millisecond}},
+		{Name: "closed", URL: closed.URL + "/ok"},
+		{Name: "tls", URL: tlsSrv.URL + "/ok", InsecureSkipVerify: true},
+		{Name: "untrusted", URL: tlsSrv.URL + "/ok"},
+	}}
+
+	m, err := NewHTTPMonitor(storage.NewCommonMetricsStorage(), log.NewDummyLogger(), cfg)
+	require.NoError(t, err)
+
+	start := time.Now()
+	require.NoError(t, m.GatherMetrics())
+	require.Less(t, time.Since(start), 3*time.Second)
+
+	gauges := metricsByType(m.GetMetricsStorage(), metrics.GaugeMetricType)
+	require.Empty(t, metricsByType(m.GetMetricsStorage(), metrics.CounterMetricType))
+
+	expectedUp := map[string]float64{"ok": 1, "fail": 0, "expected": 1, "redirect": 1, "slow": 0, "closed": 0, "tls": 1, "untrusted": 0}
+	for name, up := range expectedUp {
+		require.Equal(t, up, *gauges[HTTPUpMetric+name].Value, name)
+		require.Contains(t, gauges, HTTPLatencyMetric+name)
+	}
+
+	require.Equal(t, float64(http.StatusOK), *gauges[HTTPStatusCodeMetric+"ok"].Value)
+	require.Equal(t, float64(len("hello")), *gauges[HTTPResponseSizeMetric+"ok"].Value)
+	require.Equal(t, float64(http.StatusServiceUnavailable), *gauges[HTTPStatusCodeMetric+"fail"].Value)
+	// Redirect isn't followed.
+	require.Equal(t, float64(http.StatusFound), *gauges[HTTPStatusCodeMetric+"redirect"].Value)
+
+	// No response received.
+	for _, name := range []string{"slow", "closed", "untrusted"} {
+		require.NotContains(t, gauges, HTTPStatusCodeMetric+name)
+		require.NotContains(t, gauges, HTTPResponseSizeMetric+name)
+	}
+
+	require.NotContains(t, gauges, HTTPCertExpiryDaysMetric+"ok")
+	expiry := tlsSrv.Certificate().NotAfter
+	require.InDelta(t, time.Until(expiry).Hours()/24, *gauges[HTTPCertExpiryDaysMetric+"tls"].Value, 1)
+}
+
+func Test_HTTPMonitorInterval(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer srv.Close()
+
+	cfg := config.HTTPMonitorConfig{Targets: []config.HTTPProbeConfig{
+		{Name: "target", URL: srv.URL, Interval: commonConfig.DurationOption{D: time.Minute}},
+	}}
+	m, err := NewHTTPMonitor(storage.NewCommonMetricsStorage(), log.NewDummyLogger(), cfg)
+	require.NoError(t, err)
+
+	now := time.Now()
+	m.now = func() time.Time { return now }
+
+	require.NoError(t, m.GatherMetrics())
+	require.Equal(t, 1, requests)
+
+	now = now.Add(30 * time.Second)
+	require.NoError(t, m.GatherMetrics())
+	require.Equal(t, 1, requests)
+	require.Empty(t, m.GetMetricsStorage().GetAllMetrics())
+
+	now = now.Add(30 * time.Second)
+	require.NoError(t, m.GatherMetrics())
+	require.Equal(t, 2, requests)
+}
+
+func Test_HTTPMonitorConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		targets []config.HTTPProbeConfig
+	}{
+		{"empty name", []config.HTTPProbeConfig{{URL: "http://localhost"}}},
+		{"duplicate", []config.HTTPProbeConfig{{Name: "a", URL: "http://localhost"}, {Name: "a", URL: "http://localhost"}}},
+		{"invalid scheme", []config.HTTPProbeConfig{{Name: "a", URL: "ftp://localhost"}}},
+		{"no url", []config.HTTPProbeConfig{{Name: "a"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewHTTPMonitor(storage.NewCommonMetricsStorage(), log.NewDummyLogger(),
+				config.HTTPMonitorConfig{Targets: tt.targets})
+			require.Error(t, err)
+		})
+	}
+}
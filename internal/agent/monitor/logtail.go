@@ -0,0 +1,410 @@
+package monitor
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/fuzzy-toozy/metrics-service/internal/agent/config"
+	"github.com/fuzzy-toozy/metrics-service/internal/agent/monitor/storage"
+	"github.com/fuzzy-toozy/metrics-service/internal/log"
+	"github.com/fuzzy-toozy/metrics-service/internal/metrics"
+)
+
+// Log metrics names prefixes, suffix is rule name followed by capture name for values.
+// Histogram buckets are reported as LogValueBucket_<rule>_<capture>{le="<bound>"}.
+const (
+	LogMatchesMetric     = "LogMatches_"
+	LogValueMinMetric    = "LogValueMin_"
+	LogValueMaxMetric    = "LogValueMax_"
+	LogValueAvgMetric    = "LogValueAvg_"
+	LogValueBucketMetric = "LogValueBucket_"
+)
+
+const (
+	// logFingerprintSize max amount of bytes at the beginning of file identifying it in persisted offsets.
+	logFingerprintSize = 1024
+	// logMaxLineSize max length of line, longer lines are split.
+	logMaxLineSize = 1024 * 1024
+)
+
+// logOffset persisted read position of log file.
+type logOffset struct {
+	Offset int64 `json:"offset"`
+	// Fingerprint checksum of the first FingerprintSize bytes of file,
+	// detects files replaced while agent wasn't running.
+	Fingerprint     uint32 `json:"fingerprint"`
+	FingerprintSize int    `json:"fingerprint_size"`
+}
+
+// logValueStats numeric values of capture matched since previous gather.
+type logValueStats struct {
+	min   float64
+	max   float64
+	sum   float64
+	count int64
+	// buckets counts of values less than or equal to bucket bounds.
+	buckets []int64
+}
+
+type logRule struct {
+	cfg     config.LogRuleConfig
+	regex   *regexp.Regexp
+	suffix  string
+	matches int64
+	// values stats of numeric captures by capture index.
+	values map[int]*logValueStats
+}
+
+func (r *logRule) apply(line string) {
+	match := r.regex.FindStringSubmatch(line)
+	if match == nil {
+		return
+	}
+	r.matches++
+
+	for i, name := range r.regex.SubexpNames() {
+		if len(name) == 0 {
+			continue
+		}
+
+		val, err := strconv.ParseFloat(match[i], 64)
+		if err != nil {
+			continue
+		}
+
+		st, ok := r.values[i]
+		if !ok {
+			st = &logValueStats{min: val, max: val, buckets: make([]int64, len(r.cfg.Buckets))}
+			r.values[i] = st
+		}
+		if val < st.min {
+			st.min = val
+		}
+		if val > st.max {
+			st.max = val
+		}
+		st.sum += val
+		st.count++
+		for b, bound := range r.cfg.Buckets {
+			if val <= bound {
+				st.buckets[b]++
+			}
+		}
+	}
+}
+
+type tailedFile struct {
+	path  string
+	rules []*logRule
+	file  *os.File
+	// offset position after the last complete line read.
+	offset int64
+	// partial incomplete last line.
+	partial []byte
+}
+
+// readLines applies rules to complete lines appended since previous read.
+func (f *tailedFile) readLines() error {
+	r := bufio.NewReader(f.file)
+	for {
+		chunk, err := r.ReadSlice('\n')
+		f.partial = append(f.partial, chunk...)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if errors.Is(err, bufio.ErrBufferFull) && len(f.partial) < logMaxLineSize {
+			continue
+		}
+		if err != nil && !errors.Is(err, bufio.ErrBufferFull) {
+			return err
+		}
+
+		f.offset += int64(len(f.partial))
+		line := strings.TrimRight(string(f.partial), "\r\n")
+		f.partial = f.partial[:0]
+		for _, rule := range f.rules {
+			rule.apply(line)
+		}
+	}
+}
+
+func (f *tailedFile) seek(offset int64) error {
+	if _, err := f.file.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	f.offset = offset
+	f.partial = f.partial[:0]
+	return nil
+}
+
+// fingerprint returns checksum and size of the first size bytes of file.
+func fingerprint(file *os.File, size int) (uint32, int, error) {
+	buf := make([]byte, size)
+	n, err := file.ReadAt(buf, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return 0, 0, err
+	}
+	return crc32.ChecksumIEEE(buf[:n]), n, nil
+}
+
+// LogMonitor counts log lines matched by rules and aggregates numeric values captured by named groups.
+// Files are tailed across rotation by rename, rotated file is read to the end before the new one is opened.
+// Truncated files are read from the beginning.
+// Files found at startup are read from persisted offset or from the end if there is none,
+// files appeared later are read from the beginning.
+type LogMonitor struct {
+	storage storage.MetricsStorage
+	log     log.Logger
+	files   []*tailedFile
+	// offsets persisted offsets of files by path.
+	offsets       map[string]logOffset
+	savedOffsets  []byte
+	offsetsFile   string
+	fromBeginning bool
+	started       bool
+}
+
+func NewLogMonitor(s storage.MetricsStorage, l log.Logger, cfg config.LogMonitorConfig) (*LogMonitor, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	m := LogMonitor{
+		storage:       s,
+		log:           l,
+		offsets:       make(map[string]logOffset),
+		offsetsFile:   cfg.OffsetsFile,
+		fromBeginning: cfg.FromBeginning,
+	}
+
+	for _, fc := range cfg.Files {
+		f := tailedFile{path: fc.Path}
+		for _, rc := range fc.Rules {
+			f.rules = append(f.rules, &logRule{
+				cfg:    rc,
+				regex:  regexp.MustCompile(rc.Regex),
+				suffix: metricSuffix(rc.Name),
+				values: make(map[int]*logValueStats),
+			})
+		}
+		m.files = append(m.files, &f)
+	}
+
+	if len(m.offsetsFile) > 0 {
+		data, err := os.ReadFile(m.offsetsFile)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("failed to read log offsets: %w", err)
+		}
+		if len(data) > 0 {
+			if err = json.Unmarshal(data, &m.offsets); err != nil {
+				l.Warnf("Ignoring invalid log offsets file %v: %v", m.offsetsFile, err)
+			}
+		}
+		m.savedOffsets = data
+	}
+
+	return &m, nil
+}
+
+// GetMetricsStorage return underlying metrics storage.
+func (m *LogMonitor) GetMetricsStorage() storage.MetricsStorage {
+	return m.storage
+}
+
+// GatherMetrics reads lines appended to log files and adds rules statistics to storage.
+// Files failed to be read are skipped.
+func (m *LogMonitor) GatherMetrics() error {
+	m.storage.Clear()
+
+	for _, f := range m.files {
+		if err := m.poll(f); err != nil {
+			m.log.Warnf("Failed to read log file %v: %v", f.path, err)
+		}
+	}
+	m.started = true
+
+	if err := m.saveOffsets(); err != nil {
+		m.log.Warnf("Failed to save log offsets: %v", err)
+	}
+
+	for _, f := range m.files {
+		for _, r := range f.rules {
+			if err := m.report(r); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (m *LogMonitor) report(r *logRule) error {
+	values := []metrics.Metric{metrics.NewCounterMetric(LogMatchesMetric+r.suffix, r.matches)}
+
+	names := r.regex.SubexpNames()
+	for i, st := range r.values {
+		name := r.suffix + "_" + metricSuffix(names[i])
+		values = append(values,
+			metrics.NewGaugeMetric(LogValueMinMetric+name, st.min),
+			metrics.NewGaugeMetric(LogValueMaxMetric+name, st.max),
+			metrics.NewGaugeMetric(LogValueAvgMetric+name, st.sum/float64(st.count)))
+
+		if len(r.cfg.Buckets) == 0 {
+			continue
+		}
+		for b, bound := range r.cfg.Buckets {
+			le := strconv.FormatFloat(bound, 'f', -1, 64)
+			values = append(values, metrics.NewCounterMetric(LogValueBucketMetric+name+`{le="`+le+`"}`, st.buckets[b]))
+		}
+		values = append(values, metrics.NewCounterMetric(LogValueBucketMetric+name+`{le="+Inf"}`, st.count))
+	}
+
+	r.matches = 0
+	r.values = make(map[int]*logValueStats)
+
+	for _, met := range values {
+		if err := m.storage.AddOrUpdate(met); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// open opens file for the first time and seeks to position reading starts from.
+func (m *LogMonitor) open(f *tailedFile) error {
+	file, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+	f.file = file
+
+	fi, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	var start int64
+	if saved, ok := m.offsets[f.path]; ok {
+		sum, n, err := fingerprint(file, saved.FingerprintSize)
+		if err != nil {
+			return err
+		}
+		// File replaced or truncated while agent wasn't running is read from the beginning.
+		if sum == saved.Fingerprint && n == saved.FingerprintSize && saved.Offset <= fi.Size() {
+			start = saved.Offset
+		}
+	} else if !m.started && !m.fromBeginning {
+		start = fi.Size()
+	}
+
+	return f.seek(start)
+}
+
+func (m *LogMonitor) poll(f *tailedFile) error {
+	if f.file == nil {
+		if err := m.open(f); err != nil {
+			if f.file != nil {
+				f.file.Close()
+				f.file = nil
+			}
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+	} else {
+		fi, err := f.file.Stat()
+		if err != nil {
+			return err
+		}
+		if fi.Size() < f.offset+int64(len(f.partial)) {
+			m.log.Infof("Log file %v is truncated, reading from the beginning", f.path)
+			if err = f.seek(0); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := f.readLines(); err != nil {
+		return err
+	}
+
+	fi, err := os.Stat(f.path)
+	if err != nil {
+		// Rotated file is kept open until the new one is created.
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	cur, err := f.file.Stat()
+	if err != nil {
+		return err
+	}
+	if os.SameFile(fi, cur) {
+		return nil
+	}
+
+	m.log.Infof("Log file %v is rotated, reading new file", f.path)
+	f.file.Close()
+	f.file, err = os.Open(f.path)
+	if err != nil {
+		return err
+	}
+	if err = f.seek(0); err != nil {
+		return err
+	}
+
+	return f.readLines()
+}
+
+// saveOffsets persists offsets of opened files if they changed.
+func (m *LogMonitor) saveOffsets() error {
+	if len(m.offsetsFile) == 0 {
+		return nil
+	}
+
+	for _, f := range m.files {
+		if f.file == nil {
+			continue
+		}
+		sum, n, err := fingerprint(f.file, logFingerprintSize)
+		if err != nil {
+			return err
+		}
+		m.offsets[f.path] = logOffset{Offset: f.offset, Fingerprint: sum, FingerprintSize: n}
+	}
+
+	data, err := json.Marshal(m.offsets)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(data, m.savedOffsets) {
+		return nil
+	}
+
+	// Offsets are replaced atomically so they aren't lost if agent is stopped while writing.
+	tmp := filepath.Join(filepath.Dir(m.offsetsFile), "."+filepath.Base(m.offsetsFile)+".tmp")
+	if err = os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, m.offsetsFile); err != nil {
+		return err
+	}
+	m.savedOffsets = data
+
+	return nil
+}
@@ -0,0 +1,165 @@
+package monitor
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fuzzy-toozy/metrics-service/internal/agent/config"
+	"github.com/fuzzy-toozy/metrics-service/internal/agent/monitor/storage"
+	"github.com/fuzzy-toozy/metrics-service/internal/log"
+	"github.com/fuzzy-toozy/metrics-service/internal/metrics"
+	"github.com/stretchr/testify/require"
+)
+
+func appendLog(t *testing.T, path string, data string) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	defer f.Close()
+	_, err = f.WriteString(data)
+	require.NoError(t, err)
+}
+
+func logTestConfig(path string) config.LogMonitorConfig {
+	return config.LogMonitorConfig{Files: []config.LogFileConfig{{
+		Path: path,
+		Rules: []config.LogRuleConfig{
+			{Name: "errors", Regex: "ERROR"},
+			{Name: "requests", Regex: `(?P<method>[A-Z]+) took (?P<latency>[0-9.]+)ms`, Buckets: []float64{10, 100}},
+		},
+	}}}
+}
+
+func matches(t *testing.T, m *LogMonitor) (int64, int64) {
+	counters := metricsByType(m.GetMetricsStorage(), metrics.CounterMetricType)
+	return *counters[LogMatchesMetric+"errors"].Delta, *counters[LogMatchesMetric+"requests"].Delta
+}
+
+func Test_LogMonitor(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	appendLog(t, path, "ERROR before start\n")
+
+	m, err := NewLogMonitor(storage.NewCommonMetricsStorage(), log.NewDummyLogger(), logTestConfig(path))
+	require.NoError(t, err)
+
+	// Existing lines are skipped.
+	require.NoError(t, m.GatherMetrics())
+	errs, reqs := matches(t, m)
+	require.Equal(t, int64(0), errs)
+	require.Equal(t, int64(0), reqs)
+
+	appendLog(t, path, "INFO GET took 5ms\nERROR failed\nINFO POST took 50ms\nINFO GET took 500ms\nERROR partial")
+	require.NoError(t, m.GatherMetrics())
+	errs, reqs = matches(t, m)
+	// Incomplete line isn't matched until it's finished.
+	require.Equal(t, int64(1), errs)
+	require.Equal(t, int64(3), reqs)
+
+	gauges := metricsByType(m.GetMetricsStorage(), metrics.GaugeMetricType)
+	require.Equal(t, 5.0, *gauges[LogValueMinMetric+"requests_latency"].Value)
+	require.Equal(t, 500.0, *gauges[LogValueMaxMetric+"requests_latency"].Value)
+	require.Equal(t, 185.0, *gauges[LogValueAvgMetric+"requests_latency"].Value)
+	// Non-numeric captures aren't reported.
+	require.NotContains(t, gauges, LogValueMinMetric+"requests_method")
+	require.Len(t, gauges, 3)
+
+	counters := metricsByType(m.GetMetricsStorage(), metrics.CounterMetricType)
+	require.Equal(t, int64(1), *counters[LogValueBucketMetric+`requests_latency{le="10"}`].Delta)
+	require.Equal(t, int64(2), *counters[LogValueBucketMetric+`requests_latency{le="100"}`].Delta)
+	require.Equal(t, int64(3), *counters[LogValueBucketMetric+`requests_latency{le="+Inf"}`].Delta)
+
+	appendLog(t, path, " line\n")
+	require.NoError(t, m.GatherMetrics())
+	errs, reqs = matches(t, m)
+	require.Equal(t, int64(1), errs)
+	require.Equal(t, int64(0), reqs)
+	require.Empty(t, metricsByType(m.GetMetricsStorage(), metrics.GaugeMetricType))
+
+	// Truncated file is read from the beginning.
+	require.NoError(t, os.Truncate(path, 0))
+	appendLog(t, path, "ERROR after truncate\n")
+	require.NoError(t, m.GatherMetrics())
+	errs, _ = matches(t, m)
+	require.Equal(t, int64(1), errs)
+
+	// Lines written to rotated file are read before switching to the new one.
+	appendLog(t, path, "ERROR before rotate\n")
+	require.NoError(t, os.Rename(path, path+".1"))
+	appendLog(t, path+".1", "ERROR late write\n")
+	require.NoError(t, m.GatherMetrics())
+	errs, _ = matches(t, m)
+	require.Equal(t, int64(2), errs)
+
+	appendLog(t, path, "ERROR new file\nERROR new file\n")
+	require.NoError(t, m.GatherMetrics())
+	errs, _ = matches(t, m)
+	require.Equal(t, int64(2), errs)
+}
+
+func Test_LogMonitorOffsets(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	missing := filepath.Join(dir, "missing.log")
+	offsets := filepath.Join(dir, "offsets.json")
+
+	cfg := logTestConfig(path)
+	cfg.Files = append(cfg.Files, config.LogFileConfig{Path: missing, Rules: []config.LogRuleConfig{{Name: "missing", Regex: "ERROR"}}})
+	cfg.OffsetsFile = offsets
+	cfg.FromBeginning = true
+
+	appendLog(t, path, "ERROR one\n")
+	m, err := NewLogMonitor(storage.NewCommonMetricsStorage(), log.NewDummyLogger(), cfg)
+	require.NoError(t, err)
+	require.NoError(t, m.GatherMetrics())
+	errs, _ := matches(t, m)
+	require.Equal(t, int64(1), errs)
+
+	// Lines written while agent is stopped are read after restart.
+	appendLog(t, path, "ERROR two\nERROR three\n")
+	m, err = NewLogMonitor(storage.NewCommonMetricsStorage(), log.NewDummyLogger(), cfg)
+	require.NoError(t, err)
+	require.NoError(t, m.GatherMetrics())
+	errs, _ = matches(t, m)
+	require.Equal(t, int64(2), errs)
+
+	// File appeared after start is read from the beginning.
+	appendLog(t, missing, "ERROR x\n")
+	require.NoError(t, m.GatherMetrics())
+	counters := metricsByType(m.GetMetricsStorage(), metrics.CounterMetricType)
+	require.Equal(t, int64(1), *counters[LogMatchesMetric+"missing"].Delta)
+
+	// File replaced while agent is stopped is read from the beginning.
+	require.NoError(t, os.Remove(path))
+	appendLog(t, path, "WARN replaced\nERROR replaced\n")
+	m, err = NewLogMonitor(storage.NewCommonMetricsStorage(), log.NewDummyLogger(), cfg)
+	require.NoError(t, err)
+	require.NoError(t, m.GatherMetrics())
+	errs, _ = matches(t, m)
+	require.Equal(t, int64(1), errs)
+}
+
+func Test_LogMonitorConfig(t *testing.T) {
+	rule := config.LogRuleConfig{Name: "a", Regex: "a"}
+	tests := []struct {
+		name  string
+		files []config.LogFileConfig
+	}{
+		{"empty path", []config.LogFileConfig{{Rules: []config.LogRuleConfig{rule}}}},
+		{"no rules", []config.LogFileConfig{{Path: "a.log"}}},
+		{"duplicate rule", []config.LogFileConfig{
+			{Path: "a.log", Rules: []config.LogRuleConfig{rule}},
+			{Path: "b.log", Rules: []config.LogRuleConfig{rule}},
+		}},
+		{"invalid regex", []config.LogFileConfig{{Path: "a.log", Rules: []config.LogRuleConfig{{Name: "a", Regex: "("}}}}},
+		{"unsorted buckets", []config.LogFileConfig{{Path: "a.log",
+			Rules: []config.LogRuleConfig{{Name: "a", Regex: "a", Buckets: []float64{10, 1}}}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewLogMonitor(storage.NewCommonMetricsStorage(), log.NewDummyLogger(),
+				config.LogMonitorConfig{Files: tt.files})
+			require.Error(t, err)
+		})
+	}
+}
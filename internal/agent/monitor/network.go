@@ -0,0 +1,219 @@
+package monitor
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/fuzzy-toozy/metrics-service/internal/agent/config"
+	"github.com/fuzzy-toozy/metrics-service/internal/agent/monitor/storage"
+	"github.com/fuzzy-toozy/metrics-service/internal/log"
+	"github.com/fuzzy-toozy/metrics-service/internal/metrics"
+)
+
+// Network metrics names prefixes, suffix is interface name or TCP state.
+const (
+	NetBytesRecvMetric   = "NetBytesRecv_"
+	NetBytesSentMetric   = "NetBytesSent_"
+	NetPacketsRecvMetric = "NetPacketsRecv_"
+	NetPacketsSentMetric = "NetPacketsSent_"
+	NetErrorsRecvMetric  = "NetErrorsRecv_"
+	NetErrorsSentMetric  = "NetErrorsSent_"
+	NetDropsRecvMetric   = "NetDropsRecv_"
+	NetDropsSentMetric   = "NetDropsSent_"
+	TCPConnectionsMetric = "TCPConnections_"
+)
+
+// tcpStates names of TCP states by their codes in /proc/net/tcp.
+var tcpStates = map[string]string{
+	"01": "ESTABLISHED",
+	"02": "SYN_SENT",
+	"03": "SYN_RECV",
+	"04": "FIN_WAIT1",
+	"05": "FIN_WAIT2",
+	"06": "TIME_WAIT",
+	"07": "CLOSE",
+	"08": "CLOSE_WAIT",
+	"09": "LAST_ACK",
+	"0A": "LISTEN",
+	"0B": "CLOSING",
+	"0C": "NEW_SYN_RECV",
+}
+
+// NetworkMonitor per-interface traffic counters and TCP connections by state read from procfs.
+// Interface totals are reported as counters of increase since previous gather.
+type NetworkMonitor struct {
+	storage     storage.MetricsStorage
+	log         log.Logger
+	counters    *counterTracker
+	procRoot    string
+	ifaceFilter stringFilter
+}
+
+func NewNetworkMonitor(s storage.MetricsStorage, l log.Logger, procRoot string, cfg config.NetworkMonitorConfig) *NetworkMonitor {
+	return &NetworkMonitor{
+		storage:     s,
+		log:         l,
+		counters:    newCounterTracker(),
+		procRoot:    procRoot,
+		ifaceFilter: newStringFilter(cfg.IncludeInterfaces, cfg.ExcludeInterfaces),
+	}
+}
+
+// GetMetricsStorage return underlying metrics storage.
+func (m *NetworkMonitor) GetMetricsStorage() storage.MetricsStorage {
+	return m.storage
+}
+
+// GatherMetrics reads interfaces statistics and TCP sockets and add them to storage.
+func (m *NetworkMonitor) GatherMetrics() error {
+	m.storage.Clear()
+
+	// TCP states are gathered even if some interfaces failed.
+	return errors.Join(m.gatherInterfaces(), m.gatherTCPStates())
+}
+
+func (m *NetworkMonitor) gatherInterfaces() error {
+	f, err := os.Open(filepath.Join(m.procRoot, "net", "dev"))
+	if err != nil {
+		return fmt.Errorf("failed to open interfaces statistics: %w", err)
+	}
+	defer f.Close()
+	defer m.counters.commit()
+
+	// Receive and transmit columns of /proc/net/dev.
+	const (
+		rxBytes   = 0
+		rxPackets = 1
+		rxErrs    = 2
+		rxDrop    = 3
+		txBytes   = 8
+		txPackets = 9
+		txErrs    = 10
+		txDrop    = 11
+		columns   = 16
+	)
+
+	var errs []error
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		name, data, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			// Header lines.
+			continue
+		}
+
+		name = strings.TrimSpace(name)
+		if !m.ifaceFilter.match(name) {
+			continue
+		}
+
+		fields := strings.Fields(data)
+		if len(fields) < columns {
+			errs = append(errs, fmt.Errorf("invalid statistics of interface %v", name))
+			continue
+		}
+
+		suffix := metricSuffix(name)
+		values := make([]namedValue, 0, 8)
+		for _, c := range []struct {
+			name   string
+			column int
+		}{
+			{NetBytesRecvMetric, rxBytes},
+			{NetPacketsRecvMetric, rxPackets},
+			{NetErrorsRecvMetric, rxErrs},
+			{NetDropsRecvMetric, rxDrop},
+			{NetBytesSentMetric, txBytes},
+			{NetPacketsSentMetric, txPackets},
+			{NetErrorsSentMetric, txErrs},
+			{NetDropsSentMetric, txDrop},
+		} {
+			total, err := strconv.ParseUint(fields[c.column], 10, 64)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("invalid statistics of interface %v: %w", name, err))
+				values = nil
+				break
+			}
+			values = append(values, namedValue{name: c.name + suffix, val: total})
+		}
+
+		// Interface is skipped as a whole if any of its statistics is invalid.
+		for _, v := range values {
+			delta, ok := m.counters.delta(v.name, v.val)
+			if !ok {
+				continue
+			}
+			if err = m.storage.AddOrUpdate(metrics.NewCounterMetric(v.name, delta)); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	if err = scanner.Err(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to read interfaces statistics: %w", err))
+	}
+
+	return errors.Join(errs...)
+}
+
+func (m *NetworkMonitor) gatherTCPStates() error {
+	counts := make(map[string]int, len(tcpStates))
+	for _, state := range tcpStates {
+		counts[state] = 0
+	}
+
+	for _, file := range []string{"tcp", "tcp6"} {
+		if err := countTCPStates(filepath.Join(m.procRoot, "net", file), counts); err != nil {
+			return err
+		}
+	}
+
+	for state, count := range counts {
+		if err := m.storage.AddOrUpdate(metrics.NewGaugeMetric(TCPConnectionsMetric+state, float64(count))); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// countTCPStates adds sockets listed in /proc/net/tcp formatted file to counts by state.
+// Missing file is skipped, IPv6 may be disabled.
+func countTCPStates(path string, counts map[string]int) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("failed to open TCP sockets: %w", err)
+	}
+	defer f.Close()
+
+	const stateColumn = 3
+
+	scanner := bufio.NewScanner(f)
+	// Skip header.
+	scanner.Scan()
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) <= stateColumn {
+			continue
+		}
+
+		if state, ok := tcpStates[strings.ToUpper(fields[stateColumn])]; ok {
+			counts[state]++
+		}
+	}
+
+	if err = scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read TCP sockets: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,125 @@
+package monitor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fuzzy-toozy/metrics-service/internal/agent/config"
+	"github.com/fuzzy-toozy/metrics-service/internal/agent/monitor/storage"
+	"github.com/fuzzy-toozy/metrics-service/internal/log"
+	"github.com/fuzzy-toozy/metrics-service/internal/metrics"
+	"github.com/stretchr/testify/require"
+)
+
+// copyProcFixture copies files of testdata/proc/net to temporary procfs root.
+func copyProcFixture(t *testing.T) string {
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "net"), 0755))
+	for _, name := range []string{"dev", "tcp", "tcp6"} {
+		data, err := os.ReadFile(filepath.Join("testdata", "proc", "net", name))
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(root, "net", name), data, 0644))
+	}
+	return root
+}
+
+func metricsByType(s storage.MetricsStorage, mtype string) map[string]metrics.Metric {
+	res := make(map[string]metrics.Metric)
+	for _, m := range s.GetAllMetrics() {
+		if m.MType == mtype {
+			res[m.ID] = m
+		}
+	}
+	return res
+}
+
+func Test_NetworkMonitor(t *testing.T) {
+	root := copyProcFixture(t)
+	cfg := config.NetworkMonitorConfig{ExcludeInterfaces: []string{"lo", "veth*"}}
+	m := NewNetworkMonitor(storage.NewCommonMetricsStorage(), log.NewDummyLogger(), root, cfg)
+
+	// Counters are reported starting from the second gather.
+	require.NoError(t, m.GatherMetrics())
+	require.Empty(t, metricsByType(m.GetMetricsStorage(), metrics.CounterMetricType))
+
+	gauges := metricsByType(m.GetMetricsStorage(), metrics.GaugeMetricType)
+	require.Len(t, gauges, len(tcpStates))
+	expectedStates := map[string]float64{"LISTEN": 2, "ESTABLISHED": 2, "TIME_WAIT": 1, "CLOSE_WAIT": 1}
+	for state, count := range expectedStates {
+		require.Equal(t, count, *gauges[TCPConnectionsMetric+state].Value, state)
+	}
+	require.Equal(t, float64(0), *gauges[TCPConnectionsMetric+"SYN_SENT"].Value)
+
+	dev := `Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+    lo:   2000      20    0    0    0     0          0         0     2000      20    0    0    0     0       0          0
+  eth0: 500100    4010    3    1    0     0          0        12   250500    3005    1    5    0     0       0          0
+`
+	require.NoError(t, os.WriteFile(filepath.Join(root, "net", "dev"), []byte(dev), 0644))
+
+	require.NoError(t, m.GatherMetrics())
+	counters := metricsByType(m.GetMetricsStorage(), metrics.CounterMetricType)
+	expected := map[string]int64{
+		NetBytesRecvMetric + "eth0":   100,
+		NetPacketsRecvMetric + "eth0": 10,
+		NetErrorsRecvMetric + "eth0":  1,
+		NetDropsRecvMetric + "eth0":   0,
+		NetBytesSentMetric + "eth0":   500,
+		NetPacketsSentMetric + "eth0": 5,
+		NetErrorsSentMetric + "eth0":  0,
+		NetDropsSentMetric + "eth0":   2,
+	}
+	require.Len(t, counters, len(expected))
+	for id, delta := range expected {
+		require.Equal(t, delta, *counters[id].Delta, id)
+	}
+}
+
+func Test_NetworkMonitorInvalidData(t *testing.T) {
+	root := copyProcFixture(t)
+	require.NoError(t, os.WriteFile(filepath.Join(root, "net", "dev"), []byte("eth0: 1 2 3\n"), 0644))
+
+	m := NewNetworkMonitor(storage.NewCommonMetricsStorage(), log.NewDummyLogger(), root, config.NetworkMonitorConfig{})
+	require.Error(t, m.GatherMetrics())
+
+	m = NewNetworkMonitor(storage.NewCommonMetricsStorage(), log.NewDummyLogger(), t.TempDir(), config.NetworkMonitorConfig{})
+	require.Error(t, m.GatherMetrics())
+}
+
+func Test_NetworkMonitorSkipsInvalidInterface(t *testing.T) {
+	root := copyProcFixture(t)
+	writeDev := func(bytes int) {
+		dev := fmt.Sprintf("  eth0: %v 10 0 0 0 0 0 0 200 20 0 0 0 0 0 0\n  eth1: 1 2 3\n", bytes)
+		require.NoError(t, os.WriteFile(filepath.Join(root, "net", "dev"), []byte(dev), 0644))
+	}
+
+	m := NewNetworkMonitor(storage.NewCommonMetricsStorage(), log.NewDummyLogger(), root, config.NetworkMonitorConfig{})
+
+	// Invalid interface is reported as error, valid one and TCP states are still gathered.
+	writeDev(100)
+	require.Error(t, m.GatherMetrics())
+	require.Len(t, metricsByType(m.GetMetricsStorage(), metrics.GaugeMetricType), len(tcpStates))
+
+	writeDev(150)
+	require.Error(t, m.GatherMetrics())
+	counters := metricsByType(m.GetMetricsStorage(), metrics.CounterMetricType)
+	require.Len(t, counters, 8)
+	require.Equal(t, int64(50), *counters[NetBytesRecvMetric+"eth0"].Delta)
+
+	// Increase is not counted again by the next gather.
+	require.Error(t, m.GatherMetrics())
+	counters = metricsByType(m.GetMetricsStorage(), metrics.CounterMetricType)
+	require.Equal(t, int64(0), *counters[NetBytesRecvMetric+"eth0"].Delta)
+}
+
+func Test_NetworkMonitorLocal(t *testing.T) {
+	if _, err := os.Stat("/proc/net/dev"); err != nil {
+		t.Skip("procfs is not available")
+	}
+
+	m := NewNetworkMonitor(storage.NewCommonMetricsStorage(), log.NewDummyLogger(), "/proc", config.NetworkMonitorConfig{})
+	require.NoError(t, m.GatherMetrics())
+	require.NoError(t, m.GatherMetrics())
+}
@@ -0,0 +1,320 @@
+package monitor
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fuzzy-toozy/metrics-service/internal/agent/config"
+	"github.com/fuzzy-toozy/metrics-service/internal/agent/monitor/storage"
+	"github.com/fuzzy-toozy/metrics-service/internal/log"
+	"github.com/fuzzy-toozy/metrics-service/internal/metrics"
+)
+
+// Process metrics names prefixes, suffix is process group name.
+const (
+	ProcessCountMetric    = "ProcessCount_"
+	ProcessRSSMetric      = "ProcessRSS_"
+	ProcessCPUMetric      = "ProcessCPU_"
+	ProcessFDsMetric      = "ProcessFDs_"
+	ProcessThreadsMetric  = "ProcessThreads_"
+	ProcessRestartsMetric = "ProcessRestarts_"
+)
+
+// clockTicks USER_HZ, units of process CPU times in procfs.
+const clockTicks = 100
+
+// procKey identifies process, start time distinguishes processes with reused pid.
+type procKey struct {
+	pid       int
+	startTime uint64
+}
+
+// procInfo statistics of process read from procfs.
+type procInfo struct {
+	name     string
+	cmdline  string
+	key      procKey
+	cpuTicks uint64
+	rss      uint64
+	threads  uint64
+	fds      uint64
+}
+
+type processGroup struct {
+	cmdline *regexp.Regexp
+	// seen processes matched during previous gather.
+	seen map[procKey]struct{}
+	cfg  config.ProcessGroupConfig
+}
+
+// ProcessMonitor resource usage of process groups matched by name, command line or pidfile, read from procfs.
+// Restarts are counted as processes started in group since previous gather.
+type ProcessMonitor struct {
+	storage  storage.MetricsStorage
+	log      log.Logger
+	now      func() time.Time
+	prevTime time.Time
+	prevCPU  map[procKey]uint64
+	procRoot string
+	groups   []processGroup
+}
+
+func NewProcessMonitor(s storage.MetricsStorage, l log.Logger, procRoot string, cfg config.ProcessMonitorConfig) (*ProcessMonitor, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	m := ProcessMonitor{storage: s, log: l, now: time.Now, procRoot: procRoot, prevCPU: make(map[procKey]uint64)}
+	for _, g := range cfg.Groups {
+		group := processGroup{cfg: g}
+		if len(g.CmdlineRegex) > 0 {
+			group.cmdline = regexp.MustCompile(g.CmdlineRegex)
+		}
+		m.groups = append(m.groups, group)
+	}
+
+	return &m, nil
+}
+
+// GetMetricsStorage return underlying metrics storage.
+func (m *ProcessMonitor) GetMetricsStorage() storage.MetricsStorage {
+	return m.storage
+}
+
+// readPidFile returns pid stored in file or 0 if it can't be read.
+func readPidFile(path string) int {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0
+	}
+
+	return pid
+}
+
+func (g *processGroup) match(p *procInfo, pidFilePid int) bool {
+	if len(g.cfg.ProcessName) > 0 && p.name != g.cfg.ProcessName {
+		return false
+	}
+
+	if g.cmdline != nil && !g.cmdline.MatchString(p.cmdline) {
+		return false
+	}
+
+	if len(g.cfg.PidFile) > 0 && p.key.pid != pidFilePid {
+		return false
+	}
+
+	return true
+}
+
+// GatherMetrics reads processes from procfs and adds statistics of every group to storage.
+func (m *ProcessMonitor) GatherMetrics() error {
+	m.storage.Clear()
+
+	entries, err := os.ReadDir(m.procRoot)
+	if err != nil {
+		return fmt.Errorf("failed to read procfs: %w", err)
+	}
+
+	procs := make([]*procInfo, 0, len(entries))
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil || !e.IsDir() {
+			continue
+		}
+
+		p, err := m.readProcess(pid)
+		if err != nil {
+			// Process may exit while it's read.
+			m.log.Debugf("Failed to read process %v: %v", pid, err)
+			continue
+		}
+		procs = append(procs, p)
+	}
+
+	now := m.now()
+	elapsed := now.Sub(m.prevTime).Seconds()
+	firstGather := m.prevTime.IsZero()
+
+	for i := range m.groups {
+		g := &m.groups[i]
+
+		pidFilePid := 0
+		if len(g.cfg.PidFile) > 0 {
+			pidFilePid = readPidFile(g.cfg.PidFile)
+		}
+
+		var rss, threads, fds, cpuTicks, started uint64
+		matched := make(map[procKey]struct{})
+		for _, p := range procs {
+			if !g.match(p, pidFilePid) {
+				continue
+			}
+
+			matched[p.key] = struct{}{}
+			rss += p.rss
+			threads += p.threads
+			fds += p.fds
+			if prev, ok := m.prevCPU[p.key]; ok && p.cpuTicks >= prev {
+				cpuTicks += p.cpuTicks - prev
+			}
+			if _, ok := g.seen[p.key]; !ok {
+				started++
+			}
+		}
+		g.seen = matched
+
+		suffix := metricSuffix(g.cfg.Name)
+		values := []metrics.Metric{
+			metrics.NewGaugeMetric(ProcessCountMetric+suffix, float64(len(matched))),
+			metrics.NewGaugeMetric(ProcessRSSMetric+suffix, float64(rss)),
+			metrics.NewGaugeMetric(ProcessFDsMetric+suffix, float64(fds)),
+			metrics.NewGaugeMetric(ProcessThreadsMetric+suffix, float64(threads)),
+		}
+
+		if !firstGather && elapsed > 0 {
+			const percent = 100
+			cpu := float64(cpuTicks) / clockTicks / elapsed * percent
+			values = append(values,
+				metrics.NewGaugeMetric(ProcessCPUMetric+suffix, cpu),
+				metrics.NewCounterMetric(ProcessRestartsMetric+suffix, int64(started)))
+		}
+
+		for _, met := range values {
+			if err = m.storage.AddOrUpdate(met); err != nil {
+				return err
+			}
+		}
+	}
+
+	m.prevCPU = make(map[procKey]uint64, len(procs))
+	for _, p := range procs {
+		m.prevCPU[p.key] = p.cpuTicks
+	}
+	m.prevTime = now
+
+	return nil
+}
+
+func (m *ProcessMonitor) readProcess(pid int) (*procInfo, error) {
+	dir := filepath.Join(m.procRoot, strconv.Itoa(pid))
+	p := procInfo{key: procKey{pid: pid}}
+
+	comm, err := os.ReadFile(filepath.Join(dir, "comm"))
+	if err != nil {
+		return nil, err
+	}
+	p.name = strings.TrimSpace(string(comm))
+
+	cmdline, err := os.ReadFile(filepath.Join(dir, "cmdline"))
+	if err != nil {
+		return nil, err
+	}
+	p.cmdline = strings.TrimSpace(string(bytes.ReplaceAll(cmdline, []byte{0}, []byte{' '})))
+
+	if err = readProcStat(filepath.Join(dir, "stat"), &p); err != nil {
+		return nil, err
+	}
+
+	if err = readProcStatus(filepath.Join(dir, "status"), &p); err != nil {
+		return nil, err
+	}
+
+	// Descriptors of processes of other users can't be listed.
+	if fds, err := os.ReadDir(filepath.Join(dir, "fd")); err == nil {
+		p.fds = uint64(len(fds))
+	}
+
+	return &p, nil
+}
+
+// readProcStat reads CPU times and start time from /proc/<pid>/stat.
+func readProcStat(path string, p *procInfo) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	// Process name may contain spaces and parentheses, fields are counted after the last one.
+	idx := bytes.LastIndexByte(data, ')')
+	if idx < 0 {
+		return fmt.Errorf("invalid stat format")
+	}
+
+	// Indexes of fields utime, stime and starttime counting from state field.
+	const (
+		utime     = 11
+		stime     = 12
+		startTime = 19
+	)
+
+	fields := strings.Fields(string(data[idx+1:]))
+	if len(fields) <= startTime {
+		return fmt.Errorf("invalid stat format")
+	}
+
+	values := [3]uint64{}
+	for i, f := range []int{utime, stime, startTime} {
+		if values[i], err = strconv.ParseUint(fields[f], 10, 64); err != nil {
+			return fmt.Errorf("invalid stat format: %w", err)
+		}
+	}
+
+	p.cpuTicks = values[0] + values[1]
+	p.key.startTime = values[2]
+
+	return nil
+}
+
+// readProcStatus reads resident memory and threads count from /proc/<pid>/status.
+func readProcStatus(path string, p *procInfo) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	const kb = 1024
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, val, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+
+		fields := strings.Fields(val)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch key {
+		case "VmRSS":
+			rss, err := strconv.ParseUint(fields[0], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid VmRSS: %w", err)
+			}
+			p.rss = rss * kb
+		case "Threads":
+			threads, err := strconv.ParseUint(fields[0], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid Threads: %w", err)
+			}
+			p.threads = threads
+		}
+	}
+
+	return scanner.Err()
+}
@@ -0,0 +1,163 @@
+package monitor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fuzzy-toozy/metrics-service/internal/agent/config"
+	"github.com/fuzzy-toozy/metrics-service/internal/agent/monitor/storage"
+	"github.com/fuzzy-toozy/metrics-service/internal/log"
+	"github.com/fuzzy-toozy/metrics-service/internal/metrics"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeProcess struct {
+	name      string
+	cmdline   []string
+	utime     uint64
+	stime     uint64
+	startTime uint64
+	rssKB     uint64
+	threads   uint64
+	fds       int
+}
+
+// writeFakeProcess creates procfs entries of process pid in root.
+func writeFakeProcess(t *testing.T, root string, pid int, p fakeProcess) {
+	dir := filepath.Join(root, strconv.Itoa(pid))
+	require.NoError(t, os.RemoveAll(dir))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "fd"), 0755))
+
+	stat := fmt.Sprintf("%v (%v) S 1 %v %v 0 -1 4194560 100 0 0 0 %v %v 0 0 20 0 %v 0 %v 1000 200 18446744073709551615",
+		pid, p.name, pid, pid, p.utime, p.stime, p.threads, p.startTime)
+	status := fmt.Sprintf("Name:\t%v\nState:\tS (sleeping)\nVmRSS:\t%v kB\nThreads:\t%v\n", p.name, p.rssKB, p.threads)
+	cmdline := strings.Join(p.cmdline, "\x00") + "\x00"
+
+	files := map[string]string{"comm": p.name + "\n", "stat": stat, "status": status, "cmdline": cmdline}
+	for name, data := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(data), 0644))
+	}
+
+	for i := 0; i < p.fds; i++ {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "fd", strconv.Itoa(i)), nil, 0644))
+	}
+}
+
+func Test_ProcessMonitor(t *testing.T) {
+	root := t.TempDir()
+	pidFile := filepath.Join(t.TempDir(), "db.pid")
+	require.NoError(t, os.WriteFile(pidFile, []byte("30\n"), 0644))
+
+	writeFakeProcess(t, root, 10, fakeProcess{name: "nginx", cmdline: []string{"nginx", "-g", "daemon off;"},
+		utime: 100, stime: 50, startTime: 1000, rssKB: 1024, threads: 1, fds: 3})
+	writeFakeProcess(t, root, 11, fakeProcess{name: "nginx", cmdline: []string{"nginx: worker process"},
+		utime: 10, stime: 10, startTime: 1001, rssKB: 2048, threads: 2, fds: 4})
+	writeFakeProcess(t, root, 20, fakeProcess{name: "java", cmdline: []string{"/usr/bin/java", "-jar", "/opt/app/server.jar"},
+		utime: 1000, stime: 0, startTime: 1100, rssKB: 4096, threads: 30, fds: 10})
+	writeFakeProcess(t, root, 30, fakeProcess{name: "postgres", cmdline: []string{"postgres", "-D", "/data"},
+		utime: 5, stime: 5, startTime: 1200, rssKB: 512, threads: 1, fds: 1})
+	writeFakeProcess(t, root, 31, fakeProcess{name: "postgres", cmdline: []string{"postgres: checkpointer"},
+		startTime: 1201, rssKB: 256, threads: 1})
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "self"), 0755))
+
+	cfg := config.ProcessMonitorConfig{Groups: []config.ProcessGroupConfig{
+		{Name: "nginx", ProcessName: "nginx"},
+		{Name: "app", CmdlineRegex: `java .*server\.jar`},
+		{Name: "db", PidFile: pidFile},
+		{Name: "missing", ProcessName: "redis-server"},
+	}}
+	m, err := NewProcessMonitor(storage.NewCommonMetricsStorage(), log.NewDummyLogger(), root, cfg)
+	require.NoError(t, err)
+
+	now := time.Unix(1000, 0)
+	m.now = func() time.Time { return now }
+
+	// CPU usage and restarts are reported starting from the second gather.
+	require.NoError(t, m.GatherMetrics())
+	gauges := metricsByType(m.GetMetricsStorage(), metrics.GaugeMetricType)
+	require.Empty(t, metricsByType(m.GetMetricsStorage(), metrics.CounterMetricType))
+	require.Len(t, gauges, 4*len(cfg.Groups))
+
+	expected := map[string]float64{
+		ProcessCountMetric + "nginx":     2,
+		ProcessRSSMetric + "nginx":       3072 * 1024,
+		ProcessFDsMetric + "nginx":       7,
+		ProcessThreadsMetric + "nginx":   3,
+		ProcessCountMetric + "app":       1,
+		ProcessThreadsMetric + "app":     30,
+		ProcessCountMetric + "db":        1,
+		ProcessRSSMetric + "db":          512 * 1024,
+		ProcessCountMetric + "missing":   0,
+		ProcessThreadsMetric + "missing": 0,
+	}
+	for id, val := range expected {
+		require.Equal(t, val, *gauges[id].Value, id)
+	}
+
+	// Worker is restarted, nginx master and java used CPU.
+	now = now.Add(10 * time.Second)
+	require.NoError(t, os.RemoveAll(filepath.Join(root, "11")))
+	writeFakeProcess(t, root, 12, fakeProcess{name: "nginx", cmdline: []string{"nginx: worker process"},
+		startTime: 2000, rssKB: 2048, threads: 2, fds: 4})
+	writeFakeProcess(t, root, 10, fakeProcess{name: "nginx", cmdline: []string{"nginx", "-g", "daemon off;"},
+		utime: 150, stime: 100, startTime: 1000, rssKB: 1024, threads: 1, fds: 3})
+	writeFakeProcess(t, root, 20, fakeProcess{name: "java", cmdline: []string{"/usr/bin/java", "-jar", "/opt/app/server.jar"},
+		utime: 1500, stime: 0, startTime: 1100, rssKB: 4096, threads: 30, fds: 10})
+
+	require.NoError(t, m.GatherMetrics())
+	gauges = metricsByType(m.GetMetricsStorage(), metrics.GaugeMetricType)
+	require.Equal(t, float64(10), *gauges[ProcessCPUMetric+"nginx"].Value)
+	require.Equal(t, float64(50), *gauges[ProcessCPUMetric+"app"].Value)
+	require.Equal(t, float64(0), *gauges[ProcessCPUMetric+"db"].Value)
+	require.Equal(t, float64(2), *gauges[ProcessCountMetric+"nginx"].Value)
+
+	counters := metricsByType(m.GetMetricsStorage(), metrics.CounterMetricType)
+	require.Equal(t, int64(1), *counters[ProcessRestartsMetric+"nginx"].Delta)
+	require.Equal(t, int64(0), *counters[ProcessRestartsMetric+"app"].Delta)
+	require.Equal(t, int64(0), *counters[ProcessRestartsMetric+"db"].Delta)
+
+	// Process is matched by new pid written to pidfile.
+	require.NoError(t, os.WriteFile(pidFile, []byte("31"), 0644))
+	now = now.Add(10 * time.Second)
+	require.NoError(t, m.GatherMetrics())
+	gauges = metricsByType(m.GetMetricsStorage(), metrics.GaugeMetricType)
+	require.Equal(t, float64(256*1024), *gauges[ProcessRSSMetric+"db"].Value)
+	counters = metricsByType(m.GetMetricsStorage(), metrics.CounterMetricType)
+	require.Equal(t, int64(1), *counters[ProcessRestartsMetric+"db"].Delta)
+}
+
+func Test_ProcessMonitorInvalidConfig(t *testing.T) {
+	for _, groups := range [][]config.ProcessGroupConfig{
+		{{ProcessName: "nginx"}},
+		{{Name: "nginx"}},
+		{{Name: "nginx", CmdlineRegex: "("}},
+		{{Name: "nginx", ProcessName: "nginx"}, {Name: "nginx", ProcessName: "nginx"}},
+	} {
+		_, err := NewProcessMonitor(storage.NewCommonMetricsStorage(), log.NewDummyLogger(), "/proc",
+			config.ProcessMonitorConfig{Groups: groups})
+		require.Error(t, err)
+	}
+}
+
+func Test_ProcessMonitorLocal(t *testing.T) {
+	if _, err := os.Stat("/proc/self/stat"); err != nil {
+		t.Skip("procfs is not available")
+	}
+
+	pidFile := filepath.Join(t.TempDir(), "self.pid")
+	require.NoError(t, os.WriteFile(pidFile, []byte(strconv.Itoa(os.Getpid())), 0644))
+
+	cfg := config.ProcessMonitorConfig{Groups: []config.ProcessGroupConfig{{Name: "self", PidFile: pidFile}}}
+	m, err := NewProcessMonitor(storage.NewCommonMetricsStorage(), log.NewDummyLogger(), "/proc", cfg)
+	require.NoError(t, err)
+	require.NoError(t, m.GatherMetrics())
+
+	gauges := metricsByType(m.GetMetricsStorage(), metrics.GaugeMetricType)
+	require.Equal(t, float64(1), *gauges[ProcessCountMetric+"self"].Value)
+	require.Greater(t, *gauges[ProcessRSSMetric+"self"].Value, float64(0))
+}
@@ -0,0 +1,341 @@
+package monitor
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fuzzy-toozy/metrics-service/internal/agent/config"
+	"github.com/fuzzy-toozy/metrics-service/internal/agent/monitor/storage"
+	"github.com/fuzzy-toozy/metrics-service/internal/log"
+	"github.com/fuzzy-toozy/metrics-service/internal/metrics"
+)
+
+// Prometheus scrape self-metrics names prefixes, suffix is target name.
+const (
+	PrometheusUpMetric       = "PrometheusUp_"
+	PrometheusDurationMetric = "PrometheusScrapeDurationSeconds_"
+	PrometheusSamplesMetric  = "PrometheusSamples_"
+)
+
+const (
+	defaultPrometheusTimeout = 10 * time.Second
+	// promNameLabel label holding metric name during relabeling.
+	promNameLabel = "__name__"
+	promAccept    = "text/plain;version=0.0.4"
+)
+
+type relabelRule struct {
+	cfg   config.RelabelRuleConfig
+	regex *regexp.Regexp
+}
+
+func newRelabelRule(cfg config.RelabelRuleConfig) relabelRule {
+	if len(cfg.SourceLabels) == 0 {
+		cfg.SourceLabels = []string{promNameLabel}
+	}
+	if len(cfg.Separator) == 0 {
+		cfg.Separator = ";"
+	}
+	if len(cfg.Regex) == 0 {
+		cfg.Regex = "(.*)"
+	}
+	if len(cfg.Action) == 0 {
+		cfg.Action = config.RelabelReplace
+	}
+	if len(cfg.Replacement) == 0 {
+		cfg.Replacement = "$1"
+	}
+
+	// Regex matches whole value as in Prometheus.
+	return relabelRule{cfg: cfg, regex: regexp.MustCompile("^(?:" + cfg.Regex + ")$")}
+}
+
+// apply rewrites labels, returns false if sample is dropped.
+func (r *relabelRule) apply(labels map[string]string) bool {
+	if r.cfg.Action == config.RelabelLabelDrop {
+		for name := range labels {
+			if name != promNameLabel && r.regex.MatchString(name) {
+				delete(labels, name)
+			}
+		}
+		return true
+	}
+
+	values := make([]string, len(r.cfg.SourceLabels))
+	for i, name := range r.cfg.SourceLabels {
+		values[i] = labels[name]
+	}
+	value := strings.Join(values, r.cfg.Separator)
+
+	switch r.cfg.Action {
+	case config.RelabelKeep:
+		return r.regex.MatchString(value)
+	case config.RelabelDrop:
+		return !r.regex.MatchString(value)
+	}
+
+	match := r.regex.FindStringSubmatchIndex(value)
+	if match == nil {
+		return true
+	}
+
+	res := string(r.regex.ExpandString(nil, r.cfg.Replacement, value, match))
+	if len(res) == 0 {
+		delete(labels, r.cfg.TargetLabel)
+	} else {
+		labels[r.cfg.TargetLabel] = res
+	}
+
+	// Sample without name can't be reported.
+	return len(labels[promNameLabel]) > 0
+}
+
+// promSeriesID builds metric ID from name and labels other than name,
+// labels are sorted by name and appended in name{label="value",...} form.
+func promSeriesID(prefix string, labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		if name != promNameLabel {
+			names = append(names, name)
+		}
+	}
+
+	b := strings.Builder{}
+	b.WriteString(prefix)
+	b.WriteString(labels[promNameLabel])
+	if len(names) == 0 {
+		return b.String()
+	}
+
+	sort.Strings(names)
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name)
+		b.WriteByte('=')
+		b.WriteString(strconv.Quote(labels[name]))
+	}
+	b.WriteByte('}')
+
+	return b.String()
+}
+
+// promCounterState reported part of cumulative series total.
+type promCounterState struct {
+	total    float64
+	reported int64
+}
+
+type promTarget struct {
+	cfg      config.PrometheusTargetConfig
+	suffix   string
+	client   *http.Client
+	schedule intervalSchedule
+	relabel  []relabelRule
+	// counters states of cumulative series observed during previous scrape.
+	counters map[string]promCounterState
+}
+
+type promScrapeResult struct {
+	target   *promTarget
+	metrics  []metrics.Metric
+	duration time.Duration
+	err      error
+}
+
+// PrometheusMonitor metrics scraped from endpoints exposing Prometheus text format on their own intervals.
+// Counters, histogram buckets and counts and summary counts are cumulative totals,
+// they are reported as counters of whole increase since previous scrape, fractional part is carried over.
+// Gauges, untyped samples, quantiles and sums are reported as gauges.
+// Samples resulting in the same metric ID after relabeling are summed.
+type PrometheusMonitor struct {
+	storage storage.MetricsStorage
+	log     log.Logger
+	now     func() time.Time
+	targets []promTarget
+}
+
+func NewPrometheusMonitor(s storage.MetricsStorage, l log.Logger, cfg config.PrometheusMonitorConfig) (*PrometheusMonitor, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	m := PrometheusMonitor{storage: s, log: l, now: time.Now}
+	for _, t := range cfg.Targets {
+		if t.Timeout.D == 0 {
+			t.Timeout.D = defaultPrometheusTimeout
+		}
+
+		target := promTarget{
+			cfg:      t,
+			suffix:   metricSuffix(t.Name),
+			client:   &http.Client{Timeout: t.Timeout.D},
+			schedule: intervalSchedule{interval: t.Interval.D},
+			counters: make(map[string]promCounterState),
+		}
+		for _, r := range t.Relabel {
+			target.relabel = append(target.relabel, newRelabelRule(r))
+		}
+		m.targets = append(m.targets, target)
+	}
+
+	return &m, nil
+}
+
+// GetMetricsStorage return underlying metrics storage.
+func (m *PrometheusMonitor) GetMetricsStorage() storage.MetricsStorage {
+	return m.storage
+}
+
+// GatherMetrics concurrently scrapes targets which interval has elapsed and adds their metrics to storage.
+func (m *PrometheusMonitor) GatherMetrics() error {
+	m.storage.Clear()
+
+	now := m.now()
+	due := make([]*promTarget, 0, len(m.targets))
+	for i := range m.targets {
+		if t := &m.targets[i]; t.schedule.due(now) {
+			due = append(due, t)
+		}
+	}
+
+	results := make([]promScrapeResult, len(due))
+	wg := sync.WaitGroup{}
+	wg.Add(len(due))
+	for i, t := range due {
+		i, t := i, t
+		go func() {
+			defer wg.Done()
+			results[i] = t.scrape()
+		}()
+	}
+	wg.Wait()
+
+	for _, r := range results {
+		up := 1.0
+		if r.err != nil {
+			m.log.Warnf("Failed to scrape prometheus target %v: %v", r.target.cfg.Name, r.err)
+			up = 0
+		}
+
+		values := append(r.metrics,
+			metrics.NewGaugeMetric(PrometheusUpMetric+r.target.suffix, up),
+			metrics.NewGaugeMetric(PrometheusDurationMetric+r.target.suffix, r.duration.Seconds()),
+			metrics.NewGaugeMetric(PrometheusSamplesMetric+r.target.suffix, float64(len(r.metrics))))
+		for _, met := range values {
+			if err := m.storage.AddOrUpdate(met); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (t *promTarget) scrape() promScrapeResult {
+	res := promScrapeResult{target: t}
+
+	req, err := http.NewRequest(http.MethodGet, t.cfg.URL, nil)
+	if err != nil {
+		res.err = fmt.Errorf("failed to create request: %w", err)
+		return res
+	}
+	req.Header.Set("Accept", promAccept)
+
+	start := time.Now()
+	defer func() {
+		res.duration = time.Since(start)
+	}()
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		res.err = err
+		return res
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		res.err = fmt.Errorf("unexpected status code %v", resp.StatusCode)
+		return res
+	}
+
+	samples, err := parsePromText(resp.Body)
+	if err != nil {
+		res.err = err
+		return res
+	}
+	res.metrics = t.convert(samples)
+
+	return res
+}
+
+// convert relabels samples and converts them to metrics.
+func (t *promTarget) convert(samples []promSample) []metrics.Metric {
+	type series struct {
+		id         string
+		value      float64
+		cumulative bool
+	}
+
+	all := make([]series, 0, len(samples))
+	idx := make(map[string]int, len(samples))
+	for i := range samples {
+		s := &samples[i]
+		if math.IsNaN(s.value) || math.IsInf(s.value, 0) {
+			continue
+		}
+
+		s.labels[promNameLabel] = s.name
+		kept := true
+		for j := range t.relabel {
+			if kept = t.relabel[j].apply(s.labels); !kept {
+				break
+			}
+		}
+		if !kept {
+			continue
+		}
+
+		id := promSeriesID(t.cfg.Prefix, s.labels)
+		if j, ok := idx[id]; ok {
+			all[j].value += s.value
+			continue
+		}
+		idx[id] = len(all)
+		all = append(all, series{id: id, value: s.value, cumulative: s.cumulative()})
+	}
+
+	res := make([]metrics.Metric, 0, len(all))
+	counters := make(map[string]promCounterState, len(t.counters))
+	for _, s := range all {
+		if !s.cumulative {
+			res = append(res, metrics.NewGaugeMetric(s.id, s.value))
+			continue
+		}
+
+		prev, seen := t.counters[s.id]
+		if seen && s.value < prev.total {
+			// Counter was reset by target restart.
+			prev.reported = 0
+		}
+
+		whole := int64(math.Floor(s.value))
+		counters[s.id] = promCounterState{total: s.value, reported: whole}
+		// Totals accumulated before the first scrape aren't reported.
+		if seen {
+			res = append(res, metrics.NewCounterMetric(s.id, whole-prev.reported))
+		}
+	}
+	t.counters = counters
+
+	return res
+}
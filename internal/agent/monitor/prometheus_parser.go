@@ -0,0 +1,228 @@
+package monitor
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Metric types of Prometheus text exposition format.
+const (
+	promCounter   = "counter"
+	promGauge     = "gauge"
+	promHistogram = "histogram"
+	promSummary   = "summary"
+	promUntyped   = "untyped"
+)
+
+// promSample sample of Prometheus text exposition format.
+type promSample struct {
+	labels map[string]string
+	name   string
+	// mtype type of metric family declared by TYPE comment, untyped if not declared.
+	mtype string
+	value float64
+}
+
+// cumulative reports whether sample is a monotonic total which should be reported as counter.
+func (s *promSample) cumulative() bool {
+	switch s.mtype {
+	case promCounter:
+		return true
+	case promHistogram:
+		return strings.HasSuffix(s.name, "_bucket") || strings.HasSuffix(s.name, "_count")
+	case promSummary:
+		return strings.HasSuffix(s.name, "_count")
+	}
+
+	return false
+}
+
+// parsePromText parses samples of Prometheus text exposition format.
+// HELP and other comments and sample timestamps are ignored.
+func parsePromText(r io.Reader) ([]promSample, error) {
+	types := make(map[string]string)
+	res := make([]promSample, 0)
+
+	scanner := bufio.NewScanner(r)
+	// Lines with many labels may be long.
+	const maxLineSize = 1024 * 1024
+	scanner.Buffer(nil, maxLineSize)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if len(text) == 0 {
+			continue
+		}
+
+		if strings.HasPrefix(text, "#") {
+			fields := strings.Fields(text[1:])
+			if len(fields) == 3 && fields[0] == "TYPE" {
+				types[fields[1]] = strings.ToLower(fields[2])
+			}
+			continue
+		}
+
+		s, err := parsePromSample(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sample on line %v: %w", line, err)
+		}
+		s.mtype = promFamilyType(types, s.name)
+		res = append(res, s)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read samples: %w", err)
+	}
+
+	return res, nil
+}
+
+// promFamilyType finds type of family sample belongs to,
+// histogram and summary samples are named with suffixes added to family name.
+func promFamilyType(types map[string]string, name string) string {
+	if t, ok := types[name]; ok {
+		return t
+	}
+
+	for _, suffix := range []string{"_bucket", "_count", "_sum"} {
+		base, ok := strings.CutSuffix(name, suffix)
+		if !ok {
+			continue
+		}
+		if t := types[base]; t == promHistogram || t == promSummary {
+			return t
+		}
+	}
+
+	return promUntyped
+}
+
+func isPromNameChar(c byte, first bool) bool {
+	if (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '_' || c == ':' {
+		return true
+	}
+	return !first && c >= '0' && c <= '9'
+}
+
+// promName returns length of metric or label name at the beginning of s.
+func promName(s string) int {
+	i := 0
+	for i < len(s) && isPromNameChar(s[i], i == 0) {
+		i++
+	}
+	return i
+}
+
+// parsePromSample parses sample line "name{label="value",...} value [timestamp]".
+func parsePromSample(line string) (promSample, error) {
+	s := promSample{labels: make(map[string]string)}
+
+	n := promName(line)
+	if n == 0 {
+		return s, fmt.Errorf("invalid metric name")
+	}
+	s.name = line[:n]
+	rest := strings.TrimLeft(line[n:], " \t")
+
+	if strings.HasPrefix(rest, "{") {
+		n, err := parsePromLabels(rest, s.labels)
+		if err != nil {
+			return s, err
+		}
+		rest = rest[n:]
+	}
+
+	fields := strings.Fields(rest)
+	if len(fields) == 0 || len(fields) > 2 {
+		return s, fmt.Errorf("expected value and optional timestamp")
+	}
+
+	value, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return s, fmt.Errorf("invalid value: %w", err)
+	}
+	s.value = value
+
+	return s, nil
+}
+
+// parsePromLabels parses labels set in braces at the beginning of s into labels.
+// Returns length of parsed labels set.
+func parsePromLabels(s string, labels map[string]string) (int, error) {
+	skipSpaces := func(i int) int {
+		for i < len(s) && (s[i] == ' ' || s[i] == '\t') {
+			i++
+		}
+		return i
+	}
+
+	i := 1
+	for {
+		i = skipSpaces(i)
+		if i >= len(s) {
+			return 0, fmt.Errorf("unterminated labels")
+		}
+		if s[i] == '}' {
+			return i + 1, nil
+		}
+
+		n := promName(s[i:])
+		if n == 0 {
+			return 0, fmt.Errorf("invalid label name")
+		}
+		name := s[i : i+n]
+
+		i = skipSpaces(i + n)
+		if i >= len(s) || s[i] != '=' {
+			return 0, fmt.Errorf("expected '=' after label %v", name)
+		}
+
+		i = skipSpaces(i + 1)
+		if i >= len(s) || s[i] != '"' {
+			return 0, fmt.Errorf("expected quoted value of label %v", name)
+		}
+
+		value, n, err := parsePromLabelValue(s[i+1:])
+		if err != nil {
+			return 0, fmt.Errorf("invalid value of label %v: %w", name, err)
+		}
+		labels[name] = value
+
+		i = skipSpaces(i + 1 + n)
+		if i < len(s) && s[i] == ',' {
+			i++
+		}
+	}
+}
+
+// parsePromLabelValue unescapes label value up to closing quote.
+// Returns length of value including closing quote.
+func parsePromLabelValue(s string) (string, int, error) {
+	b := strings.Builder{}
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			return b.String(), i + 1, nil
+		case '\\':
+			if i+1 >= len(s) {
+				return "", 0, fmt.Errorf("unterminated escape sequence")
+			}
+			i++
+			switch s[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case '\\', '"':
+				b.WriteByte(s[i])
+			default:
+				b.WriteByte('\\')
+				b.WriteByte(s[i])
+			}
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+
+	return "", 0, fmt.Errorf("unterminated value")
+}
@@ -0,0 +1,201 @@
+package monitor
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fuzzy-toozy/metrics-service/internal/agent/config"
+	"github.com/fuzzy-toozy/metrics-service/internal/agent/monitor/storage"
+	commonConfig "github.com/fuzzy-toozy/metrics-service/internal/config"
+	"github.com/fuzzy-toozy/metrics-service/internal/log"
+	"github.com/fuzzy-toozy/metrics-service/internal/metrics"
+	"github.com/stretchr/testify/require"
+)
+
+const promExposition = `# HELP http_requests_total Total requests.
+# TYPE http_requests_total counter
+http_requests_total{method="get",code="200"} %GET%
+http_requests_total{method="post",code="200"} 3 1700000000000
+http_requests_total{method="post",code="500"} 1
+# TYPE temperature gauge
+temperature 21.5
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 2
+latency_seconds_bucket{le="+Inf"} 4
+latency_seconds_sum 1.25
+latency_seconds_count 4
+# TYPE rpc summary
+rpc{quantile="0.5"} 0.01
+rpc_sum 2
+rpc_count %RPC%
+go_goroutines{path="C:\\dir",msg="say \"hi\"\nbye"} 7
+nan_value NaN
+`
+
+func Test_ParsePromText(t *testing.T) {
+	body := strings.NewReplacer("%GET%", "10.5", "%RPC%", "5").Replace(promExposition)
+	samples, err := parsePromText(strings.NewReader(body))
+	require.NoError(t, err)
+	require.Len(t, samples, 13)
+
+	require.Equal(t, "http_requests_total", samples[0].name)
+	require.Equal(t, map[string]string{"method": "get", "code": "200"}, samples[0].labels)
+	require.Equal(t, 10.5, samples[0].value)
+	require.True(t, samples[0].cumulative())
+
+	require.Equal(t, promGauge, samples[3].mtype)
+	require.False(t, samples[3].cumulative())
+
+	types := make(map[string]bool)
+	for _, s := range samples[4:8] {
+		require.Equal(t, promHistogram, s.mtype, s.name)
+		types[s.name] = s.cumulative()
+	}
+	require.Equal(t, map[string]bool{"latency_seconds_bucket": true, "latency_seconds_sum": false, "latency_seconds_count": true}, types)
+
+	require.Equal(t, promSummary, samples[8].mtype)
+	require.False(t, samples[8].cumulative())
+	require.True(t, samples[10].cumulative())
+
+	require.Equal(t, promUntyped, samples[11].mtype)
+	require.Equal(t, map[string]string{"path": `C:\dir`, "msg": "say \"hi\"\nbye"}, samples[11].labels)
+
+	for _, invalid := range []string{
+		"1name 1",
+		"name",
+		"name{label=\"value} 1",
+		"name{label=value} 1",
+		"name{label=\"value\"} abc",
+		"name 1 2 3",
+	} {
+		_, err := parsePromText(strings.NewReader(invalid))
+		require.Error(t, err, invalid)
+	}
+}
+
+func Test_PrometheusMonitor(t *testing.T) {
+	replacer := strings.NewReplacer("%GET%", "10.5", "%RPC%", "5")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(replacer.Replace(promExposition)))
+	}))
+	defer srv.Close()
+
+	cfg := config.PrometheusMonitorConfig{Targets: []config.PrometheusTargetConfig{{
+		Name:   "app",
+		URL:    srv.URL,
+		Prefix: "app_",
+		Relabel: []config.RelabelRuleConfig{
+			{Action: config.RelabelDrop, Regex: "go_.*"},
+			{SourceLabels: []string{"__name__"}, Regex: "rpc(.*)", TargetLabel: "__name__", Replacement: "grpc$1"},
+			{Action: config.RelabelLabelDrop, Regex: "code"},
+		},
+	}}}
+
+	m, err := NewPrometheusMonitor(storage.NewCommonMetricsStorage(), log.NewDummyLogger(), cfg)
+	require.NoError(t, err)
+
+	// Counters are reported starting from the second scrape.
+	require.NoError(t, m.GatherMetrics())
+	gauges := metricsByType(m.GetMetricsStorage(), metrics.GaugeMetricType)
+	require.Empty(t, metricsByType(m.GetMetricsStorage(), metrics.CounterMetricType))
+
+	expectedGauges := map[string]float64{
+		"app_temperature":               21.5,
+		"app_latency_seconds_sum":       1.25,
+		`app_grpc{quantile="0.5"}`:      0.01,
+		"app_grpc_sum":                  2,
+		PrometheusUpMetric + "app":      1,
+		PrometheusSamplesMetric + "app": 4,
+	}
+	for id, val := range expectedGauges {
+		require.Equal(t, val, *gauges[id].Value, id)
+	}
+	require.Contains(t, gauges, PrometheusDurationMetric+"app")
+	require.Len(t, gauges, len(expectedGauges)+1)
+
+	replacer = strings.NewReplacer("%GET%", "12.25", "%RPC%", "2")
+	require.NoError(t, m.GatherMetrics())
+	counters := metricsByType(m.GetMetricsStorage(), metrics.CounterMetricType)
+
+	expectedCounters := map[string]int64{
+		// Fractional part of the first total isn't reported.
+		`app_http_requests_total{method="get"}`: 2,
+		// Samples differing by dropped label are summed.
+		`app_http_requests_total{method="post"}`: 0,
+		`app_latency_seconds_bucket{le="0.1"}`:   0,
+		`app_latency_seconds_bucket{le="+Inf"}`:  0,
+		"app_latency_seconds_count":              0,
+		// Total decreased after reset is reported as is.
+		"app_grpc_count": 2,
+	}
+	require.Len(t, counters, len(expectedCounters))
+	for id, val := range expectedCounters {
+		require.Equal(t, val, *counters[id].Delta, id)
+	}
+
+	replacer = strings.NewReplacer("%GET%", "13", "%RPC%", "2")
+	require.NoError(t, m.GatherMetrics())
+	counters = metricsByType(m.GetMetricsStorage(), metrics.CounterMetricType)
+	require.Equal(t, int64(1), *counters[`app_http_requests_total{method="get"}`].Delta)
+}
+
+func Test_PrometheusMonitorFailure(t *testing.T) {
+	status := http.StatusInternalServerError
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte("# TYPE requests counter\nrequests 10\n"))
+	}))
+	defer srv.Close()
+
+	cfg := config.PrometheusMonitorConfig{Targets: []config.PrometheusTargetConfig{
+		{Name: "app", URL: srv.URL, Interval: commonConfig.DurationOption{D: time.Minute}},
+	}}
+	m, err := NewPrometheusMonitor(storage.NewCommonMetricsStorage(), log.NewDummyLogger(), cfg)
+	require.NoError(t, err)
+
+	now := time.Now()
+	m.now = func() time.Time { return now }
+
+	require.NoError(t, m.GatherMetrics())
+	gauges := metricsByType(m.GetMetricsStorage(), metrics.GaugeMetricType)
+	require.Equal(t, 0.0, *gauges[PrometheusUpMetric+"app"].Value)
+	require.Equal(t, 0.0, *gauges[PrometheusSamplesMetric+"app"].Value)
+
+	// Target isn't scraped until interval elapses.
+	status = http.StatusOK
+	now = now.Add(30 * time.Second)
+	require.NoError(t, m.GatherMetrics())
+	require.Empty(t, m.GetMetricsStorage().GetAllMetrics())
+
+	now = now.Add(30 * time.Second)
+	require.NoError(t, m.GatherMetrics())
+	gauges = metricsByType(m.GetMetricsStorage(), metrics.GaugeMetricType)
+	require.Equal(t, 1.0, *gauges[PrometheusUpMetric+"app"].Value)
+}
+
+func Test_PrometheusMonitorConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		target config.PrometheusTargetConfig
+	}{
+		{"empty name", config.PrometheusTargetConfig{URL: "http://localhost/metrics"}},
+		{"invalid scheme", config.PrometheusTargetConfig{Name: "a", URL: "localhost/metrics"}},
+		{"invalid regex", config.PrometheusTargetConfig{Name: "a", URL: "http://localhost/metrics",
+			Relabel: []config.RelabelRuleConfig{{Action: config.RelabelDrop, Regex: "("}}}},
+		{"invalid action", config.PrometheusTargetConfig{Name: "a", URL: "http://localhost/metrics",
+			Relabel: []config.RelabelRuleConfig{{Action: "hashmod"}}}},
+		{"no target label", config.PrometheusTargetConfig{Name: "a", URL: "http://localhost/metrics",
+			Relabel: []config.RelabelRuleConfig{{Replacement: "x"}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewPrometheusMonitor(storage.NewCommonMetricsStorage(), log.NewDummyLogger(),
+				config.PrometheusMonitorConfig{Targets: []config.PrometheusTargetConfig{tt.target}})
+			require.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,238 @@
+package monitor
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/fuzzy-toozy/metrics-service/internal/agent/monitor/storage"
+	"github.com/fuzzy-toozy/metrics-service/internal/log"
+	"github.com/fuzzy-toozy/metrics-service/internal/metrics"
+)
+
+const (
+	LoadAverage1Metric    = "LoadAverage1"
+	LoadAverage5Metric    = "LoadAverage5"
+	LoadAverage15Metric   = "LoadAverage15"
+	UptimeMetric          = "Uptime"
+	ContextSwitchesMetric = "ContextSwitches"
+	InterruptsMetric      = "Interrupts"
+)
+
+// Pressure metrics names prefix, followed by resource, kind and average window or "TotalUsec",
+// e.g. PressureCPUSomeAvg10 or PressureIOFullTotalUsec.
+const PressureMetric = "Pressure"
+
+// pressureResources PSI files in /proc/pressure and their names in metrics.
+var pressureResources = []struct {
+	file string
+	name string
+}{
+	{"cpu", "CPU"},
+	{"memory", "Memory"},
+	{"io", "IO"},
+}
+
+// SystemMonitor load averages, uptime, context switches, interrupts and pressure stall information read from procfs.
+// Cumulative totals are reported as counters of increase since previous gather, averages as gauges.
+type SystemMonitor struct {
+	storage  storage.MetricsStorage
+	log      log.Logger
+	counters *counterTracker
+	procRoot string
+}
+
+func NewSystemMonitor(s storage.MetricsStorage, l log.Logger, procRoot string) *SystemMonitor {
+	return &SystemMonitor{storage: s, log: l, counters: newCounterTracker(), procRoot: procRoot}
+}
+
+// GetMetricsStorage return underlying metrics storage.
+func (m *SystemMonitor) GetMetricsStorage() storage.MetricsStorage {
+	return m.storage
+}
+
+// GatherMetrics reads system statistics and add them to storage.
+func (m *SystemMonitor) GatherMetrics() error {
+	m.storage.Clear()
+
+	// Failing statistics don't prevent others from being gathered.
+	var errs []error
+	for _, gather := range []func() error{m.gatherLoadAverage, m.gatherUptime, m.gatherStat, m.gatherPressure} {
+		if err := gather(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	m.counters.commit()
+
+	return errors.Join(errs...)
+}
+
+func (m *SystemMonitor) addCounter(id string, total uint64) error {
+	delta, ok := m.counters.delta(id, total)
+	if !ok {
+		return nil
+	}
+	return m.storage.AddOrUpdate(metrics.NewCounterMetric(id, delta))
+}
+
+// readFields reads first line of procfs file split to fields.
+func (m *SystemMonitor) readFields(name string) ([]string, error) {
+	data, err := os.ReadFile(filepath.Join(m.procRoot, name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %v: %w", name, err)
+	}
+
+	line, _, _ := strings.Cut(string(data), "\n")
+	return strings.Fields(line), nil
+}
+
+func (m *SystemMonitor) gatherLoadAverage() error {
+	fields, err := m.readFields("loadavg")
+	if err != nil {
+		return err
+	}
+
+	names := []string{LoadAverage1Metric, LoadAverage5Metric, LoadAverage15Metric}
+	if len(fields) < len(names) {
+		return fmt.Errorf("invalid loadavg format")
+	}
+
+	for i, name := range names {
+		val, err := strconv.ParseFloat(fields[i], 64)
+		if err != nil {
+			return fmt.Errorf("invalid loadavg format: %w", err)
+		}
+		if err = m.storage.AddOrUpdate(metrics.NewGaugeMetric(name, val)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *SystemMonitor) gatherUptime() error {
+	fields, err := m.readFields("uptime")
+	if err != nil {
+		return err
+	}
+
+	if len(fields) == 0 {
+		return fmt.Errorf("invalid uptime format")
+	}
+
+	uptime, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return fmt.Errorf("invalid uptime format: %w", err)
+	}
+
+	return m.storage.AddOrUpdate(metrics.NewGaugeMetric(UptimeMetric, uptime))
+}
+
+func (m *SystemMonitor) gatherStat() error {
+	f, err := os.Open(filepath.Join(m.procRoot, "stat"))
+	if err != nil {
+		return fmt.Errorf("failed to open stat: %w", err)
+	}
+	defer f.Close()
+
+	names := map[string]string{
+		"ctxt": ContextSwitchesMetric,
+		// The first value of intr is total of all interrupts.
+		"intr": InterruptsMetric,
+	}
+
+	var errs []error
+	scanner := bufio.NewScanner(f)
+	// Interrupts line may be long on systems with many IRQs.
+	const maxLineSize = 1024 * 1024
+	scanner.Buffer(nil, maxLineSize)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+
+		name, ok := names[fields[0]]
+		if !ok {
+			continue
+		}
+
+		total, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid %v in stat: %w", fields[0], err))
+			continue
+		}
+		if err = m.addCounter(name, total); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if err = scanner.Err(); err != nil {
+		errs = append(errs, fmt.Errorf("failed to read stat: %w", err))
+	}
+
+	return errors.Join(errs...)
+}
+
+// gatherPressure reads PSI files, missing files are skipped as PSI may be disabled in kernel.
+func (m *SystemMonitor) gatherPressure() error {
+	var errs []error
+	for _, r := range pressureResources {
+		data, err := os.ReadFile(filepath.Join(m.procRoot, "pressure", r.file))
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			errs = append(errs, fmt.Errorf("failed to read %v pressure: %w", r.file, err))
+			continue
+		}
+
+		for _, line := range strings.Split(string(data), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) == 0 {
+				continue
+			}
+
+			// Lines start with "some" or "full".
+			kind := strings.ToUpper(fields[0][:1]) + fields[0][1:]
+			prefix := PressureMetric + r.name + kind
+
+			for _, field := range fields[1:] {
+				key, val, ok := strings.Cut(field, "=")
+				if !ok {
+					continue
+				}
+
+				if key == "total" {
+					total, err := strconv.ParseUint(val, 10, 64)
+					if err != nil {
+						errs = append(errs, fmt.Errorf("invalid %v pressure total: %w", r.file, err))
+						continue
+					}
+					if err = m.addCounter(prefix+"TotalUsec", total); err != nil {
+						errs = append(errs, err)
+					}
+					continue
+				}
+
+				avg, err := strconv.ParseFloat(val, 64)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("invalid %v pressure %v: %w", r.file, key, err))
+					continue
+				}
+				// Averages are named avg10, avg60 and avg300.
+				name := prefix + "Avg" + strings.TrimPrefix(key, "avg")
+				if err = m.storage.AddOrUpdate(metrics.NewGaugeMetric(name, avg)); err != nil {
+					errs = append(errs, err)
+				}
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
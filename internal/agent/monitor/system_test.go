@@ -0,0 +1,124 @@
+package monitor
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fuzzy-toozy/metrics-service/internal/agent/monitor/storage"
+	"github.com/fuzzy-toozy/metrics-service/internal/log"
+	"github.com/fuzzy-toozy/metrics-service/internal/metrics"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_SystemMonitor(t *testing.T) {
+	root := copyFixture(t, "proc")
+	m := NewSystemMonitor(storage.NewCommonMetricsStorage(), log.NewDummyLogger(), root)
+
+	// Counters are reported starting from the second gather.
+	require.NoError(t, m.GatherMetrics())
+	require.Empty(t, metricsByType(m.GetMetricsStorage(), metrics.CounterMetricType))
+
+	gauges := metricsByType(m.GetMetricsStorage(), metrics.GaugeMetricType)
+	expected := map[string]float64{
+		LoadAverage1Metric:         0.52,
+		LoadAverage5Metric:         0.58,
+		LoadAverage15Metric:        0.61,
+		UptimeMetric:               12345.67,
+		"PressureCPUSomeAvg10":     1.5,
+		"PressureCPUSomeAvg60":     2.25,
+		"PressureCPUSomeAvg300":    3,
+		"PressureCPUFullAvg10":     0,
+		"PressureCPUFullAvg60":     0,
+		"PressureCPUFullAvg300":    0,
+		"PressureMemorySomeAvg10":  0.1,
+		"PressureMemorySomeAvg60":  0.2,
+		"PressureMemorySomeAvg300": 0.3,
+		"PressureMemoryFullAvg10":  0.05,
+		"PressureMemoryFullAvg60":  0.1,
+		"PressureMemoryFullAvg300": 0.15,
+		"PressureIOSomeAvg10":      4,
+		"PressureIOSomeAvg60":      3,
+		"PressureIOSomeAvg300":     2,
+		"PressureIOFullAvg10":      2,
+		"PressureIOFullAvg60":      1.5,
+		"PressureIOFullAvg300":     1,
+	}
+	require.Len(t, gauges, len(expected))
+	for id, val := range expected {
+		require.Equal(t, val, *gauges[id].Value, id)
+	}
+
+	stat := "cpu  1 2 3 4\nintr 114931548 113199788 3\nctxt 1990573\nbtime 1062191376\n"
+	require.NoError(t, os.WriteFile(filepath.Join(root, "stat"), []byte(stat), 0644))
+	cpu := "some avg10=1.50 avg60=2.25 avg300=3.00 total=1000500\nfull avg10=0.00 avg60=0.00 avg300=0.00 total=0\n"
+	require.NoError(t, os.WriteFile(filepath.Join(root, "pressure", "cpu"), []byte(cpu), 0644))
+
+	require.NoError(t, m.GatherMetrics())
+	counters := metricsByType(m.GetMetricsStorage(), metrics.CounterMetricType)
+	expectedCounters := map[string]int64{
+		InterruptsMetric:              1000,
+		ContextSwitchesMetric:         100,
+		"PressureCPUSomeTotalUsec":    500,
+		"PressureCPUFullTotalUsec":    0,
+		"PressureMemorySomeTotalUsec": 0,
+		"PressureMemoryFullTotalUsec": 0,
+		"PressureIOSomeTotalUsec":     0,
+		"PressureIOFullTotalUsec":     0,
+	}
+	require.Len(t, counters, len(expectedCounters))
+	for id, delta := range expectedCounters {
+		require.Equal(t, delta, *counters[id].Delta, id)
+	}
+}
+
+func Test_SystemMonitorWithoutPressure(t *testing.T) {
+	root := copyFixture(t, "proc")
+	require.NoError(t, os.RemoveAll(filepath.Join(root, "pressure")))
+
+	m := NewSystemMonitor(storage.NewCommonMetricsStorage(), log.NewDummyLogger(), root)
+	require.NoError(t, m.GatherMetrics())
+	require.Len(t, m.GetMetricsStorage().GetAllMetrics(), 4)
+
+	require.NoError(t, os.WriteFile(filepath.Join(root, "loadavg"), []byte("x"), 0644))
+	require.Error(t, m.GatherMetrics())
+}
+
+func Test_SystemMonitorSkipsInvalidData(t *testing.T) {
+	root := copyFixture(t, "proc")
+	memory := "some avg10=0.10 avg60=0.20 avg300=0.30 total=x\nfull avg10=0.05 avg60=0.10 avg300=0.15 total=2500\n"
+	require.NoError(t, os.WriteFile(filepath.Join(root, "pressure", "memory"), []byte(memory), 0644))
+
+	m := NewSystemMonitor(storage.NewCommonMetricsStorage(), log.NewDummyLogger(), root)
+
+	// Invalid pressure total is reported as error, other statistics are still gathered.
+	require.Error(t, m.GatherMetrics())
+	gauges := metricsByType(m.GetMetricsStorage(), metrics.GaugeMetricType)
+	require.Equal(t, 0.1, *gauges["PressureMemorySomeAvg10"].Value)
+	require.Equal(t, 4.0, *gauges["PressureIOSomeAvg10"].Value)
+
+	stat := "intr 114931548 113199788 3\nctxt 1990573\n"
+	require.NoError(t, os.WriteFile(filepath.Join(root, "stat"), []byte(stat), 0644))
+
+	require.Error(t, m.GatherMetrics())
+	counters := metricsByType(m.GetMetricsStorage(), metrics.CounterMetricType)
+	require.Equal(t, int64(100), *counters[ContextSwitchesMetric].Delta)
+	require.Equal(t, int64(0), *counters["PressureMemoryFullTotalUsec"].Delta)
+	_, ok := counters["PressureMemorySomeTotalUsec"]
+	require.False(t, ok)
+
+	// Increase is not counted again by the next gather.
+	require.Error(t, m.GatherMetrics())
+	counters = metricsByType(m.GetMetricsStorage(), metrics.CounterMetricType)
+	require.Equal(t, int64(0), *counters[ContextSwitchesMetric].Delta)
+}
+
+func Test_SystemMonitorLocal(t *testing.T) {
+	if _, err := os.Stat("/proc/loadavg"); err != nil {
+		t.Skip("procfs is not available")
+	}
+
+	m := NewSystemMonitor(storage.NewCommonMetricsStorage(), log.NewDummyLogger(), "/proc")
+	require.NoError(t, m.GatherMetrics())
+	require.NoError(t, m.GatherMetrics())
+}
@@ -0,0 +1,99 @@
+// Package push Receiver of metrics pushed to agent by local applications.
+package push
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/fuzzy-toozy/metrics-service/internal/agent/monitor/storage"
+	"github.com/fuzzy-toozy/metrics-service/internal/metrics"
+)
+
+// Aggregator accumulates metrics pushed between gathers.
+// Counters with the same ID are summed, gauges are replaced by the latest value.
+// Implements monitor.Monitor, so pushed metrics are reported to server by agent as gathered ones.
+type Aggregator struct {
+	storage storage.MetricsStorage
+	// index positions of pending metrics by type and ID.
+	index   map[string]int
+	pending []metrics.Metric
+	lock    sync.Mutex
+}
+
+func NewAggregator(s storage.MetricsStorage) *Aggregator {
+	return &Aggregator{storage: s, index: make(map[string]int)}
+}
+
+// normalize checks metric and clears value not used by its type.
+func normalize(m *metrics.Metric) error {
+	if len(m.ID) == 0 {
+		return fmt.Errorf("metric id is empty")
+	}
+
+	m.MType = strings.ToLower(m.MType)
+	if _, err := m.GetData(); err != nil {
+		return err
+	}
+
+	if m.MType == metrics.CounterMetricType {
+		m.Value = nil
+	} else {
+		m.Delta = nil
+	}
+
+	return nil
+}
+
+// Add accumulates metrics, nothing is added if any of metrics is invalid.
+func (a *Aggregator) Add(received []metrics.Metric) error {
+	for i := range received {
+		if err := normalize(&received[i]); err != nil {
+			return fmt.Errorf("invalid metric %v: %w", i, err)
+		}
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	for _, m := range received {
+		key := m.MType + ":" + m.ID
+		i, ok := a.index[key]
+		if !ok {
+			a.index[key] = len(a.pending)
+			a.pending = append(a.pending, m)
+			continue
+		}
+
+		if m.MType == metrics.CounterMetricType {
+			sum := *a.pending[i].Delta + *m.Delta
+			m.Delta = &sum
+		}
+		a.pending[i] = m
+	}
+
+	return nil
+}
+
+// GetMetricsStorage return underlying metrics storage.
+func (a *Aggregator) GetMetricsStorage() storage.MetricsStorage {
+	return a.storage
+}
+
+// GatherMetrics moves metrics pushed since previous gather to storage.
+func (a *Aggregator) GatherMetrics() error {
+	a.lock.Lock()
+	pending := a.pending
+	a.pending = nil
+	a.index = make(map[string]int, len(pending))
+	a.lock.Unlock()
+
+	a.storage.Clear()
+	for _, m := range pending {
+		if err := a.storage.AddOrUpdate(m); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,133 @@
+package push
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/fuzzy-toozy/metrics-service/internal/agent/config"
+	"github.com/fuzzy-toozy/metrics-service/internal/agent/monitor/storage"
+	"github.com/fuzzy-toozy/metrics-service/internal/log"
+	"github.com/fuzzy-toozy/metrics-service/internal/metrics"
+	"github.com/stretchr/testify/require"
+)
+
+func gathered(t *testing.T, agg *Aggregator) []metrics.Metric {
+	require.NoError(t, agg.GatherMetrics())
+	return agg.GetMetricsStorage().GetAllMetrics()
+}
+
+func TestAggregator(t *testing.T) {
+	agg := NewAggregator(storage.NewCommonMetricsStorage())
+
+	require.NoError(t, agg.Add([]metrics.Metric{
+		metrics.NewCounterMetric("requests", 1),
+		metrics.NewGaugeMetric("queue", 10),
+	}))
+	// Types are case insensitive as on server.
+	one := int64(1)
+	require.NoError(t, agg.Add([]metrics.Metric{
+		metrics.NewCounterMetric("requests", 2),
+		metrics.NewGaugeMetric("queue", 5),
+		{ID: "errors", MType: "Counter", Delta: &one},
+	}))
+
+	// Invalid batches are rejected as a whole.
+	invalid := [][]metrics.Metric{
+		{metrics.NewCounterMetric("requests", 100), {ID: "bad", MType: "histogram"}},
+		{metrics.NewCounterMetric("", 1)},
+		{{ID: "no_value", MType: metrics.GaugeMetricType}},
+	}
+	for _, batch := range invalid {
+		require.Error(t, agg.Add(batch))
+	}
+
+	require.Equal(t, []metrics.Metric{
+		metrics.NewCounterMetric("requests", 3),
+		metrics.NewGaugeMetric("queue", 5),
+		metrics.NewCounterMetric("errors", 1),
+	}, gathered(t, agg))
+
+	// Metrics are reported once.
+	require.Empty(t, gathered(t, agg))
+}
+
+func TestServer(t *testing.T) {
+	agg := NewAggregator(storage.NewCommonMetricsStorage())
+	cfg := config.PushReceiverConfig{Address: "127.0.0.1:0", UDPAddress: "127.0.0.1:0"}
+	srv, err := Listen(cfg, agg, log.NewDummyLogger())
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		srv.Serve(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	url := "http://" + srv.Addr().String()
+	post := func(path string, body string, encoding string) int {
+		data := []byte(body)
+		if encoding == "gzip" {
+			buf := bytes.Buffer{}
+			zw := gzip.NewWriter(&buf)
+			_, err := zw.Write(data)
+			require.NoError(t, err)
+			require.NoError(t, zw.Close())
+			data = buf.Bytes()
+		}
+
+		req, err := http.NewRequest(http.MethodPost, url+path, bytes.NewReader(data))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Content-Encoding", encoding)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	require.Equal(t, http.StatusOK, post("/update", `{"id":"c","type":"counter","delta":1}`, ""))
+	require.Equal(t, http.StatusOK, post("/updates", `[{"id":"c","type":"counter","delta":2},{"id":"g","type":"gauge","value":1.5}]`, "gzip"))
+	require.Equal(t, http.StatusBadRequest, post("/update", `{"id":"c","type":"counter"}`, ""))
+	require.Equal(t, http.StatusBadRequest, post("/updates", `{"id":"c"`, ""))
+	require.Equal(t, http.StatusBadRequest, post("/updates", `[]`, "br"))
+
+	resp, err := http.Post(url+"/update", "text/plain", bytes.NewBufferString(`{"id":"c","type":"counter","delta":1}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusUnsupportedMediaType, resp.StatusCode)
+
+	conn, err := net.Dial("udp", srv.UDPAddr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	for _, datagram := range []string{
+		`{"id":"c","type":"counter","delta":4}`,
+		`invalid`,
+		` [{"id":"u","type":"gauge","value":7}]`,
+	} {
+		_, err = conn.Write([]byte(datagram))
+		require.NoError(t, err)
+	}
+
+	expected := []metrics.Metric{
+		metrics.NewCounterMetric("c", 7),
+		metrics.NewGaugeMetric("g", 1.5),
+		metrics.NewGaugeMetric("u", 7),
+	}
+	require.Eventually(t, func() bool {
+		agg.lock.Lock()
+		defer agg.lock.Unlock()
+		_, ok := agg.index[metrics.GaugeMetricType+":u"]
+		return ok && *agg.pending[agg.index[metrics.CounterMetricType+":c"]].Delta == 7
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, expected, gathered(t, agg))
+}
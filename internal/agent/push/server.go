@@ -0,0 +1,229 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/fuzzy-toozy/metrics-service/internal/agent/config"
+	"github.com/fuzzy-toozy/metrics-service/internal/compression"
+	"github.com/fuzzy-toozy/metrics-service/internal/log"
+	"github.com/fuzzy-toozy/metrics-service/internal/metrics"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+)
+
+const (
+	maxBodySize = 10 * 1024 * 1024
+	// maxDatagramSize max size of UDP payload.
+	maxDatagramSize   = 64 * 1024
+	readHeaderTimeout = 10 * time.Second
+	shutdownTimeout   = 5 * time.Second
+)
+
+type handler struct {
+	agg *Aggregator
+	log log.Logger
+}
+
+// NewHandler returns handler accepting metrics on /update and /updates in the same JSON format as server.
+// Accepted metrics are sent back in response, values aren't accumulated with stored ones as server does.
+func NewHandler(agg *Aggregator, l log.Logger) http.Handler {
+	h := handler{agg: agg, log: l}
+
+	r := chi.NewRouter()
+	r.Use(middleware.AllowContentType("application/json"))
+	r.Post("/update", h.update)
+	r.Post("/updates", h.updates)
+
+	return r
+}
+
+func (h *handler) decode(w http.ResponseWriter, r *http.Request, data any) error {
+	body := io.Reader(http.MaxBytesReader(w, r.Body, maxBodySize))
+
+	if encoding := r.Header.Get("Content-Encoding"); len(encoding) > 0 {
+		factory, err := compression.GetDeompressorFactory(encoding)
+		if err != nil {
+			return err
+		}
+		decompressor, err := factory(body)
+		if err != nil {
+			return fmt.Errorf("failed to create decompressor for encoding %v: %w", encoding, err)
+		}
+		defer decompressor.Close()
+		body = decompressor
+	}
+
+	return json.NewDecoder(body).Decode(data)
+}
+
+func (h *handler) respond(w http.ResponseWriter, status int, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		h.log.Debugf("Failed to write response body: %v", err)
+	}
+}
+
+func (h *handler) add(w http.ResponseWriter, received []metrics.Metric) bool {
+	if err := h.agg.Add(received); err != nil {
+		h.log.Debugf("Rejected pushed metrics: %v", err)
+		h.respond(w, http.StatusBadRequest, struct{}{})
+		return false
+	}
+	return true
+}
+
+func (h *handler) update(w http.ResponseWriter, r *http.Request) {
+	var received metrics.Metric
+	if err := h.decode(w, r, &received); err != nil {
+		h.log.Debugf("Failed to decode pushed metric: %v", err)
+		h.respond(w, http.StatusBadRequest, struct{}{})
+		return
+	}
+
+	if h.add(w, []metrics.Metric{received}) {
+		h.respond(w, http.StatusOK, received)
+	}
+}
+
+func (h *handler) updates(w http.ResponseWriter, r *http.Request) {
+	received := make([]metrics.Metric, 0)
+	if err := h.decode(w, r, &received); err != nil {
+		h.log.Debugf("Failed to decode pushed metrics: %v", err)
+		h.respond(w, http.StatusBadRequest, struct{}{})
+		return
+	}
+
+	if h.add(w, received) {
+		h.respond(w, http.StatusOK, received)
+	}
+}
+
+// decodeDatagram decodes metric or array of metrics sent in UDP datagram.
+func decodeDatagram(data []byte) ([]metrics.Metric, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		res := make([]metrics.Metric, 0)
+		err := json.Unmarshal(data, &res)
+		return res, err
+	}
+
+	var m metrics.Metric
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return []metrics.Metric{m}, nil
+}
+
+// Server serves push receiver HTTP and optional UDP endpoints.
+type Server struct {
+	server   *http.Server
+	listener net.Listener
+	udp      net.PacketConn
+	agg      *Aggregator
+	log      log.Logger
+}
+
+// Listen binds push receiver addresses, metrics aren't received until Serve is called.
+func Listen(cfg config.PushReceiverConfig, agg *Aggregator, l log.Logger) (*Server, error) {
+	listener, err := net.Listen("tcp", cfg.Address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %v: %w", cfg.Address, err)
+	}
+
+	s := Server{
+		server:   &http.Server{Handler: NewHandler(agg, l), ReadHeaderTimeout: readHeaderTimeout},
+		listener: listener,
+		agg:      agg,
+		log:      l,
+	}
+
+	if len(cfg.UDPAddress) > 0 {
+		s.udp, err = net.ListenPacket("udp", cfg.UDPAddress)
+		if err != nil {
+			listener.Close()
+			return nil, fmt.Errorf("failed to listen on UDP %v: %w", cfg.UDPAddress, err)
+		}
+	}
+
+	return &s, nil
+}
+
+// Addr returns address HTTP endpoint is bound to.
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// UDPAddr returns address UDP endpoint is bound to or nil if it's disabled.
+func (s *Server) UDPAddr() net.Addr {
+	if s.udp == nil {
+		return nil
+	}
+	return s.udp.LocalAddr()
+}
+
+// Serve receives pushed metrics until ctx is done.
+func (s *Server) Serve(ctx context.Context) {
+	wg := sync.WaitGroup{}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := s.server.Serve(s.listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.log.Errorf("Push receiver failed: %v", err)
+		}
+	}()
+
+	if s.udp != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.serveUDP()
+		}()
+	}
+
+	<-ctx.Done()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := s.server.Shutdown(shutdownCtx); err != nil {
+		s.log.Warnf("Failed to shutdown push receiver: %v", err)
+	}
+	if s.udp != nil {
+		s.udp.Close()
+	}
+
+	wg.Wait()
+	s.log.Infof("Push receiver exited. Reason: %v", ctx.Err())
+}
+
+func (s *Server) serveUDP() {
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, addr, err := s.udp.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			s.log.Debugf("Failed to read UDP datagram: %v", err)
+			continue
+		}
+
+		received, err := decodeDatagram(buf[:n])
+		if err == nil {
+			err = s.agg.Add(received)
+		}
+		if err != nil {
+			s.log.Debugf("Rejected metrics pushed from %v: %v", addr, err)
+		}
+	}
+}
@@ -0,0 +1,9 @@
+package common
+
+// Headers of requests sent by agent.
+const (
+	// AgentIDHeader identifies agent sent request.
+	AgentIDHeader = "X-Agent-ID"
+	// RequestIDHeader unique ID of request, retries of request have the same ID.
+	RequestIDHeader = "X-Request-ID"
+)
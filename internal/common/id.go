@@ -0,0 +1,45 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/beevik/guid"
+)
+
+// PersistentID returns ID stored in file at path.
+// New ID is generated and stored if file doesn't exist, so the same ID is used after restarts.
+func PersistentID(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		if id := strings.TrimSpace(string(data)); len(id) > 0 {
+			return id, nil
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return "", fmt.Errorf("failed to read ID: %w", err)
+	}
+
+	const dirPerms = 0755
+	if err = os.MkdirAll(filepath.Dir(path), dirPerms); err != nil {
+		return "", fmt.Errorf("failed to create ID dir: %w", err)
+	}
+
+	// ID is written to temporary file first, so partially written ID is never read.
+	const perms = 0644
+	id := guid.NewString()
+	tmpPath := path + ".tmp"
+	if err = os.WriteFile(tmpPath, []byte(id), perms); err != nil {
+		return "", fmt.Errorf("failed to write ID: %w", err)
+	}
+
+	if err = os.Rename(tmpPath, path); err != nil {
+		_ = os.Remove(tmpPath)
+		return "", fmt.Errorf("failed to store ID: %w", err)
+	}
+
+	return id, nil
+}
@@ -0,0 +1,25 @@
+package common
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_PersistentID(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "id")
+
+	id, err := PersistentID(path)
+	require.NoError(t, err)
+	require.NotEmpty(t, id)
+
+	// The same ID is returned after restart.
+	again, err := PersistentID(path)
+	require.NoError(t, err)
+	require.Equal(t, id, again)
+
+	other, err := PersistentID(filepath.Join(t.TempDir(), "id"))
+	require.NoError(t, err)
+	require.NotEqual(t, id, other)
+}
@@ -288,3 +288,79 @@ func BenchmarkHandlers(b *testing.B) {
 		}
 	})
 }
+
+// BenchmarkRepositoryContention compares in-memory repositories updated by many agents concurrently.
+func BenchmarkRepositoryContention(b *testing.B) {
+	const (
+		metricsNum = 10000
+		bulkSize   = 100
+	)
+
+	ids := make([]string, metricsNum)
+	for i := range ids {
+		ids[i] = guid.NewString()
+	}
+
+	repos := []struct {
+		newRepo func() storage.Repository
+		name    string
+	}{
+		{name: "Common", newRepo: func() storage.Repository { return storage.NewCommonMetricsRepository() }},
+		{name: "Sharded16", newRepo: func() storage.Repository { return storage.NewShardedMetricsRepository(16) }},
+		{name: "Sharded64", newRepo: func() storage.Repository { return storage.NewShardedMetricsRepository(64) }},
+	}
+
+	for _, r := range repos {
+		b.Run(r.name+"/AddOrUpdate", func(b *testing.B) {
+			repo := r.newRepo()
+			b.RunParallel(func(pb *testing.PB) {
+				rnd := rand.New(rand.NewSource(rand.Int63()))
+				for pb.Next() {
+					if _, err := repo.AddOrUpdate(ids[rnd.Intn(metricsNum)], "1", metrics.CounterMetricType); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		})
+
+		b.Run(r.name+"/AddMetricsBulk", func(b *testing.B) {
+			repo := r.newRepo()
+			b.RunParallel(func(pb *testing.PB) {
+				rnd := rand.New(rand.NewSource(rand.Int63()))
+				bulk := make([]metrics.Metric, bulkSize)
+				for pb.Next() {
+					for i := range bulk {
+						bulk[i] = metrics.NewCounterMetric(ids[rnd.Intn(metricsNum)], 1)
+					}
+					if err := repo.AddMetricsBulk(bulk); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		})
+
+		b.Run(r.name+"/Mixed", func(b *testing.B) {
+			repo := r.newRepo()
+			for _, id := range ids {
+				if _, err := repo.AddOrUpdate(id, "1", metrics.GaugeMetricType); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.RunParallel(func(pb *testing.PB) {
+				rnd := rand.New(rand.NewSource(rand.Int63()))
+				for i := 0; pb.Next(); i++ {
+					id := ids[rnd.Intn(metricsNum)]
+					var err error
+					if i%4 == 0 {
+						_, err = repo.AddOrUpdate(id, "2", metrics.GaugeMetricType)
+					} else {
+						_, err = repo.Get(id, metrics.GaugeMetricType)
+					}
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		})
+	}
+}
@@ -11,28 +11,63 @@ import (
 	"time"
 
 	"github.com/caarlos0/env"
+	"github.com/fuzzy-toozy/metrics-service/internal/compression"
 	"github.com/fuzzy-toozy/metrics-service/internal/config"
 	"github.com/fuzzy-toozy/metrics-service/internal/encryption"
 	"github.com/fuzzy-toozy/metrics-service/internal/log"
 )
 
+// Storage snapshot formats.
+const (
+	// StoreFormatJSON snapshot is JSON array of metrics.
+	StoreFormatJSON = "json"
+	// StoreFormatBinary snapshot is sequence of length-prefixed binary records.
+	StoreFormatBinary = "binary"
+)
+
 type Config struct {
 	// ServerAddress address of the server eg localhost:8080.
 	ServerAddress string `json:"address"`
 	// StoreFilePath path to store metrics storage backup (in case no database used).
 	StoreFilePath string `json:"store_file"`
+	// StoreKeep number of the last storage snapshots kept for fallback restore.
+	StoreKeep int `json:"store_keep"`
+	// StoreFormat storage snapshot format (json or binary).
+	StoreFormat string `json:"store_format"`
+	// StoreCompression storage snapshot compression algorithm, not compressed if empty.
+	StoreCompression string `json:"store_compression"`
+	// StoreShards number of in-memory storage shards, single lock storage is used if zero.
+	StoreShards int `json:"store_shards"`
+	// BoltDBPath path to embedded bbolt database file, used as storage if set (in case no database used).
+	BoltDBPath string `json:"bolt_db"`
 	// Assymetric encryption private key path
 	EncKeyPath string `json:"crypto_key"`
 	// DbConnString database connection string.
 	DBConnString string `json:"database_dsn"`
 	// SecretKey key to validate signature of sent data.
 	SecretKey []byte `json:"signature_key"`
+	// AdminSecret secret required by admin endpoints as bearer token, admin endpoints are disabled if empty.
+	AdminSecret string `json:"admin_secret"`
 	// Assymetric encryption private key
 	EncryptPrivKey *rsa.PrivateKey `json:"-"`
 	// DatabaseConfig database configuration.
 	DatabaseConfig DBConfig `json:"-"`
+	// ForwarderConfig configuration of forwarding accepted metrics to downstream backend.
+	ForwarderConfig ForwarderConfig `json:"forwarder"`
+	// ReplicationConfig configuration of primary/replica replication.
+	ReplicationConfig ReplicationConfig `json:"replication"`
+	// WALFilePath path prefix of write-ahead log segments (in case no database used), disabled if empty.
+	WALFilePath string `json:"wal_file"`
+	// WALSync write-ahead log fsync policy (always, interval or never).
+	WALSync string `json:"wal_sync"`
 	// StoreInterval interval between storage backups (in case no database used).
 	StoreInterval config.DurationOption `json:"store_interval"`
+	// WALSyncInterval interval between write-ahead log fsyncs for interval policy.
+	WALSyncInterval config.DurationOption `json:"wal_sync_interval"`
+	// WALSnapshotInterval interval between snapshots bounding write-ahead log size, if store interval is 0.
+	WALSnapshotInterval config.DurationOption `json:"wal_snapshot_interval"`
+	// IdempotencyTTL time requests stamped with request ID are remembered to detect their retries.
+	IdempotencyTTL config.DurationOption `json:"idempotency_ttl"`
 	// RestoreData instructs to attempt to restore data from file backupt (in case no database used).
 	RestoreData bool `json:"restore"`
 	// MaxBodySize max size of http request body.
@@ -51,7 +86,18 @@ func (c *Config) Print(logger log.Logger) {
 	logger.Infof("Server address: %v", c.ServerAddress)
 	logger.Infof("Store file path: %v", c.StoreFilePath)
 	logger.Infof("Store interval: %v", c.StoreInterval.D)
+	logger.Infof("Store keep snapshots: %v", c.StoreKeep)
+	logger.Infof("Store format: %v", c.StoreFormat)
+	logger.Infof("Store compression: %v", c.StoreCompression)
 	logger.Infof("Restore data: %v", c.RestoreData)
+	logger.Infof("Store shards: %v", c.StoreShards)
+	logger.Infof("Bolt database path: %v", c.BoltDBPath)
+	logger.Infof("WAL file path: %v", c.WALFilePath)
+	logger.Infof("WAL sync policy: %v", c.WALSync)
+	logger.Infof("WAL sync interval: %v", c.WALSyncInterval.D)
+	logger.Infof("WAL snapshot interval: %v", c.WALSnapshotInterval.D)
+	logger.Infof("Idempotency TTL: %v", c.IdempotencyTTL.D)
+	logger.Infof("Admin secret set: %v", len(c.AdminSecret) > 0)
 	logger.Infof("Max request body size: %v", c.MaxBodySize)
 	logger.Infof("Read timeout: %v", c.ReadTimeout.D)
 	logger.Infof("Write timeout: %v", c.WriteTimeout.D)
@@ -59,6 +105,12 @@ func (c *Config) Print(logger log.Logger) {
 
 	logger.Infof("Database config:")
 	c.DatabaseConfig.Print(logger)
+
+	logger.Infof("Forwarder config:")
+	c.ForwarderConfig.Print(logger)
+
+	logger.Infof("Replication config:")
+	c.ReplicationConfig.Print(logger)
 }
 
 func (c *Config) parseConfigFile(path string) error {
@@ -93,6 +145,23 @@ func (c *Config) setDefaultValues() {
 		defaultServerAddress = "localhost:8080"
 		defaultStoreFilePath = "/tmp/metrics-db.json"
 		defaultDBDriver      = "pgx"
+
+		defaultForwardProtocol      = ForwardProtocolUpdates
+		defaultForwardQueueDir      = "/tmp/metrics-forward-queue"
+		defaultForwardFlushInterval = 10
+		defaultForwardBatchSize     = 1000
+		defaultForwardMaxBatches    = 10000
+
+		defaultReplicationLogSize = 10000
+
+		defaultStoreKeep   = 3
+		defaultStoreFormat = StoreFormatJSON
+
+		defaultWALSync             = "interval"
+		defaultWALSyncInterval     = 1
+		defaultWALSnapshotInterval = 300
+
+		defaultIdempotencyTTL = 600
 	)
 
 	if c.MaxBodySize == 0 {
@@ -111,6 +180,26 @@ func (c *Config) setDefaultValues() {
 		c.StoreInterval.D = defaultStoreInterval * time.Second
 	}
 
+	if c.StoreKeep <= 0 {
+		c.StoreKeep = defaultStoreKeep
+	}
+
+	if len(c.StoreFormat) == 0 {
+		c.StoreFormat = defaultStoreFormat
+	}
+
+	if len(c.WALSync) == 0 {
+		c.WALSync = defaultWALSync
+	}
+
+	if c.WALSyncInterval.D == 0 {
+		c.WALSyncInterval.D = defaultWALSyncInterval * time.Second
+	}
+
+	if c.WALSnapshotInterval.D == 0 {
+		c.WALSnapshotInterval.D = defaultWALSnapshotInterval * time.Second
+	}
+
 	if len(c.DatabaseConfig.DriverName) == 0 {
 		c.DatabaseConfig.DriverName = defaultDBDriver
 	}
@@ -119,6 +208,10 @@ func (c *Config) setDefaultValues() {
 		c.DatabaseConfig.PingTimeout = defaultPingTimeout * time.Second
 	}
 
+	if c.IdempotencyTTL.D == 0 {
+		c.IdempotencyTTL.D = defaultIdempotencyTTL * time.Second
+	}
+
 	if c.ReadTimeout.D == 0 {
 		c.ReadTimeout.D = defaultCommonTimeout * time.Second
 	}
@@ -130,6 +223,30 @@ func (c *Config) setDefaultValues() {
 	if c.IdleTimeout.D == 0 {
 		c.IdleTimeout.D = defaultCommonTimeout * time.Second
 	}
+
+	if len(c.ForwarderConfig.Protocol) == 0 {
+		c.ForwarderConfig.Protocol = defaultForwardProtocol
+	}
+
+	if len(c.ForwarderConfig.QueueDir) == 0 {
+		c.ForwarderConfig.QueueDir = defaultForwardQueueDir
+	}
+
+	if c.ForwarderConfig.FlushInterval.D == 0 {
+		c.ForwarderConfig.FlushInterval.D = defaultForwardFlushInterval * time.Second
+	}
+
+	if c.ForwarderConfig.BatchSize == 0 {
+		c.ForwarderConfig.BatchSize = defaultForwardBatchSize
+	}
+
+	if c.ForwarderConfig.MaxQueuedBatches == 0 {
+		c.ForwarderConfig.MaxQueuedBatches = defaultForwardMaxBatches
+	}
+
+	if c.ReplicationConfig.LogSize == 0 {
+		c.ReplicationConfig.LogSize = defaultReplicationLogSize
+	}
 }
 
 // BuildConfig parses command line parameters and environment variables
@@ -142,12 +259,28 @@ func BuildConfig() (*Config, error) {
 		serverAddress  string
 		storeFilePath  string
 		configFilePath string
+		forwardURL     string
+		forwardProto   string
+		forwardDir     string
+		walFilePath    string
+		boltDBPath     string
+		walSync        string
+		storeKeep      int
+		storeShards    int
+		storeFormat    string
+		storeCompress  string
+		replRole       string
+		primaryURL     string
+		replSecret     string
+		adminSecret    string
 		maxBodySize    uint64
 		pingTimeout    config.DurationOption
 		readTimeout    config.DurationOption
 		writeTimeout   config.DurationOption
 		idleTimeout    config.DurationOption
 		storeInterval  config.DurationOption
+		walSnapshot    config.DurationOption
+		idempotencyTTL config.DurationOption
 	)
 
 	var c Config
@@ -163,12 +296,28 @@ func BuildConfig() (*Config, error) {
 	flag.Uint64Var(&maxBodySize, "bs", 0, "Max HTTP body size")
 	flag.StringVar(&configFilePath, "c", "", "Config file path")
 	flag.StringVar(&configFilePath, "config", "", "Config file path")
+	flag.IntVar(&storeKeep, "store-keep", 0, "Number of storage snapshots to keep")
+	flag.IntVar(&storeShards, "store-shards", 0, "Number of in-memory storage shards")
+	flag.StringVar(&storeFormat, "store-format", "", "Storage snapshot format (json or binary)")
+	flag.StringVar(&storeCompress, "store-compression", "", "Storage snapshot compression algorithm")
+	flag.StringVar(&boltDBPath, "bolt", "", "Embedded bbolt database path")
+	flag.StringVar(&walFilePath, "wal", "", "Write-ahead log path")
+	flag.StringVar(&walSync, "wal-sync", "", "Write-ahead log fsync policy (always, interval or never)")
+	flag.StringVar(&forwardURL, "forward-url", "", "Downstream endpoint to forward accepted metrics to")
+	flag.StringVar(&forwardProto, "forward-protocol", "", "Downstream protocol (updates or remote_write)")
+	flag.StringVar(&forwardDir, "forward-queue-dir", "", "Directory of forwarding queue")
+	flag.StringVar(&replRole, "replication-role", "", "Replication role (primary or replica)")
+	flag.StringVar(&primaryURL, "primary-url", "", "Primary server URL for replica")
+	flag.StringVar(&replSecret, "replication-secret", "", "Shared secret of replication endpoints")
+	flag.StringVar(&adminSecret, "admin-secret", "", "Secret of admin endpoints")
 
 	flag.Var(&pingTimeout, "ping_timeout", "DB ping timeout and retry timeout")
 	flag.Var(&readTimeout, "read_timeout", "Server read timeout(seconds)")
 	flag.Var(&writeTimeout, "write_timeout", "Server write timeout(seconds)")
 	flag.Var(&idleTimeout, "idle_timeout", "Server idle timeout(seconds)")
 	flag.Var(&storeInterval, "i", "Save data to NVM interval")
+	flag.Var(&walSnapshot, "wal-snapshot-interval", "Snapshot interval bounding write-ahead log size(seconds)")
+	flag.Var(&idempotencyTTL, "idempotency-ttl", "Time to remember applied request IDs(seconds)")
 
 	err := flag.CommandLine.Parse(os.Args[1:])
 	if err != nil {
@@ -203,10 +352,66 @@ func BuildConfig() (*Config, error) {
 		c.EncKeyPath = encKeyPath
 	}
 
+	if storeKeep > 0 {
+		c.StoreKeep = storeKeep
+	}
+
+	if storeShards > 0 {
+		c.StoreShards = storeShards
+	}
+
+	if len(storeFormat) > 0 {
+		c.StoreFormat = storeFormat
+	}
+
+	if len(storeCompress) > 0 {
+		c.StoreCompression = storeCompress
+	}
+
+	if len(walFilePath) > 0 {
+		c.WALFilePath = walFilePath
+	}
+
+	if len(boltDBPath) > 0 {
+		c.BoltDBPath = boltDBPath
+	}
+
+	if len(walSync) > 0 {
+		c.WALSync = walSync
+	}
+
 	if maxBodySize > 0 {
 		c.MaxBodySize = maxBodySize
 	}
 
+	if len(forwardURL) > 0 {
+		c.ForwarderConfig.URL = forwardURL
+	}
+
+	if len(forwardProto) > 0 {
+		c.ForwarderConfig.Protocol = forwardProto
+	}
+
+	if len(forwardDir) > 0 {
+		c.ForwarderConfig.QueueDir = forwardDir
+	}
+
+	if len(replRole) > 0 {
+		c.ReplicationConfig.Role = replRole
+	}
+
+	if len(primaryURL) > 0 {
+		c.ReplicationConfig.PrimaryURL = primaryURL
+	}
+
+	if len(replSecret) > 0 {
+		c.ReplicationConfig.Secret = replSecret
+	}
+
+	if len(adminSecret) > 0 {
+		c.AdminSecret = adminSecret
+	}
+
 	if pingTimeout.D > 0 {
 		c.DatabaseConfig.PingTimeout = pingTimeout.D
 	}
@@ -227,6 +432,14 @@ func BuildConfig() (*Config, error) {
 		c.StoreInterval = storeInterval
 	}
 
+	if walSnapshot.D > 0 {
+		c.WALSnapshotInterval = walSnapshot
+	}
+
+	if idempotencyTTL.D > 0 {
+		c.IdempotencyTTL = idempotencyTTL
+	}
+
 	err = c.ParseEnvVariables()
 	if err != nil {
 		return nil, err
@@ -243,6 +456,34 @@ func BuildConfig() (*Config, error) {
 		}
 	}
 
+	if c.ForwarderConfig.Protocol != ForwardProtocolUpdates && c.ForwarderConfig.Protocol != ForwardProtocolRemoteWrite {
+		return nil, fmt.Errorf("invalid forward protocol '%v'", c.ForwarderConfig.Protocol)
+	}
+
+	if c.StoreFormat != StoreFormatJSON && c.StoreFormat != StoreFormatBinary {
+		return nil, fmt.Errorf("invalid store format '%v'", c.StoreFormat)
+	}
+
+	if len(c.StoreCompression) > 0 {
+		if _, err = compression.GetCompressorFactory(c.StoreCompression); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(c.ReplicationConfig.Role) > 0 && len(c.ReplicationConfig.Secret) == 0 {
+		return nil, fmt.Errorf("replication secret is required")
+	}
+
+	switch c.ReplicationConfig.Role {
+	case "", ReplicationRolePrimary:
+	case ReplicationRoleReplica:
+		if len(c.ReplicationConfig.PrimaryURL) == 0 {
+			return nil, fmt.Errorf("primary URL is required for replica")
+		}
+	default:
+		return nil, fmt.Errorf("invalid replication role '%v'", c.ReplicationConfig.Role)
+	}
+
 	return &c, nil
 }
 
@@ -252,11 +493,26 @@ func (c *Config) ParseEnvVariables() error {
 	type EnvConfig struct {
 		ServerAddress string `env:"ADDRESS"`
 		StoreInterval string `env:"STORE_INTERVAL"`
+		IdempotTTL    string `env:"IDEMPOTENCY_TTL"`
 		StoragePath   string `env:"FILE_STORAGE_PATH"`
 		Restore       string `env:"RESTORE"`
 		DBConnStr     string `env:"DATABASE_DSN"`
 		SecretKey     string `env:"KEY"`
 		EncKeyPath    string `env:"CRYPTO_KEY"`
+		ForwardURL    string `env:"FORWARD_URL"`
+		ForwardProto  string `env:"FORWARD_PROTOCOL"`
+		StoreKeep     string `env:"STORE_KEEP"`
+		StoreShards   string `env:"STORE_SHARDS"`
+		StoreFormat   string `env:"STORE_FORMAT"`
+		StoreCompress string `env:"STORE_COMPRESSION"`
+		WALFilePath   string `env:"WAL_FILE_PATH"`
+		BoltDBPath    string `env:"BOLT_DB_PATH"`
+		WALSync       string `env:"WAL_SYNC"`
+		WALSnapshot   string `env:"WAL_SNAPSHOT_INTERVAL"`
+		ReplRole      string `env:"REPLICATION_ROLE"`
+		PrimaryURL    string `env:"PRIMARY_URL"`
+		ReplSecret    string `env:"REPLICATION_SECRET"`
+		AdminSecret   string `env:"ADMIN_SECRET"`
 	}
 	ecfg := EnvConfig{}
 	err := env.Parse(&ecfg)
@@ -276,6 +532,14 @@ func (c *Config) ParseEnvVariables() error {
 		c.StoreInterval.D = time.Duration(val * uint64(time.Second))
 	}
 
+	if len(ecfg.IdempotTTL) > 0 {
+		val, err := strconv.ParseUint(ecfg.IdempotTTL, 10, 64)
+		if err != nil {
+			return err
+		}
+		c.IdempotencyTTL.D = time.Duration(val * uint64(time.Second))
+	}
+
 	if len(ecfg.StoragePath) > 0 {
 		c.StoreFilePath = ecfg.StoragePath
 	}
@@ -296,5 +560,73 @@ func (c *Config) ParseEnvVariables() error {
 		c.SecretKey = []byte(ecfg.SecretKey)
 	}
 
+	if len(ecfg.ForwardURL) > 0 {
+		c.ForwarderConfig.URL = ecfg.ForwardURL
+	}
+
+	if len(ecfg.ForwardProto) > 0 {
+		c.ForwarderConfig.Protocol = ecfg.ForwardProto
+	}
+
+	if len(ecfg.StoreKeep) > 0 {
+		val, err := strconv.Atoi(ecfg.StoreKeep)
+		if err != nil {
+			return err
+		}
+		c.StoreKeep = val
+	}
+
+	if len(ecfg.StoreShards) > 0 {
+		val, err := strconv.Atoi(ecfg.StoreShards)
+		if err != nil {
+			return err
+		}
+		c.StoreShards = val
+	}
+
+	if len(ecfg.StoreFormat) > 0 {
+		c.StoreFormat = ecfg.StoreFormat
+	}
+
+	if len(ecfg.StoreCompress) > 0 {
+		c.StoreCompression = ecfg.StoreCompress
+	}
+
+	if len(ecfg.WALFilePath) > 0 {
+		c.WALFilePath = ecfg.WALFilePath
+	}
+
+	if len(ecfg.BoltDBPath) > 0 {
+		c.BoltDBPath = ecfg.BoltDBPath
+	}
+
+	if len(ecfg.WALSync) > 0 {
+		c.WALSync = ecfg.WALSync
+	}
+
+	if len(ecfg.WALSnapshot) > 0 {
+		val, err := strconv.ParseUint(ecfg.WALSnapshot, 10, 64)
+		if err != nil {
+			return err
+		}
+		c.WALSnapshotInterval.D = time.Duration(val * uint64(time.Second))
+	}
+
+	if len(ecfg.ReplRole) > 0 {
+		c.ReplicationConfig.Role = ecfg.ReplRole
+	}
+
+	if len(ecfg.PrimaryURL) > 0 {
+		c.ReplicationConfig.PrimaryURL = ecfg.PrimaryURL
+	}
+
+	if len(ecfg.ReplSecret) > 0 {
+		c.ReplicationConfig.Secret = ecfg.ReplSecret
+	}
+
+	if len(ecfg.AdminSecret) > 0 {
+		c.AdminSecret = ecfg.AdminSecret
+	}
+
 	return nil
 }
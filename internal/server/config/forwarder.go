@@ -0,0 +1,39 @@
+package config
+
+import (
+	"github.com/fuzzy-toozy/metrics-service/internal/config"
+	"github.com/fuzzy-toozy/metrics-service/internal/log"
+)
+
+const (
+	// ForwardProtocolUpdates forward metrics to another metrics server via /updates endpoint.
+	ForwardProtocolUpdates = "updates"
+	// ForwardProtocolRemoteWrite forward metrics via Prometheus remote-write protocol.
+	ForwardProtocolRemoteWrite = "remote_write"
+)
+
+// ForwarderConfig configuration of metrics forwarding to downstream backend.
+type ForwarderConfig struct {
+	// URL full downstream endpoint url, forwarding is disabled if empty.
+	URL string `json:"url"`
+	// Protocol downstream protocol (updates or remote_write).
+	Protocol string `json:"protocol"`
+	// QueueDir directory of persistent queue of batches waiting to be sent.
+	QueueDir string `json:"queue_dir"`
+	// FlushInterval max interval between flushes of accepted updates to queue.
+	FlushInterval config.DurationOption `json:"flush_interval"`
+	// BatchSize max amount of metrics in one batch.
+	BatchSize int `json:"batch_size"`
+	// MaxQueuedBatches max amount of queued batches, oldest batches are dropped on overflow.
+	MaxQueuedBatches int `json:"max_queued_batches"`
+}
+
+// Print prints forwarder configuration to log.
+func (c *ForwarderConfig) Print(logger log.Logger) {
+	logger.Infof("Forward URL: %v", c.URL)
+	logger.Infof("Forward protocol: %v", c.Protocol)
+	logger.Infof("Forward queue dir: %v", c.QueueDir)
+	logger.Infof("Forward flush interval: %v", c.FlushInterval.D)
+	logger.Infof("Forward batch size: %v", c.BatchSize)
+	logger.Infof("Forward max queued batches: %v", c.MaxQueuedBatches)
+}
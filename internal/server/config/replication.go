@@ -0,0 +1,32 @@
+package config
+
+import (
+	"github.com/fuzzy-toozy/metrics-service/internal/log"
+)
+
+const (
+	// ReplicationRolePrimary server accepts updates and streams them to replicas.
+	ReplicationRolePrimary = "primary"
+	// ReplicationRoleReplica server follows primary and serves reads only.
+	ReplicationRoleReplica = "replica"
+)
+
+// ReplicationConfig configuration of primary/replica replication.
+type ReplicationConfig struct {
+	// Role replication role (primary or replica), replication is disabled if empty.
+	Role string `json:"role"`
+	// PrimaryURL base url of primary server including schema (for replica role).
+	PrimaryURL string `json:"primary_url"`
+	// Secret shared secret required by replication endpoints, sent by replica as bearer token.
+	Secret string `json:"secret"`
+	// LogSize amount of updates retained by primary for replicas resumption.
+	LogSize int `json:"log_size"`
+}
+
+// Print prints replication configuration to log.
+func (c *ReplicationConfig) Print(logger log.Logger) {
+	logger.Infof("Replication role: %v", c.Role)
+	logger.Infof("Replication primary URL: %v", c.PrimaryURL)
+	logger.Infof("Replication secret set: %v", len(c.Secret) > 0)
+	logger.Infof("Replication log size: %v", c.LogSize)
+}
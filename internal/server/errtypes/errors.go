@@ -31,6 +31,14 @@ type NotFoundError struct {
 	genericErrorWrapper
 }
 
+type ReadOnlyError struct {
+	genericErrorWrapper
+}
+
+type ConflictError struct {
+	genericErrorWrapper
+}
+
 func MakeServerError(err error) ServerError {
 	return ServerError{genericErrorWrapper: genericErrorWrapper{err: err}}
 }
@@ -43,12 +51,22 @@ func MakeNotFoundError(err error) NotFoundError {
 	return NotFoundError{genericErrorWrapper: genericErrorWrapper{err: err}}
 }
 
+func MakeReadOnlyError(err error) ReadOnlyError {
+	return ReadOnlyError{genericErrorWrapper: genericErrorWrapper{err: err}}
+}
+
+func MakeConflictError(err error) ConflictError {
+	return ConflictError{genericErrorWrapper: genericErrorWrapper{err: err}}
+}
+
 func ErrorToStatus(err error) int {
 	status := http.StatusOK
 
 	var serverError ServerError
 	var notFoundError NotFoundError
 	var requestError BadDataError
+	var readOnlyError ReadOnlyError
+	var conflictError ConflictError
 
 	if errors.As(err, &serverError) {
 		status = http.StatusInternalServerError
@@ -56,6 +74,10 @@ func ErrorToStatus(err error) int {
 		status = http.StatusNotFound
 	} else if errors.As(err, &requestError) {
 		status = http.StatusBadRequest
+	} else if errors.As(err, &readOnlyError) {
+		status = http.StatusForbidden
+	} else if errors.As(err, &conflictError) {
+		status = http.StatusConflict
 	} else if errors.Is(err, context.DeadlineExceeded) {
 		status = http.StatusRequestTimeout
 	}
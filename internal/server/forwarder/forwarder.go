@@ -0,0 +1,300 @@
+// Package forwarder Forwarding of accepted metrics to downstream backend.
+// Accepted updates are coalesced in memory, flushed as batches to persistent
+// on-disk queue and shipped to downstream by background sender.
+package forwarder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fuzzy-toozy/metrics-service/internal/common"
+	logging "github.com/fuzzy-toozy/metrics-service/internal/log"
+	"github.com/fuzzy-toozy/metrics-service/internal/metrics"
+	"github.com/fuzzy-toozy/metrics-service/internal/server/config"
+	"github.com/fuzzy-toozy/metrics-service/internal/server/storage"
+)
+
+// Forwarder self-metrics names.
+const (
+	QueueDepthMetricName     = "ForwarderQueueDepth"
+	DroppedBatchesMetricName = "ForwarderDroppedBatches"
+	// RejectedBatchesMetricName batches dropped because downstream rejected them.
+	RejectedBatchesMetricName = "ForwarderRejectedBatches"
+	SentBatchesMetricName     = "ForwarderSentBatches"
+	SendErrorsMetricName      = "ForwarderSendErrors"
+)
+
+// Stats forwarder backpressure statistics.
+type Stats struct {
+	QueueDepth      int
+	DroppedBatches  uint64
+	RejectedBatches uint64
+	SentBatches     uint64
+	SendErrors      uint64
+}
+
+type Forwarder struct {
+	sink          Sink
+	queue         *DiskQueue
+	retryExecutor common.RetryExecutor
+	statsRepo     storage.Repository
+	log           logging.Logger
+	pending       map[string]metrics.Metric
+	accepted      time.Time
+	flushCh       chan struct{}
+	sendCh        chan struct{}
+	done          chan struct{}
+	order         []string
+	batchSize     int
+	flushInterval time.Duration
+	sentBatches   atomic.Uint64
+	rejected      atomic.Uint64
+	sendErrors    atomic.Uint64
+	wg            sync.WaitGroup
+	lock          sync.Mutex
+}
+
+// agentIDFile file in queue dir holding ID server sends updates with.
+const agentIDFile = "agent_id"
+
+// NewSink creates downstream sink for configured protocol.
+// Updates are signed with key if it isn't empty.
+func NewSink(cfg config.ForwarderConfig, key []byte) (Sink, error) {
+	switch cfg.Protocol {
+	case config.ForwardProtocolUpdates:
+		// ID is kept with the queue, so downstream recognizes batches resent after restart.
+		agentID, err := common.PersistentID(filepath.Join(cfg.QueueDir, agentIDFile))
+		if err != nil {
+			return nil, fmt.Errorf("failed to get forwarder agent ID: %w", err)
+		}
+		return NewUpdatesSink(cfg.URL, agentID, key), nil
+	case config.ForwardProtocolRemoteWrite:
+		return NewRemoteWriteSink(cfg.URL), nil
+	}
+	return nil, fmt.Errorf("unsupported forward protocol '%v'", cfg.Protocol)
+}
+
+// NewDefaultRetryExecutor creates retry executor which retries network errors
+// and ErrDownstreamUnavailable.
+func NewDefaultRetryExecutor(stopCtx context.Context) *common.CommonRetryExecutor {
+	const interval = 2
+	const retries = 3
+	return common.NewCommonRetryExecutor(stopCtx, interval*time.Second, retries, []error{ErrDownstreamUnavailable})
+}
+
+// NewForwarder creates forwarder. Self-metrics are written to statsRepo if it's not nil.
+func NewForwarder(cfg config.ForwarderConfig, sink Sink, retryExecutor common.RetryExecutor,
+	statsRepo storage.Repository, log logging.Logger) (*Forwarder, error) {
+	queue, err := NewDiskQueue(cfg.QueueDir, cfg.MaxQueuedBatches)
+	if err != nil {
+		return nil, err
+	}
+
+	f := Forwarder{
+		sink:          sink,
+		queue:         queue,
+		retryExecutor: retryExecutor,
+		statsRepo:     statsRepo,
+		log:           log,
+		pending:       make(map[string]metrics.Metric),
+		flushCh:       make(chan struct{}, 1),
+		sendCh:        make(chan struct{}, 1),
+		done:          make(chan struct{}),
+		batchSize:     cfg.BatchSize,
+		flushInterval: cfg.FlushInterval.D,
+	}
+
+	return &f, nil
+}
+
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// Add schedules accepted update for forwarding.
+// Received holds metrics as they were received, applied holds values stored in repository.
+// Which of them is forwarded depends on the sink.
+func (f *Forwarder) Add(received []metrics.Metric, applied []metrics.Metric) {
+	data := received
+	if f.sink.Cumulative() {
+		data = applied
+	}
+
+	f.lock.Lock()
+	for _, m := range data {
+		key := m.MType + ":" + m.ID
+		prev, ok := f.pending[key]
+		if !ok {
+			f.order = append(f.order, key)
+		} else if !f.sink.Cumulative() && m.MType == metrics.CounterMetricType && prev.Delta != nil && m.Delta != nil {
+			sum := *prev.Delta + *m.Delta
+			m.Delta = &sum
+		}
+		f.pending[key] = m
+	}
+	f.accepted = time.Now()
+	full := len(f.pending) >= f.batchSize
+	f.lock.Unlock()
+
+	if full {
+		notify(f.flushCh)
+	}
+}
+
+// flush moves pending metrics to the queue in batches of configured size.
+func (f *Forwarder) flush() {
+	f.lock.Lock()
+	pending := f.pending
+	order := f.order
+	accepted := f.accepted
+	f.pending = make(map[string]metrics.Metric)
+	f.order = nil
+	f.lock.Unlock()
+
+	for start := 0; start < len(order); start += f.batchSize {
+		end := start + f.batchSize
+		if end > len(order) {
+			end = len(order)
+		}
+
+		batch := Batch{Accepted: accepted, Metrics: make([]metrics.Metric, 0, end-start)}
+		for _, key := range order[start:end] {
+			batch.Metrics = append(batch.Metrics, pending[key])
+		}
+
+		if err := f.queue.Push(batch); err != nil {
+			f.log.Errorf("Failed to queue forward batch: %v", err)
+		}
+	}
+
+	if len(order) > 0 {
+		notify(f.sendCh)
+	}
+}
+
+// sendQueued sends queued batches oldest first until queue is empty or sending fails.
+// Batches rejected by downstream are dropped, so they don't block the queue.
+func (f *Forwarder) sendQueued() {
+	for {
+		select {
+		case <-f.done:
+			return
+		default:
+		}
+
+		batch, seq, ok := f.queue.Peek()
+		if !ok {
+			return
+		}
+
+		err := f.retryExecutor.RetryOnError(func() error {
+			return f.sink.Send(batch)
+		})
+
+		if errors.Is(err, ErrBatchRejected) {
+			f.rejected.Add(1)
+			f.log.Errorf("Dropping forward batch: %v", err)
+		} else if err != nil {
+			f.sendErrors.Add(1)
+			f.log.Errorf("Failed to forward batch: %v", err)
+			return
+		} else {
+			f.sentBatches.Add(1)
+		}
+
+		if err = f.queue.Remove(seq); err != nil {
+			f.log.Errorf("Failed to remove forwarded batch: %v", err)
+			return
+		}
+	}
+}
+
+// GetStats returns current forwarder statistics.
+func (f *Forwarder) GetStats() Stats {
+	return Stats{
+		QueueDepth:      f.queue.Len(),
+		DroppedBatches:  f.queue.Dropped(),
+		RejectedBatches: f.rejected.Load(),
+		SentBatches:     f.sentBatches.Load(),
+		SendErrors:      f.sendErrors.Load(),
+	}
+}
+
+func (f *Forwarder) reportStats() {
+	if f.statsRepo == nil {
+		return
+	}
+
+	stats := f.GetStats()
+	values := map[string]float64{
+		QueueDepthMetricName:      float64(stats.QueueDepth),
+		DroppedBatchesMetricName:  float64(stats.DroppedBatches),
+		RejectedBatchesMetricName: float64(stats.RejectedBatches),
+		SentBatchesMetricName:     float64(stats.SentBatches),
+		SendErrorsMetricName:      float64(stats.SendErrors),
+	}
+
+	for name, val := range values {
+		m := metrics.NewGaugeMetric(name, val)
+		data, err := m.GetData()
+		if err == nil {
+			_, err = f.statsRepo.AddOrUpdate(m.ID, data, m.MType)
+		}
+		if err != nil {
+			f.log.Errorf("Failed to update forwarder metric %v: %v", name, err)
+		}
+	}
+}
+
+// Run starts background flushing and sending.
+func (f *Forwarder) Run() {
+	f.wg.Add(2)
+
+	go func() {
+		defer f.wg.Done()
+		ticker := time.NewTicker(f.flushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				f.flush()
+			case <-f.flushCh:
+				f.flush()
+			case <-f.done:
+				return
+			}
+		}
+	}()
+
+	go func() {
+		defer f.wg.Done()
+		ticker := time.NewTicker(f.flushInterval)
+		defer ticker.Stop()
+		for {
+			f.sendQueued()
+			f.reportStats()
+			select {
+			case <-ticker.C:
+			case <-f.sendCh:
+			case <-f.done:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops background workers and persists pending metrics to the queue,
+// so they're sent after restart.
+func (f *Forwarder) Stop() {
+	close(f.done)
+	f.wg.Wait()
+	f.flush()
+}
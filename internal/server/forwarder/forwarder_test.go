@@ -0,0 +1,268 @@
+package forwarder
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fuzzy-toozy/metrics-service/internal/common"
+	"github.com/fuzzy-toozy/metrics-service/internal/log"
+	"github.com/fuzzy-toozy/metrics-service/internal/metrics"
+	serverConfig "github.com/fuzzy-toozy/metrics-service/internal/server/config"
+	"github.com/fuzzy-toozy/metrics-service/internal/server/handlers"
+	"github.com/fuzzy-toozy/metrics-service/internal/server/storage"
+	"github.com/golang/snappy"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_DiskQueue(t *testing.T) {
+	dir := t.TempDir()
+	q, err := NewDiskQueue(dir, 2)
+	require.NoError(t, err)
+
+	for i := int64(0); i < 3; i++ {
+		require.NoError(t, q.Push(Batch{Metrics: []metrics.Metric{metrics.NewCounterMetric("c", i)}}))
+	}
+	require.Equal(t, 2, q.Len())
+	require.Equal(t, uint64(1), q.Dropped())
+
+	// Reopened queue keeps order of batches.
+	q, err = NewDiskQueue(dir, 2)
+	require.NoError(t, err)
+	require.Equal(t, 2, q.Len())
+
+	for _, expected := range []int64{1, 2} {
+		batch, seq, ok := q.Peek()
+		require.True(t, ok)
+		require.Len(t, batch.Metrics, 1)
+		require.Equal(t, expected, *batch.Metrics[0].Delta)
+		require.NoError(t, q.Remove(seq))
+	}
+
+	_, _, ok := q.Peek()
+	require.False(t, ok)
+}
+
+func Test_DiskQueueBatch(t *testing.T) {
+	dir := t.TempDir()
+	q, err := NewDiskQueue(dir, 2)
+	require.NoError(t, err)
+
+	accepted := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	require.NoError(t, q.Push(Batch{Accepted: accepted, Metrics: []metrics.Metric{metrics.NewGaugeMetric("g", 1)}}))
+
+	// Request ID is the same for every attempt to send batch, including ones after restart.
+	batch, _, ok := q.Peek()
+	require.True(t, ok)
+	require.True(t, accepted.Equal(batch.Accepted))
+	require.NotEmpty(t, batch.RequestID)
+
+	q, err = NewDiskQueue(dir, 2)
+	require.NoError(t, err)
+	again, seq, ok := q.Peek()
+	require.True(t, ok)
+	require.Equal(t, batch.RequestID, again.RequestID)
+	require.NoError(t, q.Remove(seq))
+
+	// Batch accepted later gets different ID even if sequence number is reused.
+	q, err = NewDiskQueue(dir, 2)
+	require.NoError(t, err)
+	require.NoError(t, q.Push(Batch{Accepted: accepted.Add(time.Second), Metrics: batch.Metrics}))
+	other, seq, ok := q.Peek()
+	require.True(t, ok)
+	require.NotEqual(t, batch.RequestID, other.RequestID)
+	require.NoError(t, q.Remove(seq))
+
+	// Batches queued by previous versions hold only metrics.
+	require.NoError(t, os.WriteFile(q.batchPath(7), []byte(`[{"id":"c","type":"counter","delta":1}]`), 0644))
+	q, err = NewDiskQueue(dir, 2)
+	require.NoError(t, err)
+	legacy, _, ok := q.Peek()
+	require.True(t, ok)
+	require.Len(t, legacy.Metrics, 1)
+	require.False(t, legacy.Accepted.IsZero())
+}
+
+func newTestConfig(t *testing.T, url string) serverConfig.ForwarderConfig {
+	cfg := serverConfig.ForwarderConfig{
+		URL:              url,
+		Protocol:         serverConfig.ForwardProtocolUpdates,
+		QueueDir:         t.TempDir(),
+		BatchSize:        100,
+		MaxQueuedBatches: 10,
+	}
+	cfg.FlushInterval.D = 10 * time.Millisecond
+	return cfg
+}
+
+func Test_ForwardUpdates(t *testing.T) {
+	downstream := storage.NewCommonMetricsRepository()
+	h := handlers.NewMetricRegistryHandler(downstream, log.NewDummyLogger(),
+		handlers.MetricURLInfo{Type: "mtype", Name: "mname", Value: "mval"}, nil, serverConfig.DBConfig{})
+	srv := httptest.NewServer(handlers.WithCompression(handlers.SetupRouting(h), log.NewDummyLogger()))
+	defer srv.Close()
+
+	cfg := newTestConfig(t, srv.URL+"/updates")
+	sink, err := NewSink(cfg, nil)
+	require.NoError(t, err)
+
+	local := storage.NewCommonMetricsRepository()
+	retry := common.NewCommonRetryExecutor(context.Background(), time.Millisecond, 1, []error{ErrDownstreamUnavailable})
+	fwd, err := NewForwarder(cfg, sink, retry, local, log.NewDummyLogger())
+	require.NoError(t, err)
+
+	repo := NewForwardingRepository(local, fwd)
+	_, err = repo.AddOrUpdate("c", "5", metrics.CounterMetricType)
+	require.NoError(t, err)
+	require.NoError(t, repo.AddMetricsBulk([]metrics.Metric{
+		metrics.NewCounterMetric("c", 3),
+		metrics.NewGaugeMetric("g", 1.5),
+	}))
+
+	fwd.Run()
+	require.Eventually(t, func() bool {
+		m, err := downstream.Get("g", metrics.GaugeMetricType)
+		return err == nil && m.Value != nil
+	}, 5*time.Second, 10*time.Millisecond)
+	fwd.Stop()
+
+	m, err := downstream.Get("c", metrics.CounterMetricType)
+	require.NoError(t, err)
+	require.Equal(t, int64(8), *m.Delta)
+
+	stats := fwd.GetStats()
+	require.Equal(t, 0, stats.QueueDepth)
+	require.Equal(t, uint64(1), stats.SentBatches)
+
+	_, err = local.Get(SentBatchesMetricName, metrics.GaugeMetricType)
+	require.NoError(t, err)
+}
+
+func Test_ForwardPersistsOnFailure(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	cfg := newTestConfig(t, srv.URL+"/updates")
+	retry := common.NewCommonRetryExecutor(context.Background(), time.Millisecond, 1, []error{ErrDownstreamUnavailable})
+	fwd, err := NewForwarder(cfg, NewUpdatesSink(cfg.URL, "server", nil), retry, nil, log.NewDummyLogger())
+	require.NoError(t, err)
+
+	fwd.Add([]metrics.Metric{metrics.NewCounterMetric("c", 1)}, nil)
+	fwd.Run()
+	require.Eventually(t, func() bool {
+		return fwd.GetStats().SendErrors > 0
+	}, 5*time.Second, 10*time.Millisecond)
+	fwd.Stop()
+
+	require.GreaterOrEqual(t, calls.Load(), int32(2))
+
+	q, err := NewDiskQueue(cfg.QueueDir, cfg.MaxQueuedBatches)
+	require.NoError(t, err)
+	batch, _, ok := q.Peek()
+	require.True(t, ok)
+	require.Len(t, batch.Metrics, 1)
+	require.Equal(t, "c", batch.Metrics[0].ID)
+}
+
+func Test_ForwardDropsRejected(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	cfg := newTestConfig(t, srv.URL+"/updates")
+	retry := common.NewCommonRetryExecutor(context.Background(), time.Millisecond, 1, []error{ErrDownstreamUnavailable})
+	fwd, err := NewForwarder(cfg, NewUpdatesSink(cfg.URL, "server", nil), retry, nil, log.NewDummyLogger())
+	require.NoError(t, err)
+
+	// Rejected batch doesn't block the following ones.
+	require.NoError(t, fwd.queue.Push(Batch{Metrics: []metrics.Metric{metrics.NewCounterMetric("bad", 1)}}))
+	require.NoError(t, fwd.queue.Push(Batch{Metrics: []metrics.Metric{metrics.NewCounterMetric("good", 1)}}))
+	fwd.Run()
+	require.Eventually(t, func() bool {
+		return fwd.GetStats().SentBatches == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	fwd.Stop()
+
+	stats := fwd.GetStats()
+	require.Equal(t, uint64(1), stats.RejectedBatches)
+	require.Equal(t, uint64(0), stats.SendErrors)
+	require.Equal(t, 0, stats.QueueDepth)
+	require.Equal(t, int32(2), calls.Load())
+}
+
+func Test_RemoteWriteSink(t *testing.T) {
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "snappy", r.Header.Get("Content-Encoding"))
+		data, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		body, err = snappy.Decode(nil, data)
+		require.NoError(t, err)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	sink := NewRemoteWriteSink(srv.URL)
+	require.True(t, sink.Cumulative())
+
+	// Samples are timestamped by batch acceptance time.
+	batch := Batch{Accepted: time.UnixMilli(1700000000000), Metrics: []metrics.Metric{metrics.NewCounterMetric(`req{a="b"}`, 10)}}
+	require.NoError(t, sink.Send(batch))
+	require.Equal(t, encodeWriteRequest(batch.Metrics, batch.Accepted), body)
+	require.Equal(t, "req_a__b__", sanitizeMetricName(`req{a="b"}`))
+}
+
+func Test_UpdatesSinkIdempotent(t *testing.T) {
+	const key = "secret"
+	downstream := storage.NewCommonMetricsRepository()
+	h := handlers.NewMetricRegistryHandler(downstream, log.NewDummyLogger(),
+		handlers.MetricURLInfo{Type: "mtype", Name: "mname", Value: "mval"}, nil, serverConfig.DBConfig{})
+	store := storage.NewMemoryIdempotencyStore(time.Minute, storage.DefaultIdempotencyKeysPerAgent)
+	handler := handlers.WithIdempotency(handlers.SetupRouting(h), store, log.NewDummyLogger())
+	handler = handlers.WithSignatureCheck(handler, log.NewDummyLogger(), []byte(key))
+	handler = handlers.WithCompression(handler, log.NewDummyLogger())
+
+	var signed atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.Header.Get("HashSHA256")) > 0 {
+			signed.Add(1)
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	cfg := newTestConfig(t, srv.URL+"/updates")
+	sink, err := NewSink(cfg, []byte(key))
+	require.NoError(t, err)
+
+	// Batch resent after downstream applied it, e.g. on timeout, is applied once.
+	batch := Batch{Accepted: time.Now(), RequestID: "1-1", Metrics: []metrics.Metric{metrics.NewCounterMetric("c", 5)}}
+	require.NoError(t, sink.Send(batch))
+	require.NoError(t, sink.Send(batch))
+	require.Equal(t, int32(2), signed.Load())
+
+	m, err := downstream.Get("c", metrics.CounterMetricType)
+	require.NoError(t, err)
+	require.Equal(t, int64(5), *m.Delta)
+
+	// Sink created after restart sends batches with the same agent ID.
+	sink, err = NewSink(cfg, []byte(key))
+	require.NoError(t, err)
+	require.NoError(t, sink.Send(batch))
+	m, err = downstream.Get("c", metrics.CounterMetricType)
+	require.NoError(t, err)
+	require.Equal(t, int64(5), *m.Delta)
+}
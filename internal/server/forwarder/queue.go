@@ -0,0 +1,228 @@
+package forwarder
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fuzzy-toozy/metrics-service/internal/metrics"
+)
+
+const batchFileExt = ".batch"
+
+// Batch metrics queued for forwarding together.
+type Batch struct {
+	// Accepted time metrics of batch were accepted by server.
+	Accepted time.Time `json:"accepted"`
+	// Metrics metrics of batch.
+	Metrics []metrics.Metric `json:"metrics"`
+	// RequestID ID of batch derived from its sequence number and acceptance time,
+	// it's the same for every attempt to send the batch.
+	RequestID string `json:"-"`
+}
+
+// DiskQueue bounded persistent FIFO queue of metric batches.
+// Each batch is stored in a separate file named by its sequence number,
+// so queued batches survive server restarts.
+// When queue is full the oldest batch is dropped.
+type DiskQueue struct {
+	dir        string
+	seqs       []uint64
+	next       uint64
+	dropped    uint64
+	maxBatches int
+	lock       sync.Mutex
+}
+
+// NewDiskQueue opens queue in directory dir, creating the directory if needed.
+// Batches left from previous runs are picked up in order.
+func NewDiskQueue(dir string, maxBatches int) (*DiskQueue, error) {
+	const perms = 0755
+	if err := os.MkdirAll(dir, perms); err != nil {
+		return nil, fmt.Errorf("failed to create queue dir: %w", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read queue dir: %w", err)
+	}
+
+	q := DiskQueue{dir: dir, maxBatches: maxBatches}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, batchFileExt) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, batchFileExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		q.seqs = append(q.seqs, seq)
+	}
+
+	sort.Slice(q.seqs, func(i, j int) bool { return q.seqs[i] < q.seqs[j] })
+	if len(q.seqs) > 0 {
+		q.next = q.seqs[len(q.seqs)-1] + 1
+	}
+
+	return &q, nil
+}
+
+func (q *DiskQueue) batchPath(seq uint64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%020d%v", seq, batchFileExt))
+}
+
+// Push appends batch to the end of the queue.
+func (q *DiskQueue) Push(batch Batch) error {
+	data, err := json.Marshal(batch)
+	if err != nil {
+		return fmt.Errorf("failed to encode batch: %w", err)
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	seq := q.next
+	if err = q.writeBatch(q.batchPath(seq), data); err != nil {
+		return err
+	}
+
+	q.next++
+	q.seqs = append(q.seqs, seq)
+
+	for q.maxBatches > 0 && len(q.seqs) > q.maxBatches {
+		q.dropOldest()
+	}
+
+	return nil
+}
+
+// writeBatch atomically writes batch file, batch is on disk when it returns.
+func (q *DiskQueue) writeBatch(path string, data []byte) error {
+	const perms = 0644
+	tmpPath := path + ".tmp"
+	f, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perms)
+	if err != nil {
+		return fmt.Errorf("failed to create batch: %w", err)
+	}
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if errc := f.Close(); err == nil {
+		err = errc
+	}
+
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("failed to write batch: %w", err)
+	}
+
+	if err = os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to commit batch: %w", err)
+	}
+
+	d, err := os.Open(q.dir)
+	if err != nil {
+		return fmt.Errorf("failed to sync queue dir: %w", err)
+	}
+	err = d.Sync()
+	if errc := d.Close(); err == nil {
+		err = errc
+	}
+	if err != nil {
+		return fmt.Errorf("failed to sync queue dir: %w", err)
+	}
+
+	return nil
+}
+
+// dropOldest removes the oldest batch, must be called with lock held.
+func (q *DiskQueue) dropOldest() {
+	_ = os.Remove(q.batchPath(q.seqs[0]))
+	q.seqs = q.seqs[1:]
+	q.dropped++
+}
+
+// readBatch reads batch file. Batches queued by previous versions hold only metrics,
+// modification time of their files is used as acceptance time.
+func (q *DiskQueue) readBatch(seq uint64) (Batch, error) {
+	path := q.batchPath(seq)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Batch{}, err
+	}
+
+	var batch Batch
+	if len(data) > 0 && data[0] == '[' {
+		info, err := os.Stat(path)
+		if err != nil {
+			return Batch{}, err
+		}
+		batch.Accepted = info.ModTime()
+		err = json.Unmarshal(data, &batch.Metrics)
+		return batch, err
+	}
+
+	err = json.Unmarshal(data, &batch)
+	return batch, err
+}
+
+// Peek returns the oldest batch and its sequence number without removing it.
+// Unreadable batches are dropped.
+func (q *DiskQueue) Peek() (Batch, uint64, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for len(q.seqs) > 0 {
+		seq := q.seqs[0]
+		if batch, err := q.readBatch(seq); err == nil {
+			// Sequence numbers are reused after restart with empty queue, acceptance time tells batches apart.
+			batch.RequestID = fmt.Sprintf("%v-%v", seq, batch.Accepted.UnixNano())
+			return batch, seq, true
+		}
+		q.dropOldest()
+	}
+
+	return Batch{}, 0, false
+}
+
+// Remove removes batch with sequence number seq if it is the oldest one.
+func (q *DiskQueue) Remove(seq uint64) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if len(q.seqs) == 0 || q.seqs[0] != seq {
+		return nil
+	}
+
+	err := os.Remove(q.batchPath(seq))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove batch: %w", err)
+	}
+	q.seqs = q.seqs[1:]
+
+	return nil
+}
+
+// Len returns amount of queued batches.
+func (q *DiskQueue) Len() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return len(q.seqs)
+}
+
+// Dropped returns amount of batches dropped due to overflow or corruption.
+func (q *DiskQueue) Dropped() uint64 {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.dropped
+}
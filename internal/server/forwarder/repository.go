@@ -0,0 +1,58 @@
+package forwarder
+
+import (
+	"github.com/fuzzy-toozy/metrics-service/internal/metrics"
+	"github.com/fuzzy-toozy/metrics-service/internal/server/storage"
+)
+
+// ForwardingRepository repository proxy which schedules
+// every successfully applied update for forwarding.
+type ForwardingRepository struct {
+	storage.Repository
+	forwarder *Forwarder
+}
+
+func NewForwardingRepository(r storage.Repository, f *Forwarder) *ForwardingRepository {
+	return &ForwardingRepository{Repository: r, forwarder: f}
+}
+
+func (r *ForwardingRepository) AddOrUpdate(key string, val string, mtype string) (string, error) {
+	res, err := r.Repository.AddOrUpdate(key, val, mtype)
+	if err != nil {
+		return res, err
+	}
+
+	received, err := metrics.NewMetric(key, val, mtype)
+	if err != nil {
+		return res, nil
+	}
+
+	applied, err := metrics.NewMetric(key, res, mtype)
+	if err != nil {
+		return res, nil
+	}
+
+	r.forwarder.Add([]metrics.Metric{received}, []metrics.Metric{applied})
+
+	return res, nil
+}
+
+func (r *ForwardingRepository) AddMetricsBulk(metricsData []metrics.Metric) error {
+	// Repositories replace counter values with accumulated ones in place,
+	// metric values are pointers replaced on update, so shallow copy keeps received values.
+	received := make([]metrics.Metric, len(metricsData))
+	copy(received, metricsData)
+
+	if err := r.Repository.AddMetricsBulk(metricsData); err != nil {
+		return err
+	}
+
+	r.forwarder.Add(received, metricsData)
+
+	return nil
+}
+
+// ForRequest returns proxy of repository recording key with its updates.
+func (r *ForwardingRepository) ForRequest(key storage.RequestKey) storage.Repository {
+	return &ForwardingRepository{Repository: storage.ForRequest(r.Repository, key), forwarder: r.forwarder}
+}
@@ -0,0 +1,218 @@
+package forwarder
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/fuzzy-toozy/metrics-service/internal/common"
+	"github.com/fuzzy-toozy/metrics-service/internal/compression"
+	"github.com/fuzzy-toozy/metrics-service/internal/encryption"
+	"github.com/fuzzy-toozy/metrics-service/internal/metrics"
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// ErrDownstreamUnavailable returned by sinks when downstream responds with
+// server side error, sending should be retried later.
+var ErrDownstreamUnavailable = errors.New("downstream unavailable")
+
+// ErrBatchRejected returned by sinks when downstream permanently rejects batch,
+// sending the same batch again is pointless.
+var ErrBatchRejected = errors.New("batch rejected by downstream")
+
+// Sink sends metric batches to downstream backend.
+type Sink interface {
+	// Send sends batch to downstream.
+	Send(batch Batch) error
+	// Cumulative reports if sink expects accumulated counter values
+	// instead of counter increments.
+	Cumulative() bool
+}
+
+const defaultSinkTimeout = 30 * time.Second
+
+func sendRequest(client *http.Client, req *http.Request) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests {
+		return ErrDownstreamUnavailable
+	}
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("%w: status %v", ErrBatchRejected, resp.StatusCode)
+	}
+
+	return nil
+}
+
+// UpdatesSink sends batches to another metrics server via /updates endpoint.
+// Counter increments are sent, since receiving server accumulates them.
+// Batches are sent with agent and request IDs, so receiving server applies retries of batch once.
+type UpdatesSink struct {
+	client  *http.Client
+	url     string
+	agentID string
+	key     []byte
+}
+
+func NewUpdatesSink(url string, agentID string, key []byte) *UpdatesSink {
+	return &UpdatesSink{url: url, agentID: agentID, key: key, client: &http.Client{Timeout: defaultSinkTimeout}}
+}
+
+func (s *UpdatesSink) Cumulative() bool {
+	return false
+}
+
+func (s *UpdatesSink) Send(batch Batch) error {
+	const algo = "gzip"
+	factory, err := compression.GetCompressorFactory(algo)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(batch.Metrics)
+	if err != nil {
+		return fmt.Errorf("failed to encode batch: %w", err)
+	}
+
+	// Signature is checked against decompressed body.
+	var signature string
+	if len(s.key) > 0 {
+		if signature, err = encryption.SignData(data, s.key); err != nil {
+			return fmt.Errorf("failed to sign batch: %w", err)
+		}
+	}
+
+	body := bytes.Buffer{}
+	compressor, err := factory(&body)
+	if err != nil {
+		return fmt.Errorf("failed to create compressor: %w", err)
+	}
+
+	if _, err = compressor.Write(data); err != nil {
+		return fmt.Errorf("failed to compress batch: %w", err)
+	}
+
+	if err = compressor.Close(); err != nil {
+		return fmt.Errorf("failed to finalize compressor: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.url, &body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", algo)
+	if len(signature) > 0 {
+		req.Header.Set("HashSHA256", signature)
+	}
+	if len(batch.RequestID) > 0 {
+		req.Header.Set(common.RequestIDHeader, batch.RequestID)
+		req.Header.Set(common.AgentIDHeader, s.agentID)
+	}
+
+	return sendRequest(s.client, req)
+}
+
+// RemoteWriteSink sends batches using Prometheus remote-write protocol.
+// Accumulated counter values are sent, as Prometheus expects cumulative counters.
+type RemoteWriteSink struct {
+	client *http.Client
+	url    string
+}
+
+func NewRemoteWriteSink(url string) *RemoteWriteSink {
+	return &RemoteWriteSink{url: url, client: &http.Client{Timeout: defaultSinkTimeout}}
+}
+
+func (s *RemoteWriteSink) Cumulative() bool {
+	return true
+}
+
+// sanitizeMetricName replaces characters not allowed in Prometheus metric names.
+func sanitizeMetricName(name string) string {
+	res := []byte(name)
+	for i, c := range res {
+		isAlpha := (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '_' || c == ':'
+		isDigit := c >= '0' && c <= '9'
+		if !isAlpha && !(isDigit && i > 0) {
+			res[i] = '_'
+		}
+	}
+	return string(res)
+}
+
+func appendLabel(b []byte, name, value string) []byte {
+	label := protowire.AppendTag(nil, 1, protowire.BytesType)
+	label = protowire.AppendString(label, name)
+	label = protowire.AppendTag(label, 2, protowire.BytesType)
+	label = protowire.AppendString(label, value)
+
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	return protowire.AppendBytes(b, label)
+}
+
+// encodeWriteRequest encodes batch into prometheus.WriteRequest protobuf message.
+func encodeWriteRequest(batch []metrics.Metric, timestamp time.Time) []byte {
+	var req []byte
+	for i := range batch {
+		m := &batch[i]
+		var value float64
+		switch {
+		case m.Delta != nil:
+			value = float64(*m.Delta)
+		case m.Value != nil:
+			value = *m.Value
+		default:
+			continue
+		}
+
+		series := appendLabel(nil, "__name__", sanitizeMetricName(m.ID))
+		series = appendLabel(series, "id", m.ID)
+		series = appendLabel(series, "type", strings.ToLower(m.MType))
+
+		sample := protowire.AppendTag(nil, 1, protowire.Fixed64Type)
+		sample = protowire.AppendFixed64(sample, math.Float64bits(value))
+		sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+		sample = protowire.AppendVarint(sample, uint64(timestamp.UnixMilli()))
+
+		series = protowire.AppendTag(series, 2, protowire.BytesType)
+		series = protowire.AppendBytes(series, sample)
+
+		req = protowire.AppendTag(req, 1, protowire.BytesType)
+		req = protowire.AppendBytes(req, series)
+	}
+
+	return req
+}
+
+// Send sends batch with samples timestamped by batch acceptance time,
+// so batches sent late after downstream outage keep their original time.
+func (s *RemoteWriteSink) Send(batch Batch) error {
+	body := snappy.Encode(nil, encodeWriteRequest(batch.Metrics, batch.Accepted))
+
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+	return sendRequest(s.client, req)
+}
@@ -0,0 +1,104 @@
+package handlers
+
+// Provides export and import of repository data.
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/fuzzy-toozy/metrics-service/internal/server/errtypes"
+	"github.com/go-chi/chi/middleware"
+)
+
+const adminBearerPrefix = "Bearer "
+
+// ExportMetrics streams all stored metrics as JSON array.
+//
+// @Summary Export metrics
+// @Description Streams all stored metrics in the same JSON format as file storage backup.
+// @Tags Admin
+// @ID export-metrics
+// @Produce json
+// @Success 200 {array} metrics.Metric
+// @Failure 403
+// @Failure 500
+// @Router /admin/export [get]
+func (h *MetricRegistryHandler) ExportMetrics(w http.ResponseWriter, r *http.Request) {
+	setJSONContent(w)
+	w.Header().Set("Content-Disposition", `attachment; filename="metrics.json"`)
+
+	// Status is sent with the first chunk of data, so errors after it can only be logged.
+	if err := h.registry.Save(w); err != nil {
+		h.log.Errorf("Failed to export metrics: %v", err)
+	}
+}
+
+// ImportMetrics stores metrics from JSON array produced by export replacing existing values.
+//
+// @Summary Import metrics
+// @Description Stores metrics from JSON array produced by export, existing values are replaced.
+// @Tags Admin
+// @ID import-metrics
+// @Accept json
+// @Produce json
+// @Param data body []metrics.Metric true "Metrics data"
+// @Success 200
+// @Failure 400
+// @Failure 403
+// @Failure 500
+// @Router /admin/import [post]
+func (h *MetricRegistryHandler) ImportMetrics(w http.ResponseWriter, r *http.Request) {
+	if err := h.registry.Load(r.Body); err != nil {
+		h.log.Errorf("Failed to import metrics: %v", err)
+		respEmptyJSON(w, errtypes.ErrorToStatus(err), h.log)
+		return
+	}
+
+	if h.storageSaver != nil {
+		if err := h.storageSaver.Save(); err != nil {
+			h.log.Errorf("Failed to update persistent storage: %v", err)
+		}
+	}
+
+	respEmptyJSON(w, http.StatusOK, h.log)
+}
+
+// adminAuthorized checks admin secret sent as bearer token.
+func adminAuthorized(r *http.Request, secret string) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), adminBearerPrefix)
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(secret)) == 1
+}
+
+// WithAdminRoutes returns handler serving admin endpoints of h under /admin/
+// and passing other requests to next.
+// Admin endpoints respond with 403 unless request carries
+// "Authorization: Bearer <admin secret>" header.
+// Endpoints aren't served at all if secret is empty.
+//
+// GET /admin/export streams all stored metrics.
+// POST /admin/import replaces stored metrics with imported ones.
+func WithAdminRoutes(next http.Handler, h *MetricRegistryHandler, secret string) http.Handler {
+	if len(secret) == 0 {
+		return next
+	}
+
+	importHandler := middleware.AllowContentType("application/json")(http.HandlerFunc(h.ImportMetrics))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimRight(r.URL.Path, "/")
+		if strings.HasPrefix(path, "/admin/") && !adminAuthorized(r, secret) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		switch {
+		case path == "/admin/export" && r.Method == http.MethodGet:
+			h.ExportMetrics(w, r)
+		case path == "/admin/import" && r.Method == http.MethodPost:
+			importHandler.ServeHTTP(w, r)
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
@@ -0,0 +1,145 @@
+package handlers
+
+// Provides middleware applying update requests at most once.
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/fuzzy-toozy/metrics-service/internal/common"
+	logging "github.com/fuzzy-toozy/metrics-service/internal/log"
+	"github.com/fuzzy-toozy/metrics-service/internal/server/storage"
+)
+
+// IdempotentReplayHeader set in responses returned from idempotency store.
+const IdempotentReplayHeader = "X-Idempotent-Replay"
+
+type requestKeyContextKey struct{}
+
+// requestKey returns key of request handled at most once, if any.
+func requestKey(r *http.Request) (storage.RequestKey, bool) {
+	key, ok := r.Context().Value(requestKeyContextKey{}).(storage.RequestKey)
+	return key, ok
+}
+
+type inflightRequests struct {
+	requests map[storage.RequestKey]chan struct{}
+	lock     sync.Mutex
+}
+
+// acquire waits until request with the same key handled by this server is finished.
+// Returned function must be called when request is handled.
+func (f *inflightRequests) acquire(ctx context.Context, key storage.RequestKey) (func(), error) {
+	for {
+		f.lock.Lock()
+		ch, ok := f.requests[key]
+		if !ok {
+			ch = make(chan struct{})
+			f.requests[key] = ch
+			f.lock.Unlock()
+
+			return func() {
+				f.lock.Lock()
+				delete(f.requests, key)
+				f.lock.Unlock()
+				close(ch)
+			}, nil
+		}
+		f.lock.Unlock()
+
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+type recordingResponseWriter struct {
+	http.ResponseWriter
+	body   bytes.Buffer
+	status int
+}
+
+func (w *recordingResponseWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingResponseWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+// WithIdempotency returns handler applying POST requests with request ID header at most once.
+// Successful response is stored and returned for retries of request.
+func WithIdempotency(h http.Handler, store storage.IdempotencyStore, log logging.Logger) http.Handler {
+	inflight := inflightRequests{requests: make(map[storage.RequestKey]chan struct{})}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(common.RequestIDHeader)
+		if r.Method != http.MethodPost || len(requestID) == 0 {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		key := storage.RequestKey{AgentID: r.Header.Get(common.AgentIDHeader), RequestID: requestID}
+
+		// Retry received while request is still handled waits for it instead of failing.
+		done, err := inflight.acquire(r.Context(), key)
+		if err != nil {
+			log.Debugf("Request %v of agent %v wasn't handled: %v", key.RequestID, key.AgentID, err)
+			http.Error(w, "", http.StatusServiceUnavailable)
+			return
+		}
+		defer done()
+
+		stored, err := store.Begin(key)
+		if err != nil {
+			if errors.Is(err, storage.ErrRequestInProgress) {
+				http.Error(w, "request is in progress", http.StatusConflict)
+				return
+			}
+			log.Errorf("Failed to check request %v of agent %v: %v", key.RequestID, key.AgentID, err)
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+
+		if stored != nil {
+			log.Debugf("Request %v of agent %v is already applied", key.RequestID, key.AgentID)
+			if len(stored.ContentType) > 0 {
+				w.Header().Set("Content-Type", stored.ContentType)
+			}
+			w.Header().Set(IdempotentReplayHeader, "true")
+			w.WriteHeader(stored.Status)
+			if _, err = w.Write(stored.Body); err != nil {
+				log.Errorf("Failed to write response body: %v", err)
+			}
+			return
+		}
+
+		// Repositories supporting it record request key together with its updates.
+		rw := recordingResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(&rw, r.WithContext(context.WithValue(r.Context(), requestKeyContextKey{}, key)))
+
+		if rw.status != http.StatusOK {
+			// Failed request isn't applied, so it can be retried.
+			if err = store.Abort(key); err != nil {
+				log.Errorf("Failed to abort request %v of agent %v: %v", key.RequestID, key.AgentID, err)
+			}
+			return
+		}
+
+		err = store.Complete(key, storage.StoredResponse{
+			Status:      rw.status,
+			ContentType: w.Header().Get("Content-Type"),
+			Body:        rw.body.Bytes(),
+		})
+		if err != nil {
+			log.Errorf("Failed to store response of request %v of agent %v: %v", key.RequestID, key.AgentID, err)
+		}
+	})
+}
@@ -0,0 +1,97 @@
+package handlers
+
+// Provides OpenTelemetry OTLP/HTTP metrics receiver handler.
+
+import (
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/fuzzy-toozy/metrics-service/internal/log"
+	"github.com/fuzzy-toozy/metrics-service/internal/metrics"
+	"github.com/fuzzy-toozy/metrics-service/internal/server/errtypes"
+	"github.com/fuzzy-toozy/metrics-service/internal/server/otlp"
+)
+
+const (
+	otlpProtobufContentType = "application/x-protobuf"
+	otlpJSONContentType     = "application/json"
+)
+
+func respOTLP(w http.ResponseWriter, contentType string, status int, log log.Logger) {
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	if contentType != otlpJSONContentType {
+		return
+	}
+	_, err := w.Write([]byte("{}"))
+	if err != nil {
+		log.Errorf("Failed to write response body: %v", err)
+	}
+}
+
+// UpdateMetricsFromOTLP receives metrics exported by OpenTelemetry SDKs.
+//
+// @Summary Receive OTLP metrics
+// @Description Receives ExportMetricsServiceRequest in protobuf or JSON encoding and stores converted metrics.
+// @Tags Metrics
+// @ID update-metrics-from-otlp
+// @Accept application/x-protobuf,json
+// @Produce application/x-protobuf,json
+// @Success 200
+// @Failure 400
+// @Failure 415
+// @Failure 500
+// @Router /v1/metrics [post]
+//
+// Monotonic sums are stored as counters, gauges and non-monotonic sums as gauges.
+// Histograms are stored as <name>_count and <name>_bucket counters and <name>_sum gauge.
+// Resource and data point attributes are appended to metric ID as name{key="value",...}.
+func (h *MetricRegistryHandler) UpdateMetricsFromOTLP(w http.ResponseWriter, r *http.Request) {
+	contentType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || (contentType != otlpProtobufContentType && contentType != otlpJSONContentType) {
+		h.log.Debugf("Unsupported OTLP content type '%v'", r.Header.Get("Content-Type"))
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		h.log.Debugf("Failed to read OTLP request body: %v", err)
+		respOTLP(w, contentType, http.StatusBadRequest, h.log)
+		return
+	}
+
+	var req *otlp.ExportRequest
+	if contentType == otlpProtobufContentType {
+		req, err = otlp.UnmarshalProto(body)
+	} else {
+		req, err = otlp.UnmarshalJSON(body)
+	}
+
+	if err != nil {
+		h.log.Debugf("Failed to decode OTLP request: %v", err)
+		respOTLP(w, contentType, http.StatusBadRequest, h.log)
+		return
+	}
+
+	stored := false
+	err = h.otlpConverter.Convert(req, func(receivedData []metrics.Metric) error {
+		stored = true
+		return h.repository(r).AddMetricsBulk(receivedData)
+	})
+	if err != nil {
+		h.log.Errorf("Failed to add OTLP metrics: %v", err)
+		respOTLP(w, contentType, errtypes.ErrorToStatus(err), h.log)
+		return
+	}
+
+	if stored && h.storageSaver != nil {
+		err = h.storageSaver.Save()
+		if err != nil {
+			h.log.Errorf("Failed to update persistent storage: %v", err)
+		}
+	}
+
+	respOTLP(w, contentType, http.StatusOK, h.log)
+}
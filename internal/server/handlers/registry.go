@@ -4,7 +4,10 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"sort"
+	"strconv"
 	"strings"
 	"text/template"
 
@@ -12,6 +15,7 @@ import (
 	"github.com/fuzzy-toozy/metrics-service/internal/metrics"
 	"github.com/fuzzy-toozy/metrics-service/internal/server/config"
 	"github.com/fuzzy-toozy/metrics-service/internal/server/errtypes"
+	"github.com/fuzzy-toozy/metrics-service/internal/server/otlp"
 	"github.com/fuzzy-toozy/metrics-service/internal/server/storage"
 	"github.com/go-chi/chi"
 )
@@ -22,15 +26,43 @@ type MetricURLInfo struct {
 	Value string
 }
 
+// PartialAcceptParam query parameter of /updates request enabling partial accept mode.
+// In this mode valid metrics of the batch are stored even if some metrics are rejected.
+const PartialAcceptParam = "partial"
+
+// BulkItemError error of metric rejected from bulk update.
+type BulkItemError struct {
+	ID    string `json:"id"`
+	Error string `json:"error"`
+	Index int    `json:"index"`
+}
+
+// BulkUpdateReport response of bulk update in partial accept mode.
+type BulkUpdateReport struct {
+	// Metrics accepted metrics with updated values.
+	Metrics []metrics.Metric `json:"metrics"`
+	// Errors rejected metrics with their index in request.
+	Errors []BulkItemError `json:"errors"`
+}
+
 type MetricRegistryHandler struct {
 	registry       storage.Repository
 	log            log.Logger
 	metricInfo     MetricURLInfo
 	allMetrics     *template.Template
 	storageSaver   storage.StorageSaver
+	otlpConverter  *otlp.Converter
 	databaseConfig config.DBConfig
 }
 
+// repository returns repository applying updates of request.
+func (h *MetricRegistryHandler) repository(r *http.Request) storage.Repository {
+	if key, ok := requestKey(r); ok {
+		return storage.ForRequest(h.registry, key)
+	}
+	return h.registry
+}
+
 func setJSONContent(w http.ResponseWriter) {
 	w.Header().Set("Content-Type", "application/json")
 }
@@ -304,8 +336,9 @@ func (h *MetricRegistryHandler) GetAllMetrics(w http.ResponseWriter, r *http.Req
 	w.WriteHeader(http.StatusOK)
 }
 
-func (h *MetricRegistryHandler) updateMetric(mtype, mname, mvalue string) (metricValue string, statusCode int, err error) {
-	updatedVal, err := h.registry.AddOrUpdate(mname, mvalue, mtype)
+func (h *MetricRegistryHandler) updateMetric(registry storage.Repository,
+	mtype, mname, mvalue string) (metricValue string, statusCode int, err error) {
+	updatedVal, err := registry.AddOrUpdate(mname, mvalue, mtype)
 
 	status := errtypes.ErrorToStatus(err)
 	if err != nil {
@@ -342,7 +375,7 @@ func (h *MetricRegistryHandler) UpdateMetric(w http.ResponseWriter, r *http.Requ
 	metricValue := strings.ToLower(chi.URLParam(r, h.metricInfo.Value))
 	metricName := chi.URLParam(r, h.metricInfo.Name)
 
-	value, status, err := h.updateMetric(metricType, metricName, metricValue)
+	value, status, err := h.updateMetric(h.repository(r), metricType, metricName, metricValue)
 
 	if err != nil {
 		h.log.Debugf("Failed to update metric: %v", err)
@@ -364,11 +397,15 @@ func (h *MetricRegistryHandler) UpdateMetric(w http.ResponseWriter, r *http.Requ
 // @Accept json
 // @Produce json
 // @Param data body []metrics.Metric true "Metrics data"
+// @Param partial query bool false "Store valid metrics even if some are rejected and respond with per metric report"
 // @Success 200 {array} metrics.Metric "Updated metrics"
 // @Failure 400
 // @Failure 500
 // @Router /updates [post]
 //
+// Batch is applied atomically: in case any metric is invalid nothing is stored.
+// In partial accept mode valid metrics are stored and response is BulkUpdateReport.
+//
 // Request data example:
 //
 //		[{
@@ -402,7 +439,12 @@ func (h *MetricRegistryHandler) UpdateMetricsFromJSON(w http.ResponseWriter, r *
 		return
 	}
 
-	err := h.registry.AddMetricsBulk(receivedData)
+	if partial, _ := strconv.ParseBool(r.URL.Query().Get(PartialAcceptParam)); partial {
+		h.updateMetricsPartial(w, h.repository(r), receivedData)
+		return
+	}
+
+	err := h.repository(r).AddMetricsBulk(receivedData)
 	status := errtypes.ErrorToStatus(err)
 	if err != nil {
 		h.log.Errorf("Failed to add metrics: %v", err)
@@ -413,6 +455,64 @@ func (h *MetricRegistryHandler) UpdateMetricsFromJSON(w http.ResponseWriter, r *
 	respMetricsJSON(receivedData, w, status, h.log)
 }
 
+// updateMetricsPartial stores valid metrics of data, rejected ones are reported in response.
+func (h *MetricRegistryHandler) updateMetricsPartial(w http.ResponseWriter, registry storage.Repository, data []metrics.Metric) {
+	report := BulkUpdateReport{Errors: make([]BulkItemError, 0)}
+
+	// Original indexes of metrics still accepted.
+	indexes := make([]int, len(data))
+	for i := range indexes {
+		indexes[i] = i
+	}
+
+	// Every retry removes at least one rejected metric, metrics might become invalid
+	// between attempts because of concurrent updates.
+	accepted := data
+	for len(accepted) > 0 {
+		err := registry.AddMetricsBulk(accepted)
+		var bulkErr *storage.BulkUpdateError
+		if !errors.As(err, &bulkErr) || len(bulkErr.Items) == 0 {
+			if err != nil {
+				h.log.Errorf("Failed to add metrics: %v", err)
+				respEmptyJSON(w, errtypes.ErrorToStatus(err), h.log)
+				return
+			}
+			break
+		}
+
+		rejected := make(map[int]struct{}, len(bulkErr.Items))
+		for _, item := range bulkErr.Items {
+			rejected[item.Index] = struct{}{}
+			report.Errors = append(report.Errors, BulkItemError{Index: indexes[item.Index], ID: item.ID, Error: item.Err.Error()})
+		}
+
+		n := 0
+		for i := range accepted {
+			if _, ok := rejected[i]; !ok {
+				accepted[n] = accepted[i]
+				indexes[n] = indexes[i]
+				n++
+			}
+		}
+		accepted = accepted[:n]
+		indexes = indexes[:n]
+	}
+
+	sort.Slice(report.Errors, func(i, j int) bool { return report.Errors[i].Index < report.Errors[j].Index })
+	report.Metrics = accepted
+
+	status := http.StatusOK
+	if len(accepted) == 0 && len(report.Errors) > 0 {
+		status = http.StatusBadRequest
+	}
+
+	setJSONContent(w)
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		h.log.Errorf("Failed to write response JSON body: %v", err)
+	}
+}
+
 // UpdateMetricFromJSON updates or adds metrics received in request.
 //
 // @Summary Update or add metrics from JSON
@@ -476,7 +576,7 @@ func (h *MetricRegistryHandler) UpdateMetricFromJSON(w http.ResponseWriter, r *h
 		return
 	}
 
-	value, status, err := h.updateMetric(receivedData.MType, receivedData.ID, value)
+	value, status, err := h.updateMetric(h.repository(r), receivedData.MType, receivedData.ID, value)
 
 	if err != nil {
 		h.log.Debugf("Failed to update metric: %v", err)
@@ -496,7 +596,8 @@ func (h *MetricRegistryHandler) UpdateMetricFromJSON(w http.ResponseWriter, r *h
 
 func NewMetricRegistryHandler(registry storage.Repository, logger log.Logger, minfo MetricURLInfo,
 	storageSaver storage.StorageSaver, DBConfig config.DBConfig) *MetricRegistryHandler {
-	return &MetricRegistryHandler{registry: registry, log: logger, metricInfo: minfo, storageSaver: storageSaver, databaseConfig: DBConfig}
+	return &MetricRegistryHandler{registry: registry, log: logger, metricInfo: minfo, storageSaver: storageSaver,
+		otlpConverter: otlp.NewConverter(otlp.DefaultSeriesTTL), databaseConfig: DBConfig}
 }
 
 func NewDefaultMetricRegistryHandler(logger log.Logger, registry storage.Repository,
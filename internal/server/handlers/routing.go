@@ -58,6 +58,12 @@ func SetupRouting(h *MetricRegistryHandler) http.Handler {
 		})
 	})
 
+	r.Route("/v1/metrics", func(r chi.Router) {
+		r.Post("/", func(w http.ResponseWriter, r *http.Request) {
+			h.UpdateMetricsFromOTLP(w, r)
+		})
+	})
+
 	r.Route("/value", func(r chi.Router) {
 		r.Get(fmt.Sprintf("/{%v}/{%v}", minfo.Type, minfo.Name), func(w http.ResponseWriter, r *http.Request) {
 			h.GetMetric(w, r)
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+
+	logging "github.com/fuzzy-toozy/metrics-service/internal/log"
+	"github.com/fuzzy-toozy/metrics-service/internal/server/config"
+	"github.com/fuzzy-toozy/metrics-service/internal/server/storage"
+)
+
+// MigrateCommand name of server subcommand managing database schema.
+const MigrateCommand = "migrate"
+
+// RunMigrate runs database schema migration command.
+// Usage: server migrate [flags] up|down [steps]|status, steps defaults to 1.
+func RunMigrate(logger logging.Logger) error {
+	cfg, err := config.BuildConfig()
+	if err != nil {
+		return fmt.Errorf("failed to build server config: %w", err)
+	}
+
+	if !cfg.DatabaseConfig.UseDatabase {
+		return errors.New("database connection string is not set")
+	}
+
+	args := flag.Args()
+	if len(args) == 0 {
+		return errors.New("migrate command expected: up, down [steps] or status")
+	}
+
+	db, err := sql.Open(cfg.DatabaseConfig.DriverName, cfg.DatabaseConfig.ConnString)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			logger.Errorf("Failed to close database: %v", err)
+		}
+	}()
+
+	migrator, err := storage.NewMigrator(db, logger)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	switch args[0] {
+	case "up":
+		return migrator.Up(ctx)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps <= 0 {
+				return fmt.Errorf("invalid number of steps '%v'", args[1])
+			}
+		}
+		return migrator.Down(ctx, steps)
+	case "status":
+		status, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		logger.Infof("Schema version: %v, latest: %v", status.Current, status.Latest)
+		for _, m := range status.Pending {
+			logger.Infof("Pending migration %v_%v", m.Version, m.Name)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate command '%v'", args[0])
+	}
+}
@@ -0,0 +1,270 @@
+package otlp
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fuzzy-toozy/metrics-service/internal/metrics"
+)
+
+// DefaultSeriesTTL time after which state of series not received anymore is dropped.
+const DefaultSeriesTTL = time.Hour
+
+// seriesState accumulated state of a single counter-like series.
+type seriesState struct {
+	lastSeen  time.Time
+	startTime uint64
+	total     float64
+	reported  int64
+}
+
+// Converter converts OTLP data points into server metrics.
+// Monotonic sums and histogram counts are converted to counter metrics,
+// which are accumulated by the server, so the converter keeps state of each series
+// to turn cumulative values into increments and to carry fractional parts of
+// double values between requests.
+// Gauges and non-monotonic sums are converted to gauge metrics.
+// Histogram data points are converted to Prometheus-like _count, _sum and _bucket series.
+// State of series not received for ttl is dropped.
+// Cumulative series first seen after converter start or series eviction, but started earlier,
+// may have been reported before, so their current value is recorded as baseline and not reported.
+// Data points flagged with no recorded value are ignored.
+type Converter struct {
+	series    map[string]seriesState
+	now       func() time.Time
+	since     time.Time
+	nextEvict time.Time
+	ttl       time.Duration
+	lock      sync.Mutex
+}
+
+func NewConverter(ttl time.Duration) *Converter {
+	return &Converter{series: make(map[string]seriesState), ttl: ttl, now: time.Now}
+}
+
+// SeriesID builds metric ID from OTLP metric name and attributes.
+// Attributes are sorted by key and appended in name{key="value",...} form.
+func SeriesID(name string, attrs []KeyValue) string {
+	if len(attrs) == 0 {
+		return name
+	}
+
+	sorted := make([]KeyValue, len(attrs))
+	copy(sorted, attrs)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Key < sorted[j].Key
+	})
+
+	b := strings.Builder{}
+	b.WriteString(name)
+	b.WriteByte('{')
+	for i, kv := range sorted {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(kv.Key)
+		b.WriteByte('=')
+		b.WriteString(strconv.Quote(kv.Value))
+	}
+	b.WriteByte('}')
+
+	return b.String()
+}
+
+// mergeAttributes merges resource and data point attributes,
+// data point attributes take precedence.
+func mergeAttributes(resource, point []KeyValue) []KeyValue {
+	if len(resource) == 0 {
+		return point
+	}
+
+	res := make([]KeyValue, 0, len(resource)+len(point))
+	idx := make(map[string]int, len(resource)+len(point))
+	for _, attrs := range [][]KeyValue{resource, point} {
+		for _, kv := range attrs {
+			if i, ok := idx[kv.Key]; ok {
+				res[i] = kv
+				continue
+			}
+			idx[kv.Key] = len(res)
+			res = append(res, kv)
+		}
+	}
+
+	return res
+}
+
+func isValidValue(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
+
+// accumulate updates staged series state with received value and returns
+// current series total and whole increment not reported yet.
+// Cumulative series are reset if start time changes or value decreases.
+// Unspecified temporality is handled as cumulative.
+// Must be called with lock held.
+func (c *Converter) accumulate(staged map[string]seriesState, id string, startTime uint64, value float64,
+	temporality AggregationTemporality) (float64, int64) {
+	state, ok := staged[id]
+	if !ok {
+		state, ok = c.series[id]
+	}
+	if !ok {
+		state = seriesState{startTime: startTime}
+		if temporality != TemporalityDelta && startTime != 0 && startTime < uint64(c.since.UnixNano()) {
+			state.reported = int64(math.Floor(value))
+		}
+	}
+	defer func() { staged[id] = state }()
+
+	if temporality == TemporalityDelta {
+		state.total += value
+	} else {
+		if ok && ((startTime != 0 && startTime != state.startTime) || value < state.total) {
+			state.reported = 0
+		}
+		state.startTime = startTime
+		state.total = value
+	}
+
+	whole := int64(math.Floor(state.total))
+	delta := whole - state.reported
+	state.reported = whole
+
+	return state.total, delta
+}
+
+func (c *Converter) convertSum(staged map[string]seriesState, res []metrics.Metric, name string, resAttrs []KeyValue, sum *Sum) []metrics.Metric {
+	for i := range sum.DataPoints {
+		p := &sum.DataPoints[i]
+		value := p.Value()
+		if p.NoRecordedValue() || !isValidValue(value) {
+			continue
+		}
+
+		id := SeriesID(name, mergeAttributes(resAttrs, p.Attributes))
+		total, delta := c.accumulate(staged, id, p.StartTimeUnixNano, value, sum.Temporality)
+		if sum.IsMonotonic {
+			res = append(res, metrics.NewCounterMetric(id, delta))
+		} else {
+			res = append(res, metrics.NewGaugeMetric(id, total))
+		}
+	}
+
+	return res
+}
+
+func (c *Converter) convertHistogram(staged map[string]seriesState, res []metrics.Metric, name string, resAttrs []KeyValue, hist *Histogram) []metrics.Metric {
+	for i := range hist.DataPoints {
+		p := &hist.DataPoints[i]
+		if p.NoRecordedValue() {
+			continue
+		}
+		attrs := mergeAttributes(resAttrs, p.Attributes)
+
+		id := SeriesID(name+"_count", attrs)
+		_, delta := c.accumulate(staged, id, p.StartTimeUnixNano, float64(p.Count), hist.Temporality)
+		res = append(res, metrics.NewCounterMetric(id, delta))
+
+		if p.HasSum && isValidValue(p.Sum) {
+			id = SeriesID(name+"_sum", attrs)
+			total, _ := c.accumulate(staged, id, p.StartTimeUnixNano, p.Sum, hist.Temporality)
+			res = append(res, metrics.NewGaugeMetric(id, total))
+		}
+
+		var cumulative uint64
+		for b, count := range p.BucketCounts {
+			cumulative += count
+			le := "+Inf"
+			if b < len(p.ExplicitBounds) {
+				le = strconv.FormatFloat(p.ExplicitBounds[b], 'f', -1, 64)
+			}
+			bucketAttrs := make([]KeyValue, 0, len(attrs)+1)
+			bucketAttrs = append(bucketAttrs, attrs...)
+			bucketAttrs = append(bucketAttrs, KeyValue{Key: "le", Value: le})
+
+			id = SeriesID(name+"_bucket", bucketAttrs)
+			_, delta = c.accumulate(staged, id, p.StartTimeUnixNano, float64(cumulative), hist.Temporality)
+			res = append(res, metrics.NewCounterMetric(id, delta))
+		}
+	}
+
+	return res
+}
+
+// Convert converts all supported data points of export request into metrics and passes them to store.
+// Series state is updated only if store succeeds, so increments of rejected request are converted again on retry.
+// Requests are converted and stored one at a time, so each one is converted from state committed by the previous one.
+// Exponential histograms and summaries are ignored.
+func (c *Converter) Convert(req *ExportRequest, store func([]metrics.Metric) error) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.since.IsZero() {
+		c.since = c.now()
+	}
+
+	staged := make(map[string]seriesState)
+	res := make([]metrics.Metric, 0)
+	for _, rm := range req.ResourceMetrics {
+		resAttrs := rm.Resource.Attributes
+		for _, sm := range rm.ScopeMetrics {
+			for i := range sm.Metrics {
+				m := &sm.Metrics[i]
+				switch {
+				case m.Gauge != nil:
+					for j := range m.Gauge.DataPoints {
+						p := &m.Gauge.DataPoints[j]
+						value := p.Value()
+						if p.NoRecordedValue() || !isValidValue(value) {
+							continue
+						}
+						id := SeriesID(m.Name, mergeAttributes(resAttrs, p.Attributes))
+						res = append(res, metrics.NewGaugeMetric(id, value))
+					}
+				case m.Sum != nil:
+					res = c.convertSum(staged, res, m.Name, resAttrs, m.Sum)
+				case m.Histogram != nil:
+					res = c.convertHistogram(staged, res, m.Name, resAttrs, m.Histogram)
+				}
+			}
+		}
+	}
+
+	if len(res) > 0 {
+		if err := store(res); err != nil {
+			return err
+		}
+	}
+
+	c.commit(staged)
+
+	return nil
+}
+
+// commit stores staged series state and drops series expired since the last eviction.
+// Series started before eviction are handled as possibly reported since then.
+// Must be called with lock held.
+func (c *Converter) commit(staged map[string]seriesState) {
+	now := c.now()
+	for id, state := range staged {
+		state.lastSeen = now
+		c.series[id] = state
+	}
+
+	if now.Before(c.nextEvict) {
+		return
+	}
+	c.nextEvict = now.Add(c.ttl / 2)
+
+	for id, state := range c.series {
+		if now.Sub(state.lastSeen) > c.ttl {
+			delete(c.series, id)
+			c.since = now
+		}
+	}
+}
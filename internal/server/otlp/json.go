@@ -0,0 +1,211 @@
+package otlp
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// jsonUint64 64 bit integer which OTLP JSON encoding may send either as number or as string.
+type jsonUint64 uint64
+
+func (v *jsonUint64) UnmarshalJSON(data []byte) error {
+	res, err := strconv.ParseUint(strings.Trim(string(data), `"`), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid integer value %s: %w", data, err)
+	}
+	*v = jsonUint64(res)
+	return nil
+}
+
+type jsonInt64 int64
+
+func (v *jsonInt64) UnmarshalJSON(data []byte) error {
+	res, err := strconv.ParseInt(strings.Trim(string(data), `"`), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid integer value %s: %w", data, err)
+	}
+	*v = jsonInt64(res)
+	return nil
+}
+
+// jsonTemporality aggregation temporality sent either as enum number or enum name.
+type jsonTemporality AggregationTemporality
+
+func (v *jsonTemporality) UnmarshalJSON(data []byte) error {
+	switch strings.Trim(string(data), `"`) {
+	case "0", "AGGREGATION_TEMPORALITY_UNSPECIFIED":
+		*v = jsonTemporality(TemporalityUnspecified)
+	case "1", "AGGREGATION_TEMPORALITY_DELTA":
+		*v = jsonTemporality(TemporalityDelta)
+	case "2", "AGGREGATION_TEMPORALITY_CUMULATIVE":
+		*v = jsonTemporality(TemporalityCumulative)
+	default:
+		return fmt.Errorf("invalid aggregation temporality %s", data)
+	}
+	return nil
+}
+
+type jsonAnyValue struct {
+	StringValue *string    `json:"stringValue"`
+	BoolValue   *bool      `json:"boolValue"`
+	IntValue    *jsonInt64 `json:"intValue"`
+	DoubleValue *float64   `json:"doubleValue"`
+}
+
+func (v *jsonAnyValue) String() string {
+	switch {
+	case v.StringValue != nil:
+		return *v.StringValue
+	case v.BoolValue != nil:
+		return strconv.FormatBool(*v.BoolValue)
+	case v.IntValue != nil:
+		return strconv.FormatInt(int64(*v.IntValue), 10)
+	case v.DoubleValue != nil:
+		return strconv.FormatFloat(*v.DoubleValue, 'f', -1, 64)
+	}
+	return ""
+}
+
+type jsonKeyValue struct {
+	Key   string       `json:"key"`
+	Value jsonAnyValue `json:"value"`
+}
+
+type jsonNumberDataPoint struct {
+	AsDouble          *float64       `json:"asDouble"`
+	AsInt             *jsonInt64     `json:"asInt"`
+	Attributes        []jsonKeyValue `json:"attributes"`
+	StartTimeUnixNano jsonUint64     `json:"startTimeUnixNano"`
+	TimeUnixNano      jsonUint64     `json:"timeUnixNano"`
+	Flags             uint32         `json:"flags"`
+}
+
+type jsonHistogramDataPoint struct {
+	Sum               *float64       `json:"sum"`
+	Attributes        []jsonKeyValue `json:"attributes"`
+	BucketCounts      []jsonUint64   `json:"bucketCounts"`
+	ExplicitBounds    []float64      `json:"explicitBounds"`
+	StartTimeUnixNano jsonUint64     `json:"startTimeUnixNano"`
+	TimeUnixNano      jsonUint64     `json:"timeUnixNano"`
+	Count             jsonUint64     `json:"count"`
+	Flags             uint32         `json:"flags"`
+}
+
+type jsonMetric struct {
+	Gauge *struct {
+		DataPoints []jsonNumberDataPoint `json:"dataPoints"`
+	} `json:"gauge"`
+	Sum *struct {
+		DataPoints             []jsonNumberDataPoint `json:"dataPoints"`
+		AggregationTemporality jsonTemporality       `json:"aggregationTemporality"`
+		IsMonotonic            bool                  `json:"isMonotonic"`
+	} `json:"sum"`
+	Histogram *struct {
+		DataPoints             []jsonHistogramDataPoint `json:"dataPoints"`
+		AggregationTemporality jsonTemporality          `json:"aggregationTemporality"`
+	} `json:"histogram"`
+	Name string `json:"name"`
+}
+
+type jsonExportRequest struct {
+	ResourceMetrics []struct {
+		Resource struct {
+			Attributes []jsonKeyValue `json:"attributes"`
+		} `json:"resource"`
+		ScopeMetrics []struct {
+			Metrics []jsonMetric `json:"metrics"`
+		} `json:"scopeMetrics"`
+	} `json:"resourceMetrics"`
+}
+
+func fromJSONAttributes(attrs []jsonKeyValue) []KeyValue {
+	res := make([]KeyValue, 0, len(attrs))
+	for i := range attrs {
+		res = append(res, KeyValue{Key: attrs[i].Key, Value: attrs[i].Value.String()})
+	}
+	return res
+}
+
+func fromJSONNumberPoints(points []jsonNumberDataPoint) []NumberDataPoint {
+	res := make([]NumberDataPoint, 0, len(points))
+	for _, p := range points {
+		dp := NumberDataPoint{
+			Attributes:        fromJSONAttributes(p.Attributes),
+			StartTimeUnixNano: uint64(p.StartTimeUnixNano),
+			TimeUnixNano:      uint64(p.TimeUnixNano),
+			Flags:             p.Flags,
+		}
+		if p.AsInt != nil {
+			dp.AsInt = int64(*p.AsInt)
+			dp.IsInt = true
+		} else if p.AsDouble != nil {
+			dp.AsDouble = *p.AsDouble
+		}
+		res = append(res, dp)
+	}
+	return res
+}
+
+func fromJSONMetric(m *jsonMetric) Metric {
+	res := Metric{Name: m.Name}
+	if m.Gauge != nil {
+		res.Gauge = &Gauge{DataPoints: fromJSONNumberPoints(m.Gauge.DataPoints)}
+	}
+
+	if m.Sum != nil {
+		res.Sum = &Sum{
+			DataPoints:  fromJSONNumberPoints(m.Sum.DataPoints),
+			Temporality: AggregationTemporality(m.Sum.AggregationTemporality),
+			IsMonotonic: m.Sum.IsMonotonic,
+		}
+	}
+
+	if m.Histogram != nil {
+		res.Histogram = &Histogram{Temporality: AggregationTemporality(m.Histogram.AggregationTemporality)}
+		for _, p := range m.Histogram.DataPoints {
+			dp := HistogramDataPoint{
+				Attributes:        fromJSONAttributes(p.Attributes),
+				ExplicitBounds:    p.ExplicitBounds,
+				StartTimeUnixNano: uint64(p.StartTimeUnixNano),
+				TimeUnixNano:      uint64(p.TimeUnixNano),
+				Count:             uint64(p.Count),
+				Flags:             p.Flags,
+			}
+			for _, c := range p.BucketCounts {
+				dp.BucketCounts = append(dp.BucketCounts, uint64(c))
+			}
+			if p.Sum != nil {
+				dp.Sum = *p.Sum
+				dp.HasSum = true
+			}
+			res.Histogram.DataPoints = append(res.Histogram.DataPoints, dp)
+		}
+	}
+
+	return res
+}
+
+// UnmarshalJSON decodes JSON encoded ExportMetricsServiceRequest.
+func UnmarshalJSON(b []byte) (*ExportRequest, error) {
+	var jreq jsonExportRequest
+	if err := json.Unmarshal(b, &jreq); err != nil {
+		return nil, fmt.Errorf("failed to decode export request: %w", err)
+	}
+
+	req := ExportRequest{ResourceMetrics: make([]ResourceMetrics, 0, len(jreq.ResourceMetrics))}
+	for _, jrm := range jreq.ResourceMetrics {
+		rm := ResourceMetrics{Resource: Resource{Attributes: fromJSONAttributes(jrm.Resource.Attributes)}}
+		for _, jsm := range jrm.ScopeMetrics {
+			sm := ScopeMetrics{Metrics: make([]Metric, 0, len(jsm.Metrics))}
+			for i := range jsm.Metrics {
+				sm.Metrics = append(sm.Metrics, fromJSONMetric(&jsm.Metrics[i]))
+			}
+			rm.ScopeMetrics = append(rm.ScopeMetrics, sm)
+		}
+		req.ResourceMetrics = append(req.ResourceMetrics, rm)
+	}
+
+	return &req, nil
+}
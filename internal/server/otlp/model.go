@@ -0,0 +1,112 @@
+// Package otlp OpenTelemetry OTLP/HTTP metrics decoding.
+// Decodes ExportMetricsServiceRequest messages in protobuf and JSON encodings
+// and converts received data points into server metrics.
+package otlp
+
+// AggregationTemporality temporality of Sum and Histogram data points.
+type AggregationTemporality int32
+
+const (
+	TemporalityUnspecified AggregationTemporality = iota
+	TemporalityDelta
+	TemporalityCumulative
+)
+
+// FlagNoRecordedValue data point flag marking point without recorded value,
+// e.g. when series is stale.
+const FlagNoRecordedValue uint32 = 1
+
+// ExportRequest decoded ExportMetricsServiceRequest.
+type ExportRequest struct {
+	ResourceMetrics []ResourceMetrics
+}
+
+// ResourceMetrics metrics produced by a single resource.
+type ResourceMetrics struct {
+	Resource     Resource
+	ScopeMetrics []ScopeMetrics
+}
+
+// Resource entity producing telemetry.
+type Resource struct {
+	Attributes []KeyValue
+}
+
+// ScopeMetrics metrics produced by a single instrumentation scope.
+type ScopeMetrics struct {
+	Metrics []Metric
+}
+
+// Metric single OTLP metric. Only one of Gauge, Sum and Histogram is set.
+type Metric struct {
+	Gauge     *Gauge
+	Sum       *Sum
+	Histogram *Histogram
+	Name      string
+}
+
+// Gauge data points of gauge metric.
+type Gauge struct {
+	DataPoints []NumberDataPoint
+}
+
+// Sum data points of sum metric.
+type Sum struct {
+	DataPoints  []NumberDataPoint
+	Temporality AggregationTemporality
+	IsMonotonic bool
+}
+
+// Histogram data points of histogram metric.
+type Histogram struct {
+	DataPoints  []HistogramDataPoint
+	Temporality AggregationTemporality
+}
+
+// NumberDataPoint single value of gauge or sum metric.
+type NumberDataPoint struct {
+	Attributes        []KeyValue
+	StartTimeUnixNano uint64
+	TimeUnixNano      uint64
+	AsDouble          float64
+	AsInt             int64
+	Flags             uint32
+	IsInt             bool
+}
+
+// Value returns data point value as float64 regardless of its encoding.
+func (p *NumberDataPoint) Value() float64 {
+	if p.IsInt {
+		return float64(p.AsInt)
+	}
+	return p.AsDouble
+}
+
+// HistogramDataPoint single value of histogram metric.
+type HistogramDataPoint struct {
+	Attributes        []KeyValue
+	BucketCounts      []uint64
+	ExplicitBounds    []float64
+	StartTimeUnixNano uint64
+	TimeUnixNano      uint64
+	Count             uint64
+	Sum               float64
+	Flags             uint32
+	HasSum            bool
+}
+
+// NoRecordedValue reports whether data point carries no value.
+func (p *NumberDataPoint) NoRecordedValue() bool {
+	return p.Flags&FlagNoRecordedValue != 0
+}
+
+// NoRecordedValue reports whether data point carries no value.
+func (p *HistogramDataPoint) NoRecordedValue() bool {
+	return p.Flags&FlagNoRecordedValue != 0
+}
+
+// KeyValue attribute with value converted to its string representation.
+type KeyValue struct {
+	Key   string
+	Value string
+}
@@ -0,0 +1,314 @@
+package otlp
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/fuzzy-toozy/metrics-service/internal/metrics"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func appendMessage(b []byte, num protowire.Number, msg []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, msg)
+}
+
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+func appendFixed64(b []byte, num protowire.Number, v uint64) []byte {
+	b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, v)
+}
+
+func appendVarint(b []byte, num protowire.Number, v uint64) []byte {
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+func makeKeyValue(key, val string) []byte {
+	anyValue := appendString(nil, 1, val)
+	kv := appendString(nil, 1, key)
+	return appendMessage(kv, 2, anyValue)
+}
+
+func makeProtoRequest() []byte {
+	gaugePoint := appendFixed64(nil, 4, math.Float64bits(1.5))
+	gaugePoint = appendMessage(gaugePoint, 7, makeKeyValue("host", "a"))
+	gauge := appendMessage(nil, 1, gaugePoint)
+	gaugeMetric := appendString(nil, 1, "temperature")
+	gaugeMetric = appendMessage(gaugeMetric, 5, gauge)
+
+	sumPoint := appendFixed64(nil, 2, 100)
+	sumPoint = appendFixed64(sumPoint, 6, 42)
+	sum := appendMessage(nil, 1, sumPoint)
+	sum = appendVarint(sum, 2, uint64(TemporalityCumulative))
+	sum = appendVarint(sum, 3, protowire.EncodeBool(true))
+	sumMetric := appendString(nil, 1, "requests")
+	sumMetric = appendMessage(sumMetric, 7, sum)
+
+	var counts, bounds []byte
+	for _, c := range []uint64{1, 2, 3} {
+		counts = protowire.AppendFixed64(counts, c)
+	}
+	for _, b := range []float64{0.5, 1} {
+		bounds = protowire.AppendFixed64(bounds, math.Float64bits(b))
+	}
+	histPoint := appendFixed64(nil, 4, 6)
+	histPoint = appendFixed64(histPoint, 5, math.Float64bits(4.5))
+	histPoint = appendMessage(histPoint, 6, counts)
+	histPoint = appendMessage(histPoint, 7, bounds)
+	hist := appendMessage(nil, 1, histPoint)
+	hist = appendVarint(hist, 2, uint64(TemporalityDelta))
+	histMetric := appendString(nil, 1, "latency")
+	histMetric = appendMessage(histMetric, 9, hist)
+
+	scope := appendMessage(nil, 2, gaugeMetric)
+	scope = appendMessage(scope, 2, sumMetric)
+	scope = appendMessage(scope, 2, histMetric)
+
+	resource := appendMessage(nil, 1, makeKeyValue("service.name", "svc"))
+	rm := appendMessage(nil, 1, resource)
+	rm = appendMessage(rm, 2, scope)
+
+	return appendMessage(nil, 1, rm)
+}
+
+const jsonRequest = `{
+  "resourceMetrics": [{
+    "resource": {"attributes": [{"key": "service.name", "value": {"stringValue": "svc"}}]},
+    "scopeMetrics": [{
+      "metrics": [
+        {"name": "temperature", "gauge": {"dataPoints": [
+          {"asDouble": 1.5, "attributes": [{"key": "host", "value": {"stringValue": "a"}}]}
+        ]}},
+        {"name": "requests", "sum": {"aggregationTemporality": "AGGREGATION_TEMPORALITY_CUMULATIVE",
+          "isMonotonic": true, "dataPoints": [{"asInt": "42", "startTimeUnixNano": "100"}]}},
+        {"name": "latency", "histogram": {"aggregationTemporality": 1, "dataPoints": [
+          {"count": "6", "sum": 4.5, "bucketCounts": ["1", "2", "3"], "explicitBounds": [0.5, 1]}
+        ]}}
+      ]
+    }]
+  }]
+}`
+
+func toMap(t *testing.T, data []metrics.Metric) map[string]string {
+	res := make(map[string]string, len(data))
+	for _, m := range data {
+		v, err := m.GetData()
+		require.NoError(t, err)
+		res[m.MType+":"+m.ID] = v
+	}
+	return res
+}
+
+func convert(t *testing.T, c *Converter, req *ExportRequest) []metrics.Metric {
+	res := make([]metrics.Metric, 0)
+	require.NoError(t, c.Convert(req, func(data []metrics.Metric) error {
+		res = data
+		return nil
+	}))
+	return res
+}
+
+// newConverter returns converter started at Unix epoch,
+// so series of test requests are started after it.
+func newConverter(ttl time.Duration) *Converter {
+	c := NewConverter(ttl)
+	c.now = func() time.Time { return time.Unix(0, 0) }
+	return c
+}
+
+func Test_DecodeAndConvert(t *testing.T) {
+	expected := map[string]string{
+		`gauge:temperature{host="a",service.name="svc"}`:       "1.5",
+		`counter:requests{service.name="svc"}`:                 "42",
+		`counter:latency_count{service.name="svc"}`:            "6",
+		`gauge:latency_sum{service.name="svc"}`:                "4.5",
+		`counter:latency_bucket{le="0.5",service.name="svc"}`:  "1",
+		`counter:latency_bucket{le="1",service.name="svc"}`:    "3",
+		`counter:latency_bucket{le="+Inf",service.name="svc"}`: "6",
+	}
+
+	protoReq, err := UnmarshalProto(makeProtoRequest())
+	require.NoError(t, err)
+	require.Equal(t, expected, toMap(t, convert(t, newConverter(DefaultSeriesTTL), protoReq)))
+
+	jsonReq, err := UnmarshalJSON([]byte(jsonRequest))
+	require.NoError(t, err)
+	require.Equal(t, expected, toMap(t, convert(t, newConverter(DefaultSeriesTTL), jsonReq)))
+
+	_, err = UnmarshalProto([]byte{0xff})
+	require.Error(t, err)
+
+	_, err = UnmarshalJSON([]byte("{"))
+	require.Error(t, err)
+}
+
+func makeSumRequest(value float64, start uint64, temporality AggregationTemporality, monotonic bool) *ExportRequest {
+	return &ExportRequest{ResourceMetrics: []ResourceMetrics{{
+		ScopeMetrics: []ScopeMetrics{{Metrics: []Metric{{
+			Name: "sum",
+			Sum: &Sum{
+				DataPoints:  []NumberDataPoint{{AsDouble: value, StartTimeUnixNano: start}},
+				Temporality: temporality,
+				IsMonotonic: monotonic,
+			},
+		}}}},
+	}}}
+}
+
+func Test_ConvertTemporality(t *testing.T) {
+	type step struct {
+		value    float64
+		start    uint64
+		expected string
+	}
+
+	tests := []struct {
+		name        string
+		steps       []step
+		temporality AggregationTemporality
+		monotonic   bool
+	}{
+		{
+			name:        "Cumulative counter",
+			temporality: TemporalityCumulative,
+			monotonic:   true,
+			steps:       []step{{10, 1, "10"}, {15, 1, "5"}, {15, 1, "0"}, {3, 1, "3"}, {4, 2, "4"}},
+		},
+		{
+			name:        "Delta counter with fractions",
+			temporality: TemporalityDelta,
+			monotonic:   true,
+			steps:       []step{{0.6, 0, "0"}, {0.6, 0, "1"}, {2, 0, "2"}},
+		},
+		{
+			name:        "Delta non-monotonic sum",
+			temporality: TemporalityDelta,
+			steps:       []step{{5, 0, "5"}, {-2, 0, "3"}},
+		},
+		{
+			name:        "Cumulative non-monotonic sum",
+			temporality: TemporalityCumulative,
+			steps:       []step{{5, 0, "5"}, {-2, 0, "-2"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newConverter(DefaultSeriesTTL)
+			for _, s := range tt.steps {
+				res := convert(t, c, makeSumRequest(s.value, s.start, tt.temporality, tt.monotonic))
+				require.Len(t, res, 1)
+				v, err := res[0].GetData()
+				require.NoError(t, err)
+				require.Equal(t, s.expected, v)
+			}
+		})
+	}
+
+	res := convert(t, newConverter(DefaultSeriesTTL), makeSumRequest(math.NaN(), 0, TemporalityDelta, false))
+	require.Empty(t, res)
+}
+
+func Test_ConvertState(t *testing.T) {
+	now := time.Unix(0, 0)
+	c := NewConverter(time.Minute)
+	c.now = func() time.Time { return now }
+
+	delta := func(value float64) string {
+		res := convert(t, c, makeSumRequest(value, 1, TemporalityCumulative, true))
+		require.Len(t, res, 1)
+		v, err := res[0].GetData()
+		require.NoError(t, err)
+		return v
+	}
+
+	require.Equal(t, "10", delta(10))
+
+	// Increments of request failed to store are converted again.
+	storeErr := errors.New("store failed")
+	err := c.Convert(makeSumRequest(15, 1, TemporalityCumulative, true), func([]metrics.Metric) error {
+		return storeErr
+	})
+	require.ErrorIs(t, err, storeErr)
+	require.Equal(t, "5", delta(15))
+
+	// Series not received for ttl are dropped.
+	other := makeSumRequest(1, 1, TemporalityCumulative, true)
+	other.ResourceMetrics[0].ScopeMetrics[0].Metrics[0].Name = "other"
+	now = now.Add(30 * time.Second)
+	convert(t, c, other)
+	require.Len(t, c.series, 2)
+	now = now.Add(2 * time.Minute)
+	convert(t, c, other)
+	require.Contains(t, c.series, "other")
+	require.Len(t, c.series, 1)
+}
+
+func Test_ConvertBaseline(t *testing.T) {
+	now := time.Unix(0, 1000)
+	c := NewConverter(time.Minute)
+	c.now = func() time.Time { return now }
+
+	delta := func(value float64, start uint64) string {
+		res := convert(t, c, makeSumRequest(value, start, TemporalityCumulative, true))
+		require.Len(t, res, 1)
+		v, err := res[0].GetData()
+		require.NoError(t, err)
+		return v
+	}
+
+	// Series started before converter may have been reported already.
+	require.Equal(t, "0", delta(10, 1))
+	require.Equal(t, "5", delta(15, 1))
+	require.Equal(t, "3", delta(3, 2))
+
+	// Series started after converter are reported in full.
+	other := makeSumRequest(7, 2000, TemporalityCumulative, true)
+	other.ResourceMetrics[0].ScopeMetrics[0].Metrics[0].Name = "other"
+	require.Equal(t, "7", toMap(t, convert(t, c, other))["counter:other"])
+
+	// Series evicted and received again are handled as possibly reported.
+	now = now.Add(2 * time.Minute)
+	third := makeSumRequest(1, 2000, TemporalityCumulative, true)
+	third.ResourceMetrics[0].ScopeMetrics[0].Metrics[0].Name = "third"
+	convert(t, c, third)
+	require.Len(t, c.series, 1)
+	require.Equal(t, "0", delta(20, 2))
+	require.Equal(t, "0", toMap(t, convert(t, c, other))["counter:other"])
+}
+
+func Test_ConvertNoRecordedValue(t *testing.T) {
+	c := newConverter(DefaultSeriesTTL)
+	convert(t, c, makeSumRequest(10, 1, TemporalityCumulative, true))
+
+	req := makeSumRequest(0, 1, TemporalityCumulative, true)
+	req.ResourceMetrics[0].ScopeMetrics[0].Metrics[0].Sum.DataPoints[0].Flags = FlagNoRecordedValue
+	require.Empty(t, convert(t, c, req))
+
+	// Point without recorded value is not handled as reset.
+	res := toMap(t, convert(t, c, makeSumRequest(12, 1, TemporalityCumulative, true)))
+	require.Equal(t, map[string]string{"counter:sum": "2"}, res)
+
+	point := appendFixed64(nil, 2, 1)
+	point = appendVarint(point, 8, uint64(FlagNoRecordedValue))
+	sum := appendVarint(appendMessage(nil, 1, point), 2, uint64(TemporalityCumulative))
+	scope := appendMessage(nil, 2, appendMessage(appendString(nil, 1, "sum"), 7, sum))
+	protoReq, err := UnmarshalProto(appendMessage(nil, 1, appendMessage(nil, 2, scope)))
+	require.NoError(t, err)
+	require.Empty(t, convert(t, c, protoReq))
+
+	jsonReq, err := UnmarshalJSON([]byte(`{"resourceMetrics": [{"scopeMetrics": [{"metrics": [
+		{"name": "latency", "histogram": {"aggregationTemporality": 2, "dataPoints": [{"count": "0", "flags": 1}]}},
+		{"name": "temperature", "gauge": {"dataPoints": [{"flags": 1}]}}
+	]}]}]}`))
+	require.NoError(t, err)
+	require.Empty(t, convert(t, c, jsonReq))
+}
@@ -0,0 +1,323 @@
+package otlp
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// fieldCallback is called for each field of a protobuf message.
+// Value holds raw varint/fixed value for scalar fields and
+// data holds the payload of length-delimited fields.
+type fieldCallback func(num protowire.Number, typ protowire.Type, value uint64, data []byte) error
+
+func walkMessage(b []byte, callback fieldCallback) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return fmt.Errorf("invalid field tag: %w", protowire.ParseError(n))
+		}
+		b = b[n:]
+
+		var value uint64
+		var data []byte
+		switch typ {
+		case protowire.VarintType:
+			value, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed64Type:
+			value, n = protowire.ConsumeFixed64(b)
+		case protowire.Fixed32Type:
+			var v32 uint32
+			v32, n = protowire.ConsumeFixed32(b)
+			value = uint64(v32)
+		case protowire.BytesType:
+			data, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+
+		if n < 0 {
+			return fmt.Errorf("invalid value of field %v: %w", num, protowire.ParseError(n))
+		}
+		b = b[n:]
+
+		if err := callback(num, typ, value, data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// UnmarshalProto decodes protobuf encoded ExportMetricsServiceRequest.
+func UnmarshalProto(b []byte) (*ExportRequest, error) {
+	req := ExportRequest{}
+	err := walkMessage(b, func(num protowire.Number, typ protowire.Type, _ uint64, data []byte) error {
+		if num != 1 || typ != protowire.BytesType {
+			return nil
+		}
+		rm, err := decodeResourceMetrics(data)
+		if err != nil {
+			return err
+		}
+		req.ResourceMetrics = append(req.ResourceMetrics, rm)
+		return nil
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode export request: %w", err)
+	}
+
+	return &req, nil
+}
+
+func decodeResourceMetrics(b []byte) (ResourceMetrics, error) {
+	rm := ResourceMetrics{}
+	err := walkMessage(b, func(num protowire.Number, typ protowire.Type, _ uint64, data []byte) error {
+		if typ != protowire.BytesType {
+			return nil
+		}
+		switch num {
+		case 1:
+			return walkMessage(data, func(num protowire.Number, typ protowire.Type, _ uint64, data []byte) error {
+				if num != 1 || typ != protowire.BytesType {
+					return nil
+				}
+				kv, err := decodeKeyValue(data)
+				if err != nil {
+					return err
+				}
+				rm.Resource.Attributes = append(rm.Resource.Attributes, kv)
+				return nil
+			})
+		case 2:
+			sm, err := decodeScopeMetrics(data)
+			if err != nil {
+				return err
+			}
+			rm.ScopeMetrics = append(rm.ScopeMetrics, sm)
+		}
+		return nil
+	})
+	return rm, err
+}
+
+func decodeScopeMetrics(b []byte) (ScopeMetrics, error) {
+	sm := ScopeMetrics{}
+	err := walkMessage(b, func(num protowire.Number, typ protowire.Type, _ uint64, data []byte) error {
+		if num != 2 || typ != protowire.BytesType {
+			return nil
+		}
+		m, err := decodeMetric(data)
+		if err != nil {
+			return err
+		}
+		sm.Metrics = append(sm.Metrics, m)
+		return nil
+	})
+	return sm, err
+}
+
+func decodeMetric(b []byte) (Metric, error) {
+	m := Metric{}
+	err := walkMessage(b, func(num protowire.Number, typ protowire.Type, _ uint64, data []byte) error {
+		if typ != protowire.BytesType {
+			return nil
+		}
+		var err error
+		switch num {
+		case 1:
+			m.Name = string(data)
+		case 5:
+			m.Gauge = &Gauge{}
+			m.Gauge.DataPoints, _, _, err = decodeNumberPoints(data)
+		case 7:
+			m.Sum = &Sum{}
+			m.Sum.DataPoints, m.Sum.Temporality, m.Sum.IsMonotonic, err = decodeNumberPoints(data)
+		case 9:
+			m.Histogram, err = decodeHistogram(data)
+		}
+		return err
+	})
+	return m, err
+}
+
+// decodeNumberPoints decodes Gauge and Sum messages, they share data points field number.
+func decodeNumberPoints(b []byte) ([]NumberDataPoint, AggregationTemporality, bool, error) {
+	var points []NumberDataPoint
+	var temporality AggregationTemporality
+	var monotonic bool
+	err := walkMessage(b, func(num protowire.Number, typ protowire.Type, value uint64, data []byte) error {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			p, err := decodeNumberDataPoint(data)
+			if err != nil {
+				return err
+			}
+			points = append(points, p)
+		case num == 2 && typ == protowire.VarintType:
+			temporality = AggregationTemporality(value)
+		case num == 3 && typ == protowire.VarintType:
+			monotonic = protowire.DecodeBool(value)
+		}
+		return nil
+	})
+	return points, temporality, monotonic, err
+}
+
+func decodeNumberDataPoint(b []byte) (NumberDataPoint, error) {
+	p := NumberDataPoint{}
+	err := walkMessage(b, func(num protowire.Number, typ protowire.Type, value uint64, data []byte) error {
+		switch num {
+		case 2:
+			p.StartTimeUnixNano = value
+		case 3:
+			p.TimeUnixNano = value
+		case 4:
+			p.AsDouble = math.Float64frombits(value)
+			p.IsInt = false
+		case 6:
+			p.AsInt = int64(value)
+			p.IsInt = true
+		case 7:
+			if typ != protowire.BytesType {
+				return nil
+			}
+			kv, err := decodeKeyValue(data)
+			if err != nil {
+				return err
+			}
+			p.Attributes = append(p.Attributes, kv)
+		case 8:
+			p.Flags = uint32(value)
+		}
+		return nil
+	})
+	return p, err
+}
+
+func decodeHistogram(b []byte) (*Histogram, error) {
+	h := Histogram{}
+	err := walkMessage(b, func(num protowire.Number, typ protowire.Type, value uint64, data []byte) error {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			p, err := decodeHistogramDataPoint(data)
+			if err != nil {
+				return err
+			}
+			h.DataPoints = append(h.DataPoints, p)
+		case num == 2 && typ == protowire.VarintType:
+			h.Temporality = AggregationTemporality(value)
+		}
+		return nil
+	})
+	return &h, err
+}
+
+func decodePackedFixed64(b []byte) ([]uint64, error) {
+	res := make([]uint64, 0, len(b)/8)
+	for len(b) > 0 {
+		v, n := protowire.ConsumeFixed64(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		res = append(res, v)
+		b = b[n:]
+	}
+	return res, nil
+}
+
+func decodeHistogramDataPoint(b []byte) (HistogramDataPoint, error) {
+	p := HistogramDataPoint{}
+	err := walkMessage(b, func(num protowire.Number, typ protowire.Type, value uint64, data []byte) error {
+		switch num {
+		case 2:
+			p.StartTimeUnixNano = value
+		case 3:
+			p.TimeUnixNano = value
+		case 4:
+			p.Count = value
+		case 5:
+			p.Sum = math.Float64frombits(value)
+			p.HasSum = true
+		case 6:
+			if typ != protowire.BytesType {
+				p.BucketCounts = append(p.BucketCounts, value)
+				return nil
+			}
+			counts, err := decodePackedFixed64(data)
+			if err != nil {
+				return err
+			}
+			p.BucketCounts = append(p.BucketCounts, counts...)
+		case 7:
+			if typ != protowire.BytesType {
+				p.ExplicitBounds = append(p.ExplicitBounds, math.Float64frombits(value))
+				return nil
+			}
+			bounds, err := decodePackedFixed64(data)
+			if err != nil {
+				return err
+			}
+			for _, v := range bounds {
+				p.ExplicitBounds = append(p.ExplicitBounds, math.Float64frombits(v))
+			}
+		case 9:
+			if typ != protowire.BytesType {
+				return nil
+			}
+			kv, err := decodeKeyValue(data)
+			if err != nil {
+				return err
+			}
+			p.Attributes = append(p.Attributes, kv)
+		case 10:
+			p.Flags = uint32(value)
+		}
+		return nil
+	})
+	return p, err
+}
+
+func decodeKeyValue(b []byte) (KeyValue, error) {
+	kv := KeyValue{}
+	err := walkMessage(b, func(num protowire.Number, typ protowire.Type, _ uint64, data []byte) error {
+		if typ != protowire.BytesType {
+			return nil
+		}
+		switch num {
+		case 1:
+			kv.Key = string(data)
+		case 2:
+			val, err := decodeAnyValue(data)
+			if err != nil {
+				return err
+			}
+			kv.Value = val
+		}
+		return nil
+	})
+	return kv, err
+}
+
+// decodeAnyValue decodes scalar AnyValue variants into string.
+// Array and key-value list values are not supported and are decoded as empty strings.
+func decodeAnyValue(b []byte) (string, error) {
+	var res string
+	err := walkMessage(b, func(num protowire.Number, _ protowire.Type, value uint64, data []byte) error {
+		switch num {
+		case 1, 7:
+			res = string(data)
+		case 2:
+			res = strconv.FormatBool(protowire.DecodeBool(value))
+		case 3:
+			res = strconv.FormatInt(int64(value), 10)
+		case 4:
+			res = strconv.FormatFloat(math.Float64frombits(value), 'f', -1, 64)
+		}
+		return nil
+	})
+	return res, err
+}
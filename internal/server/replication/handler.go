@@ -0,0 +1,119 @@
+package replication
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	logging "github.com/fuzzy-toozy/metrics-service/internal/log"
+	"github.com/fuzzy-toozy/metrics-service/internal/server/config"
+	"github.com/fuzzy-toozy/metrics-service/internal/server/errtypes"
+)
+
+func respJSON(w http.ResponseWriter, data any, log logging.Logger) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(data); err != nil {
+		log.Errorf("Failed to write response JSON body: %v", err)
+	}
+}
+
+func (r *Replicator) handleLog(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	from, err := strconv.ParseUint(query.Get("from"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid from parameter", http.StatusBadRequest)
+		return
+	}
+
+	limit := defaultReadLimit
+	if l := query.Get("limit"); len(l) > 0 {
+		limit, err = strconv.Atoi(l)
+		if err != nil {
+			http.Error(w, "invalid limit parameter", http.StatusBadRequest)
+			return
+		}
+	}
+
+	resp, err := r.ReadLog(req.Context(), query.Get("epoch"), from, limit)
+	if errors.Is(err, errResync) {
+		w.WriteHeader(http.StatusGone)
+		return
+	}
+
+	if err != nil {
+		r.logger.Debugf("Failed to read replication log: %v", err)
+		w.WriteHeader(errtypes.ErrorToStatus(err))
+		return
+	}
+
+	respJSON(w, resp, r.logger)
+}
+
+func (r *Replicator) handleSnapshot(w http.ResponseWriter, req *http.Request) {
+	if r.Role() != config.ReplicationRolePrimary {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	snapshot, err := r.Snapshot()
+	if err != nil {
+		r.logger.Errorf("Failed to make replication snapshot: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	respJSON(w, snapshot, r.logger)
+}
+
+func (r *Replicator) handlePromote(w http.ResponseWriter, req *http.Request) {
+	if err := r.Promote(); err != nil {
+		r.logger.Debugf("Failed to promote replica: %v", err)
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	respJSON(w, r.GetStatus(), r.logger)
+}
+
+// authorized checks replication secret sent as bearer token.
+func (r *Replicator) authorized(req *http.Request) bool {
+	token, ok := strings.CutPrefix(req.Header.Get("Authorization"), bearerPrefix)
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(r.secret)) == 1
+}
+
+// WithReplicationRoutes returns handler serving replication endpoints
+// under /replication/ and passing other requests to h.
+// Replication endpoints respond with 401 unless request carries
+// "Authorization: Bearer <replication secret>" header.
+//
+// GET /replication/log?epoch=<epoch>&from=<seq>[&limit=<n>] returns log entries
+// starting from seq, responds with 410 if replica has to resync from snapshot.
+// GET /replication/snapshot returns full repository state and its log position.
+// GET /replication/status returns replication role, epoch and position.
+// POST /replication/promote promotes replica to primary.
+func WithReplicationRoutes(h http.Handler, r *Replicator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		path := strings.TrimRight(req.URL.Path, "/")
+		if strings.HasPrefix(path, "/replication/") && !r.authorized(req) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch {
+		case path == "/replication/log" && req.Method == http.MethodGet:
+			r.handleLog(w, req)
+		case path == "/replication/snapshot" && req.Method == http.MethodGet:
+			r.handleSnapshot(w, req)
+		case path == "/replication/status" && req.Method == http.MethodGet:
+			respJSON(w, r.GetStatus(), r.logger)
+		case path == "/replication/promote" && req.Method == http.MethodPost:
+			r.handlePromote(w, req)
+		default:
+			h.ServeHTTP(w, req)
+		}
+	})
+}
@@ -0,0 +1,107 @@
+package replication
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/fuzzy-toozy/metrics-service/internal/metrics"
+)
+
+// Entry single replicated update. Metrics hold values as they were received
+// by primary, so counters hold increments.
+type Entry struct {
+	Metrics []metrics.Metric `json:"metrics"`
+	Seq     uint64           `json:"seq"`
+}
+
+// Log bounded in-memory log of applied updates ordered by sequence numbers.
+// Sequence numbers are contiguous, the oldest entries are evicted on overflow.
+type Log struct {
+	// notify closed and replaced on each append to wake up waiting readers.
+	notify  chan struct{}
+	entries []Entry
+	next    uint64
+	size    int
+	lock    sync.Mutex
+}
+
+// NewLog creates log retaining size entries with the first entry numbered first.
+func NewLog(size int, first uint64) *Log {
+	return &Log{size: size, next: first, notify: make(chan struct{})}
+}
+
+// Append adds new entry with next sequence number. Must be called
+// in the same order updates are applied to repository.
+func (l *Log) Append(data []metrics.Metric) uint64 {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	seq := l.next
+	l.next++
+	l.entries = append(l.entries, Entry{Seq: seq, Metrics: data})
+	if len(l.entries) > l.size {
+		l.entries = l.entries[len(l.entries)-l.size:]
+	}
+
+	close(l.notify)
+	l.notify = make(chan struct{})
+
+	return seq
+}
+
+// LastSeq returns sequence number of the last appended entry.
+func (l *Log) LastSeq() uint64 {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.next - 1
+}
+
+// Read returns up to limit entries starting from sequence number from.
+// Returns false if entry from is already evicted or was never appended,
+// in which case reader has to resync from snapshot.
+func (l *Log) Read(from uint64, limit int) ([]Entry, bool, <-chan struct{}) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if from > l.next {
+		return nil, false, l.notify
+	}
+
+	if from == l.next {
+		return nil, true, l.notify
+	}
+
+	if len(l.entries) == 0 || from < l.entries[0].Seq {
+		return nil, false, l.notify
+	}
+
+	start := int(from - l.entries[0].Seq)
+	end := len(l.entries)
+	if limit > 0 && end-start > limit {
+		end = start + limit
+	}
+
+	res := make([]Entry, end-start)
+	copy(res, l.entries[start:end])
+
+	return res, true, l.notify
+}
+
+// Wait reads entries starting from sequence number from, waiting up to timeout
+// for new entries if there are none yet.
+func (l *Log) Wait(ctx context.Context, from uint64, limit int, timeout time.Duration) ([]Entry, bool) {
+	entries, ok, notify := l.Read(from, limit)
+	if !ok || len(entries) > 0 {
+		return entries, ok
+	}
+
+	select {
+	case <-notify:
+	case <-time.After(timeout):
+	case <-ctx.Done():
+	}
+
+	entries, ok, _ = l.Read(from, limit)
+	return entries, ok
+}
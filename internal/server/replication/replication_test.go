@@ -0,0 +1,192 @@
+package replication
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fuzzy-toozy/metrics-service/internal/log"
+	"github.com/fuzzy-toozy/metrics-service/internal/metrics"
+	"github.com/fuzzy-toozy/metrics-service/internal/server/config"
+	"github.com/fuzzy-toozy/metrics-service/internal/server/errtypes"
+	"github.com/fuzzy-toozy/metrics-service/internal/server/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Log(t *testing.T) {
+	l := NewLog(2, 1)
+	for i := int64(0); i < 3; i++ {
+		l.Append([]metrics.Metric{metrics.NewCounterMetric("c", i)})
+	}
+	require.Equal(t, uint64(3), l.LastSeq())
+
+	_, ok, _ := l.Read(1, 0)
+	require.False(t, ok)
+
+	entries, ok, _ := l.Read(2, 0)
+	require.True(t, ok)
+	require.Len(t, entries, 2)
+	require.Equal(t, uint64(2), entries[0].Seq)
+
+	entries, ok, _ = l.Read(4, 0)
+	require.True(t, ok)
+	require.Empty(t, entries)
+
+	_, ok, _ = l.Read(5, 0)
+	require.False(t, ok)
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		l.Append([]metrics.Metric{metrics.NewCounterMetric("c", 1)})
+	}()
+	entries, ok = l.Wait(context.Background(), 4, 0, 5*time.Second)
+	require.True(t, ok)
+	require.Len(t, entries, 1)
+}
+
+const testSecret = "secret"
+
+func getCounter(t *testing.T, r storage.Repository, id string) int64 {
+	m, err := r.Get(id, metrics.CounterMetricType)
+	require.NoError(t, err)
+	return *m.Delta
+}
+
+func Test_Replication(t *testing.T) {
+	logger := log.NewDummyLogger()
+	primaryBase := storage.NewCommonMetricsRepository()
+	primary := NewReplicator(config.ReplicationConfig{Role: config.ReplicationRolePrimary, Secret: testSecret, LogSize: 100}, primaryBase, logger)
+	primary.pollTimeout = 50 * time.Millisecond
+	primaryRepo := NewReplicatedRepository(primaryBase, primary)
+
+	srv := httptest.NewServer(WithReplicationRoutes(http.NotFoundHandler(), primary))
+	defer srv.Close()
+
+	_, err := primaryRepo.AddOrUpdate("c", "5", metrics.CounterMetricType)
+	require.NoError(t, err)
+
+	replicaBase := storage.NewCommonMetricsRepository()
+	_, err = replicaBase.AddOrUpdate("stale", "1", metrics.GaugeMetricType)
+	require.NoError(t, err)
+
+	replica := NewReplicator(config.ReplicationConfig{Role: config.ReplicationRoleReplica,
+		PrimaryURL: srv.URL, Secret: testSecret, LogSize: 100}, replicaBase, logger)
+	replicaRepo := NewReplicatedRepository(replicaBase, replica)
+
+	// Replication endpoints require secret.
+	for _, secret := range []string{"", "wrong"} {
+		req, err := http.NewRequest(http.MethodPost, srv.URL+"/replication/promote", nil)
+		require.NoError(t, err)
+		if len(secret) > 0 {
+			req.Header.Set("Authorization", "Bearer "+secret)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		require.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	}
+
+	wrongSecret := NewReplicator(config.ReplicationConfig{Role: config.ReplicationRoleReplica,
+		PrimaryURL: srv.URL, Secret: "wrong", LogSize: 100}, storage.NewCommonMetricsRepository(), logger)
+	require.Error(t, wrongSecret.syncOnce())
+
+	// Snapshot sync replaces replica state.
+	require.NoError(t, replica.syncOnce())
+	require.Equal(t, int64(5), getCounter(t, replicaRepo, "c"))
+	_, err = replicaRepo.Get("stale", metrics.GaugeMetricType)
+	require.Error(t, err)
+
+	require.NoError(t, primaryRepo.AddMetricsBulk([]metrics.Metric{
+		metrics.NewCounterMetric("c", 2),
+		metrics.NewGaugeMetric("g", 1.5),
+	}))
+
+	// Repeated reads resume from the last applied entry and don't double count.
+	require.NoError(t, replica.syncOnce())
+	require.NoError(t, replica.syncOnce())
+	require.Equal(t, int64(7), getCounter(t, replicaRepo, "c"))
+	require.Equal(t, getCounter(t, primaryRepo, "c"), getCounter(t, replicaRepo, "c"))
+
+	// Replica rejects updates.
+	_, err = replicaRepo.AddOrUpdate("c", "1", metrics.CounterMetricType)
+	require.Error(t, err)
+	require.Equal(t, http.StatusForbidden, errtypes.ErrorToStatus(err))
+
+	replica.Run()
+	_, err = primaryRepo.AddOrUpdate("c", "3", metrics.CounterMetricType)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return replica.GetStatus().Seq == primary.GetStatus().Seq
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, int64(10), getCounter(t, replicaRepo, "c"))
+
+	require.NoError(t, replica.Promote())
+	require.Error(t, replica.Promote())
+	require.Equal(t, config.ReplicationRolePrimary, replica.Role())
+
+	_, err = replicaRepo.AddOrUpdate("c", "1", metrics.CounterMetricType)
+	require.NoError(t, err)
+	require.Equal(t, int64(11), getCounter(t, replicaRepo, "c"))
+	require.Equal(t, primary.GetStatus().Seq+1, replica.GetStatus().Seq)
+}
+
+func Test_ReplicationResyncAfterEviction(t *testing.T) {
+	logger := log.NewDummyLogger()
+	primaryBase := storage.NewCommonMetricsRepository()
+	primary := NewReplicator(config.ReplicationConfig{Role: config.ReplicationRolePrimary, Secret: testSecret, LogSize: 1}, primaryBase, logger)
+	primary.pollTimeout = 10 * time.Millisecond
+	primaryRepo := NewReplicatedRepository(primaryBase, primary)
+
+	srv := httptest.NewServer(WithReplicationRoutes(http.NotFoundHandler(), primary))
+	defer srv.Close()
+
+	replicaBase := storage.NewCommonMetricsRepository()
+	replica := NewReplicator(config.ReplicationConfig{Role: config.ReplicationRoleReplica,
+		PrimaryURL: srv.URL, Secret: testSecret, LogSize: 1}, replicaBase, logger)
+	require.NoError(t, replica.syncOnce())
+
+	for i := 0; i < 3; i++ {
+		_, err := primaryRepo.AddOrUpdate("c", "1", metrics.CounterMetricType)
+		require.NoError(t, err)
+	}
+
+	require.ErrorIs(t, replica.syncOnce(), errResync)
+	require.NoError(t, replica.syncOnce())
+	require.Equal(t, int64(3), getCounter(t, replicaBase, "c"))
+	require.NoError(t, replica.syncOnce())
+	require.Equal(t, int64(3), getCounter(t, replicaBase, "c"))
+}
+
+func Test_ReplicationDelete(t *testing.T) {
+	logger := log.NewDummyLogger()
+	primaryBase := storage.NewCommonMetricsRepository()
+	primary := NewReplicator(config.ReplicationConfig{Role: config.ReplicationRolePrimary, Secret: testSecret, LogSize: 100}, primaryBase, logger)
+	primary.pollTimeout = 10 * time.Millisecond
+	primaryRepo := NewReplicatedRepository(primaryBase, primary)
+
+	srv := httptest.NewServer(WithReplicationRoutes(http.NotFoundHandler(), primary))
+	defer srv.Close()
+
+	replicaBase := storage.NewCommonMetricsRepository()
+	replica := NewReplicator(config.ReplicationConfig{Role: config.ReplicationRoleReplica,
+		PrimaryURL: srv.URL, Secret: testSecret, LogSize: 100}, replicaBase, logger)
+	replicaRepo := NewReplicatedRepository(replicaBase, replica)
+
+	_, err := primaryRepo.AddOrUpdate("c", "5", metrics.CounterMetricType)
+	require.NoError(t, err)
+	require.NoError(t, replica.syncOnce())
+	require.Equal(t, int64(5), getCounter(t, replicaRepo, "c"))
+
+	// Replica rejects deletions.
+	err = replicaRepo.Delete("c")
+	require.Equal(t, http.StatusForbidden, errtypes.ErrorToStatus(err))
+
+	// Deletion on primary makes replica resync from snapshot.
+	require.NoError(t, primaryRepo.Delete("c"))
+	require.ErrorIs(t, replica.syncOnce(), errResync)
+	require.NoError(t, replica.syncOnce())
+	_, err = replicaRepo.Get("c", metrics.CounterMetricType)
+	require.Error(t, err)
+}
@@ -0,0 +1,389 @@
+// Package replication Primary/replica replication of metrics repository.
+// Primary keeps ordered log of applied updates numbered by sequence numbers
+// and serves it to replicas over HTTP. Replicas apply log entries in order
+// and track the last applied sequence number, so after reconnect they resume
+// from the next entry and counter increments are never applied twice.
+// If required entries are already evicted from primary log or primary was
+// restarted (log epoch changed) replica resyncs from full snapshot.
+package replication
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/beevik/guid"
+	logging "github.com/fuzzy-toozy/metrics-service/internal/log"
+	"github.com/fuzzy-toozy/metrics-service/internal/metrics"
+	"github.com/fuzzy-toozy/metrics-service/internal/server/config"
+	"github.com/fuzzy-toozy/metrics-service/internal/server/errtypes"
+	"github.com/fuzzy-toozy/metrics-service/internal/server/storage"
+)
+
+// LogResponse response of primary log endpoint.
+type LogResponse struct {
+	Epoch   string  `json:"epoch"`
+	Entries []Entry `json:"entries"`
+}
+
+// Snapshot full repository state at log position Seq.
+type Snapshot struct {
+	Epoch   string           `json:"epoch"`
+	Metrics []metrics.Metric `json:"metrics"`
+	Seq     uint64           `json:"seq"`
+}
+
+// Status replication status of server.
+type Status struct {
+	Role  string `json:"role"`
+	Epoch string `json:"epoch"`
+	Seq   uint64 `json:"seq"`
+}
+
+var errResync = errors.New("replica has to resync from snapshot")
+
+const (
+	defaultPollTimeout   = 10 * time.Second
+	defaultRetryInterval = 2 * time.Second
+	defaultReadLimit     = 1000
+	bearerPrefix         = "Bearer "
+)
+
+type Replicator struct {
+	repo          storage.Repository
+	log           *Log
+	logger        logging.Logger
+	client        *http.Client
+	stopCtx       context.Context
+	stop          context.CancelFunc
+	role          string
+	epoch         string
+	primaryURL    string
+	secret        string
+	followEpoch   string
+	lastSeq       uint64
+	logSize       int
+	pollTimeout   time.Duration
+	retryInterval time.Duration
+	wg            sync.WaitGroup
+	// lock guards role, epoch and log.
+	lock sync.RWMutex
+	// writeLock serializes repository updates with log appends and snapshots.
+	writeLock sync.Mutex
+}
+
+// NewReplicator creates replicator for repository repo, which must be the
+// repository updates are finally applied to.
+func NewReplicator(cfg config.ReplicationConfig, repo storage.Repository, logger logging.Logger) *Replicator {
+	r := Replicator{
+		repo:          repo,
+		logger:        logger,
+		role:          cfg.Role,
+		epoch:         guid.NewString(),
+		primaryURL:    strings.TrimRight(cfg.PrimaryURL, "/"),
+		secret:        cfg.Secret,
+		logSize:       cfg.LogSize,
+		pollTimeout:   defaultPollTimeout,
+		retryInterval: defaultRetryInterval,
+	}
+	r.stopCtx, r.stop = context.WithCancel(context.Background())
+	r.log = NewLog(cfg.LogSize, 1)
+	r.client = &http.Client{Timeout: 3 * r.pollTimeout}
+	return &r
+}
+
+// Role returns current replication role.
+func (r *Replicator) Role() string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.role
+}
+
+// GetStatus returns current replication status.
+func (r *Replicator) GetStatus() Status {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	s := Status{Role: r.role, Epoch: r.epoch}
+	if r.role == config.ReplicationRolePrimary {
+		s.Seq = r.log.LastSeq()
+	} else {
+		r.writeLock.Lock()
+		s.Seq = r.lastSeq
+		r.writeLock.Unlock()
+	}
+	return s
+}
+
+// Apply applies update with apply callback and appends received metrics to the log.
+// Fails with read only error if server is not primary.
+func (r *Replicator) Apply(received []metrics.Metric, apply func() error) error {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	if r.role != config.ReplicationRolePrimary {
+		return errtypes.MakeReadOnlyError(errors.New("updates are not accepted by replica"))
+	}
+
+	r.writeLock.Lock()
+	defer r.writeLock.Unlock()
+
+	if err := apply(); err != nil {
+		return err
+	}
+
+	r.log.Append(received)
+
+	return nil
+}
+
+// Restore applies change of repository state which isn't logged, e.g. bulk restore or deletion,
+// with apply callback. New log epoch is started, so replicas resync from snapshot.
+func (r *Replicator) Restore(apply func() error) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.role != config.ReplicationRolePrimary {
+		return errtypes.MakeReadOnlyError(errors.New("restore is not accepted by replica"))
+	}
+
+	r.writeLock.Lock()
+	defer r.writeLock.Unlock()
+
+	if err := apply(); err != nil {
+		return err
+	}
+
+	r.epoch = guid.NewString()
+	r.log = NewLog(r.logSize, r.log.LastSeq()+1)
+
+	return nil
+}
+
+// Snapshot returns current repository state and matching log position.
+func (r *Replicator) Snapshot() (Snapshot, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	r.writeLock.Lock()
+	defer r.writeLock.Unlock()
+
+	data, err := r.repo.GetAll()
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	return Snapshot{Epoch: r.epoch, Seq: r.log.LastSeq(), Metrics: data}, nil
+}
+
+// ReadLog reads primary log entries for replica, waiting for new entries up to poll timeout.
+// Returns errResync if replica has to resync from snapshot.
+func (r *Replicator) ReadLog(ctx context.Context, epoch string, from uint64, limit int) (LogResponse, error) {
+	r.lock.RLock()
+	log := r.log
+	currentEpoch := r.epoch
+	role := r.role
+	r.lock.RUnlock()
+
+	if role != config.ReplicationRolePrimary {
+		return LogResponse{}, errtypes.MakeReadOnlyError(errors.New("replica doesn't serve replication log"))
+	}
+
+	if epoch != currentEpoch {
+		return LogResponse{}, errResync
+	}
+
+	entries, ok := log.Wait(ctx, from, limit, r.pollTimeout)
+	if !ok {
+		return LogResponse{}, errResync
+	}
+
+	return LogResponse{Epoch: currentEpoch, Entries: entries}, nil
+}
+
+// Promote turns replica into primary. Replication from former primary is stopped
+// and new log epoch is started, so other replicas resync if they switch to this server.
+func (r *Replicator) Promote() error {
+	r.lock.Lock()
+	if r.role != config.ReplicationRoleReplica {
+		r.lock.Unlock()
+		return errors.New("only replica can be promoted")
+	}
+	r.role = config.ReplicationRolePrimary
+	r.lock.Unlock()
+
+	r.stop()
+	r.wg.Wait()
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.writeLock.Lock()
+	defer r.writeLock.Unlock()
+
+	r.epoch = guid.NewString()
+	r.log = NewLog(r.logSize, r.lastSeq+1)
+
+	r.logger.Infof("Replica promoted to primary at seq %v", r.lastSeq)
+
+	return nil
+}
+
+// Run starts following primary if server is replica.
+func (r *Replicator) Run() {
+	if r.Role() != config.ReplicationRoleReplica {
+		return
+	}
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		for {
+			if r.stopCtx.Err() != nil {
+				return
+			}
+
+			err := r.syncOnce()
+			if err == nil {
+				continue
+			}
+
+			if r.stopCtx.Err() != nil {
+				return
+			}
+
+			if !errors.Is(err, errResync) {
+				r.logger.Errorf("Replication from %v failed: %v", r.primaryURL, err)
+			}
+
+			select {
+			case <-r.stopCtx.Done():
+				return
+			case <-time.After(r.retryInterval):
+			}
+		}
+	}()
+}
+
+// Stop stops following primary.
+func (r *Replicator) Stop() {
+	r.stop()
+	r.wg.Wait()
+}
+
+func (r *Replicator) get(path string, query url.Values, out any) error {
+	u := r.primaryURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(r.stopCtx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", bearerPrefix+r.secret)
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode == http.StatusGone {
+		return errResync
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("primary responded with status %v", resp.StatusCode)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// syncOnce performs single replication step: snapshot sync if replica
+// doesn't follow any epoch yet or log read otherwise.
+func (r *Replicator) syncOnce() error {
+	if len(r.followEpoch) == 0 {
+		return r.syncSnapshot()
+	}
+
+	query := url.Values{}
+	query.Set("epoch", r.followEpoch)
+	query.Set("from", strconv.FormatUint(r.lastSeq+1, 10))
+	query.Set("limit", strconv.Itoa(defaultReadLimit))
+
+	var resp LogResponse
+	if err := r.get("/replication/log", query, &resp); err != nil {
+		if errors.Is(err, errResync) {
+			r.followEpoch = ""
+		}
+		return err
+	}
+
+	if err := r.applyEntries(resp.Entries); err != nil {
+		r.followEpoch = ""
+		return err
+	}
+
+	return nil
+}
+
+func (r *Replicator) applyEntries(entries []Entry) error {
+	r.writeLock.Lock()
+	defer r.writeLock.Unlock()
+
+	for _, e := range entries {
+		if e.Seq <= r.lastSeq {
+			continue
+		}
+
+		if e.Seq != r.lastSeq+1 {
+			return fmt.Errorf("%w: gap in replication log at seq %v", errResync, r.lastSeq+1)
+		}
+
+		data := make([]metrics.Metric, len(e.Metrics))
+		copy(data, e.Metrics)
+		if err := r.repo.AddMetricsBulk(data); err != nil {
+			return fmt.Errorf("failed to apply entry %v: %w", e.Seq, err)
+		}
+
+		r.lastSeq = e.Seq
+	}
+
+	return nil
+}
+
+func (r *Replicator) syncSnapshot() error {
+	var snapshot Snapshot
+	if err := r.get("/replication/snapshot", nil, &snapshot); err != nil {
+		return fmt.Errorf("failed to get snapshot: %w", err)
+	}
+
+	replacer, ok := r.repo.(storage.MetricsReplacer)
+	if !ok {
+		return errors.New("repository doesn't support replacing metrics from snapshot")
+	}
+
+	r.writeLock.Lock()
+	defer r.writeLock.Unlock()
+
+	// Snapshot is swapped in atomically, so readers never see partially synced state.
+	if err := replacer.ReplaceMetrics(snapshot.Metrics); err != nil {
+		return fmt.Errorf("failed to apply snapshot: %w", err)
+	}
+
+	r.lastSeq = snapshot.Seq
+	r.followEpoch = snapshot.Epoch
+
+	r.logger.Infof("Replica synced from snapshot at seq %v", snapshot.Seq)
+
+	return nil
+}
@@ -0,0 +1,63 @@
+package replication
+
+import (
+	"io"
+
+	"github.com/fuzzy-toozy/metrics-service/internal/metrics"
+	"github.com/fuzzy-toozy/metrics-service/internal/server/errtypes"
+	"github.com/fuzzy-toozy/metrics-service/internal/server/storage"
+)
+
+// ReplicatedRepository repository proxy which appends every applied update
+// to replication log on primary and rejects updates on replica.
+type ReplicatedRepository struct {
+	storage.Repository
+	replicator *Replicator
+}
+
+func NewReplicatedRepository(r storage.Repository, replicator *Replicator) *ReplicatedRepository {
+	return &ReplicatedRepository{Repository: r, replicator: replicator}
+}
+
+func (r *ReplicatedRepository) AddOrUpdate(key string, val string, mtype string) (string, error) {
+	received, err := metrics.NewMetric(key, val, mtype)
+	if err != nil {
+		return "", errtypes.MakeBadDataError(err)
+	}
+
+	var res string
+	err = r.replicator.Apply([]metrics.Metric{received}, func() error {
+		var err error
+		res, err = r.Repository.AddOrUpdate(key, val, mtype)
+		return err
+	})
+
+	return res, err
+}
+
+func (r *ReplicatedRepository) AddMetricsBulk(metricsData []metrics.Metric) error {
+	received := make([]metrics.Metric, len(metricsData))
+	copy(received, metricsData)
+
+	return r.replicator.Apply(received, func() error {
+		return r.Repository.AddMetricsBulk(metricsData)
+	})
+}
+
+// ForRequest returns proxy of repository recording key with its updates.
+func (r *ReplicatedRepository) ForRequest(key storage.RequestKey) storage.Repository {
+	return &ReplicatedRepository{Repository: storage.ForRequest(r.Repository, key), replicator: r.replicator}
+}
+
+func (r *ReplicatedRepository) Load(reader io.Reader) error {
+	return r.replicator.Restore(func() error {
+		return r.Repository.Load(reader)
+	})
+}
+
+// Delete deletes metric on primary. Deletions aren't logged, so replicas resync from snapshot.
+func (r *ReplicatedRepository) Delete(key string) error {
+	return r.replicator.Restore(func() error {
+		return r.Repository.Delete(key)
+	})
+}
@@ -12,7 +12,9 @@ import (
 
 	logging "github.com/fuzzy-toozy/metrics-service/internal/log"
 	"github.com/fuzzy-toozy/metrics-service/internal/server/config"
+	"github.com/fuzzy-toozy/metrics-service/internal/server/forwarder"
 	"github.com/fuzzy-toozy/metrics-service/internal/server/handlers"
+	"github.com/fuzzy-toozy/metrics-service/internal/server/replication"
 	"github.com/fuzzy-toozy/metrics-service/internal/server/storage"
 	"golang.org/x/sync/errgroup"
 )
@@ -22,6 +24,8 @@ type Server struct {
 	asyncStorageSaver *storage.PeriodicSaver
 	storageSaver      storage.StorageSaver
 	metricsStorage    storage.Repository
+	forwarder         *forwarder.Forwarder
+	replicator        *replication.Replicator
 	config            *config.Config
 	logger            logging.Logger
 	stopCtx           context.Context
@@ -47,34 +51,97 @@ func NewServer(logger logging.Logger) (*Server, error) {
 		if err != nil {
 			return nil, fmt.Errorf("failed to create metrics storage: %w", err)
 		}
+	} else if len(config.BoltDBPath) > 0 {
+		s.metricsStorage, err = storage.NewBoltMetricRepository(config.BoltDBPath, s.logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create metrics storage: %w", err)
+		}
+	} else if config.StoreShards > 0 {
+		s.metricsStorage = storage.NewShardedMetricsRepository(config.StoreShards)
 	} else {
 		s.metricsStorage = storage.NewCommonMetricsRepository()
 	}
+	baseStorage := s.metricsStorage
+	// Database backed storages persist every update by themselves.
+	persistentStorage := config.DatabaseConfig.UseDatabase || len(config.BoltDBPath) > 0
+
+	var walRepo *storage.WALRepository
+	if len(config.WALFilePath) > 0 && !persistentStorage {
+		wal, err := storage.NewWriteAheadLog(config.WALFilePath, config.WALSync, config.WALSyncInterval.D, logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open write-ahead log: %w", err)
+		}
+		walRepo = storage.NewWALRepository(s.metricsStorage, wal)
+		s.metricsStorage = walRepo
+	}
+
+	if len(config.ReplicationConfig.Role) > 0 {
+		s.replicator = replication.NewReplicator(config.ReplicationConfig, s.metricsStorage, s.logger)
+		s.metricsStorage = replication.NewReplicatedRepository(s.metricsStorage, s.replicator)
+	}
+
+	if len(config.ForwarderConfig.URL) > 0 {
+		sink, err := forwarder.NewSink(config.ForwarderConfig, config.SecretKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create forwarder sink: %w", err)
+		}
+
+		s.forwarder, err = forwarder.NewForwarder(config.ForwarderConfig, sink,
+			forwarder.NewDefaultRetryExecutor(s.stopCtx), s.metricsStorage, s.logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create forwarder: %w", err)
+		}
+
+		s.metricsStorage = forwarder.NewForwardingRepository(s.metricsStorage, s.forwarder)
+	}
 
 	registryHandler, err := handlers.NewDefaultMetricRegistryHandler(logger, s.metricsStorage, s.storageSaver, config.DatabaseConfig)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create server: %w", err)
 	}
 
-	if config.RestoreData && !config.DatabaseConfig.UseDatabase {
-		const perms = 0444
-		f, err := os.OpenFile(config.StoreFilePath, os.O_RDONLY, perms)
-		if err == nil {
-			err = s.metricsStorage.Load(f)
+	if !persistentStorage {
+		// Decorators hide snapshot specific methods, so snapshots are taken from the innermost repository.
+		snapshotSource := baseStorage
+		if walRepo != nil {
+			snapshotSource = walRepo
 		}
-		if err != nil {
-			logger.Errorf("Failed to restore data from persistent storage(%v): %v", config.StoreFilePath, err)
-		} else {
-			logger.Infof("Successfully loaded data from persistent storage %v", config.StoreFilePath)
+		fileSaver := storage.NewFileSaver(snapshotSource, config.StoreFilePath, storage.SnapshotOptions{
+			Format:      config.StoreFormat,
+			Compression: config.StoreCompression,
+			Keep:        config.StoreKeep,
+		}, logger)
+
+		if config.RestoreData {
+			pos, err := fileSaver.Load()
+			if err != nil {
+				logger.Errorf("Failed to restore data from persistent storage(%v): %v", config.StoreFilePath, err)
+			} else {
+				logger.Infof("Successfully loaded data from persistent storage %v", config.StoreFilePath)
+			}
+
+			if walRepo != nil {
+				records, err := walRepo.Replay(pos)
+				if err != nil {
+					return nil, fmt.Errorf("failed to replay write-ahead log: %w", err)
+				}
+				logger.Infof("Replayed %v write-ahead log records", records)
+			}
+		} else if walRepo != nil {
+			if err := walRepo.Reset(); err != nil {
+				return nil, fmt.Errorf("failed to reset write-ahead log: %w", err)
+			}
 		}
-	}
 
-	if len(config.StoreFilePath) > 0 && !config.DatabaseConfig.UseDatabase {
-		fileSaver := storage.NewFileSaver(s.metricsStorage, config.StoreFilePath, logger)
 		if config.StoreInterval.D > 0 {
 			s.asyncStorageSaver = storage.NewPeriodicSaver(config.StoreInterval.D, logger, fileSaver)
 			s.asyncStorageSaver.Run()
 			logger.Infof("Async persistent storage saver is started")
+		} else if walRepo != nil {
+			// Updates are persisted by write-ahead log, snapshots only bound its size.
+			s.asyncStorageSaver = storage.NewPeriodicSaver(config.WALSnapshotInterval.D, logger, fileSaver)
+			s.asyncStorageSaver.Run()
+			logger.Infof("Updates are persisted to write-ahead log, snapshot interval: %v", config.WALSnapshotInterval.D)
 		} else {
 			s.storageSaver = fileSaver
 			logger.Infof("Persistent storage will be updated synchronously")
@@ -83,6 +150,22 @@ func NewServer(logger logging.Logger) (*Server, error) {
 
 	serverHandler := handlers.SetupRouting(registryHandler)
 
+	// Requests of agents are remembered in database if it's shared by several servers.
+	var idempotencyStore storage.IdempotencyStore
+	if pgStorage, ok := baseStorage.(*storage.PGMetricRepository); ok {
+		idempotencyStore = pgStorage.IdempotencyStore(config.IdempotencyTTL.D)
+	} else {
+		idempotencyStore = storage.NewMemoryIdempotencyStore(config.IdempotencyTTL.D, storage.DefaultIdempotencyKeysPerAgent)
+	}
+	serverHandler = handlers.WithIdempotency(serverHandler, idempotencyStore, logger)
+
+	// Admin endpoints export and replace all data, they are disabled unless secret is configured.
+	serverHandler = handlers.WithAdminRoutes(serverHandler, registryHandler, config.AdminSecret)
+
+	if s.replicator != nil {
+		serverHandler = replication.WithReplicationRoutes(serverHandler, s.replicator)
+	}
+
 	if s.config.SecretKey != nil {
 		serverHandler = handlers.WithSignatureCheck(serverHandler, logger, config.SecretKey)
 	}
@@ -108,12 +191,30 @@ func (s *Server) Run() error {
 		return s.httpServer.ListenAndServe()
 	}
 
+	if s.forwarder != nil {
+		s.forwarder.Run()
+		s.logger.Infof("Metrics forwarder is started")
+	}
+
+	if s.replicator != nil {
+		s.replicator.Run()
+		s.logger.Infof("Replication is started with role %v", s.replicator.Role())
+	}
+
 	stop := func() error {
 		err := s.httpServer.Shutdown(context.Background())
 		if err != nil {
 			s.logger.Errorf("server shutdown failed: %w", err)
 		}
 
+		if s.replicator != nil {
+			s.replicator.Stop()
+		}
+
+		if s.forwarder != nil {
+			s.forwarder.Stop()
+		}
+
 		if s.asyncStorageSaver != nil {
 			s.asyncStorageSaver.Stop()
 		}
@@ -6,7 +6,9 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/fuzzy-toozy/metrics-service/internal/common"
 	"github.com/fuzzy-toozy/metrics-service/internal/log"
 	"github.com/fuzzy-toozy/metrics-service/internal/metrics"
 	"github.com/fuzzy-toozy/metrics-service/internal/server/config"
@@ -79,8 +81,11 @@ func TestMetrics(t *testing.T) {
 			args:     args{w: httptest.NewRecorder(), r: httptest.NewRequest(http.MethodPost, "/update/gauge/name/10.11", nil)},
 			wantCode: http.StatusOK},
 		{name: "Valid counter",
-			args:     args{w: httptest.NewRecorder(), r: httptest.NewRequest(http.MethodPost, "/update/counter/name/10", nil)},
+			args:     args{w: httptest.NewRecorder(), r: httptest.NewRequest(http.MethodPost, "/update/counter/cname/10", nil)},
 			wantCode: http.StatusOK},
+		{name: "Counter with gauge name",
+			args:     args{w: httptest.NewRecorder(), r: httptest.NewRequest(http.MethodPost, "/update/counter/name/10", nil)},
+			wantCode: http.StatusBadRequest},
 		{name: "Set counter metric",
 			args:     args{w: httptest.NewRecorder(), r: httptest.NewRequest(http.MethodPost, "/update/counter/one/999", nil)},
 			wantCode: http.StatusOK},
@@ -138,3 +143,243 @@ func TestMetrics(t *testing.T) {
 		})
 	}
 }
+
+func TestOTLPMetrics(t *testing.T) {
+	registry := storage.NewCommonMetricsRepository()
+	h := handlers.NewMetricRegistryHandler(registry, log.NewDummyLogger(),
+		handlers.MetricURLInfo{Type: "mtype", Name: "mname", Value: "mval"}, nil, config.DBConfig{})
+	r := handlers.SetupRouting(h)
+
+	body := `{"resourceMetrics":[{"scopeMetrics":[{"metrics":[` +
+		`{"name":"requests","sum":{"aggregationTemporality":2,"isMonotonic":true,"dataPoints":[{"asInt":"7"}]}}]}]}]}`
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/v1/metrics", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+		require.Equal(t, http.StatusOK, resp.Code)
+		require.Equal(t, "{}", resp.Body.String())
+	}
+
+	m, err := registry.Get("requests", metrics.CounterMetricType)
+	require.NoError(t, err)
+	require.NotNil(t, m.Delta)
+	require.Equal(t, int64(7), *m.Delta)
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/metrics", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "text/plain")
+	resp := httptest.NewRecorder()
+	r.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusUnsupportedMediaType, resp.Code)
+}
+
+func TestExportImport(t *testing.T) {
+	const secret = "admin"
+	newRouter := func(registry storage.Repository) http.Handler {
+		h := handlers.NewMetricRegistryHandler(registry, log.NewDummyLogger(),
+			handlers.MetricURLInfo{Type: "mtype", Name: "mname", Value: "mval"}, nil, config.DBConfig{})
+		return handlers.WithAdminRoutes(handlers.SetupRouting(h), h, secret)
+	}
+	authorize := func(req *http.Request) *http.Request {
+		req.Header.Set("Authorization", "Bearer "+secret)
+		return req
+	}
+
+	source := storage.NewCommonMetricsRepository()
+	require.NoError(t, source.AddMetricsBulk([]metrics.Metric{
+		metrics.NewCounterMetric("c", 5),
+		metrics.NewGaugeMetric("g", 1.5),
+	}))
+
+	resp := httptest.NewRecorder()
+	newRouter(source).ServeHTTP(resp, authorize(httptest.NewRequest(http.MethodGet, "/admin/export", nil)))
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.Equal(t, "application/json", resp.Header().Get("Content-Type"))
+
+	target := storage.NewCommonMetricsRepository()
+	_, err := target.AddOrUpdate("c", "100", metrics.CounterMetricType)
+	require.NoError(t, err)
+
+	targetRouter := newRouter(target)
+	req := authorize(httptest.NewRequest(http.MethodPost, "/admin/import", bytes.NewReader(resp.Body.Bytes())))
+	req.Header.Set("Content-Type", "application/json")
+	resp = httptest.NewRecorder()
+	targetRouter.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	expected, err := source.GetAll()
+	require.NoError(t, err)
+	imported, err := target.GetAll()
+	require.NoError(t, err)
+	require.ElementsMatch(t, expected, imported)
+
+	req = authorize(httptest.NewRequest(http.MethodPost, "/admin/import", bytes.NewBufferString("{")))
+	req.Header.Set("Content-Type", "application/json")
+	resp = httptest.NewRecorder()
+	targetRouter.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+
+	// Admin endpoints require secret.
+	for _, token := range []string{"", "Bearer wrong", secret} {
+		req = httptest.NewRequest(http.MethodGet, "/admin/export", nil)
+		req.Header.Set("Authorization", token)
+		resp = httptest.NewRecorder()
+		targetRouter.ServeHTTP(resp, req)
+		require.Equal(t, http.StatusForbidden, resp.Code, token)
+	}
+
+	// Admin endpoints aren't served without configured secret.
+	h := handlers.NewMetricRegistryHandler(target, log.NewDummyLogger(),
+		handlers.MetricURLInfo{Type: "mtype", Name: "mname", Value: "mval"}, nil, config.DBConfig{})
+	resp = httptest.NewRecorder()
+	handlers.WithAdminRoutes(handlers.SetupRouting(h), h, "").ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/admin/export", nil))
+	require.Equal(t, http.StatusNotFound, resp.Code)
+}
+
+func TestBulkUpdatePartial(t *testing.T) {
+	registry := storage.NewCommonMetricsRepository()
+	h := handlers.NewMetricRegistryHandler(registry, log.NewDummyLogger(),
+		handlers.MetricURLInfo{Type: "mtype", Name: "mname", Value: "mval"}, nil, config.DBConfig{})
+	r := handlers.SetupRouting(h)
+
+	body := `[{"id":"c","type":"counter","delta":5},{"id":"bad","type":"counter"},` +
+		`{"id":"g","type":"gauge","value":1.5},{"id":"x","type":"garbage","value":1}]`
+
+	send := func(uri string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, uri, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+		return resp
+	}
+
+	// Batch with invalid metrics is rejected as a whole.
+	resp := send("/updates")
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+	all, err := registry.GetAll()
+	require.NoError(t, err)
+	require.Empty(t, all)
+
+	resp = send("/updates?partial=true")
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	report := handlers.BulkUpdateReport{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&report))
+	require.ElementsMatch(t, []metrics.Metric{
+		metrics.NewCounterMetric("c", 5),
+		metrics.NewGaugeMetric("g", 1.5),
+	}, report.Metrics)
+	require.Len(t, report.Errors, 2)
+	require.Equal(t, 1, report.Errors[0].Index)
+	require.Equal(t, "bad", report.Errors[0].ID)
+	require.NotEmpty(t, report.Errors[0].Error)
+	require.Equal(t, 3, report.Errors[1].Index)
+	require.Equal(t, "x", report.Errors[1].ID)
+
+	all, err = registry.GetAll()
+	require.NoError(t, err)
+	require.Len(t, all, 2)
+
+	body = `[{"id":"bad","type":"counter"}]`
+	resp = send("/updates?partial=true")
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&report))
+	require.Empty(t, report.Metrics)
+	require.Len(t, report.Errors, 1)
+}
+
+func TestIdempotentUpdates(t *testing.T) {
+	registry := storage.NewCommonMetricsRepository()
+	h := handlers.NewMetricRegistryHandler(registry, log.NewDummyLogger(),
+		handlers.MetricURLInfo{Type: "mtype", Name: "mname", Value: "mval"}, nil, config.DBConfig{})
+	store := storage.NewMemoryIdempotencyStore(time.Minute, storage.DefaultIdempotencyKeysPerAgent)
+	r := handlers.WithIdempotency(handlers.SetupRouting(h), store, log.NewDummyLogger())
+
+	send := func(requestID string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/updates", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(common.AgentIDHeader, "agent")
+		if len(requestID) > 0 {
+			req.Header.Set(common.RequestIDHeader, requestID)
+		}
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+		return resp
+	}
+
+	body := `[{"id":"c","type":"counter","delta":5}]`
+
+	first := send("1", body)
+	require.Equal(t, http.StatusOK, first.Code)
+	require.Empty(t, first.Header().Get(handlers.IdempotentReplayHeader))
+
+	// Retry isn't applied and gets the same response.
+	retry := send("1", body)
+	require.Equal(t, http.StatusOK, retry.Code)
+	require.Equal(t, "true", retry.Header().Get(handlers.IdempotentReplayHeader))
+	require.Equal(t, first.Body.String(), retry.Body.String())
+
+	m, err := registry.Get("c", metrics.CounterMetricType)
+	require.NoError(t, err)
+	require.Equal(t, int64(5), *m.Delta)
+
+	// Failed request can be retried with the same ID.
+	resp := send("2", `[{"id":"c","type":"counter"}]`)
+	require.Equal(t, http.StatusBadRequest, resp.Code)
+	resp = send("2", body)
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.Empty(t, resp.Header().Get(handlers.IdempotentReplayHeader))
+
+	// Requests without ID are always applied.
+	require.Equal(t, http.StatusOK, send("", body).Code)
+	require.Equal(t, http.StatusOK, send("", body).Code)
+
+	m, err = registry.Get("c", metrics.CounterMetricType)
+	require.NoError(t, err)
+	require.Equal(t, int64(20), *m.Delta)
+}
+
+// recordingRepository remembers keys of requests its updates are applied for.
+type recordingRepository struct {
+	storage.Repository
+	keys *[]storage.RequestKey
+}
+
+func (r recordingRepository) ForRequest(key storage.RequestKey) storage.Repository {
+	*r.keys = append(*r.keys, key)
+	return r.Repository
+}
+
+func TestIdempotentUpdatesRecorded(t *testing.T) {
+	var keys []storage.RequestKey
+	registry := recordingRepository{Repository: storage.NewCommonMetricsRepository(), keys: &keys}
+	h := handlers.NewMetricRegistryHandler(registry, log.NewDummyLogger(),
+		handlers.MetricURLInfo{Type: "mtype", Name: "mname", Value: "mval"}, nil, config.DBConfig{})
+	store := storage.NewMemoryIdempotencyStore(time.Minute, storage.DefaultIdempotencyKeysPerAgent)
+	r := handlers.WithIdempotency(handlers.SetupRouting(h), store, log.NewDummyLogger())
+
+	send := func(uri string, body string, requestID string) {
+		req := httptest.NewRequest(http.MethodPost, uri, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(common.AgentIDHeader, "agent")
+		if len(requestID) > 0 {
+			req.Header.Set(common.RequestIDHeader, requestID)
+		}
+		resp := httptest.NewRecorder()
+		r.ServeHTTP(resp, req)
+		require.Equal(t, http.StatusOK, resp.Code)
+	}
+
+	// Updates of requests with ID are applied by repository recording their key.
+	send("/updates", `[{"id":"c","type":"counter","delta":5}]`, "1")
+	send("/updates?partial=true", `[{"id":"c","type":"counter","delta":5}]`, "2")
+	send("/update", `{"id":"c","type":"counter","delta":5}`, "3")
+	send("/updates", `[{"id":"c","type":"counter","delta":5}]`, "")
+
+	require.Equal(t, []storage.RequestKey{
+		{AgentID: "agent", RequestID: "1"},
+		{AgentID: "agent", RequestID: "2"},
+		{AgentID: "agent", RequestID: "3"},
+	}, keys)
+}
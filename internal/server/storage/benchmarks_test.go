@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/fuzzy-toozy/metrics-service/internal/log"
+	"github.com/fuzzy-toozy/metrics-service/internal/metrics"
+	"github.com/fuzzy-toozy/metrics-service/internal/server/config"
+)
+
+const benchmarkSnapshotMetrics = 100000
+
+func makeSnapshotBenchRepo() *CommonMetricsRepository {
+	repo := NewCommonMetricsRepository()
+	data := make([]metrics.Metric, 0, benchmarkSnapshotMetrics)
+	for i := 0; i < benchmarkSnapshotMetrics; i++ {
+		if i%2 == 0 {
+			data = append(data, metrics.NewCounterMetric(fmt.Sprintf("counter_%v", i), int64(i)))
+		} else {
+			data = append(data, metrics.NewGaugeMetric(fmt.Sprintf("gauge_%v", i), float64(i)/3))
+		}
+	}
+	if err := repo.RestoreMetrics(data); err != nil {
+		panic(err)
+	}
+	return repo
+}
+
+var benchmarkSnapshotOptions = []SnapshotOptions{
+	{Format: config.StoreFormatJSON},
+	{Format: config.StoreFormatBinary},
+	{Format: config.StoreFormatBinary, Compression: "gzip"},
+}
+
+func BenchmarkSnapshotSave(b *testing.B) {
+	repo := makeSnapshotBenchRepo()
+	for _, opts := range benchmarkSnapshotOptions {
+		b.Run(opts.Format+opts.Compression, func(b *testing.B) {
+			fs := NewFileSaver(repo, filepath.Join(b.TempDir(), "snapshot"), opts, log.NewDummyLogger())
+
+			var err error
+			for i := 0; i < b.N; i++ {
+				err = fs.Save()
+			}
+			runtime.KeepAlive(err)
+		})
+	}
+}
+
+func BenchmarkSnapshotLoad(b *testing.B) {
+	repo := makeSnapshotBenchRepo()
+	for _, opts := range benchmarkSnapshotOptions {
+		b.Run(opts.Format+opts.Compression, func(b *testing.B) {
+			fileName := filepath.Join(b.TempDir(), "snapshot")
+			if err := NewFileSaver(repo, fileName, opts, log.NewDummyLogger()).Save(); err != nil {
+				b.Fatal(err)
+			}
+
+			b.ResetTimer()
+			var err error
+			for i := 0; i < b.N; i++ {
+				_, err = NewFileSaver(NewCommonMetricsRepository(), fileName, opts, log.NewDummyLogger()).Load()
+			}
+			runtime.KeepAlive(err)
+		})
+	}
+}
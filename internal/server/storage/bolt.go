@@ -0,0 +1,342 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	logging "github.com/fuzzy-toozy/metrics-service/internal/log"
+	"github.com/fuzzy-toozy/metrics-service/internal/metrics"
+	"github.com/fuzzy-toozy/metrics-service/internal/server/errtypes"
+	bolt "go.etcd.io/bbolt"
+)
+
+var boltMetricsBucket = []byte("metrics")
+
+const (
+	boltOpenTimeout  = time.Second
+	boltRestoreBatch = 1000
+)
+
+// BoltMetricRepository repository stored in embedded bbolt database file.
+// Metrics are stored as JSON values keyed by metric ID, every update is a durable transaction.
+type BoltMetricRepository struct {
+	db  *bolt.DB
+	log logging.Logger
+}
+
+// NewBoltMetricRepository opens or creates bbolt database at path.
+func NewBoltMetricRepository(path string, log logging.Logger) (*BoltMetricRepository, error) {
+	const perms = 0600
+	db, err := bolt.Open(path, perms, &bolt.Options{Timeout: boltOpenTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bolt database: %w", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltMetricsBucket)
+		return err
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to create metrics bucket: %w", err)
+	}
+
+	return &BoltMetricRepository{db: db, log: log}, nil
+}
+
+func boltGet(b *bolt.Bucket, key string) (metrics.Metric, bool, error) {
+	data := b.Get([]byte(key))
+	if data == nil {
+		return metrics.Metric{}, false, nil
+	}
+
+	var m metrics.Metric
+	if err := json.Unmarshal(data, &m); err != nil {
+		return metrics.Metric{}, false, errtypes.MakeServerError(fmt.Errorf("failed to decode stored metric '%v': %w", key, err))
+	}
+
+	return m, true, nil
+}
+
+func boltPut(b *bolt.Bucket, m metrics.Metric) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return errtypes.MakeServerError(err)
+	}
+	return b.Put([]byte(m.ID), data)
+}
+
+// boltUpdate applies update to stored metric the same way CommonMetricsRepository does.
+func boltUpdate(b *bolt.Bucket, key string, val string, mtype string) (string, error) {
+	if !metrics.IsValidMetricType(mtype) {
+		return "", errtypes.MakeBadDataError(fmt.Errorf("invalid metric type %v", mtype))
+	}
+
+	m, ok, err := boltGet(b, key)
+	if err != nil {
+		return "", err
+	}
+
+	if ok && m.MType != mtype {
+		return "", typeMismatchError(key, mtype)
+	}
+
+	if !ok {
+		m, err = metrics.NewMetric(key, val, mtype)
+	} else {
+		err = m.UpdateData(val)
+	}
+	if err != nil {
+		return "", errtypes.MakeBadDataError(err)
+	}
+
+	if err = boltPut(b, m); err != nil {
+		return "", err
+	}
+
+	return m.GetData()
+}
+
+func (r *BoltMetricRepository) AddOrUpdate(key string, val string, mtype string) (string, error) {
+	var res string
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		res, err = boltUpdate(tx.Bucket(boltMetricsBucket), key, val, mtype)
+		return err
+	})
+	return res, err
+}
+
+// AddMetricsBulk applies all updates in single transaction, so either all of them are stored or none.
+// In case any metric is invalid BulkUpdateError lists rejected metrics.
+func (r *BoltMetricRepository) AddMetricsBulk(metricsData []metrics.Metric) error {
+	var values []string
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltMetricsBucket)
+		var staged map[string]metrics.Metric
+		var err error
+		var stageErr error
+		staged, values, err = stageMetricsBulk(metricsData, func(key string) (metrics.Metric, bool) {
+			m, ok, err := boltGet(b, key)
+			if err != nil && stageErr == nil {
+				stageErr = err
+			}
+			return m, ok
+		})
+		if stageErr != nil {
+			return stageErr
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, m := range staged {
+			if err = boltPut(b, m); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return setBulkValues(metricsData, values)
+}
+
+func (r *BoltMetricRepository) Delete(key string) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltMetricsBucket).Delete([]byte(key))
+	})
+}
+
+func (r *BoltMetricRepository) Get(key string, mtype string) (metrics.Metric, error) {
+	if !metrics.IsValidMetricType(mtype) {
+		return metrics.Metric{}, errtypes.MakeBadDataError(fmt.Errorf("invalid metric type '%v'", mtype))
+	}
+
+	var res metrics.Metric
+	err := r.db.View(func(tx *bolt.Tx) error {
+		m, ok, err := boltGet(tx.Bucket(boltMetricsBucket), key)
+		if err != nil {
+			return err
+		}
+
+		if !ok || m.MType != mtype {
+			return errtypes.MakeNotFoundError(fmt.Errorf("metric '%v' not found", key))
+		}
+
+		res = m
+		return nil
+	})
+
+	return res, err
+}
+
+func (r *BoltMetricRepository) GetAll() ([]metrics.Metric, error) {
+	res := make([]metrics.Metric, 0)
+	err := r.SnapshotMetrics(func(m metrics.Metric) error {
+		res = append(res, m)
+		return nil
+	})
+
+	return res, err
+}
+
+// SnapshotMetrics passes metrics of consistent snapshot to fn.
+func (r *BoltMetricRepository) SnapshotMetrics(fn func(m metrics.Metric) error) error {
+	return r.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltMetricsBucket).ForEach(func(k, v []byte) error {
+			var m metrics.Metric
+			if err := json.Unmarshal(v, &m); err != nil {
+				return errtypes.MakeServerError(fmt.Errorf("failed to decode stored metric '%s': %w", k, err))
+			}
+			return fn(m)
+		})
+	})
+}
+
+func (r *BoltMetricRepository) MarshalJSON() ([]byte, error) {
+	b := bytes.Buffer{}
+	if err := r.Save(&b); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func (r *BoltMetricRepository) UnmarshalJSON(data []byte) error {
+	return r.Load(bytes.NewReader(data))
+}
+
+func (r *BoltMetricRepository) HealthCheck() error {
+	return r.db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(boltMetricsBucket) == nil {
+			return errors.New("metrics bucket is missing")
+		}
+		return nil
+	})
+}
+
+// Save streams consistent snapshot of all metrics to w in the same JSON format as CommonMetricsRepository.
+func (r *BoltMetricRepository) Save(w io.Writer) error {
+	return r.db.View(func(tx *bolt.Tx) error {
+		bw := bufio.NewWriter(w)
+		if err := bw.WriteByte('['); err != nil {
+			return err
+		}
+
+		first := true
+		err := tx.Bucket(boltMetricsBucket).ForEach(func(k, v []byte) error {
+			if !first {
+				if err := bw.WriteByte(','); err != nil {
+					return err
+				}
+			}
+			first = false
+
+			// Values are stored JSON encoded already.
+			_, err := bw.Write(v)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		if _, err = bw.WriteString("]\n"); err != nil {
+			return err
+		}
+
+		return bw.Flush()
+	})
+}
+
+// Load streams metrics in the same JSON format as CommonMetricsRepository from reader
+// and stores them in batches, replacing existing values.
+func (r *BoltMetricRepository) Load(reader io.Reader) error {
+	dec := json.NewDecoder(reader)
+	tok, err := dec.Token()
+	if err != nil {
+		return errtypes.MakeBadDataError(fmt.Errorf("failed to read metrics array: %w", err))
+	}
+
+	if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+		return errtypes.MakeBadDataError(errors.New("metrics array expected"))
+	}
+
+	batch := make([]metrics.Metric, 0, boltRestoreBatch)
+	for dec.More() {
+		var m metrics.Metric
+		if err = dec.Decode(&m); err != nil {
+			return errtypes.MakeBadDataError(fmt.Errorf("failed to decode metric: %w", err))
+		}
+
+		batch = append(batch, m)
+		if len(batch) == boltRestoreBatch {
+			if err = r.RestoreMetrics(batch); err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+	}
+
+	if _, err = dec.Token(); err != nil {
+		return errtypes.MakeBadDataError(fmt.Errorf("failed to read metrics array: %w", err))
+	}
+
+	return r.RestoreMetrics(batch)
+}
+
+// RestoreMetrics stores metrics replacing existing ones with the same IDs.
+func (r *BoltMetricRepository) RestoreMetrics(data []metrics.Metric) error {
+	for _, m := range data {
+		if _, err := m.GetData(); err != nil {
+			return errtypes.MakeBadDataError(err)
+		}
+	}
+
+	return r.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltMetricsBucket)
+		for _, m := range data {
+			if err := boltPut(b, m); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ReplaceMetrics replaces all stored metrics with data in single transaction.
+func (r *BoltMetricRepository) ReplaceMetrics(data []metrics.Metric) error {
+	for _, m := range data {
+		if _, err := m.GetData(); err != nil {
+			return errtypes.MakeBadDataError(err)
+		}
+	}
+
+	return r.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket(boltMetricsBucket); err != nil {
+			return err
+		}
+
+		b, err := tx.CreateBucket(boltMetricsBucket)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range data {
+			if err = boltPut(b, m); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r *BoltMetricRepository) Close() error {
+	return r.db.Close()
+}
@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/fuzzy-toozy/metrics-service/internal/log"
+	"github.com/fuzzy-toozy/metrics-service/internal/metrics"
+	"github.com/fuzzy-toozy/metrics-service/internal/server/errtypes"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestBoltRepository(t *testing.T, path string) *BoltMetricRepository {
+	repo, err := NewBoltMetricRepository(path, log.NewDummyLogger())
+	require.NoError(t, err)
+	return repo
+}
+
+func Test_BoltMetricsRepo(t *testing.T) {
+	repo := newTestBoltRepository(t, filepath.Join(t.TempDir(), "metrics.db"))
+	defer func() {
+		require.NoError(t, repo.Close())
+	}()
+
+	require.NoError(t, repo.HealthCheck())
+	testMetricsRepo(t, repo)
+}
+
+func Test_BoltPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.db")
+
+	repo := newTestBoltRepository(t, path)
+	_, err := repo.AddOrUpdate("c", "5", metrics.CounterMetricType)
+	require.NoError(t, err)
+
+	bulk := []metrics.Metric{
+		metrics.NewCounterMetric("c", 2),
+		metrics.NewGaugeMetric("g", 1.5),
+	}
+	require.NoError(t, repo.AddMetricsBulk(bulk))
+	require.Equal(t, int64(7), *bulk[0].Delta)
+	require.NoError(t, repo.Close())
+
+	repo = newTestBoltRepository(t, path)
+	defer func() {
+		require.NoError(t, repo.Close())
+	}()
+	require.Equal(t, int64(7), getCounterValue(t, repo, "c"))
+
+	// Failed bulk update leaves storage untouched.
+	err = repo.AddMetricsBulk([]metrics.Metric{
+		metrics.NewCounterMetric("c", 1),
+		{ID: "bad", MType: "garbage"},
+	})
+	require.Error(t, err)
+	require.ErrorAs(t, err, &errtypes.BadDataError{})
+	require.Equal(t, int64(7), getCounterValue(t, repo, "c"))
+
+	_, err = repo.Get("c", metrics.GaugeMetricType)
+	require.ErrorAs(t, err, &errtypes.NotFoundError{})
+}
+
+func Test_BoltSaveLoad(t *testing.T) {
+	dir := t.TempDir()
+
+	repo := newTestBoltRepository(t, filepath.Join(dir, "src.db"))
+	defer func() {
+		require.NoError(t, repo.Close())
+	}()
+
+	expected := []metrics.Metric{
+		metrics.NewCounterMetric("c1", 10),
+		metrics.NewCounterMetric("c2", 20),
+		metrics.NewGaugeMetric("g1", 0.25),
+	}
+	require.NoError(t, repo.AddMetricsBulk(append([]metrics.Metric(nil), expected...)))
+
+	b := bytes.Buffer{}
+	require.NoError(t, repo.Save(&b))
+
+	common := NewCommonMetricsRepository()
+	require.NoError(t, common.Load(bytes.NewReader(b.Bytes())))
+	all, err := common.GetAll()
+	require.NoError(t, err)
+	require.ElementsMatch(t, expected, all)
+
+	restored := newTestBoltRepository(t, filepath.Join(dir, "dst.db"))
+	defer func() {
+		require.NoError(t, restored.Close())
+	}()
+
+	_, err = restored.AddOrUpdate("c1", "100", metrics.CounterMetricType)
+	require.NoError(t, err)
+	require.NoError(t, restored.Load(bytes.NewReader(b.Bytes())))
+
+	all, err = restored.GetAll()
+	require.NoError(t, err)
+	require.ElementsMatch(t, expected, all)
+
+	require.Error(t, restored.Load(bytes.NewReader([]byte(`{"id":"c1"}`))))
+}
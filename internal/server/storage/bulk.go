@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"fmt"
+
+	"github.com/fuzzy-toozy/metrics-service/internal/metrics"
+	"github.com/fuzzy-toozy/metrics-service/internal/server/errtypes"
+)
+
+// ItemError error of single metric rejected from bulk update.
+type ItemError struct {
+	Err   error
+	ID    string
+	Index int
+}
+
+func (e ItemError) Error() string {
+	return fmt.Sprintf("metric #%v '%v': %v", e.Index, e.ID, e.Err)
+}
+
+func (e ItemError) Unwrap() error {
+	return e.Err
+}
+
+// BulkUpdateError bulk update rejected because of invalid metrics, none of the batch metrics is stored.
+// Items lists rejected metrics by their index in the batch.
+type BulkUpdateError struct {
+	Items []ItemError
+}
+
+func (e *BulkUpdateError) Error() string {
+	if len(e.Items) == 1 {
+		return e.Items[0].Error()
+	}
+	return fmt.Sprintf("%v metrics rejected, first: %v", len(e.Items), e.Items[0].Error())
+}
+
+// Add records error of metric at index.
+func (e *BulkUpdateError) Add(index int, id string, err error) {
+	e.Items = append(e.Items, ItemError{Index: index, ID: id, Err: err})
+}
+
+// Err returns bad data error wrapping e if any metric was rejected, nil otherwise.
+func (e *BulkUpdateError) Err() error {
+	if len(e.Items) == 0 {
+		return nil
+	}
+	return errtypes.MakeBadDataError(e)
+}
+
+// stageMetricsBulk applies data to copies of stored metrics without touching storage,
+// stored returns current metric by ID. Returns staged metrics and values of data items right after they're applied.
+// All invalid items are reported in returned error.
+func stageMetricsBulk(data []metrics.Metric, stored func(key string) (metrics.Metric, bool)) (map[string]metrics.Metric, []string, error) {
+	staged := make(map[string]metrics.Metric, len(data))
+	values := make([]string, len(data))
+	bulkErr := BulkUpdateError{}
+
+	for i, m := range data {
+		val, err := m.GetData()
+		if err != nil {
+			bulkErr.Add(i, m.ID, err)
+			continue
+		}
+
+		if _, ok := staged[m.ID]; !ok {
+			// Metric values are pointers replaced on update, so stored metric can be copied shallowly.
+			if sm, ok := stored(m.ID); ok {
+				staged[m.ID] = sm
+			}
+		}
+
+		if values[i], err = updateStoredMetric(staged, m.ID, val, m.MType); err != nil {
+			bulkErr.Add(i, m.ID, err)
+		}
+	}
+
+	if err := bulkErr.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return staged, values, nil
+}
+
+// setBulkValues sets values returned by stageMetricsBulk to data items.
+func setBulkValues(data []metrics.Metric, values []string) error {
+	for i := range data {
+		if err := data[i].SetData(values[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,316 @@
+package storage
+
+import (
+	"bytes"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/beevik/guid"
+	"github.com/fuzzy-toozy/metrics-service/internal/log"
+	"github.com/fuzzy-toozy/metrics-service/internal/metrics"
+	"github.com/fuzzy-toozy/metrics-service/internal/server/errtypes"
+	"github.com/stretchr/testify/require"
+)
+
+// testRepositoryConformance checks behaviour every Repository implementation must follow.
+// Metric IDs are unique per run, so repositories sharing storage between runs can be tested too.
+func testRepositoryConformance(t *testing.T, newRepo func(t *testing.T) Repository) {
+	newID := func(name string) string {
+		return name + "_" + guid.NewString()
+	}
+
+	t.Run("CounterAccumulation", func(t *testing.T) {
+		repo := newRepo(t)
+		id := newID("c")
+
+		val, err := repo.AddOrUpdate(id, "10", metrics.CounterMetricType)
+		require.NoError(t, err)
+		require.Equal(t, "10", val)
+
+		val, err = repo.AddOrUpdate(id, "-3", metrics.CounterMetricType)
+		require.NoError(t, err)
+		require.Equal(t, "7", val)
+
+		require.Equal(t, int64(7), getCounterValue(t, repo, id))
+	})
+
+	t.Run("GaugeReplacement", func(t *testing.T) {
+		repo := newRepo(t)
+		id := newID("g")
+
+		val, err := repo.AddOrUpdate(id, "1.5", metrics.GaugeMetricType)
+		require.NoError(t, err)
+		require.Equal(t, "1.5", val)
+
+		val, err = repo.AddOrUpdate(id, "-0.25", metrics.GaugeMetricType)
+		require.NoError(t, err)
+		require.Equal(t, "-0.25", val)
+
+		m, err := repo.Get(id, metrics.GaugeMetricType)
+		require.NoError(t, err)
+		require.NotNil(t, m.Value)
+		require.Equal(t, -0.25, *m.Value)
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		repo := newRepo(t)
+		counter := newID("c")
+		gauge := newID("g")
+
+		_, err := repo.AddOrUpdate(counter, "1", metrics.CounterMetricType)
+		require.NoError(t, err)
+		_, err = repo.AddOrUpdate(gauge, "1", metrics.GaugeMetricType)
+		require.NoError(t, err)
+
+		_, err = repo.AddOrUpdate(newID("x"), "1", "garbage")
+		require.ErrorAs(t, err, &errtypes.BadDataError{})
+		_, err = repo.AddOrUpdate(counter, "1.5", metrics.CounterMetricType)
+		require.ErrorAs(t, err, &errtypes.BadDataError{})
+		_, err = repo.AddOrUpdate(gauge, "inv", metrics.GaugeMetricType)
+		require.ErrorAs(t, err, &errtypes.BadDataError{})
+
+		_, err = repo.Get(counter, "garbage")
+		require.ErrorAs(t, err, &errtypes.BadDataError{})
+		_, err = repo.Get(counter, metrics.GaugeMetricType)
+		require.ErrorAs(t, err, &errtypes.NotFoundError{})
+		_, err = repo.Get(gauge, metrics.CounterMetricType)
+		require.ErrorAs(t, err, &errtypes.NotFoundError{})
+		_, err = repo.Get(newID("missing"), metrics.CounterMetricType)
+		require.ErrorAs(t, err, &errtypes.NotFoundError{})
+
+		// Metric can't change its type.
+		_, err = repo.AddOrUpdate(counter, "2", metrics.GaugeMetricType)
+		require.ErrorAs(t, err, &errtypes.BadDataError{})
+		_, err = repo.AddOrUpdate(gauge, "2", metrics.CounterMetricType)
+		require.ErrorAs(t, err, &errtypes.BadDataError{})
+		err = repo.AddMetricsBulk([]metrics.Metric{
+			metrics.NewCounterMetric(counter, 2),
+			metrics.NewCounterMetric(gauge, 2),
+		})
+		require.ErrorAs(t, err, &errtypes.BadDataError{})
+		var bulkErr *BulkUpdateError
+		require.ErrorAs(t, err, &bulkErr)
+		require.Len(t, bulkErr.Items, 1)
+		require.Equal(t, 1, bulkErr.Items[0].Index)
+
+		mixed := newID("m")
+		err = repo.AddMetricsBulk([]metrics.Metric{
+			metrics.NewGaugeMetric(mixed, 2),
+			metrics.NewCounterMetric(mixed, 2),
+		})
+		require.ErrorAs(t, err, &errtypes.BadDataError{})
+		_, err = repo.Get(mixed, metrics.GaugeMetricType)
+		require.ErrorAs(t, err, &errtypes.NotFoundError{})
+
+		// Failed updates don't change stored values.
+		require.Equal(t, int64(1), getCounterValue(t, repo, counter))
+		m, err := repo.Get(gauge, metrics.GaugeMetricType)
+		require.NoError(t, err)
+		require.Equal(t, 1.0, *m.Value)
+
+		require.NoError(t, repo.Delete(counter))
+		_, err = repo.Get(counter, metrics.CounterMetricType)
+		require.ErrorAs(t, err, &errtypes.NotFoundError{})
+		require.NoError(t, repo.Delete(counter))
+	})
+
+	t.Run("Bulk", func(t *testing.T) {
+		repo := newRepo(t)
+		counter := newID("c")
+		gauge := newID("g")
+
+		_, err := repo.AddOrUpdate(counter, "10", metrics.CounterMetricType)
+		require.NoError(t, err)
+
+		require.NoError(t, repo.AddMetricsBulk(nil))
+
+		// Every item gets value stored right after it's applied.
+		bulk := []metrics.Metric{
+			metrics.NewCounterMetric(counter, 1),
+			metrics.NewGaugeMetric(gauge, 1.5),
+			metrics.NewCounterMetric(counter, 2),
+			metrics.NewGaugeMetric(gauge, 2.5),
+		}
+		require.NoError(t, repo.AddMetricsBulk(bulk))
+		require.Equal(t, int64(11), *bulk[0].Delta)
+		require.Equal(t, 1.5, *bulk[1].Value)
+		require.Equal(t, int64(13), *bulk[2].Delta)
+		require.Equal(t, 2.5, *bulk[3].Value)
+
+		require.Equal(t, int64(13), getCounterValue(t, repo, counter))
+		m, err := repo.Get(gauge, metrics.GaugeMetricType)
+		require.NoError(t, err)
+		require.Equal(t, 2.5, *m.Value)
+
+		all, err := repo.GetAll()
+		require.NoError(t, err)
+		found := 0
+		for _, m := range all {
+			if m.ID == counter || m.ID == gauge {
+				found++
+			}
+		}
+		require.Equal(t, 2, found)
+
+		err = repo.AddMetricsBulk([]metrics.Metric{{ID: newID("bad"), MType: metrics.CounterMetricType}})
+		require.ErrorAs(t, err, &errtypes.BadDataError{})
+		err = repo.AddMetricsBulk([]metrics.Metric{{ID: newID("bad"), MType: "garbage"}})
+		require.ErrorAs(t, err, &errtypes.BadDataError{})
+	})
+
+	t.Run("BulkAtomicity", func(t *testing.T) {
+		repo := newRepo(t)
+		counter := newID("c")
+		gauge := newID("g")
+
+		_, err := repo.AddOrUpdate(counter, "10", metrics.CounterMetricType)
+		require.NoError(t, err)
+
+		bad := newID("bad")
+		err = repo.AddMetricsBulk([]metrics.Metric{
+			metrics.NewCounterMetric(counter, 1),
+			{ID: bad, MType: metrics.CounterMetricType},
+			metrics.NewGaugeMetric(gauge, 1),
+			{ID: bad, MType: "garbage"},
+		})
+		require.ErrorAs(t, err, &errtypes.BadDataError{})
+
+		// All rejected metrics are reported.
+		var bulkErr *BulkUpdateError
+		require.ErrorAs(t, err, &bulkErr)
+		require.Len(t, bulkErr.Items, 2)
+		require.Equal(t, 1, bulkErr.Items[0].Index)
+		require.Equal(t, bad, bulkErr.Items[0].ID)
+		require.Equal(t, 3, bulkErr.Items[1].Index)
+
+		// Nothing is stored in case of error.
+		require.Equal(t, int64(10), getCounterValue(t, repo, counter))
+		_, err = repo.Get(gauge, metrics.GaugeMetricType)
+		require.ErrorAs(t, err, &errtypes.NotFoundError{})
+	})
+
+	t.Run("SaveLoad", func(t *testing.T) {
+		repo := newRepo(t)
+		counter := newID("c")
+		gauge := newID("g")
+
+		require.NoError(t, repo.AddMetricsBulk([]metrics.Metric{
+			metrics.NewCounterMetric(counter, 5),
+			metrics.NewGaugeMetric(gauge, 0.5),
+		}))
+
+		saved := bytes.Buffer{}
+		require.NoError(t, repo.Save(&saved))
+
+		_, err := repo.AddOrUpdate(counter, "100", metrics.CounterMetricType)
+		require.NoError(t, err)
+		_, err = repo.AddOrUpdate(gauge, "100", metrics.GaugeMetricType)
+		require.NoError(t, err)
+
+		// Loaded values replace stored ones.
+		require.NoError(t, repo.Load(&saved))
+		require.Equal(t, int64(5), getCounterValue(t, repo, counter))
+		m, err := repo.Get(gauge, metrics.GaugeMetricType)
+		require.NoError(t, err)
+		require.Equal(t, 0.5, *m.Value)
+
+		err = repo.Load(bytes.NewReader([]byte(`{"id":"x"}`)))
+		require.ErrorAs(t, err, &errtypes.BadDataError{})
+	})
+
+	t.Run("Replace", func(t *testing.T) {
+		repo := newRepo(t)
+		counter := newID("c")
+		stale := newID("g")
+
+		require.NoError(t, repo.AddMetricsBulk([]metrics.Metric{
+			metrics.NewCounterMetric(counter, 5),
+			metrics.NewGaugeMetric(stale, 0.5),
+		}))
+
+		replacer, ok := repo.(MetricsReplacer)
+		require.True(t, ok)
+
+		err := replacer.ReplaceMetrics([]metrics.Metric{{ID: counter, MType: metrics.CounterMetricType}})
+		require.ErrorAs(t, err, &errtypes.BadDataError{})
+		require.Equal(t, int64(5), getCounterValue(t, repo, counter))
+
+		require.NoError(t, replacer.ReplaceMetrics([]metrics.Metric{metrics.NewCounterMetric(counter, 2)}))
+		require.Equal(t, int64(2), getCounterValue(t, repo, counter))
+		_, err = repo.Get(stale, metrics.GaugeMetricType)
+		require.ErrorAs(t, err, &errtypes.NotFoundError{})
+	})
+
+	t.Run("Concurrency", func(t *testing.T) {
+		repo := newRepo(t)
+		counter := newID("c")
+		gauge := newID("g")
+
+		const workers = 8
+		const iterations = 50
+		wg := sync.WaitGroup{}
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < iterations; i++ {
+					_, err := repo.AddOrUpdate(counter, "1", metrics.CounterMetricType)
+					require.NoError(t, err)
+					require.NoError(t, repo.AddMetricsBulk([]metrics.Metric{
+						metrics.NewCounterMetric(counter, 2),
+						metrics.NewCounterMetric(counter, 3),
+					}))
+					_, err = repo.AddOrUpdate(gauge, strconv.Itoa(i), metrics.GaugeMetricType)
+					require.NoError(t, err)
+					_, err = repo.GetAll()
+					require.NoError(t, err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		require.Equal(t, int64(workers*iterations*6), getCounterValue(t, repo, counter))
+		m, err := repo.Get(gauge, metrics.GaugeMetricType)
+		require.NoError(t, err)
+		require.Equal(t, float64(iterations-1), *m.Value)
+	})
+}
+
+func closeOnCleanup(t *testing.T, repo Repository) Repository {
+	t.Cleanup(func() {
+		require.NoError(t, repo.Close())
+	})
+	return repo
+}
+
+func Test_CommonRepositoryConformance(t *testing.T) {
+	testRepositoryConformance(t, func(t *testing.T) Repository {
+		return NewCommonMetricsRepository()
+	})
+}
+
+func Test_WALRepositoryConformance(t *testing.T) {
+	testRepositoryConformance(t, func(t *testing.T) Repository {
+		return closeOnCleanup(t, newTestWALRepository(t, filepath.Join(t.TempDir(), "metrics.wal")))
+	})
+}
+
+func Test_BoltRepositoryConformance(t *testing.T) {
+	testRepositoryConformance(t, func(t *testing.T) Repository {
+		return closeOnCleanup(t, newTestBoltRepository(t, filepath.Join(t.TempDir(), "metrics.db")))
+	})
+}
+
+func Test_PGRepositoryConformance(t *testing.T) {
+	testRepositoryConformance(t, func(t *testing.T) Repository {
+		return closeOnCleanup(t, newTestPGRepository(t, log.NewDummyLogger()))
+	})
+}
+
+func Test_ShardedRepositoryConformance(t *testing.T) {
+	testRepositoryConformance(t, func(t *testing.T) Repository {
+		return NewShardedMetricsRepository(16)
+	})
+}
@@ -2,12 +2,17 @@
 package storage
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/fuzzy-toozy/metrics-service/internal/common"
@@ -28,7 +33,8 @@ const (
 )
 
 type PGQueryConfig struct {
-	deleteTable string
+	incInsert   string
+	incConflict string
 	update      string
 	delete      string
 	getOne      string
@@ -42,7 +48,18 @@ func BuildPGQueryConfig(tableName string) PGQueryConfig {
 		" VALUES ($1, $2, $3, $4)" +
 		" ON CONFLICT (name) DO UPDATE" +
 		" SET value = excluded.value," +
-		" delta = excluded.delta"
+		" delta = excluded.delta," +
+		" type = excluded.type"
+
+	// Counters are incremented by database itself, so concurrent updates are never lost.
+	// Rows stored with other type are left intact and aren't returned.
+	incInsertQuery := "INSERT INTO %s (name, value, delta, type) VALUES "
+	incConflictQuery := " ON CONFLICT (name) DO UPDATE" +
+		" SET delta = CASE WHEN excluded.type = '%[2]s'" +
+		" THEN COALESCE(%[1]s.delta, 0) + excluded.delta ELSE excluded.delta END," +
+		" value = excluded.value" +
+		" WHERE %[1]s.type = excluded.type" +
+		" RETURNING name, delta"
 
 	getOneQuery := "SELECT value, delta FROM %s WHERE name = $1 AND type = $2 LIMIT 1"
 
@@ -52,26 +69,45 @@ func BuildPGQueryConfig(tableName string) PGQueryConfig {
 
 	deleteAllQuery := "DELETE FROM %s"
 
-	deleteTableQuery := "DROP table %s"
-
 	config := PGQueryConfig{
+		incInsert:   fmt.Sprintf(incInsertQuery, tableName),
+		incConflict: fmt.Sprintf(incConflictQuery, tableName, metrics.CounterMetricType),
 		update:      fmt.Sprintf(updateQuery, tableName),
 		getOne:      fmt.Sprintf(getOneQuery, tableName),
 		getAll:      fmt.Sprintf(getAllQuery, tableName),
 		delete:      fmt.Sprintf(deleteQuery, tableName),
-		deleteTable: fmt.Sprintf(deleteTableQuery, tableName),
 		deleteAll:   fmt.Sprintf(deleteAllQuery, tableName),
 	}
 
 	return config
 }
 
+// incrementQuery builds statement upserting rows metrics, counters are incremented.
+// Statement returns names and resulting deltas of updated metrics.
+func (c PGQueryConfig) incrementQuery(rows int) string {
+	const params = 4
+	b := strings.Builder{}
+	b.WriteString(c.incInsert)
+	for i := 0; i < rows; i++ {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		n := i * params
+		fmt.Fprintf(&b, "($%v, $%v, $%v, $%v)", n+1, n+2, n+3, n+4)
+	}
+	b.WriteString(c.incConflict)
+	return b.String()
+}
+
 type PGMetricRepository struct {
 	log           logging.Logger
 	db            *sql.DB
 	retryExecutor common.RetryExecutor
-	queryConfig   PGQueryConfig
-	dbConfig      config.DBConfig
+	migrator      *Migrator
+	// request key recorded with updates, see ForRequest.
+	request     *RequestKey
+	queryConfig PGQueryConfig
+	dbConfig    config.DBConfig
 }
 
 func NewPGMetricRepository(dbConfig config.DBConfig, retryExecutor common.RetryExecutor, log logging.Logger) (*PGMetricRepository, error) {
@@ -80,22 +116,20 @@ func NewPGMetricRepository(dbConfig config.DBConfig, retryExecutor common.RetryE
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
-	createTableQuery := "CREATE TABLE IF NOT EXISTS Metrics(" +
-		" name VARCHAR(250) PRIMARY KEY," +
-		" type VARCHAR(50)," +
-		" value DOUBLE PRECISION," +
-		" delta BIGINT," +
-		" CONSTRAINT EITHER_VALUE check(value IS NOT NULL OR delta IS NOT NULL)" +
-		")"
+	migrator, err := NewMigrator(db, log)
+	if err != nil {
+		return nil, err
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), dbConfig.PingTimeout)
 	defer cancel()
 
-	_, err = db.ExecContext(ctx, createTableQuery)
-	if err != nil {
-		return nil, err
+	if err = migrator.Up(ctx); err != nil {
+		return nil, fmt.Errorf("failed to migrate database schema: %w", err)
 	}
-	return &PGMetricRepository{dbConfig: dbConfig, queryConfig: BuildPGQueryConfig("Metrics"), db: db, retryExecutor: retryExecutor, log: log}, nil
+
+	return &PGMetricRepository{dbConfig: dbConfig, queryConfig: BuildPGQueryConfig("Metrics"), db: db, retryExecutor: retryExecutor,
+		log: log, migrator: migrator}, nil
 }
 
 func (r *PGMetricRepository) Close() error {
@@ -114,48 +148,57 @@ func (r *PGMetricRepository) HealthCheck() error {
 	return r.retryExecutor.RetryOnError(work)
 }
 
-type RowQuery interface {
-	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
-}
-
-func (r *PGMetricRepository) getCounterIncDelta(ctx context.Context, db RowQuery, name string, val string) (string, error) {
-	res := db.QueryRowContext(ctx, r.queryConfig.getOne, name, metrics.CounterMetricType)
-	var deltaVal sql.NullInt64
-	var valValue sql.NullFloat64
-
-	err := r.retryExecutor.RetryOnError(func() error {
-		return res.Scan(&valValue, &deltaVal)
-	})
+// incrementBatchSize max number of rows in single upsert statement.
+const incrementBatchSize = 1000
+
+// increment upserts metrics in single transaction, counters are incremented atomically.
+// Returns resulting deltas of counters by name.
+// Metrics stored with other type are rejected and nothing is updated.
+func (r *PGMetricRepository) increment(metricsData []metrics.Metric) (map[string]int64, error) {
+	// Statement can't update the same row twice, so counter increments are merged.
+	// Rows are sorted to lock them in the same order in concurrent transactions.
+	merged := make(map[string]metrics.Metric, len(metricsData))
+	bulkErr := BulkUpdateError{}
+	for i, m := range metricsData {
+		if _, err := m.GetData(); err != nil {
+			bulkErr.Add(i, m.ID, err)
+			continue
+		}
 
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return val, nil
+		prev, ok := merged[m.ID]
+		if ok && prev.MType != m.MType {
+			bulkErr.Add(i, m.ID, typeMismatchError(m.ID, m.MType))
+			continue
 		}
-		return "", err
+		if ok && m.MType == metrics.CounterMetricType {
+			m = metrics.NewCounterMetric(m.ID, *prev.Delta+*m.Delta)
+		}
+		merged[m.ID] = m
 	}
 
-	delta, err := strconv.ParseInt(val, 10, 64)
-
-	if err != nil {
-		return "", err
+	if err := bulkErr.Err(); err != nil {
+		return nil, err
 	}
 
-	delta += deltaVal.Int64
-
-	return strconv.FormatInt(delta, 10), nil
-}
+	rows := make([]metrics.Metric, 0, len(merged))
+	for _, m := range merged {
+		rows = append(rows, m)
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].ID < rows[j].ID })
 
-func (r *PGMetricRepository) AddMetricsBulk(metricsData []metrics.Metric) error {
+	var deltas map[string]int64
 	work := func() error {
-		tx, err := r.db.Begin()
+		deltas = make(map[string]int64, len(rows))
+		updated := make(map[string]struct{}, len(rows))
 
+		tx, err := r.db.Begin()
 		if err != nil {
 			return errtypes.MakeServerError(fmt.Errorf("failed to begin transaction: %w", err))
 		}
 
 		defer func() {
 			errd := tx.Rollback()
-			if errd != nil {
+			if errd != nil && !errors.Is(errd, sql.ErrTxDone) {
 				r.log.Errorf("Failed to rollback tx: %v", errd)
 			}
 		}()
@@ -163,98 +206,128 @@ func (r *PGMetricRepository) AddMetricsBulk(metricsData []metrics.Metric) error
 		ctx, cancel := context.WithTimeout(context.Background(), r.dbConfig.PingTimeout)
 		defer cancel()
 
-		stmt, err := tx.PrepareContext(ctx, r.queryConfig.update)
-
-		if err != nil {
-			return errtypes.MakeServerError(fmt.Errorf("failed to prepare query %v: %w", r.queryConfig.update, err))
-		}
-
-		defer func() {
-			errs := stmt.Close()
-			if errs != nil {
-				r.log.Errorf("Failed to close prepared statement: %v", errs)
+		if r.request != nil {
+			if err = r.claimRequest(ctx, tx); err != nil {
+				return err
 			}
-		}()
+		}
 
-		for i := range metricsData {
-			m := &metricsData[i]
-			var val string
-			val, err = m.GetData()
-			if err != nil {
-				return errtypes.MakeBadDataError(fmt.Errorf("invalid data for metric '%v': %w", m.ID, err))
+		for start := 0; start < len(rows); start += incrementBatchSize {
+			end := start + incrementBatchSize
+			if end > len(rows) {
+				end = len(rows)
 			}
 
-			if m.MType == metrics.CounterMetricType {
-				var updateVal string
-				updateVal, err = r.getCounterIncDelta(ctx, tx, m.ID, val)
-				if err != nil {
-					return errtypes.MakeServerError(err)
-				}
-				err = m.SetData(updateVal)
-				if err != nil {
-					return errtypes.MakeServerError(err)
-				}
+			args := make([]any, 0, 4*(end-start))
+			for _, m := range rows[start:end] {
+				args = append(args, m.ID, m.Value, m.Delta, m.MType)
 			}
 
-			_, err = stmt.ExecContext(ctx, m.ID, m.Value, m.Delta, m.MType)
-
-			if err != nil {
-				return errtypes.MakeServerError(fmt.Errorf("failed to execute query '%v' for metric '%v': %w", r.queryConfig.update, m.ID, err))
+			if err = r.readDeltas(ctx, tx, r.queryConfig.incrementQuery(end-start), args, deltas, updated); err != nil {
+				return err
 			}
 		}
 
-		err = tx.Commit()
+		if len(updated) != len(rows) {
+			return mismatchedMetrics(metricsData, updated)
+		}
 
-		if err != nil {
+		if err = tx.Commit(); err != nil {
 			return errtypes.MakeServerError(fmt.Errorf("failed to commit metrics update: %w", err))
 		}
 
 		return nil
 	}
 
-	return r.retryExecutor.RetryOnError(work)
+	return deltas, r.retryExecutor.RetryOnError(work)
 }
 
-func (r *PGMetricRepository) AddOrUpdate(key string, val string, mtype string) (string, error) {
-	_, err := metrics.NewMetric(key, val, mtype)
+// mismatchedMetrics returns error listing metrics missing in updated, those are stored with other type.
+func mismatchedMetrics(metricsData []metrics.Metric, updated map[string]struct{}) error {
+	bulkErr := BulkUpdateError{}
+	for i, m := range metricsData {
+		if _, ok := updated[m.ID]; !ok {
+			bulkErr.Add(i, m.ID, typeMismatchError(m.ID, m.MType))
+		}
+	}
+	return bulkErr.Err()
+}
+
+func (r *PGMetricRepository) readDeltas(ctx context.Context, tx *sql.Tx, query string, args []any,
+	deltas map[string]int64, updated map[string]struct{}) error {
+	res, err := tx.QueryContext(ctx, query, args...)
 	if err != nil {
-		return "", errtypes.MakeBadDataError(err)
+		return errtypes.MakeServerError(fmt.Errorf("failed to execute metrics update: %w", err))
 	}
 
-	var updateValOut *string
-	work := func() error {
-		ctx, cancel := context.WithTimeout(context.Background(), r.dbConfig.PingTimeout)
-		defer cancel()
+	defer func() {
+		if err := res.Close(); err != nil {
+			r.log.Errorf("Failed to close rows: %v", err)
+		}
+	}()
 
-		updateVal := val
-		updateValOut = &updateVal
-		if mtype == metrics.CounterMetricType {
-			updateVal, err = r.getCounterIncDelta(ctx, r.db, key, val)
-			if err != nil {
-				return errtypes.MakeServerError(err)
-			}
+	for res.Next() {
+		var name string
+		var delta sql.NullInt64
+		if err = res.Scan(&name, &delta); err != nil {
+			return errtypes.MakeServerError(fmt.Errorf("failed to read updated metric: %w", err))
 		}
+		updated[name] = struct{}{}
+		if delta.Valid {
+			deltas[name] = delta.Int64
+		}
+	}
 
-		var metric metrics.Metric
-		metric, err = metrics.NewMetric(key, updateVal, mtype)
-		if err != nil {
-			return errtypes.MakeServerError(err)
+	if err = res.Err(); err != nil {
+		return errtypes.MakeServerError(fmt.Errorf("failed to read updated metrics: %w", err))
+	}
+
+	return nil
+}
+
+func (r *PGMetricRepository) AddMetricsBulk(metricsData []metrics.Metric) error {
+	deltas, err := r.increment(metricsData)
+	if err != nil {
+		return err
+	}
+
+	// Each counter gets value it had right after its own increment,
+	// so later increments of the same counter are subtracted from the final value.
+	for i := len(metricsData) - 1; i >= 0; i-- {
+		m := &metricsData[i]
+		if m.MType != metrics.CounterMetricType {
+			continue
 		}
-		_, err = r.db.ExecContext(ctx, r.queryConfig.update, metric.ID, metric.Value, metric.Delta, metric.MType)
 
-		if err != nil {
-			return errtypes.MakeServerError(fmt.Errorf("failed to execute add/update query %v: %w", r.queryConfig.update, err))
+		total, ok := deltas[m.ID]
+		if !ok {
+			continue
 		}
 
-		return nil
+		inc := *m.Delta
+		m.Delta = &total
+		deltas[m.ID] = total - inc
 	}
 
-	err = r.retryExecutor.RetryOnError(work)
+	return nil
+}
+
+func (r *PGMetricRepository) AddOrUpdate(key string, val string, mtype string) (string, error) {
+	m, err := metrics.NewMetric(key, val, mtype)
+	if err != nil {
+		return "", errtypes.MakeBadDataError(err)
+	}
+
+	deltas, err := r.increment([]metrics.Metric{m})
 	if err != nil {
 		return "", err
 	}
 
-	return *updateValOut, nil
+	if delta, ok := deltas[key]; ok && mtype == metrics.CounterMetricType {
+		return strconv.FormatInt(delta, 10), nil
+	}
+
+	return val, nil
 }
 
 func (r *PGMetricRepository) Delete(key string) error {
@@ -293,7 +366,7 @@ func (r *PGMetricRepository) DeleteAll() error {
 
 func (r *PGMetricRepository) Get(key string, mtype string) (metrics.Metric, error) {
 	if !metrics.IsValidMetricType(mtype) {
-		return metrics.Metric{}, errtypes.MakeServerError(fmt.Errorf("invalid metric type '%v'", mtype))
+		return metrics.Metric{}, errtypes.MakeBadDataError(fmt.Errorf("invalid metric type '%v'", mtype))
 	}
 
 	var metricOut metrics.Metric
@@ -343,29 +416,10 @@ func (r *PGMetricRepository) GetAll() ([]metrics.Metric, error) {
 		}()
 
 		for row.Next() {
-			var name string
-			var value sql.NullString
-			var delta sql.NullString
-			var mtype string
-
-			err = row.Scan(&name, &delta, &value, &mtype)
-
-			if err != nil {
-				return errtypes.MakeServerError(fmt.Errorf("failed to get metric: %w", err))
-			}
-
-			var data string
-			if value.Valid {
-				data = value.String
-			} else {
-				data = delta.String
-			}
-
 			var m metrics.Metric
-			m, err = metrics.NewMetric(name, data, mtype)
-
+			m, err = scanMetric(row)
 			if err != nil {
-				return errtypes.MakeServerError(fmt.Errorf("failed to create metric from value %v: %w", value, err))
+				return err
 			}
 
 			result = append(result, m)
@@ -381,37 +435,256 @@ func (r *PGMetricRepository) GetAll() ([]metrics.Metric, error) {
 	return result, r.retryExecutor.RetryOnError(work)
 }
 
+func scanMetric(row *sql.Rows) (metrics.Metric, error) {
+	var name string
+	var value sql.NullFloat64
+	var delta sql.NullInt64
+	var mtype string
+
+	if err := row.Scan(&name, &value, &delta, &mtype); err != nil {
+		return metrics.Metric{}, errtypes.MakeServerError(fmt.Errorf("failed to get metric: %w", err))
+	}
+
+	switch {
+	case mtype == metrics.CounterMetricType && delta.Valid:
+		return metrics.NewCounterMetric(name, delta.Int64), nil
+	case mtype == metrics.GaugeMetricType && value.Valid:
+		return metrics.NewGaugeMetric(name, value.Float64), nil
+	default:
+		return metrics.Metric{}, errtypes.MakeServerError(fmt.Errorf("invalid stored metric '%v' of type '%v'", name, mtype))
+	}
+}
+
 func (r *PGMetricRepository) MarshalJSON() ([]byte, error) {
-	return nil, errors.New("not implemented")
+	b := bytes.Buffer{}
+	if err := r.Save(&b); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
 }
 
 func (r *PGMetricRepository) UnmarshalJSON(data []byte) error {
-	return errors.New("not implemented")
+	return r.Load(bytes.NewReader(data))
 }
 
+// SnapshotMetrics passes stored metrics to fn as they're read from database.
+func (r *PGMetricRepository) SnapshotMetrics(fn func(m metrics.Metric) error) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var row *sql.Rows
+	err := r.retryExecutor.RetryOnError(func() error {
+		var err error
+		row, err = r.db.QueryContext(ctx, r.queryConfig.getAll)
+		if err != nil {
+			return errtypes.MakeServerError(fmt.Errorf("failed to query all repo metrics: %w", err))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err := row.Close(); err != nil {
+			r.log.Errorf("Failed to close row: %v", err)
+		}
+	}()
+
+	for row.Next() {
+		var m metrics.Metric
+		if m, err = scanMetric(row); err != nil {
+			return err
+		}
+
+		if err = fn(m); err != nil {
+			return err
+		}
+	}
+
+	if err = row.Err(); err != nil {
+		return errtypes.MakeServerError(fmt.Errorf("failed to iterate all metrics: %w", err))
+	}
+
+	return nil
+}
+
+// Save streams all stored metrics to w in the same JSON format as CommonMetricsRepository.
 func (r *PGMetricRepository) Save(w io.Writer) error {
-	return errors.New("not implemented")
+	bw := bufio.NewWriter(w)
+	if err := bw.WriteByte('['); err != nil {
+		return err
+	}
+
+	first := true
+	err := r.SnapshotMetrics(func(m metrics.Metric) error {
+		data, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+
+		if !first {
+			if err = bw.WriteByte(','); err != nil {
+				return err
+			}
+		}
+		first = false
+
+		_, err = bw.Write(data)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if _, err = bw.WriteString("]\n"); err != nil {
+		return err
+	}
+
+	return bw.Flush()
 }
 
+// Load streams metrics in the same JSON format as CommonMetricsRepository from reader
+// and stores them in single transaction replacing existing values, so nothing is stored if load fails midway.
 func (r *PGMetricRepository) Load(reader io.Reader) error {
-	return errors.New("not implemented")
+	dec := json.NewDecoder(reader)
+	tok, err := dec.Token()
+	if err != nil {
+		return errtypes.MakeBadDataError(fmt.Errorf("failed to read metrics array: %w", err))
+	}
+
+	if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+		return errtypes.MakeBadDataError(errors.New("metrics array expected"))
+	}
+
+	// Reader can't be read again, so import isn't retried.
+	tx, err := r.db.Begin()
+	if err != nil {
+		return errtypes.MakeServerError(fmt.Errorf("failed to begin transaction: %w", err))
+	}
+
+	defer func() {
+		errd := tx.Rollback()
+		if errd != nil && !errors.Is(errd, sql.ErrTxDone) {
+			r.log.Errorf("Failed to rollback tx: %v", errd)
+		}
+	}()
+
+	stmt, err := tx.Prepare(r.queryConfig.update)
+	if err != nil {
+		return errtypes.MakeServerError(fmt.Errorf("failed to prepare query %v: %w", r.queryConfig.update, err))
+	}
+
+	defer func() {
+		if errs := stmt.Close(); errs != nil {
+			r.log.Errorf("Failed to close prepared statement: %v", errs)
+		}
+	}()
+
+	for dec.More() {
+		var m metrics.Metric
+		if err = dec.Decode(&m); err != nil {
+			return errtypes.MakeBadDataError(fmt.Errorf("failed to decode metric: %w", err))
+		}
+
+		if _, err = m.GetData(); err != nil {
+			return errtypes.MakeBadDataError(err)
+		}
+
+		if err = r.execTimeout(stmt, m.ID, m.Value, m.Delta, m.MType); err != nil {
+			return errtypes.MakeServerError(fmt.Errorf("failed to store metric '%v': %w", m.ID, err))
+		}
+	}
+
+	if _, err = dec.Token(); err != nil {
+		return errtypes.MakeBadDataError(fmt.Errorf("failed to read metrics array: %w", err))
+	}
+
+	if err = tx.Commit(); err != nil {
+		return errtypes.MakeServerError(fmt.Errorf("failed to commit metrics: %w", err))
+	}
+
+	return nil
 }
 
-func (r *PGMetricRepository) Release() error {
+// execTimeout executes prepared statement with database timeout.
+func (r *PGMetricRepository) execTimeout(stmt *sql.Stmt, args ...any) error {
+	ctx, cancel := context.WithTimeout(context.Background(), r.dbConfig.PingTimeout)
+	defer cancel()
+	_, err := stmt.ExecContext(ctx, args...)
+	return err
+}
+
+// ReplaceMetrics replaces all stored metrics with data in single transaction.
+func (r *PGMetricRepository) ReplaceMetrics(data []metrics.Metric) error {
+	for _, m := range data {
+		if _, err := m.GetData(); err != nil {
+			return errtypes.MakeBadDataError(err)
+		}
+	}
+
+	return r.replaceMetrics(data)
+}
 
+// replaceMetrics replaces all stored metrics with data in single transaction.
+func (r *PGMetricRepository) replaceMetrics(data []metrics.Metric) error {
 	work := func() error {
+		tx, err := r.db.Begin()
+		if err != nil {
+			return errtypes.MakeServerError(fmt.Errorf("failed to begin transaction: %w", err))
+		}
+
+		defer func() {
+			errd := tx.Rollback()
+			if errd != nil && !errors.Is(errd, sql.ErrTxDone) {
+				r.log.Errorf("Failed to rollback tx: %v", errd)
+			}
+		}()
+
 		ctx, cancel := context.WithTimeout(context.Background(), r.dbConfig.PingTimeout)
 		defer cancel()
 
-		_, err := r.db.ExecContext(ctx, r.queryConfig.deleteTable)
+		if _, err = tx.ExecContext(ctx, r.queryConfig.deleteAll); err != nil {
+			return errtypes.MakeServerError(fmt.Errorf("failed to delete all metrics: %w", err))
+		}
 
+		stmt, err := tx.PrepareContext(ctx, r.queryConfig.update)
 		if err != nil {
-			return err
+			return errtypes.MakeServerError(fmt.Errorf("failed to prepare query %v: %w", r.queryConfig.update, err))
+		}
+
+		defer func() {
+			errs := stmt.Close()
+			if errs != nil {
+				r.log.Errorf("Failed to close prepared statement: %v", errs)
+			}
+		}()
+
+		for _, m := range data {
+			if _, err = stmt.ExecContext(ctx, m.ID, m.Value, m.Delta, m.MType); err != nil {
+				return errtypes.MakeServerError(fmt.Errorf("failed to execute query '%v' for metric '%v': %w", r.queryConfig.update, m.ID, err))
+			}
+		}
+
+		if err = tx.Commit(); err != nil {
+			return errtypes.MakeServerError(fmt.Errorf("failed to commit metrics: %w", err))
 		}
 
 		return nil
 	}
 
+	return r.retryExecutor.RetryOnError(work)
+}
+
+func (r *PGMetricRepository) Release() error {
+
+	work := func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), r.dbConfig.PingTimeout)
+		defer cancel()
+
+		return r.migrator.Down(ctx, len(r.migrator.migrations))
+	}
+
 	return r.retryExecutor.RetryOnError(func() error {
 		return work()
 	})
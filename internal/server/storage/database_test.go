@@ -3,7 +3,9 @@ package storage
 import (
 	"bytes"
 	"context"
+	"database/sql"
 	"math/rand"
+	"sync"
 	"testing"
 	"time"
 
@@ -14,25 +16,44 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
-func Test_Database(t *testing.T) {
+// testDBConfig returns configuration of database set by DATABASE_DSN
+// or of new in-process fake database if it's not set.
+func testDBConfig(t *testing.T) config.DBConfig {
 	cfg := config.Config{}
-	err := cfg.ParseEnvVariables()
-	require.NoError(t, err)
+	require.NoError(t, cfg.ParseEnvVariables())
 
 	cfg.DatabaseConfig.DriverName = "pgx"
-	cfg.DatabaseConfig.PingTimeout = 2 * time.Second
+	cfg.DatabaseConfig.PingTimeout = 5 * time.Second
 
 	if len(cfg.DatabaseConfig.ConnString) == 0 {
-		return
+		cfg.DatabaseConfig.DriverName = fakePGDriverName
+		cfg.DatabaseConfig.ConnString = newFakePGConnString()
 	}
 
+	return cfg.DatabaseConfig
+}
+
+func newTestPGRepository(t *testing.T, logger log.Logger) *PGMetricRepository {
 	stopCtx, stopFunc := context.WithCancel(context.TODO())
-	defer stopFunc()
-	repo, err := NewPGMetricRepository(cfg.DatabaseConfig, NewDefaultDBRetryExecutor(stopCtx), log.NewDevZapLogger())
+	t.Cleanup(stopFunc)
+
+	repo, err := NewPGMetricRepository(testDBConfig(t), NewDefaultDBRetryExecutor(stopCtx), logger)
 	require.NoError(t, err)
+	return repo
+}
+
+func Test_Database(t *testing.T) {
+	stopCtx, stopFunc := context.WithCancel(context.TODO())
+	defer stopFunc()
+	repo := newTestPGRepository(t, log.NewDevZapLogger())
 
 	require.NoError(t, repo.HealthCheck())
 
+	status, err := repo.migrator.Status(stopCtx)
+	require.NoError(t, err)
+	require.Empty(t, status.Pending)
+	require.Equal(t, status.Latest, status.Current)
+
 	m := metrics.NewCounterMetric(guid.NewString(), rand.Int63())
 	data, err := m.GetData()
 	require.NoError(t, err)
@@ -80,14 +101,144 @@ func Test_Database(t *testing.T) {
 
 	require.Equal(t, cnt, len(metricsMap))
 
-	require.Error(t, repo.Save(bytes.NewBuffer(make([]byte, 0))))
+	exported := bytes.Buffer{}
+	require.NoError(t, repo.Save(&exported))
+	require.NoError(t, repo.DeleteAll())
+	require.NoError(t, repo.Load(&exported))
+
+	metricsDB, err = repo.GetAll()
+	require.NoError(t, err)
+	for _, mDB := range metricsDB {
+		if mTest, ok := metricsMap[mDB.ID]; ok {
+			require.True(t, mTest.Equal(&mDB))
+		}
+	}
+
+	dump, err := repo.MarshalJSON()
+	require.NoError(t, err)
+	require.NoError(t, repo.UnmarshalJSON(dump))
+
 	require.Error(t, repo.Load(bytes.NewBuffer(make([]byte, 0))))
-	_, err = repo.MarshalJSON()
-	require.Error(t, err)
-	err = repo.UnmarshalJSON(make([]byte, 0))
-	require.Error(t, err)
+	require.Error(t, repo.UnmarshalJSON([]byte("{}")))
 
 	require.NoError(t, repo.DeleteAll())
 	require.NoError(t, repo.Release())
 	require.NoError(t, repo.Close())
 }
+
+func Test_DatabaseConcurrentIncrements(t *testing.T) {
+	dbConfig := testDBConfig(t)
+
+	stopCtx, stopFunc := context.WithCancel(context.TODO())
+	defer stopFunc()
+
+	// Separate repositories act as separate servers sharing database.
+	const servers = 4
+	const iterations = 50
+	repos := make([]*PGMetricRepository, servers)
+	for i := range repos {
+		repo, err := NewPGMetricRepository(dbConfig, NewDefaultDBRetryExecutor(stopCtx), log.NewDevZapLogger())
+		require.NoError(t, err)
+		repos[i] = repo
+	}
+
+	id := guid.NewString()
+	wg := sync.WaitGroup{}
+	for _, repo := range repos {
+		wg.Add(1)
+		go func(repo *PGMetricRepository) {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				_, err := repo.AddOrUpdate(id, "1", metrics.CounterMetricType)
+				require.NoError(t, err)
+				require.NoError(t, repo.AddMetricsBulk([]metrics.Metric{
+					metrics.NewCounterMetric(id, 2),
+					metrics.NewCounterMetric(id, 3),
+				}))
+			}
+		}(repo)
+	}
+	wg.Wait()
+
+	m, err := repos[0].Get(id, metrics.CounterMetricType)
+	require.NoError(t, err)
+	require.Equal(t, int64(servers*iterations*6), *m.Delta)
+
+	// Bulk update reports counter values right after each increment.
+	bulk := []metrics.Metric{metrics.NewCounterMetric(id, 1), metrics.NewCounterMetric(id, 2)}
+	require.NoError(t, repos[0].AddMetricsBulk(bulk))
+	require.Equal(t, *m.Delta+1, *bulk[0].Delta)
+	require.Equal(t, *m.Delta+3, *bulk[1].Delta)
+
+	require.NoError(t, repos[0].Delete(id))
+	for _, repo := range repos {
+		require.NoError(t, repo.Close())
+	}
+}
+
+func Test_DatabaseLoadAtomic(t *testing.T) {
+	repo := newTestPGRepository(t, log.NewDummyLogger())
+	defer func() {
+		require.NoError(t, repo.Close())
+	}()
+
+	counter := guid.NewString()
+	_, err := repo.AddOrUpdate(counter, "5", metrics.CounterMetricType)
+	require.NoError(t, err)
+
+	// Metrics loaded before invalid record aren't stored.
+	gauge := guid.NewString()
+	data := `[{"id":"` + gauge + `","type":"gauge","value":1},{"id":"` + counter + `","type":"counter","delta":1},{"id":"bad"}]`
+	require.Error(t, repo.Load(bytes.NewReader([]byte(data))))
+
+	require.Equal(t, int64(5), getCounterValue(t, repo, counter))
+	_, err = repo.Get(gauge, metrics.GaugeMetricType)
+	require.Error(t, err)
+}
+
+// Test_DatabaseRowLocks checks that uncommitted increments are invisible and concurrent ones wait for them.
+func Test_DatabaseRowLocks(t *testing.T) {
+	repo := newTestPGRepository(t, log.NewDummyLogger())
+	defer func() {
+		require.NoError(t, repo.Close())
+	}()
+
+	counter := guid.NewString()
+	_, err := repo.AddOrUpdate(counter, "1", metrics.CounterMetricType)
+	require.NoError(t, err)
+
+	increment := func(tx *sql.Tx, delta int64) {
+		_, err := tx.Exec(repo.queryConfig.incrementQuery(1), counter, nil, delta, metrics.CounterMetricType)
+		require.NoError(t, err)
+	}
+
+	tx, err := repo.db.Begin()
+	require.NoError(t, err)
+	increment(tx, 1000)
+	require.NoError(t, tx.Rollback())
+	require.Equal(t, int64(1), getCounterValue(t, repo, counter))
+
+	tx, err = repo.db.Begin()
+	require.NoError(t, err)
+	defer func() {
+		require.ErrorIs(t, tx.Rollback(), sql.ErrTxDone)
+	}()
+	increment(tx, 10)
+	require.Equal(t, int64(1), getCounterValue(t, repo, counter))
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := repo.AddOrUpdate(counter, "100", metrics.CounterMetricType)
+		done <- err
+	}()
+
+	select {
+	case err = <-done:
+		require.FailNow(t, "increment of locked row didn't wait", "error: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	require.NoError(t, tx.Commit())
+	require.NoError(t, <-done)
+	require.Equal(t, int64(111), getCounterValue(t, repo, counter))
+}
@@ -0,0 +1,679 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/beevik/guid"
+)
+
+// fakePGDriverName in-process stand-in for Postgres used to run PGMetricRepository without a server.
+// It understands only the statements issued by PGMetricRepository and Migrator and fails on anything else.
+// Like Postgres in read committed mode, written rows stay locked until end of transaction
+// and other connections see their committed values, so lost updates aren't hidden by the fake.
+// Schema changes aren't isolated, Migrator serializes them by advisory lock anyway.
+const fakePGDriverName = "fakepg"
+
+func init() {
+	sql.Register(fakePGDriverName, &fakePGDriver{dbs: make(map[string]*fakePGDatabase)})
+}
+
+// newFakePGConnString returns connection string of new empty fake database.
+func newFakePGConnString() string {
+	return "fakepg://" + guid.NewString()
+}
+
+type fakePGRow struct {
+	value any
+	delta any
+	mtype string
+}
+
+type fakePGRequest struct {
+	created     time.Time
+	status      any
+	contentType any
+	body        any
+}
+
+type fakePGTables struct {
+	metrics  map[string]fakePGRow
+	versions map[int64]struct{}
+	requests map[[2]string]fakePGRequest
+}
+
+const (
+	fakePGMetricsTable  = "metrics"
+	fakePGRequestsTable = "idempotency_keys"
+)
+
+type fakePGLockKey struct {
+	table string
+	id    [2]string
+}
+
+// fakePGLock row lock held by transaction, keeps committed row to show it to other connections.
+// Nil committed row means row didn't exist before transaction.
+type fakePGLock struct {
+	owner   *fakePGConn
+	metric  *fakePGRow
+	request *fakePGRequest
+}
+
+type fakePGDatabase struct {
+	advisory map[int64]*sync.Mutex
+	locks    map[fakePGLockKey]*fakePGLock
+	unlocked *sync.Cond
+	fakePGTables
+	lock    sync.Mutex
+	advLock sync.Mutex
+}
+
+func newFakePGDatabase() *fakePGDatabase {
+	db := &fakePGDatabase{advisory: make(map[int64]*sync.Mutex), locks: make(map[fakePGLockKey]*fakePGLock)}
+	db.unlocked = sync.NewCond(&db.lock)
+	return db
+}
+
+func (d *fakePGDatabase) advisoryMutex(key int64) *sync.Mutex {
+	d.advLock.Lock()
+	defer d.advLock.Unlock()
+	m, ok := d.advisory[key]
+	if !ok {
+		m = &sync.Mutex{}
+		d.advisory[key] = m
+	}
+	return m
+}
+
+// waitsFor checks whether from waits for row locked by to directly or through other transactions.
+func (d *fakePGDatabase) waitsFor(from *fakePGConn, to *fakePGConn) bool {
+	for c := from; c != nil; {
+		if c == to {
+			return true
+		}
+		if c.waiting == nil {
+			return false
+		}
+		lock, ok := d.locks[*c.waiting]
+		if !ok {
+			return false
+		}
+		c = lock.owner
+	}
+	return false
+}
+
+type fakePGDriver struct {
+	dbs  map[string]*fakePGDatabase
+	lock sync.Mutex
+}
+
+func (d *fakePGDriver) Open(name string) (driver.Conn, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	db, ok := d.dbs[name]
+	if !ok {
+		db = newFakePGDatabase()
+		d.dbs[name] = db
+	}
+	return &fakePGConn{db: db}, nil
+}
+
+// fakePGConn connection state is guarded by database lock.
+type fakePGConn struct {
+	db      *fakePGDatabase
+	waiting *fakePGLockKey
+	locked  []fakePGLockKey
+	undo    []func()
+	inTx    bool
+}
+
+func (c *fakePGConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakePGStmt{conn: c, query: query}, nil
+}
+
+func (c *fakePGConn) Close() error {
+	return nil
+}
+
+func (c *fakePGConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *fakePGConn) BeginTx(_ context.Context, _ driver.TxOptions) (driver.Tx, error) {
+	c.db.lock.Lock()
+	defer c.db.lock.Unlock()
+	if c.inTx {
+		return nil, errors.New("fakepg: transaction already started")
+	}
+	c.inTx = true
+	return &fakePGTx{conn: c}, nil
+}
+
+func (c *fakePGConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	rows, err := c.run(query, namedValues(args))
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(len(rows.data)), nil
+}
+
+func (c *fakePGConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return c.run(query, namedValues(args))
+}
+
+func namedValues(args []driver.NamedValue) []driver.Value {
+	res := make([]driver.Value, len(args))
+	for i, a := range args {
+		res[i] = a.Value
+	}
+	return res
+}
+
+// lockRow locks row until end of transaction, waits if row is locked by other transaction.
+// Returns lock and whether it was just taken.
+func (c *fakePGConn) lockRow(key fakePGLockKey) (*fakePGLock, bool, error) {
+	d := c.db
+	for {
+		lock, ok := d.locks[key]
+		if !ok {
+			lock = &fakePGLock{owner: c}
+			d.locks[key] = lock
+			c.locked = append(c.locked, key)
+			return lock, true, nil
+		}
+		if lock.owner == c {
+			return lock, false, nil
+		}
+		if d.waitsFor(lock.owner, c) {
+			return nil, false, errors.New("fakepg: deadlock detected")
+		}
+		c.waiting = &key
+		d.unlocked.Wait()
+		c.waiting = nil
+	}
+}
+
+// lockMetric locks metric row, row is restored on rollback.
+func (c *fakePGConn) lockMetric(name string) error {
+	lock, locked, err := c.lockRow(fakePGLockKey{table: fakePGMetricsTable, id: [2]string{name}})
+	if err != nil || !locked {
+		return err
+	}
+
+	d := c.db
+	if d.metrics == nil {
+		return errFakePGNoTable
+	}
+	if row, ok := d.metrics[name]; ok {
+		lock.metric = &row
+	}
+
+	committed := lock.metric
+	c.undo = append(c.undo, func() {
+		if d.metrics == nil {
+			return
+		}
+		if committed == nil {
+			delete(d.metrics, name)
+		} else {
+			d.metrics[name] = *committed
+		}
+	})
+	return nil
+}
+
+// lockRequest locks idempotency key row, row is restored on rollback.
+func (c *fakePGConn) lockRequest(key [2]string) error {
+	lock, locked, err := c.lockRow(fakePGLockKey{table: fakePGRequestsTable, id: key})
+	if err != nil || !locked {
+		return err
+	}
+
+	d := c.db
+	if d.requests == nil {
+		return errFakePGNoRequestsTable
+	}
+	if req, ok := d.requests[key]; ok {
+		lock.request = &req
+	}
+
+	committed := lock.request
+	c.undo = append(c.undo, func() {
+		if d.requests == nil {
+			return
+		}
+		if committed == nil {
+			delete(d.requests, key)
+		} else {
+			d.requests[key] = *committed
+		}
+	})
+	return nil
+}
+
+// visibleMetric returns metric row as seen by c, rows locked by other transactions have committed values.
+func (c *fakePGConn) visibleMetric(name string) (fakePGRow, bool) {
+	lock, ok := c.db.locks[fakePGLockKey{table: fakePGMetricsTable, id: [2]string{name}}]
+	if ok && lock.owner != c {
+		if lock.metric == nil {
+			return fakePGRow{}, false
+		}
+		return *lock.metric, true
+	}
+	row, ok := c.db.metrics[name]
+	return row, ok
+}
+
+// visibleRequest returns idempotency key row as seen by c.
+func (c *fakePGConn) visibleRequest(key [2]string) (fakePGRequest, bool) {
+	lock, ok := c.db.locks[fakePGLockKey{table: fakePGRequestsTable, id: key}]
+	if ok && lock.owner != c {
+		if lock.request == nil {
+			return fakePGRequest{}, false
+		}
+		return *lock.request, true
+	}
+	req, ok := c.db.requests[key]
+	return req, ok
+}
+
+// rollbackTo undoes changes made after undo log had n entries.
+func (c *fakePGConn) rollbackTo(n int) {
+	for i := len(c.undo) - 1; i >= n; i-- {
+		c.undo[i]()
+	}
+	c.undo = c.undo[:n]
+}
+
+// finish ends transaction and releases its row locks.
+func (c *fakePGConn) finish(commit bool) {
+	if !commit {
+		c.rollbackTo(0)
+	}
+	for _, key := range c.locked {
+		delete(c.db.locks, key)
+	}
+	c.locked = nil
+	c.undo = nil
+	c.inTx = false
+	c.db.unlocked.Broadcast()
+}
+
+type fakePGTx struct {
+	conn *fakePGConn
+}
+
+func (t *fakePGTx) Commit() error {
+	t.conn.db.lock.Lock()
+	defer t.conn.db.lock.Unlock()
+	t.conn.finish(true)
+	return nil
+}
+
+func (t *fakePGTx) Rollback() error {
+	t.conn.db.lock.Lock()
+	defer t.conn.db.lock.Unlock()
+	t.conn.finish(false)
+	return nil
+}
+
+type fakePGStmt struct {
+	conn  *fakePGConn
+	query string
+}
+
+func (s *fakePGStmt) Close() error {
+	return nil
+}
+
+func (s *fakePGStmt) NumInput() int {
+	return -1
+}
+
+func (s *fakePGStmt) Exec(args []driver.Value) (driver.Result, error) {
+	rows, err := s.conn.run(s.query, args)
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(len(rows.data)), nil
+}
+
+func (s *fakePGStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.conn.run(s.query, args)
+}
+
+type fakePGRows struct {
+	columns []string
+	data    [][]driver.Value
+	pos     int
+}
+
+func (r *fakePGRows) Columns() []string {
+	return r.columns
+}
+
+func (r *fakePGRows) Close() error {
+	return nil
+}
+
+func (r *fakePGRows) Next(dest []driver.Value) error {
+	if r.pos >= len(r.data) {
+		return io.EOF
+	}
+	copy(dest, r.data[r.pos])
+	r.pos++
+	return nil
+}
+
+// run executes query, failed statement is undone and statements out of transaction are committed right away.
+func (c *fakePGConn) run(query string, args []driver.Value) (*fakePGRows, error) {
+	switch query {
+	case lockQuery:
+		c.db.advisoryMutex(args[0].(int64)).Lock()
+		return &fakePGRows{}, nil
+	case unlockQuery:
+		c.db.advisoryMutex(args[0].(int64)).Unlock()
+		return &fakePGRows{}, nil
+	}
+
+	c.db.lock.Lock()
+	defer c.db.lock.Unlock()
+
+	mark := len(c.undo)
+	res, err := c.runStatements(query, args)
+	if err != nil {
+		c.rollbackTo(mark)
+	}
+	if !c.inTx {
+		c.finish(err == nil)
+	}
+
+	return res, err
+}
+
+func (c *fakePGConn) runStatements(query string, args []driver.Value) (*fakePGRows, error) {
+	var res *fakePGRows
+	for _, stmt := range strings.Split(query, ";") {
+		stmt = strings.Join(strings.Fields(stmt), " ")
+		if len(stmt) == 0 {
+			continue
+		}
+
+		var err error
+		if res, err = c.exec(stmt, args); err != nil {
+			return nil, err
+		}
+	}
+
+	if res == nil {
+		res = &fakePGRows{}
+	}
+
+	return res, nil
+}
+
+var (
+	errFakePGNoTable         = errors.New("fakepg: relation \"metrics\" does not exist")
+	errFakePGNoRequestsTable = errors.New("fakepg: relation \"idempotency_keys\" does not exist")
+)
+
+func (c *fakePGConn) exec(stmt string, args []driver.Value) (*fakePGRows, error) {
+	d := c.db
+	q := BuildPGQueryConfig("Metrics")
+	lower := strings.ToLower(stmt)
+
+	switch {
+	case stmt == createSchemaVersionQuery:
+		if d.versions == nil {
+			d.versions = make(map[int64]struct{})
+			c.undo = append(c.undo, func() { d.versions = nil })
+		}
+		return &fakePGRows{}, nil
+	case stmt == currentVersionQuery:
+		var version int64
+		for v := range d.versions {
+			if v > version {
+				version = v
+			}
+		}
+		return &fakePGRows{columns: []string{"version"}, data: [][]driver.Value{{version}}}, nil
+	case stmt == insertVersionQuery:
+		version := args[0].(int64)
+		d.versions[version] = struct{}{}
+		c.undo = append(c.undo, func() { delete(d.versions, version) })
+		return &fakePGRows{}, nil
+	case stmt == deleteVersionQuery:
+		version := args[0].(int64)
+		delete(d.versions, version)
+		c.undo = append(c.undo, func() { d.versions[version] = struct{}{} })
+		return &fakePGRows{}, nil
+	case strings.HasPrefix(lower, "create table if not exists metrics("):
+		if d.metrics == nil {
+			d.metrics = make(map[string]fakePGRow)
+			c.undo = append(c.undo, func() { d.metrics = nil })
+		}
+		return &fakePGRows{}, nil
+	case lower == "drop table if exists metrics":
+		prev := d.metrics
+		d.metrics = nil
+		c.undo = append(c.undo, func() { d.metrics = prev })
+		return &fakePGRows{}, nil
+	case strings.HasPrefix(lower, "create table if not exists idempotency_keys("):
+		if d.requests == nil {
+			d.requests = make(map[[2]string]fakePGRequest)
+			c.undo = append(c.undo, func() { d.requests = nil })
+		}
+		return &fakePGRows{}, nil
+	case lower == "drop table if exists idempotency_keys":
+		prev := d.requests
+		d.requests = nil
+		c.undo = append(c.undo, func() { d.requests = prev })
+		return &fakePGRows{}, nil
+	case strings.Contains(lower, "idempotency_keys"):
+		return c.execRequests(stmt, args)
+	}
+
+	if d.metrics == nil {
+		return nil, errFakePGNoTable
+	}
+
+	switch {
+	case strings.HasPrefix(stmt, q.incInsert) && strings.HasSuffix(stmt, q.incConflict):
+		return c.increment(args)
+	case stmt == q.update:
+		row, err := newFakePGRow(args)
+		if err != nil {
+			return nil, err
+		}
+		name := args[0].(string)
+		if err = c.lockMetric(name); err != nil {
+			return nil, err
+		}
+		d.metrics[name] = row
+		return &fakePGRows{data: make([][]driver.Value, 1)}, nil
+	case stmt == q.getOne:
+		row, ok := c.visibleMetric(args[0].(string))
+		res := &fakePGRows{columns: []string{"value", "delta"}}
+		if ok && row.mtype == args[1].(string) {
+			res.data = append(res.data, []driver.Value{row.value, row.delta})
+		}
+		return res, nil
+	case stmt == q.getAll:
+		res := &fakePGRows{columns: []string{"name", "value", "delta", "type"}}
+		for _, name := range c.metricNames() {
+			if row, ok := c.visibleMetric(name); ok {
+				res.data = append(res.data, []driver.Value{name, row.value, row.delta, row.mtype})
+			}
+		}
+		return res, nil
+	case stmt == q.delete:
+		return c.deleteMetrics([]string{args[0].(string)})
+	case stmt == q.deleteAll:
+		return c.deleteMetrics(c.metricNames())
+	}
+
+	return nil, fmt.Errorf("fakepg: unsupported statement '%v'", stmt)
+}
+
+// metricNames returns sorted names of stored metrics including ones deleted by uncommitted transactions.
+func (c *fakePGConn) metricNames() []string {
+	names := make([]string, 0, len(c.db.metrics))
+	for name := range c.db.metrics {
+		names = append(names, name)
+	}
+	for key, lock := range c.db.locks {
+		if _, ok := c.db.metrics[key.id[0]]; !ok && key.table == fakePGMetricsTable && lock.metric != nil {
+			names = append(names, key.id[0])
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (c *fakePGConn) deleteMetrics(names []string) (*fakePGRows, error) {
+	res := &fakePGRows{}
+	for _, name := range names {
+		if _, ok := c.visibleMetric(name); !ok {
+			continue
+		}
+		if err := c.lockMetric(name); err != nil {
+			return nil, err
+		}
+		// Row could be deleted by transaction holding the lock.
+		if _, ok := c.db.metrics[name]; ok {
+			delete(c.db.metrics, name)
+			res.data = append(res.data, nil)
+		}
+	}
+	return res, nil
+}
+
+func (c *fakePGConn) execRequests(stmt string, args []driver.Value) (*fakePGRows, error) {
+	d := c.db
+	if d.requests == nil {
+		return nil, errFakePGNoRequestsTable
+	}
+
+	if stmt == expireRequestsQuery {
+		keys := make([][2]string, 0, len(d.requests))
+		for k := range d.requests {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i][0] < keys[j][0] || keys[i][0] == keys[j][0] && keys[i][1] < keys[j][1]
+		})
+
+		res := &fakePGRows{}
+		for _, k := range keys {
+			if r, ok := c.visibleRequest(k); !ok || !r.created.Before(args[0].(time.Time)) {
+				continue
+			}
+			if err := c.lockRequest(k); err != nil {
+				return nil, err
+			}
+			if _, ok := d.requests[k]; ok {
+				delete(d.requests, k)
+				res.data = append(res.data, nil)
+			}
+		}
+		return res, nil
+	}
+
+	key := [2]string{args[0].(string), args[1].(string)}
+	res := &fakePGRows{}
+
+	if stmt == getResponseQuery {
+		res.columns = []string{"status", "content_type", "body"}
+		if req, ok := c.visibleRequest(key); ok {
+			res.data = append(res.data, []driver.Value{req.status, req.contentType, req.body})
+		}
+		return res, nil
+	}
+
+	if err := c.lockRequest(key); err != nil {
+		return nil, err
+	}
+	req, ok := d.requests[key]
+
+	switch stmt {
+	case claimRequestQuery:
+		if !ok {
+			d.requests[key] = fakePGRequest{created: time.Now()}
+			res.data = append(res.data, nil)
+		}
+	case completeRequestQuery:
+		if ok {
+			req.status, req.contentType, req.body = args[2], args[3], args[4]
+			d.requests[key] = req
+			res.data = append(res.data, nil)
+		}
+	default:
+		return nil, fmt.Errorf("fakepg: unsupported statement '%v'", stmt)
+	}
+
+	return res, nil
+}
+
+func newFakePGRow(args []driver.Value) (fakePGRow, error) {
+	row := fakePGRow{value: args[1], delta: args[2], mtype: args[3].(string)}
+	if row.value == nil && row.delta == nil {
+		return fakePGRow{}, fmt.Errorf("fakepg: check constraint violated for metric '%v'", args[0])
+	}
+	return row, nil
+}
+
+// increment mimics multi-row upsert incrementing counters, rows stored with other type are skipped.
+// Rows are locked in order of arguments and updated using their latest committed values.
+func (c *fakePGConn) increment(args []driver.Value) (*fakePGRows, error) {
+	const params = 4
+	if len(args)%params != 0 {
+		return nil, fmt.Errorf("fakepg: invalid number of arguments %v", len(args))
+	}
+
+	d := c.db
+	updated := make(map[string]struct{}, len(args)/params)
+	res := &fakePGRows{columns: []string{"name", "delta"}}
+	for i := 0; i < len(args); i += params {
+		name := args[i].(string)
+		if _, ok := updated[name]; ok {
+			return nil, errors.New("fakepg: ON CONFLICT DO UPDATE command cannot affect row a second time")
+		}
+		updated[name] = struct{}{}
+
+		row, err := newFakePGRow(args[i : i+params])
+		if err != nil {
+			return nil, err
+		}
+
+		if err = c.lockMetric(name); err != nil {
+			return nil, err
+		}
+
+		prev, ok := d.metrics[name]
+		if ok && prev.mtype != row.mtype {
+			continue
+		}
+		if ok && row.mtype == "counter" {
+			var delta int64
+			if prev.delta != nil {
+				delta = prev.delta.(int64)
+			}
+			row.delta = delta + row.delta.(int64)
+		}
+
+		d.metrics[name] = row
+		res.data = append(res.data, []driver.Value{name, row.delta})
+	}
+
+	return res, nil
+}
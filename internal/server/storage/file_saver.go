@@ -1,7 +1,12 @@
 package storage
 
 import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
 	"os"
+	"path/filepath"
 	"sync"
 	"time"
 
@@ -21,34 +26,195 @@ type PeriodicSaver struct {
 	wg           sync.WaitGroup
 }
 
+// FileSaver saves repository snapshots to file. Snapshot is written to temporary
+// file and atomically renamed, previous snapshots are kept as <file>.1 ... <file>.<keep-1>.
 type FileSaver struct {
 	metricsStorage Repository
 	logger         logging.Logger
 	fileName       string
+	opts           SnapshotOptions
+}
+
+func (s *FileSaver) snapshotPath(n int) string {
+	if n == 0 {
+		return s.fileName
+	}
+	return fmt.Sprintf("%v.%v", s.fileName, n)
 }
 
 func (s *FileSaver) Save() error {
 	const perms = 0644
-	f, err := os.OpenFile(s.fileName, os.O_CREATE|os.O_WRONLY, perms)
-	defer func() {
-		if f != nil {
-			err = f.Sync()
-			if err != nil {
-				s.logger.Errorf("Failed to sync storage file")
-			}
+	tmpName := s.fileName + ".tmp"
+	f, err := os.OpenFile(tmpName, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perms)
+	if err != nil {
+		return err
+	}
+
+	err = s.writeSnapshot(f)
+	if errc := f.Close(); err == nil {
+		err = errc
+	}
+
+	if err != nil {
+		if errr := os.Remove(tmpName); errr != nil {
+			s.logger.Errorf("Failed to remove temporary snapshot file: %v", errr)
+		}
+		return err
+	}
+
+	if err = s.rotate(); err != nil {
+		return err
+	}
+
+	if err = os.Rename(tmpName, s.fileName); err != nil {
+		return fmt.Errorf("failed to rename snapshot file: %w", err)
+	}
+
+	if err = syncDir(filepath.Dir(s.fileName)); err != nil {
+		return err
+	}
+
+	committer, ok := s.metricsStorage.(SnapshotCommitter)
+	if !ok {
+		return nil
+	}
+
+	return committer.CommitSnapshot(s.opts.Keep)
+}
+
+func (s *FileSaver) writeSnapshot(f *os.File) error {
+	if _, err := f.Write(make([]byte, snapshotHeaderSize)); err != nil {
+		return err
+	}
+
+	flags, err := snapshotFlags(s.opts)
+	if err != nil {
+		return err
+	}
+
+	buf := bufio.NewWriter(f)
+	payload := checksumWriter{w: buf}
+	if err = encodeSnapshot(&payload, s.metricsStorage, flags); err != nil {
+		return err
+	}
+
+	if err = buf.Flush(); err != nil {
+		return err
+	}
+
+	h := snapshotHeader{Version: snapshotVersion, Flags: flags, Checksum: payload.crc, Size: payload.size}
+	if p, ok := s.metricsStorage.(SnapshotPositioner); ok {
+		h.Position = p.SnapshotPosition()
+	}
 
-			err = f.Close()
-			if err != nil {
-				s.logger.Errorf("Failed to close storage file")
+	if _, err = f.WriteAt(h.encode(), 0); err != nil {
+		return err
+	}
+
+	// Snapshot must be on disk before it replaces previous one.
+	return f.Sync()
+}
+
+// rotate shifts existing snapshots, dropping the oldest one.
+func (s *FileSaver) rotate() error {
+	for i := s.opts.Keep - 1; i > 0; i-- {
+		err := os.Rename(s.snapshotPath(i-1), s.snapshotPath(i))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to rotate snapshot file: %w", err)
+		}
+	}
+	return nil
+}
+
+// Load restores repository from the newest valid snapshot, older snapshots
+// are tried if newer ones are damaged. Returns log position stored in restored snapshot.
+func (s *FileSaver) Load() (uint64, error) {
+	var errs []error
+	for i := 0; i < s.opts.Keep; i++ {
+		name := s.snapshotPath(i)
+		pos, err := s.loadFile(name)
+		if err == nil {
+			if len(errs) > 0 {
+				s.logger.Warnf("Data restored from older snapshot %v", name)
 			}
+			return pos, nil
+		}
+
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+
+		s.logger.Warnf("Failed to load snapshot %v: %v", name, err)
+		errs = append(errs, err)
+	}
+
+	if len(errs) == 0 {
+		return 0, fmt.Errorf("no snapshot found: %w", os.ErrNotExist)
+	}
+
+	return 0, errors.Join(errs...)
+}
+
+func (s *FileSaver) loadFile(name string) (uint64, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			s.logger.Errorf("Failed to close snapshot file: %v", err)
 		}
 	}()
 
+	b := make([]byte, snapshotHeaderSize)
+	n, err := io.ReadFull(f, b)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		if errors.Is(err, io.EOF) {
+			return 0, errors.New("empty snapshot file")
+		}
+		return 0, err
+	}
+
+	h, err := decodeSnapshotHeader(b[:n])
+	if errors.Is(err, errNotSnapshot) {
+		// Snapshots saved before versioning was introduced.
+		if _, err = f.Seek(0, io.SeekStart); err != nil {
+			return 0, err
+		}
+		return 0, restoreJSONSnapshot(f, s.metricsStorage)
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	if err = verifySnapshot(bufio.NewReader(f), h); err != nil {
+		return 0, err
+	}
+
+	if _, err = f.Seek(snapshotHeaderSize, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	if err = decodeSnapshot(io.LimitReader(f, int64(h.Size)), s.metricsStorage, h.Flags); err != nil {
+		return 0, err
+	}
+
+	return h.Position, nil
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
 	if err != nil {
 		return err
 	}
 
-	return s.metricsStorage.Save(f)
+	err = d.Sync()
+	if errc := d.Close(); err == nil {
+		err = errc
+	}
+
+	return err
 }
 
 func (s *PeriodicSaver) Run() {
@@ -84,7 +250,11 @@ func NewPeriodicSaver(period time.Duration, log logging.Logger, storageSaver Sto
 	return &PeriodicSaver{period: period, done: make(chan struct{}), log: log, storageSaver: storageSaver}
 }
 
-func NewFileSaver(m Repository, fileName string, log logging.Logger) *FileSaver {
-	s := FileSaver{logger: log, fileName: fileName, metricsStorage: m}
+// NewFileSaver creates saver of repository m to fileName.
+func NewFileSaver(m Repository, fileName string, opts SnapshotOptions, log logging.Logger) *FileSaver {
+	if opts.Keep < 1 {
+		opts.Keep = 1
+	}
+	s := FileSaver{logger: log, fileName: fileName, metricsStorage: m, opts: opts}
 	return &s
 }
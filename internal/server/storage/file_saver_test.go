@@ -1,10 +1,14 @@
 package storage
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"math/rand"
 	"os"
+	"path/filepath"
 	"strconv"
 	"testing"
 	"time"
@@ -12,6 +16,7 @@ import (
 	"github.com/beevik/guid"
 	"github.com/fuzzy-toozy/metrics-service/internal/log"
 	"github.com/fuzzy-toozy/metrics-service/internal/metrics"
+	"github.com/fuzzy-toozy/metrics-service/internal/server/config"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -29,6 +34,10 @@ func getMetricsFromFile(fileName string) ([]metrics.Metric, error) {
 		}
 	}()
 
+	if _, err = f.Seek(snapshotHeaderSize, io.SeekStart); err != nil {
+		return nil, err
+	}
+
 	err = json.NewDecoder(f).Decode(&m)
 	if err != nil {
 		return nil, err
@@ -47,7 +56,7 @@ func Test_FileSaver(t *testing.T) {
 		}
 	}()
 	logger := log.NewDevZapLogger()
-	fs := NewFileSaver(repo, outFile, logger)
+	fs := NewFileSaver(repo, outFile, SnapshotOptions{Keep: 1}, logger)
 
 	id := guid.NewString()
 	intVal := rand.Int63()
@@ -82,7 +91,7 @@ func Test_PeriodicFileSaver(t *testing.T) {
 	logger := log.NewDevZapLogger()
 
 	dur := time.Duration(1+rand.Int31()%500) * time.Millisecond
-	fs := NewFileSaver(repo, outFile, logger)
+	fs := NewFileSaver(repo, outFile, SnapshotOptions{Keep: 1}, logger)
 	pfs := NewPeriodicSaver(dur, logger, fs)
 
 	id := guid.NewString()
@@ -129,3 +138,175 @@ func Test_PeriodicFileSaver(t *testing.T) {
 	require.NotNil(t, decodedM.Delta)
 	assert.Equal(t, *decodedM.Delta, intVal)
 }
+
+func Test_FileSaverFallback(t *testing.T) {
+	outFile := filepath.Join(t.TempDir(), "metrics.json")
+	logger := log.NewDummyLogger()
+
+	repo := NewCommonMetricsRepository()
+	fs := NewFileSaver(repo, outFile, SnapshotOptions{Keep: 2}, logger)
+
+	for i := 0; i < 20; i++ {
+		_, err := repo.AddOrUpdate(fmt.Sprintf("c%v", i), "1", metrics.CounterMetricType)
+		require.NoError(t, err)
+	}
+	require.NoError(t, fs.Save())
+
+	for i := 1; i < 20; i++ {
+		require.NoError(t, repo.Delete(fmt.Sprintf("c%v", i)))
+	}
+	_, err := repo.AddOrUpdate("c0", "1", metrics.CounterMetricType)
+	require.NoError(t, err)
+	require.NoError(t, fs.Save())
+
+	// Smaller snapshot replaces previous one completely.
+	m, err := getMetricsFromFile(outFile)
+	require.NoError(t, err)
+	require.Len(t, m, 1)
+
+	restored := NewCommonMetricsRepository()
+	_, err = NewFileSaver(restored, outFile, SnapshotOptions{Keep: 2}, logger).Load()
+	require.NoError(t, err)
+	all, err := restored.GetAll()
+	require.NoError(t, err)
+	require.Len(t, all, 1)
+	require.Equal(t, int64(2), *all[0].Delta)
+
+	// Damaged newest snapshot is skipped in favor of the older one.
+	f, err := os.OpenFile(outFile, os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = f.WriteAt([]byte("XX"), snapshotHeaderSize+1)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	restored = NewCommonMetricsRepository()
+	_, err = NewFileSaver(restored, outFile, SnapshotOptions{Keep: 2}, logger).Load()
+	require.NoError(t, err)
+	all, err = restored.GetAll()
+	require.NoError(t, err)
+	require.Len(t, all, 20)
+
+	_, err = NewFileSaver(NewCommonMetricsRepository(), outFile, SnapshotOptions{Keep: 1}, logger).Load()
+	require.Error(t, err)
+}
+
+func Test_FileSaverLoadLegacy(t *testing.T) {
+	outFile := filepath.Join(t.TempDir(), "metrics.json")
+	require.NoError(t, os.WriteFile(outFile, []byte(`[{"id":"c","type":"counter","delta":3}]`), 0644))
+
+	repo := NewCommonMetricsRepository()
+	_, err := NewFileSaver(repo, outFile, SnapshotOptions{Keep: 1}, log.NewDummyLogger()).Load()
+	require.NoError(t, err)
+	m, err := repo.Get("c", metrics.CounterMetricType)
+	require.NoError(t, err)
+	require.Equal(t, int64(3), *m.Delta)
+}
+
+func Test_FileSaverFormats(t *testing.T) {
+	tests := []SnapshotOptions{
+		{Format: config.StoreFormatJSON},
+		{Format: config.StoreFormatJSON, Compression: "gzip"},
+		{Format: config.StoreFormatBinary},
+		{Format: config.StoreFormatBinary, Compression: "gzip"},
+	}
+
+	for _, opts := range tests {
+		t.Run(opts.Format+opts.Compression, func(t *testing.T) {
+			outFile := filepath.Join(t.TempDir(), "metrics.snapshot")
+			repo := NewCommonMetricsRepository()
+			for i := 0; i < 2*snapshotRestoreBatch+1; i++ {
+				_, err := repo.AddOrUpdate(fmt.Sprintf("c%v", i), strconv.Itoa(i), metrics.CounterMetricType)
+				require.NoError(t, err)
+			}
+			_, err := repo.AddOrUpdate("g", "-1.25", metrics.GaugeMetricType)
+			require.NoError(t, err)
+
+			require.NoError(t, NewFileSaver(repo, outFile, opts, log.NewDummyLogger()).Save())
+
+			// Format is taken from snapshot header, not from options.
+			restored := NewCommonMetricsRepository()
+			_, err = NewFileSaver(restored, outFile, SnapshotOptions{}, log.NewDummyLogger()).Load()
+			require.NoError(t, err)
+
+			expected, err := repo.GetAll()
+			require.NoError(t, err)
+			all, err := restored.GetAll()
+			require.NoError(t, err)
+			require.ElementsMatch(t, expected, all)
+		})
+	}
+
+	_, err := snapshotFlags(SnapshotOptions{Format: "xml"})
+	require.Error(t, err)
+	_, err = snapshotFlags(SnapshotOptions{Compression: "lz4"})
+	require.Error(t, err)
+}
+
+// streamOnlyRepository fails to collect all metrics at once.
+type streamOnlyRepository struct {
+	*CommonMetricsRepository
+}
+
+func (r streamOnlyRepository) GetAll() ([]metrics.Metric, error) {
+	return nil, errors.New("metrics aren't collected for snapshot")
+}
+
+func (r streamOnlyRepository) Save(w io.Writer) error {
+	return errors.New("snapshot isn't encoded at once")
+}
+
+func Test_FileSaverStreams(t *testing.T) {
+	for _, format := range []string{config.StoreFormatBinary, config.StoreFormatJSON} {
+		t.Run(format, func(t *testing.T) {
+			outFile := filepath.Join(t.TempDir(), "metrics.snapshot")
+			repo := streamOnlyRepository{NewCommonMetricsRepository()}
+			_, err := repo.AddOrUpdate("c", "5", metrics.CounterMetricType)
+			require.NoError(t, err)
+
+			opts := SnapshotOptions{Format: format}
+			require.NoError(t, NewFileSaver(repo, outFile, opts, log.NewDummyLogger()).Save())
+
+			restored := NewCommonMetricsRepository()
+			_, err = NewFileSaver(restored, outFile, opts, log.NewDummyLogger()).Load()
+			require.NoError(t, err)
+			require.Equal(t, int64(5), getCounterValue(t, restored, "c"))
+		})
+	}
+}
+
+func Test_JSONEncoder(t *testing.T) {
+	for _, data := range [][]metrics.Metric{
+		{},
+		{metrics.NewCounterMetric("c", 5), metrics.NewGaugeMetric("g", 1.5)},
+	} {
+		buf := bytes.Buffer{}
+		enc := newJSONEncoder(&buf)
+		for _, m := range data {
+			require.NoError(t, enc.Encode(m))
+		}
+		require.NoError(t, enc.Flush())
+
+		decoded := make([]metrics.Metric, 0)
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &decoded))
+		require.Equal(t, data, decoded)
+	}
+}
+
+func Test_BinaryDecoderTruncated(t *testing.T) {
+	buf := bytes.Buffer{}
+	enc := newBinaryEncoder(&buf)
+	require.NoError(t, enc.Encode(metrics.NewGaugeMetric("g", 1)))
+	require.NoError(t, enc.Flush())
+
+	dec := newBinaryDecoder(bytes.NewReader(buf.Bytes()[:buf.Len()-1]))
+	_, err := dec.Decode()
+	require.Error(t, err)
+	require.NotErrorIs(t, err, io.EOF)
+
+	dec = newBinaryDecoder(bytes.NewReader(buf.Bytes()))
+	m, err := dec.Decode()
+	require.NoError(t, err)
+	require.Equal(t, 1.0, *m.Value)
+	_, err = dec.Decode()
+	require.ErrorIs(t, err, io.EOF)
+}
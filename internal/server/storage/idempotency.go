@@ -0,0 +1,295 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/fuzzy-toozy/metrics-service/internal/server/errtypes"
+)
+
+var (
+	// ErrRequestInProgress request with the same key is being processed.
+	ErrRequestInProgress = errors.New("request is in progress")
+	// ErrRequestApplied request with the same key is already applied.
+	ErrRequestApplied = errors.New("request is already applied")
+)
+
+// RequestKey identifies request of agent.
+type RequestKey struct {
+	AgentID   string
+	RequestID string
+}
+
+// StoredResponse response of applied request returned for its duplicates.
+type StoredResponse struct {
+	ContentType string
+	Body        []byte
+	Status      int
+}
+
+// IdempotencyStore remembers responses of recently applied requests, so retried requests aren't applied twice.
+type IdempotencyStore interface {
+	// Begin claims request key. Returns stored response if request was completed already
+	// or ErrRequestInProgress if it's being processed.
+	Begin(key RequestKey) (*StoredResponse, error)
+	// Complete stores response of claimed request.
+	Complete(key RequestKey, resp StoredResponse) error
+	// Abort releases claim of failed request, so it can be retried.
+	Abort(key RequestKey) error
+}
+
+// RequestRecorder is implemented by repositories recording applied requests together with their updates,
+// so request isn't applied twice even if server fails before its response is stored.
+type RequestRecorder interface {
+	// ForRequest returns repository recording key in transaction of update,
+	// update fails with ErrRequestApplied conflict error if key is already recorded.
+	ForRequest(key RequestKey) Repository
+}
+
+// ForRequest returns repository recording key with its updates if r is RequestRecorder, r otherwise.
+func ForRequest(r Repository, key RequestKey) Repository {
+	if recorder, ok := r.(RequestRecorder); ok {
+		return recorder.ForRequest(key)
+	}
+	return r
+}
+
+type idempotencyEntry struct {
+	created time.Time
+	resp    *StoredResponse
+}
+
+type agentRequests struct {
+	entries map[string]*idempotencyEntry
+	// order request IDs in order of claim, used to evict the oldest ones.
+	order []string
+}
+
+// DefaultIdempotencyKeysPerAgent default number of requests remembered per agent in memory.
+const DefaultIdempotencyKeysPerAgent = 1024
+
+// expirePeriods expired requests are removed at most once per ttl/expirePeriods.
+const expirePeriods = 10
+
+// MemoryIdempotencyStore keeps up to maxPerAgent requests of every agent for ttl.
+type MemoryIdempotencyStore struct {
+	agents      map[string]*agentRequests
+	lastExpire  time.Time
+	lock        sync.Mutex
+	ttl         time.Duration
+	maxPerAgent int
+}
+
+func NewMemoryIdempotencyStore(ttl time.Duration, maxPerAgent int) *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{agents: make(map[string]*agentRequests), ttl: ttl, maxPerAgent: maxPerAgent}
+}
+
+// evict removes expired and excess requests of agent starting from the oldest ones,
+// requests in progress are kept until they expire.
+func (s *MemoryIdempotencyStore) evict(a *agentRequests, now time.Time) {
+	for len(a.order) > 0 {
+		id := a.order[0]
+		e, ok := a.entries[id]
+		if ok && now.Sub(e.created) <= s.ttl && (len(a.entries) <= s.maxPerAgent || e.resp == nil) {
+			return
+		}
+
+		delete(a.entries, id)
+		a.order = a.order[1:]
+	}
+}
+
+// expire removes expired requests of all agents and agents left without requests.
+func (s *MemoryIdempotencyStore) expire(now time.Time) {
+	if now.Sub(s.lastExpire) < s.ttl/expirePeriods {
+		return
+	}
+	s.lastExpire = now
+
+	for id, a := range s.agents {
+		s.evict(a, now)
+		if len(a.entries) == 0 {
+			delete(s.agents, id)
+		}
+	}
+}
+
+func (s *MemoryIdempotencyStore) Begin(key RequestKey) (*StoredResponse, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := time.Now()
+	s.expire(now)
+
+	a, ok := s.agents[key.AgentID]
+	if !ok {
+		a = &agentRequests{entries: make(map[string]*idempotencyEntry)}
+		s.agents[key.AgentID] = a
+	}
+	s.evict(a, now)
+
+	if e, ok := a.entries[key.RequestID]; ok {
+		if e.resp == nil {
+			return nil, ErrRequestInProgress
+		}
+		return e.resp, nil
+	}
+
+	a.entries[key.RequestID] = &idempotencyEntry{created: now}
+	a.order = append(a.order, key.RequestID)
+	s.evict(a, now)
+
+	return nil, nil
+}
+
+func (s *MemoryIdempotencyStore) Complete(key RequestKey, resp StoredResponse) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	a, ok := s.agents[key.AgentID]
+	if !ok {
+		return fmt.Errorf("request '%v' of agent '%v' isn't claimed", key.RequestID, key.AgentID)
+	}
+
+	e, ok := a.entries[key.RequestID]
+	if !ok {
+		return fmt.Errorf("request '%v' of agent '%v' isn't claimed", key.RequestID, key.AgentID)
+	}
+
+	e.resp = &resp
+	return nil
+}
+
+func (s *MemoryIdempotencyStore) Abort(key RequestKey) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if a, ok := s.agents[key.AgentID]; ok {
+		if e, ok := a.entries[key.RequestID]; ok && e.resp == nil {
+			delete(a.entries, key.RequestID)
+		}
+		if len(a.entries) == 0 {
+			delete(s.agents, key.AgentID)
+		}
+	}
+
+	return nil
+}
+
+const (
+	claimRequestQuery = "INSERT INTO idempotency_keys (agent_id, request_id) VALUES ($1, $2)" +
+		" ON CONFLICT (agent_id, request_id) DO NOTHING"
+	getResponseQuery = "SELECT status, content_type, body FROM idempotency_keys" +
+		" WHERE agent_id = $1 AND request_id = $2"
+	completeRequestQuery = "UPDATE idempotency_keys SET status = $3, content_type = $4, body = $5" +
+		" WHERE agent_id = $1 AND request_id = $2"
+	expireRequestsQuery = "DELETE FROM idempotency_keys WHERE created_at < $1"
+)
+
+// PGIdempotencyStore keeps requests in database for ttl, so duplicates are detected by all servers sharing it.
+// Requests are recorded by repository returned from PGMetricRepository.ForRequest in transaction of their update,
+// store only looks them up and keeps their responses.
+type PGIdempotencyStore struct {
+	db         *sql.DB
+	lastExpire time.Time
+	lock       sync.Mutex
+	ttl        time.Duration
+	timeout    time.Duration
+}
+
+// IdempotencyStore returns store of requests kept in repository database.
+func (r *PGMetricRepository) IdempotencyStore(ttl time.Duration) *PGIdempotencyStore {
+	return &PGIdempotencyStore{db: r.db, ttl: ttl, timeout: r.dbConfig.PingTimeout}
+}
+
+// ForRequest returns repository recording key in transaction of its update.
+func (r *PGMetricRepository) ForRequest(key RequestKey) Repository {
+	scoped := *r
+	scoped.request = &key
+	return &scoped
+}
+
+// claimRequest records request key in tx, fails if request is already applied.
+func (r *PGMetricRepository) claimRequest(ctx context.Context, tx *sql.Tx) error {
+	res, err := tx.ExecContext(ctx, claimRequestQuery, r.request.AgentID, r.request.RequestID)
+	if err != nil {
+		return errtypes.MakeServerError(fmt.Errorf("failed to claim request: %w", err))
+	}
+
+	claimed, err := res.RowsAffected()
+	if err != nil {
+		return errtypes.MakeServerError(fmt.Errorf("failed to claim request: %w", err))
+	}
+
+	if claimed == 0 {
+		return errtypes.MakeConflictError(fmt.Errorf("request '%v' of agent '%v': %w",
+			r.request.RequestID, r.request.AgentID, ErrRequestApplied))
+	}
+
+	return nil
+}
+
+// expire deletes expired requests at most once per ttl/expirePeriods.
+func (s *PGIdempotencyStore) expire(ctx context.Context) error {
+	s.lock.Lock()
+	now := time.Now()
+	if now.Sub(s.lastExpire) < s.ttl/expirePeriods {
+		s.lock.Unlock()
+		return nil
+	}
+	s.lastExpire = now
+	s.lock.Unlock()
+
+	_, err := s.db.ExecContext(ctx, expireRequestsQuery, now.Add(-s.ttl))
+	return err
+}
+
+// Begin returns stored response of applied request.
+// Request applied without stored response gets empty successful response.
+func (s *PGIdempotencyStore) Begin(key RequestKey) (*StoredResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	if err := s.expire(ctx); err != nil {
+		return nil, fmt.Errorf("failed to expire requests: %w", err)
+	}
+
+	var status sql.NullInt64
+	var contentType sql.NullString
+	var body []byte
+	err := s.db.QueryRowContext(ctx, getResponseQuery, key.AgentID, key.RequestID).Scan(&status, &contentType, &body)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get stored response: %w", err)
+	}
+
+	if !status.Valid {
+		return &StoredResponse{Status: http.StatusOK}, nil
+	}
+
+	return &StoredResponse{Status: int(status.Int64), ContentType: contentType.String, Body: body}, nil
+}
+
+func (s *PGIdempotencyStore) Complete(key RequestKey, resp StoredResponse) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, completeRequestQuery, key.AgentID, key.RequestID, resp.Status, resp.ContentType, resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to store response: %w", err)
+	}
+
+	return nil
+}
+
+// Abort does nothing, requests are recorded only if their updates are committed.
+func (s *PGIdempotencyStore) Abort(_ RequestKey) error {
+	return nil
+}
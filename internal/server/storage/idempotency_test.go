@@ -0,0 +1,159 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/beevik/guid"
+	"github.com/fuzzy-toozy/metrics-service/internal/log"
+	"github.com/fuzzy-toozy/metrics-service/internal/metrics"
+	"github.com/fuzzy-toozy/metrics-service/internal/server/errtypes"
+	"github.com/stretchr/testify/require"
+)
+
+func testIdempotencyStore(t *testing.T, store IdempotencyStore) {
+	agent := guid.NewString()
+	key := RequestKey{AgentID: agent, RequestID: "1"}
+
+	stored, err := store.Begin(key)
+	require.NoError(t, err)
+	require.Nil(t, stored)
+
+	_, err = store.Begin(key)
+	require.ErrorIs(t, err, ErrRequestInProgress)
+
+	resp := StoredResponse{Status: 200, ContentType: "application/json", Body: []byte(`{"id":"c"}`)}
+	require.NoError(t, store.Complete(key, resp))
+
+	stored, err = store.Begin(key)
+	require.NoError(t, err)
+	require.NotNil(t, stored)
+	require.Equal(t, resp, *stored)
+
+	// Completed request can't be aborted.
+	require.NoError(t, store.Abort(key))
+	stored, err = store.Begin(key)
+	require.NoError(t, err)
+	require.NotNil(t, stored)
+
+	// Aborted request can be claimed again.
+	key.RequestID = "2"
+	stored, err = store.Begin(key)
+	require.NoError(t, err)
+	require.Nil(t, stored)
+	require.NoError(t, store.Abort(key))
+	stored, err = store.Begin(key)
+	require.NoError(t, err)
+	require.Nil(t, stored)
+
+	// Request IDs are scoped by agent.
+	stored, err = store.Begin(RequestKey{AgentID: guid.NewString(), RequestID: "1"})
+	require.NoError(t, err)
+	require.Nil(t, stored)
+}
+
+func Test_MemoryIdempotencyStore(t *testing.T) {
+	testIdempotencyStore(t, NewMemoryIdempotencyStore(time.Minute, DefaultIdempotencyKeysPerAgent))
+}
+
+func Test_MemoryIdempotencyStoreEviction(t *testing.T) {
+	store := NewMemoryIdempotencyStore(time.Minute, 2)
+	complete := func(id string) {
+		key := RequestKey{AgentID: "agent", RequestID: id}
+		_, err := store.Begin(key)
+		require.NoError(t, err)
+		require.NoError(t, store.Complete(key, StoredResponse{Status: 200}))
+	}
+
+	complete("1")
+	complete("2")
+	complete("3")
+
+	// The oldest request is forgotten once limit is reached.
+	stored, err := store.Begin(RequestKey{AgentID: "agent", RequestID: "1"})
+	require.NoError(t, err)
+	require.Nil(t, stored)
+
+	stored, err = store.Begin(RequestKey{AgentID: "agent", RequestID: "3"})
+	require.NoError(t, err)
+	require.NotNil(t, stored)
+
+	store = NewMemoryIdempotencyStore(time.Millisecond, DefaultIdempotencyKeysPerAgent)
+	complete("1")
+	time.Sleep(2 * time.Millisecond)
+
+	stored, err = store.Begin(RequestKey{AgentID: "agent", RequestID: "1"})
+	require.NoError(t, err)
+	require.Nil(t, stored)
+}
+
+func Test_MemoryIdempotencyStoreAgentEviction(t *testing.T) {
+	store := NewMemoryIdempotencyStore(time.Millisecond, DefaultIdempotencyKeysPerAgent)
+	for _, agent := range []string{"a", "b"} {
+		key := RequestKey{AgentID: agent, RequestID: "1"}
+		_, err := store.Begin(key)
+		require.NoError(t, err)
+		require.NoError(t, store.Complete(key, StoredResponse{Status: 200}))
+	}
+
+	// Aborted request leaves no agent behind.
+	key := RequestKey{AgentID: "c", RequestID: "1"}
+	_, err := store.Begin(key)
+	require.NoError(t, err)
+	require.NoError(t, store.Abort(key))
+	require.Len(t, store.agents, 2)
+
+	// Agents without requests are forgotten.
+	time.Sleep(2 * time.Millisecond)
+	_, err = store.Begin(RequestKey{AgentID: "d", RequestID: "1"})
+	require.NoError(t, err)
+	require.Len(t, store.agents, 1)
+}
+
+func Test_PGIdempotencyStore(t *testing.T) {
+	repo := newTestPGRepository(t, log.NewDummyLogger())
+	t.Cleanup(func() {
+		require.NoError(t, repo.Close())
+	})
+	store := repo.IdempotencyStore(time.Minute)
+
+	counter := guid.NewString()
+	key := RequestKey{AgentID: guid.NewString(), RequestID: "1"}
+
+	stored, err := store.Begin(key)
+	require.NoError(t, err)
+	require.Nil(t, stored)
+
+	// Failed update doesn't record request.
+	_, err = repo.ForRequest(key).AddOrUpdate(counter, "1.5", metrics.CounterMetricType)
+	require.ErrorAs(t, err, &errtypes.BadDataError{})
+	stored, err = store.Begin(key)
+	require.NoError(t, err)
+	require.Nil(t, stored)
+
+	// Request is recorded with its update, so it's applied once even if response isn't stored.
+	require.NoError(t, repo.ForRequest(key).AddMetricsBulk([]metrics.Metric{metrics.NewCounterMetric(counter, 5)}))
+	stored, err = store.Begin(key)
+	require.NoError(t, err)
+	require.Equal(t, &StoredResponse{Status: 200}, stored)
+
+	_, err = repo.ForRequest(key).AddOrUpdate(counter, "5", metrics.CounterMetricType)
+	require.ErrorAs(t, err, &errtypes.ConflictError{})
+	require.ErrorIs(t, err, ErrRequestApplied)
+	require.Equal(t, int64(5), getCounterValue(t, repo, counter))
+
+	resp := StoredResponse{Status: 200, ContentType: "application/json", Body: []byte(`{"id":"c"}`)}
+	require.NoError(t, store.Complete(key, resp))
+	require.NoError(t, store.Abort(key))
+	stored, err = store.Begin(key)
+	require.NoError(t, err)
+	require.Equal(t, resp, *stored)
+
+	// Request IDs are scoped by agent.
+	other := RequestKey{AgentID: guid.NewString(), RequestID: "1"}
+	stored, err = store.Begin(other)
+	require.NoError(t, err)
+	require.Nil(t, stored)
+	require.NoError(t, repo.ForRequest(other).AddMetricsBulk([]metrics.Metric{metrics.NewCounterMetric(counter, 5)}))
+	require.Equal(t, int64(10), getCounterValue(t, repo, counter))
+}
@@ -0,0 +1,273 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	logging "github.com/fuzzy-toozy/metrics-service/internal/log"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockID key of advisory lock held while migrations are applied,
+// so concurrently starting servers don't race.
+const migrationLockID = 7354201893
+
+const (
+	createSchemaVersionQuery = "CREATE TABLE IF NOT EXISTS schema_version(" +
+		" version INTEGER PRIMARY KEY," +
+		" applied_at TIMESTAMPTZ NOT NULL DEFAULT now()" +
+		")"
+	currentVersionQuery = "SELECT COALESCE(MAX(version), 0) FROM schema_version"
+	insertVersionQuery  = "INSERT INTO schema_version (version) VALUES ($1)"
+	deleteVersionQuery  = "DELETE FROM schema_version WHERE version = $1"
+	lockQuery           = "SELECT pg_advisory_lock($1)"
+	unlockQuery         = "SELECT pg_advisory_unlock($1)"
+)
+
+// Migration single schema change. Migrations are stored in migrations directory
+// as <version>_<name>.up.sql and <version>_<name>.down.sql files.
+type Migration struct {
+	Name    string
+	Up      string
+	Down    string
+	Version int
+}
+
+// MigrationStatus current schema version and migrations not applied yet.
+type MigrationStatus struct {
+	Pending []Migration
+	Current int
+	Latest  int
+}
+
+// LoadMigrations returns embedded migrations ordered by version.
+func LoadMigrations() ([]Migration, error) {
+	return loadMigrations(migrationFiles, "migrations")
+}
+
+func loadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, e := range entries {
+		name := e.Name()
+		var up bool
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			up = true
+		case strings.HasSuffix(name, ".down.sql"):
+		default:
+			continue
+		}
+
+		base := strings.TrimSuffix(strings.TrimSuffix(name, ".up.sql"), ".down.sql")
+		versionStr, title, ok := strings.Cut(base, "_")
+		if !ok {
+			return nil, fmt.Errorf("invalid migration file name '%v'", name)
+		}
+
+		version, err := strconv.Atoi(versionStr)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("invalid migration version in '%v'", name)
+		}
+
+		data, err := fs.ReadFile(fsys, path.Join(dir, name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration '%v': %w", name, err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: title}
+			byVersion[version] = m
+		} else if m.Name != title {
+			return nil, fmt.Errorf("conflicting migrations '%v' and '%v' for version %v", m.Name, title, version)
+		}
+
+		if up {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+
+	res := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if len(m.Up) == 0 {
+			return nil, fmt.Errorf("migration %v_%v has no up script", m.Version, m.Name)
+		}
+		res = append(res, *m)
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i].Version < res[j].Version })
+
+	for i, m := range res {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("migration version %v is missing", i+1)
+		}
+	}
+
+	return res, nil
+}
+
+// Migrator applies schema migrations to database.
+type Migrator struct {
+	db         *sql.DB
+	log        logging.Logger
+	migrations []Migration
+}
+
+// NewMigrator creates migrator of embedded migrations.
+func NewMigrator(db *sql.DB, log logging.Logger) (*Migrator, error) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, log: log, migrations: migrations}, nil
+}
+
+// withLock runs f on dedicated connection holding migration advisory lock.
+func (m *Migrator) withLock(ctx context.Context, f func(conn *sql.Conn) error) (err error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get database connection: %w", err)
+	}
+	defer func() {
+		if errc := conn.Close(); errc != nil {
+			m.log.Errorf("Failed to close migration connection: %v", errc)
+		}
+	}()
+
+	if _, err = conn.ExecContext(ctx, lockQuery, migrationLockID); err != nil {
+		return fmt.Errorf("failed to acquire migration lock: %w", err)
+	}
+	defer func() {
+		// Lock is released with session anyway, so failure is only logged.
+		if _, erru := conn.ExecContext(context.Background(), unlockQuery, migrationLockID); erru != nil {
+			m.log.Errorf("Failed to release migration lock: %v", erru)
+		}
+	}()
+
+	if _, err = conn.ExecContext(ctx, createSchemaVersionQuery); err != nil {
+		return fmt.Errorf("failed to create schema version table: %w", err)
+	}
+
+	return f(conn)
+}
+
+func currentVersion(ctx context.Context, conn *sql.Conn) (int, error) {
+	var version int
+	if err := conn.QueryRowContext(ctx, currentVersionQuery).Scan(&version); err != nil {
+		return 0, fmt.Errorf("failed to get schema version: %w", err)
+	}
+	return version, nil
+}
+
+// apply runs migration script and records version change in one transaction.
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, script string, versionQuery string, version int) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if errd := tx.Rollback(); errd != nil && !errors.Is(errd, sql.ErrTxDone) {
+			m.log.Errorf("Failed to rollback tx: %v", errd)
+		}
+	}()
+
+	if _, err = tx.ExecContext(ctx, script); err != nil {
+		return err
+	}
+
+	if _, err = tx.ExecContext(ctx, versionQuery, version); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Up applies all pending migrations.
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.withLock(ctx, func(conn *sql.Conn) error {
+		current, err := currentVersion(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		if current > len(m.migrations) {
+			return fmt.Errorf("database schema version %v is newer than supported %v", current, len(m.migrations))
+		}
+
+		for _, mig := range m.migrations[current:] {
+			if err = m.apply(ctx, conn, mig.Up, insertVersionQuery, mig.Version); err != nil {
+				return fmt.Errorf("failed to apply migration %v_%v: %w", mig.Version, mig.Name, err)
+			}
+			m.log.Infof("Applied migration %v_%v", mig.Version, mig.Name)
+		}
+
+		return nil
+	})
+}
+
+// Down reverts the last steps applied migrations.
+func (m *Migrator) Down(ctx context.Context, steps int) error {
+	return m.withLock(ctx, func(conn *sql.Conn) error {
+		current, err := currentVersion(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		if current > len(m.migrations) {
+			return fmt.Errorf("database schema version %v is newer than supported %v", current, len(m.migrations))
+		}
+
+		for ; steps > 0 && current > 0; steps-- {
+			mig := m.migrations[current-1]
+			if len(mig.Down) == 0 {
+				return fmt.Errorf("migration %v_%v can't be reverted", mig.Version, mig.Name)
+			}
+
+			if err = m.apply(ctx, conn, mig.Down, deleteVersionQuery, mig.Version); err != nil {
+				return fmt.Errorf("failed to revert migration %v_%v: %w", mig.Version, mig.Name, err)
+			}
+			m.log.Infof("Reverted migration %v_%v", mig.Version, mig.Name)
+			current--
+		}
+
+		return nil
+	})
+}
+
+// Status returns current schema version and pending migrations.
+func (m *Migrator) Status(ctx context.Context) (MigrationStatus, error) {
+	var status MigrationStatus
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		current, err := currentVersion(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		status.Current = current
+		status.Latest = len(m.migrations)
+		if current < len(m.migrations) {
+			status.Pending = m.migrations[current:]
+		}
+
+		return nil
+	})
+
+	return status, err
+}
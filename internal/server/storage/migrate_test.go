@@ -0,0 +1,41 @@
+package storage
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_LoadMigrations(t *testing.T) {
+	migrations, err := LoadMigrations()
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+	for i, m := range migrations {
+		require.Equal(t, i+1, m.Version)
+		require.NotEmpty(t, m.Up)
+		require.NotEmpty(t, m.Down)
+	}
+
+	fsys := fstest.MapFS{
+		"m/0002_add.up.sql":    {Data: []byte("ALTER")},
+		"m/0001_init.up.sql":   {Data: []byte("CREATE")},
+		"m/0001_init.down.sql": {Data: []byte("DROP")},
+		"m/README.md":          {Data: []byte("docs")},
+	}
+	migrations, err = loadMigrations(fsys, "m")
+	require.NoError(t, err)
+	require.Len(t, migrations, 2)
+	require.Equal(t, Migration{Version: 1, Name: "init", Up: "CREATE", Down: "DROP"}, migrations[0])
+	require.Equal(t, "add", migrations[1].Name)
+	require.Empty(t, migrations[1].Down)
+
+	_, err = loadMigrations(fstest.MapFS{"m/0002_add.up.sql": {Data: []byte("ALTER")}}, "m")
+	require.Error(t, err)
+
+	_, err = loadMigrations(fstest.MapFS{"m/0001_init.down.sql": {Data: []byte("DROP")}}, "m")
+	require.Error(t, err)
+
+	_, err = loadMigrations(fstest.MapFS{"m/init.up.sql": {Data: []byte("CREATE")}}, "m")
+	require.Error(t, err)
+}
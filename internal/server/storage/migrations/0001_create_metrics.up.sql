@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS Metrics(
+    name VARCHAR(250) PRIMARY KEY,
+    type VARCHAR(50),
+    value DOUBLE PRECISION,
+    delta BIGINT,
+    CONSTRAINT EITHER_VALUE check(value IS NOT NULL OR delta IS NOT NULL)
+);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS idempotency_keys(
+    agent_id VARCHAR(250) NOT NULL,
+    request_id VARCHAR(250) NOT NULL,
+    status INTEGER,
+    content_type VARCHAR(250),
+    body BYTEA,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (agent_id, request_id)
+);
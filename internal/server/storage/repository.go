@@ -50,54 +50,84 @@ func (r *CommonMetricsRepository) GetAll() ([]metrics.Metric, error) {
 	return res, nil
 }
 
+// SnapshotMetrics passes stored metrics to fn holding read lock, so snapshot is consistent.
+func (r *CommonMetricsRepository) SnapshotMetrics(fn func(m metrics.Metric) error) error {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	for _, m := range r.storage {
+		if err := fn(m); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (r *CommonMetricsRepository) Close() error {
 	return nil
 }
 
-func (r *CommonMetricsRepository) AddMetricsBulk(metrics []metrics.Metric) error {
-	for i, m := range metrics {
-		val, err := m.GetData()
-		if err != nil {
-			return err
-		}
-		uVal, err := r.AddOrUpdate(m.ID, val, m.MType)
-		if err != nil {
-			return err
+// AddMetricsBulk applies all metrics under single lock. In case any metric is invalid
+// nothing is stored and BulkUpdateError lists rejected metrics.
+func (r *CommonMetricsRepository) AddMetricsBulk(data []metrics.Metric) error {
+	r.lock.Lock()
+	staged, values, err := stageMetricsBulk(data, func(key string) (metrics.Metric, bool) {
+		m, ok := r.storage[key]
+		return m, ok
+	})
+	if err == nil {
+		for k, m := range staged {
+			r.storage[k] = m
 		}
+	}
+	r.lock.Unlock()
 
-		err = metrics[i].SetData(uVal)
-		if err != nil {
-			return err
-		}
+	if err != nil {
+		return err
 	}
-	return nil
+
+	return setBulkValues(data, values)
 }
 
 func (r *CommonMetricsRepository) AddOrUpdate(key string, val string, mtype string) (string, error) {
 	r.lock.Lock()
 	defer r.lock.Unlock()
+	return updateStoredMetric(r.storage, key, val, mtype)
+}
+
+// typeMismatchError rejects update of stored metric with value of different type.
+func typeMismatchError(key string, mtype string) error {
+	return errtypes.MakeBadDataError(fmt.Errorf("metric '%v' is already stored with type other than %v", key, mtype))
+}
+
+// updateStoredMetric adds metric to storage or updates stored one, caller must hold storage lock.
+func updateStoredMetric(storage map[string]metrics.Metric, key string, val string, mtype string) (string, error) {
 	if !metrics.IsValidMetricType(mtype) {
 		return "", errtypes.MakeBadDataError(fmt.Errorf("invalid metric type %v", mtype))
 	}
 
-	m, ok := r.storage[key]
+	m, ok := storage[key]
 	if !ok {
 		var err error
 		m, err = metrics.NewMetric(key, val, mtype)
 		if err != nil {
 			return "", errtypes.MakeBadDataError(err)
 		}
-		r.storage[key] = m
+		storage[key] = m
 		return val, nil
 	}
 
+	if m.MType != mtype {
+		return "", typeMismatchError(key, mtype)
+	}
+
 	err := m.UpdateData(val)
 
 	if err != nil {
 		return "", errtypes.MakeBadDataError(err)
 	}
 
-	r.storage[key] = m
+	storage[key] = m
 
 	val, err = m.GetData()
 
@@ -135,6 +165,38 @@ func (r *CommonMetricsRepository) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// RestoreMetrics stores metrics replacing existing ones with the same IDs.
+func (r *CommonMetricsRepository) RestoreMetrics(data []metrics.Metric) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for _, m := range data {
+		if !metrics.IsValidMetricType(m.MType) {
+			return fmt.Errorf("invalid metric type '%v' for metric '%v'", m.MType, m.ID)
+		}
+		r.storage[m.ID] = m
+	}
+
+	return nil
+}
+
+// ReplaceMetrics replaces all stored metrics with data.
+func (r *CommonMetricsRepository) ReplaceMetrics(data []metrics.Metric) error {
+	storage := make(map[string]metrics.Metric, len(data))
+	for _, m := range data {
+		if _, err := m.GetData(); err != nil {
+			return errtypes.MakeBadDataError(err)
+		}
+		storage[m.ID] = m
+	}
+
+	r.lock.Lock()
+	r.storage = storage
+	r.lock.Unlock()
+
+	return nil
+}
+
 func (r *CommonMetricsRepository) Delete(key string) error {
 	r.lock.Lock()
 	defer r.lock.Unlock()
@@ -166,5 +228,10 @@ func (r *CommonMetricsRepository) Load(reader io.Reader) error {
 	if err != nil {
 		return err
 	}
-	return json.Unmarshal(b.Bytes(), &r)
+
+	if err = json.Unmarshal(b.Bytes(), &r); err != nil {
+		return errtypes.MakeBadDataError(err)
+	}
+
+	return nil
 }
@@ -21,7 +21,10 @@ type MetricTestData struct {
 }
 
 func Test_MetricsRepo(t *testing.T) {
-	repo := NewCommonMetricsRepository()
+	testMetricsRepo(t, NewCommonMetricsRepository())
+}
+
+func testMetricsRepo(t *testing.T, repo Repository) {
 	data := []MetricTestData{
 		{
 			name:              "m1",
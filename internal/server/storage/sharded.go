@@ -0,0 +1,263 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/fuzzy-toozy/metrics-service/internal/metrics"
+	"github.com/fuzzy-toozy/metrics-service/internal/server/errtypes"
+)
+
+type metricsShard struct {
+	storage map[string]metrics.Metric
+	lock    sync.RWMutex
+}
+
+// ShardedMetricsRepository in-memory repository partitioned by metric ID hash.
+// Every shard has its own lock, so updates of different metrics rarely contend.
+type ShardedMetricsRepository struct {
+	shards []metricsShard
+}
+
+// NewShardedMetricsRepository creates repository with shardsCount shards (at least one).
+func NewShardedMetricsRepository(shardsCount int) *ShardedMetricsRepository {
+	if shardsCount <= 0 {
+		shardsCount = 1
+	}
+
+	r := ShardedMetricsRepository{shards: make([]metricsShard, shardsCount)}
+	for i := range r.shards {
+		r.shards[i].storage = make(map[string]metrics.Metric)
+	}
+
+	return &r
+}
+
+// shardIndex returns shard of metric, FNV-1a hash is calculated inline to avoid allocations.
+func (r *ShardedMetricsRepository) shardIndex(key string) int {
+	const (
+		offset = 2166136261
+		prime  = 16777619
+	)
+
+	h := uint32(offset)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= prime
+	}
+
+	return int(h % uint32(len(r.shards)))
+}
+
+func (r *ShardedMetricsRepository) shard(key string) *metricsShard {
+	return &r.shards[r.shardIndex(key)]
+}
+
+// groupByShard returns indexes of data items grouped by shard preserving their order.
+func (r *ShardedMetricsRepository) groupByShard(data []metrics.Metric) [][]int {
+	groups := make([][]int, len(r.shards))
+	for i := range data {
+		idx := r.shardIndex(data[i].ID)
+		groups[idx] = append(groups[idx], i)
+	}
+	return groups
+}
+
+func (r *ShardedMetricsRepository) HealthCheck() error {
+	return nil
+}
+
+func (r *ShardedMetricsRepository) Close() error {
+	return nil
+}
+
+func (r *ShardedMetricsRepository) AddOrUpdate(key string, val string, mtype string) (string, error) {
+	s := r.shard(key)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return updateStoredMetric(s.storage, key, val, mtype)
+}
+
+// AddMetricsBulk applies metrics holding locks of all affected shards, so batch is applied atomically.
+// In case any metric is invalid nothing is stored and BulkUpdateError lists rejected metrics.
+func (r *ShardedMetricsRepository) AddMetricsBulk(data []metrics.Metric) error {
+	affected := make([]bool, len(r.shards))
+	for i := range data {
+		affected[r.shardIndex(data[i].ID)] = true
+	}
+
+	// Shards are locked in index order, so concurrent batches can't deadlock.
+	for i, ok := range affected {
+		if ok {
+			r.shards[i].lock.Lock()
+		}
+	}
+
+	staged, values, err := stageMetricsBulk(data, func(key string) (metrics.Metric, bool) {
+		m, ok := r.shard(key).storage[key]
+		return m, ok
+	})
+	if err == nil {
+		for k, m := range staged {
+			r.shard(k).storage[k] = m
+		}
+	}
+
+	for i, ok := range affected {
+		if ok {
+			r.shards[i].lock.Unlock()
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return setBulkValues(data, values)
+}
+
+func (r *ShardedMetricsRepository) Delete(key string) error {
+	s := r.shard(key)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.storage, key)
+	return nil
+}
+
+func (r *ShardedMetricsRepository) Get(key string, mtype string) (metrics.Metric, error) {
+	if !metrics.IsValidMetricType(mtype) {
+		return metrics.Metric{}, errtypes.MakeBadDataError(fmt.Errorf("invalid metric type '%v'", mtype))
+	}
+
+	s := r.shard(key)
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	m, ok := s.storage[key]
+	if !ok || m.MType != mtype {
+		return metrics.Metric{}, errtypes.MakeNotFoundError(fmt.Errorf("metric '%v' not found", key))
+	}
+
+	return m, nil
+}
+
+// GetAll returns all metrics. Shards are read one by one,
+// so result isn't a consistent snapshot in case of concurrent updates.
+func (r *ShardedMetricsRepository) GetAll() ([]metrics.Metric, error) {
+	res := make([]metrics.Metric, 0)
+	for i := range r.shards {
+		s := &r.shards[i]
+		s.lock.RLock()
+		for _, m := range s.storage {
+			res = append(res, m)
+		}
+		s.lock.RUnlock()
+	}
+
+	return res, nil
+}
+
+// SnapshotMetrics passes stored metrics to fn shard by shard, like GetAll it isn't consistent.
+func (r *ShardedMetricsRepository) SnapshotMetrics(fn func(m metrics.Metric) error) error {
+	for i := range r.shards {
+		s := &r.shards[i]
+		s.lock.RLock()
+		for _, m := range s.storage {
+			if err := fn(m); err != nil {
+				s.lock.RUnlock()
+				return err
+			}
+		}
+		s.lock.RUnlock()
+	}
+
+	return nil
+}
+
+// RestoreMetrics stores metrics replacing existing ones with the same IDs.
+func (r *ShardedMetricsRepository) RestoreMetrics(data []metrics.Metric) error {
+	for _, m := range data {
+		if !metrics.IsValidMetricType(m.MType) {
+			return fmt.Errorf("invalid metric type '%v' for metric '%v'", m.MType, m.ID)
+		}
+	}
+
+	for i, group := range r.groupByShard(data) {
+		if len(group) == 0 {
+			continue
+		}
+
+		s := &r.shards[i]
+		s.lock.Lock()
+		for _, idx := range group {
+			s.storage[data[idx].ID] = data[idx]
+		}
+		s.lock.Unlock()
+	}
+
+	return nil
+}
+
+// ReplaceMetrics replaces all stored metrics with data holding locks of all shards.
+func (r *ShardedMetricsRepository) ReplaceMetrics(data []metrics.Metric) error {
+	storages := make([]map[string]metrics.Metric, len(r.shards))
+	for i := range storages {
+		storages[i] = make(map[string]metrics.Metric)
+	}
+
+	for _, m := range data {
+		if _, err := m.GetData(); err != nil {
+			return errtypes.MakeBadDataError(err)
+		}
+		storages[r.shardIndex(m.ID)][m.ID] = m
+	}
+
+	for i := range r.shards {
+		r.shards[i].lock.Lock()
+	}
+	for i := range r.shards {
+		r.shards[i].storage = storages[i]
+	}
+	for i := range r.shards {
+		r.shards[i].lock.Unlock()
+	}
+
+	return nil
+}
+
+func (r *ShardedMetricsRepository) MarshalJSON() ([]byte, error) {
+	allMetrics, err := r.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(allMetrics)
+}
+
+func (r *ShardedMetricsRepository) UnmarshalJSON(data []byte) error {
+	allMetrics := make([]metrics.Metric, 0)
+	if err := json.Unmarshal(data, &allMetrics); err != nil {
+		return err
+	}
+
+	return r.RestoreMetrics(allMetrics)
+}
+
+func (r *ShardedMetricsRepository) Save(w io.Writer) error {
+	return json.NewEncoder(w).Encode(r)
+}
+
+func (r *ShardedMetricsRepository) Load(reader io.Reader) error {
+	b := bytes.Buffer{}
+	_, err := io.Copy(&b, reader)
+	if err != nil {
+		return err
+	}
+
+	if err = json.Unmarshal(b.Bytes(), r); err != nil {
+		return errtypes.MakeBadDataError(err)
+	}
+
+	return nil
+}
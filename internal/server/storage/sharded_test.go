@@ -0,0 +1,41 @@
+package storage
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/fuzzy-toozy/metrics-service/internal/metrics"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ShardedMetricsRepo(t *testing.T) {
+	testMetricsRepo(t, NewShardedMetricsRepository(8))
+	testMetricsRepo(t, NewShardedMetricsRepository(0))
+}
+
+func Test_ShardedBulkUpdate(t *testing.T) {
+	repo := NewShardedMetricsRepository(4)
+
+	const metricsNum = 100
+	data := make([]metrics.Metric, 0, 2*metricsNum)
+	for i := 0; i < metricsNum; i++ {
+		data = append(data, metrics.NewCounterMetric(fmt.Sprintf("c%v", i), int64(i)))
+	}
+	for i := 0; i < metricsNum; i++ {
+		data = append(data, metrics.NewCounterMetric(fmt.Sprintf("c%v", i), 1))
+	}
+
+	require.NoError(t, repo.AddMetricsBulk(data))
+	for i := 0; i < metricsNum; i++ {
+		require.Equal(t, int64(i), *data[i].Delta)
+		require.Equal(t, int64(i+1), *data[metricsNum+i].Delta)
+	}
+
+	for i := range repo.shards {
+		require.NotEmpty(t, repo.shards[i].storage)
+	}
+
+	all, err := repo.GetAll()
+	require.NoError(t, err)
+	require.Len(t, all, metricsNum)
+}
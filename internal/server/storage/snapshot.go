@@ -0,0 +1,383 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/fuzzy-toozy/metrics-service/internal/compression"
+	"github.com/fuzzy-toozy/metrics-service/internal/metrics"
+	"github.com/fuzzy-toozy/metrics-service/internal/server/config"
+	"github.com/fuzzy-toozy/metrics-service/internal/server/errtypes"
+)
+
+// Snapshot file layout: fixed size header followed by payload.
+// Header holds format version, payload size and CRC32 so incomplete or damaged
+// snapshots are detected before any data is loaded.
+const (
+	snapshotMagic      = "MSNP"
+	snapshotVersion    = 1
+	snapshotHeaderSize = 28
+)
+
+// Header flags: low byte holds payload format, high byte holds payload compression.
+const (
+	snapshotFormatJSON   = 0
+	snapshotFormatBinary = 1
+
+	snapshotCompressionNone = 0
+	snapshotCompressionGzip = 1
+
+	// snapshotRestoreBatch number of binary snapshot records restored at once.
+	snapshotRestoreBatch = 1024
+)
+
+var snapshotCompressions = map[string]uint16{
+	"":     snapshotCompressionNone,
+	"gzip": snapshotCompressionGzip,
+}
+
+// SnapshotOptions options of snapshot files.
+type SnapshotOptions struct {
+	// Format payload format, config.StoreFormatJSON or config.StoreFormatBinary.
+	Format string
+	// Compression payload compression algorithm, not compressed if empty.
+	Compression string
+	// Keep number of the last snapshots kept.
+	Keep int
+}
+
+// MetricsSnapshotter implemented by repositories which can pass stored metrics to fn one by one
+// without collecting all of them in memory. Repositories which have to take snapshot atomically
+// with some side effect (e.g. log rotation) do it here too. GetAll is used otherwise.
+type MetricsSnapshotter interface {
+	SnapshotMetrics(fn func(m metrics.Metric) error) error
+}
+
+// MetricsRestorer implemented by repositories which can restore stored metrics
+// from snapshot records, required to load binary snapshots.
+type MetricsRestorer interface {
+	RestoreMetrics(data []metrics.Metric) error
+}
+
+// MetricsReplacer implemented by repositories which can replace all stored metrics atomically,
+// so readers see either previous or new state.
+type MetricsReplacer interface {
+	ReplaceMetrics(data []metrics.Metric) error
+}
+
+// SnapshotPositioner implemented by repositories whose snapshots correspond
+// to a position in external log, e.g. write-ahead log segment.
+// Position of the last snapshot produced by Save is stored in snapshot header.
+type SnapshotPositioner interface {
+	SnapshotPosition() uint64
+}
+
+type snapshotHeader struct {
+	Version  uint16
+	Flags    uint16
+	Checksum uint32
+	Size     uint64
+	Position uint64
+}
+
+var errNotSnapshot = errors.New("not a versioned snapshot")
+
+func snapshotFlags(opts SnapshotOptions) (uint16, error) {
+	var format uint16
+	switch opts.Format {
+	case config.StoreFormatJSON, "":
+		format = snapshotFormatJSON
+	case config.StoreFormatBinary:
+		format = snapshotFormatBinary
+	default:
+		return 0, fmt.Errorf("invalid snapshot format '%v'", opts.Format)
+	}
+
+	c, ok := snapshotCompressions[opts.Compression]
+	if !ok {
+		return 0, fmt.Errorf("invalid snapshot compression '%v'", opts.Compression)
+	}
+
+	return format | c<<8, nil
+}
+
+func compressionName(flags uint16) (string, error) {
+	c := flags >> 8
+	for name, id := range snapshotCompressions {
+		if id == c {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported snapshot compression %v", c)
+}
+
+// encodeSnapshot writes snapshot payload of repo in format specified by flags.
+func encodeSnapshot(w io.Writer, repo Repository, flags uint16) (err error) {
+	name, err := compressionName(flags)
+	if err != nil {
+		return err
+	}
+
+	if len(name) > 0 {
+		var factory compression.CompressorFactory
+		if factory, err = compression.GetCompressorFactory(name); err != nil {
+			return err
+		}
+
+		var cw io.WriteCloser
+		if cw, err = factory(w); err != nil {
+			return err
+		}
+		defer func() {
+			if errc := cw.Close(); err == nil {
+				err = errc
+			}
+		}()
+		w = cw
+	}
+
+	if flags&0xff == snapshotFormatJSON {
+		enc := newJSONEncoder(w)
+		if err = snapshotMetrics(repo, enc.Encode); err != nil {
+			return err
+		}
+		return enc.Flush()
+	}
+
+	enc := newBinaryEncoder(w)
+	if err = snapshotMetrics(repo, enc.Encode); err != nil {
+		return err
+	}
+
+	return enc.Flush()
+}
+
+// jsonEncoder writes metrics one by one as JSON array in the same format as CommonMetricsRepository.Save,
+// so snapshot isn't held in memory.
+type jsonEncoder struct {
+	w     *bufio.Writer
+	enc   *json.Encoder
+	count int
+}
+
+func newJSONEncoder(w io.Writer) *jsonEncoder {
+	bw := bufio.NewWriter(w)
+	return &jsonEncoder{w: bw, enc: json.NewEncoder(bw)}
+}
+
+// Encode writes array element of metric m.
+func (e *jsonEncoder) Encode(m metrics.Metric) error {
+	sep := byte(',')
+	if e.count == 0 {
+		sep = '['
+	}
+	if err := e.w.WriteByte(sep); err != nil {
+		return err
+	}
+	e.count++
+
+	return e.enc.Encode(m)
+}
+
+// Flush closes array and writes buffered data to underlying writer.
+func (e *jsonEncoder) Flush() error {
+	end := "]\n"
+	if e.count == 0 {
+		end = "[]\n"
+	}
+	if _, err := e.w.WriteString(end); err != nil {
+		return err
+	}
+
+	return e.w.Flush()
+}
+
+// snapshotMetrics passes all metrics of repo to fn, streaming them if repo supports it.
+func snapshotMetrics(repo Repository, fn func(m metrics.Metric) error) error {
+	if s, ok := repo.(MetricsSnapshotter); ok {
+		return s.SnapshotMetrics(fn)
+	}
+
+	data, err := repo.GetAll()
+	if err != nil {
+		return err
+	}
+
+	for _, m := range data {
+		if err = fn(m); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// decodeSnapshot loads snapshot payload in format specified by flags into repo.
+func decodeSnapshot(r io.Reader, repo Repository, flags uint16) error {
+	name, err := compressionName(flags)
+	if err != nil {
+		return err
+	}
+
+	if len(name) > 0 {
+		factory, err := compression.GetDeompressorFactory(name)
+		if err != nil {
+			return err
+		}
+
+		cr, err := factory(r)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = cr.Close()
+		}()
+		r = cr
+	}
+
+	switch flags & 0xff {
+	case snapshotFormatJSON:
+		return restoreJSONSnapshot(r, repo)
+	case snapshotFormatBinary:
+	default:
+		return fmt.Errorf("unsupported snapshot format %v", flags&0xff)
+	}
+
+	restorer, ok := repo.(MetricsRestorer)
+	if !ok {
+		return errors.New("repository doesn't support binary snapshots")
+	}
+
+	dec := newBinaryDecoder(r)
+	batch := make([]metrics.Metric, 0, snapshotRestoreBatch)
+	for {
+		m, err := dec.Decode()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		batch = append(batch, m)
+		if len(batch) == snapshotRestoreBatch {
+			if err = restorer.RestoreMetrics(batch); err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+	}
+
+	return restorer.RestoreMetrics(batch)
+}
+
+// restoreJSONSnapshot loads JSON array snapshot into repo. Repositories supporting restore
+// from snapshot records get metrics in batches, so restore bypasses logging of updates, e.g. write-ahead log.
+func restoreJSONSnapshot(r io.Reader, repo Repository) error {
+	restorer, ok := repo.(MetricsRestorer)
+	if !ok {
+		return repo.Load(r)
+	}
+
+	dec := json.NewDecoder(r)
+	tok, err := dec.Token()
+	if err != nil {
+		return errtypes.MakeBadDataError(fmt.Errorf("failed to read metrics array: %w", err))
+	}
+
+	if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+		return errtypes.MakeBadDataError(errors.New("metrics array expected"))
+	}
+
+	batch := make([]metrics.Metric, 0, snapshotRestoreBatch)
+	for dec.More() {
+		var m metrics.Metric
+		if err = dec.Decode(&m); err != nil {
+			return errtypes.MakeBadDataError(fmt.Errorf("failed to decode metric: %w", err))
+		}
+
+		batch = append(batch, m)
+		if len(batch) == snapshotRestoreBatch {
+			if err = restorer.RestoreMetrics(batch); err != nil {
+				return err
+			}
+			batch = batch[:0]
+		}
+	}
+
+	if _, err = dec.Token(); err != nil {
+		return errtypes.MakeBadDataError(fmt.Errorf("failed to read metrics array: %w", err))
+	}
+
+	return restorer.RestoreMetrics(batch)
+}
+
+func (h snapshotHeader) encode() []byte {
+	b := make([]byte, snapshotHeaderSize)
+	copy(b, snapshotMagic)
+	binary.BigEndian.PutUint16(b[4:], h.Version)
+	binary.BigEndian.PutUint16(b[6:], h.Flags)
+	binary.BigEndian.PutUint32(b[8:], h.Checksum)
+	binary.BigEndian.PutUint64(b[12:], h.Size)
+	binary.BigEndian.PutUint64(b[20:], h.Position)
+	return b
+}
+
+func decodeSnapshotHeader(b []byte) (snapshotHeader, error) {
+	if len(b) < snapshotHeaderSize || !bytes.Equal(b[:len(snapshotMagic)], []byte(snapshotMagic)) {
+		return snapshotHeader{}, errNotSnapshot
+	}
+
+	h := snapshotHeader{
+		Version:  binary.BigEndian.Uint16(b[4:]),
+		Flags:    binary.BigEndian.Uint16(b[6:]),
+		Checksum: binary.BigEndian.Uint32(b[8:]),
+		Size:     binary.BigEndian.Uint64(b[12:]),
+		Position: binary.BigEndian.Uint64(b[20:]),
+	}
+
+	if h.Version != snapshotVersion {
+		return h, fmt.Errorf("unsupported snapshot version %v", h.Version)
+	}
+
+	return h, nil
+}
+
+// checksumWriter counts size and CRC32 of written data.
+type checksumWriter struct {
+	w    io.Writer
+	size uint64
+	crc  uint32
+}
+
+func (c *checksumWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.crc = crc32.Update(c.crc, crc32.IEEETable, p[:n])
+	c.size += uint64(n)
+	return n, err
+}
+
+// verifySnapshot checks payload of snapshot against header, r must be positioned at payload start.
+func verifySnapshot(r io.Reader, h snapshotHeader) error {
+	c := checksumWriter{w: io.Discard}
+	n, err := io.Copy(&c, r)
+	if err != nil {
+		return fmt.Errorf("failed to read snapshot: %w", err)
+	}
+
+	if uint64(n) != h.Size {
+		return fmt.Errorf("snapshot size mismatch: expected %v, got %v", h.Size, n)
+	}
+
+	if c.crc != h.Checksum {
+		return errors.New("snapshot checksum mismatch")
+	}
+
+	return nil
+}
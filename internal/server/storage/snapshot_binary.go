@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/fuzzy-toozy/metrics-service/internal/metrics"
+)
+
+// Binary snapshot is a sequence of length-prefixed records, one per metric.
+// Record: uvarint record length, metric type byte, uvarint ID length, ID, 8 byte big endian value.
+const (
+	binaryCounterType = 0
+	binaryGaugeType   = 1
+
+	binaryMaxRecordSize = 1 << 20
+)
+
+type binaryEncoder struct {
+	w   *bufio.Writer
+	buf []byte
+}
+
+func newBinaryEncoder(w io.Writer) *binaryEncoder {
+	return &binaryEncoder{w: bufio.NewWriter(w)}
+}
+
+// Encode writes record of metric m.
+func (e *binaryEncoder) Encode(m metrics.Metric) error {
+	rec := e.buf[:0]
+	switch m.MType {
+	case metrics.CounterMetricType:
+		if m.Delta == nil {
+			return fmt.Errorf("no value for metric '%v'", m.ID)
+		}
+		rec = append(rec, binaryCounterType)
+		rec = binary.AppendUvarint(rec, uint64(len(m.ID)))
+		rec = append(rec, m.ID...)
+		rec = binary.BigEndian.AppendUint64(rec, uint64(*m.Delta))
+	case metrics.GaugeMetricType:
+		if m.Value == nil {
+			return fmt.Errorf("no value for metric '%v'", m.ID)
+		}
+		rec = append(rec, binaryGaugeType)
+		rec = binary.AppendUvarint(rec, uint64(len(m.ID)))
+		rec = append(rec, m.ID...)
+		rec = binary.BigEndian.AppendUint64(rec, math.Float64bits(*m.Value))
+	default:
+		return fmt.Errorf("invalid metric type '%v' for metric '%v'", m.MType, m.ID)
+	}
+	e.buf = rec
+
+	var prefix [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(prefix[:], uint64(len(rec)))
+	if _, err := e.w.Write(prefix[:n]); err != nil {
+		return err
+	}
+
+	_, err := e.w.Write(rec)
+	return err
+}
+
+// Flush writes buffered records to underlying writer.
+func (e *binaryEncoder) Flush() error {
+	return e.w.Flush()
+}
+
+type binaryDecoder struct {
+	r   *bufio.Reader
+	buf []byte
+}
+
+func newBinaryDecoder(r io.Reader) *binaryDecoder {
+	return &binaryDecoder{r: bufio.NewReader(r)}
+}
+
+// Decode reads next metric record. Returns io.EOF if there are no more records.
+func (d *binaryDecoder) Decode() (metrics.Metric, error) {
+	size, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return metrics.Metric{}, io.EOF
+		}
+		return metrics.Metric{}, fmt.Errorf("malformed record length: %w", err)
+	}
+
+	if size > binaryMaxRecordSize {
+		return metrics.Metric{}, fmt.Errorf("record size %v exceeds limit", size)
+	}
+
+	if uint64(cap(d.buf)) < size {
+		d.buf = make([]byte, size)
+	}
+	rec := d.buf[:size]
+
+	if _, err = io.ReadFull(d.r, rec); err != nil {
+		return metrics.Metric{}, fmt.Errorf("truncated record: %w", err)
+	}
+
+	const valueSize = 8
+	if len(rec) < 1+valueSize {
+		return metrics.Metric{}, errors.New("record is too short")
+	}
+
+	mtype := rec[0]
+	idLen, n := binary.Uvarint(rec[1:])
+	if n <= 0 || uint64(len(rec)-1-n) != idLen+valueSize {
+		return metrics.Metric{}, errors.New("malformed record")
+	}
+
+	id := string(rec[1+n : 1+n+int(idLen)])
+	val := binary.BigEndian.Uint64(rec[len(rec)-valueSize:])
+
+	switch mtype {
+	case binaryCounterType:
+		return metrics.NewCounterMetric(id, int64(val)), nil
+	case binaryGaugeType:
+		return metrics.NewGaugeMetric(id, math.Float64frombits(val)), nil
+	default:
+		return metrics.Metric{}, fmt.Errorf("invalid metric type %v in record", mtype)
+	}
+}
@@ -0,0 +1,592 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	logging "github.com/fuzzy-toozy/metrics-service/internal/log"
+	"github.com/fuzzy-toozy/metrics-service/internal/metrics"
+	"github.com/fuzzy-toozy/metrics-service/internal/server/errtypes"
+)
+
+// WAL fsync policies.
+const (
+	// WALSyncAlways fsync after every appended record.
+	WALSyncAlways = "always"
+	// WALSyncInterval fsync periodically in background.
+	WALSyncInterval = "interval"
+	// WALSyncNever never fsync explicitly, rely on OS.
+	WALSyncNever = "never"
+)
+
+// SnapshotCommitter implemented by repositories which need to be notified
+// that snapshot produced by Save was durably stored. Kept is the number of the
+// last snapshots retained by saver, any of them may be restored later.
+type SnapshotCommitter interface {
+	CommitSnapshot(kept int) error
+}
+
+// WriteAheadLog append only log of accepted updates.
+// Log is split into segments named <path>.<number>, new segment is started
+// each time snapshot is taken, segments older than the last durable snapshot are removed.
+// Each record is a line with CRC32 of JSON encoded metrics followed by the JSON itself,
+// so torn records left by a crash are detected and dropped on replay.
+type WriteAheadLog struct {
+	file    *os.File
+	done    chan struct{}
+	log     logging.Logger
+	path    string
+	policy  string
+	commits []uint64
+	segment uint64
+	// commitBefore segment started by the last rotation.
+	commitBefore uint64
+	wg           sync.WaitGroup
+	lock         sync.Mutex
+	dirty        bool
+}
+
+// NewWriteAheadLog opens write-ahead log at path with fsync policy.
+// Segments left from previous runs are kept until enough snapshots are committed to cover them.
+// For WALSyncInterval policy log is synced every syncInterval.
+func NewWriteAheadLog(path string, policy string, syncInterval time.Duration, log logging.Logger) (*WriteAheadLog, error) {
+	switch policy {
+	case WALSyncAlways, WALSyncInterval, WALSyncNever:
+	default:
+		return nil, fmt.Errorf("invalid WAL sync policy '%v'", policy)
+	}
+
+	w := WriteAheadLog{path: path, policy: policy, log: log, done: make(chan struct{})}
+
+	segments, err := w.segments()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(segments) > 0 {
+		w.segment = segments[len(segments)-1]
+	}
+
+	if err = w.openSegment(w.segment); err != nil {
+		return nil, err
+	}
+
+	if policy == WALSyncInterval {
+		w.wg.Add(1)
+		go func() {
+			defer w.wg.Done()
+			ticker := time.NewTicker(syncInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					if err := w.Sync(); err != nil {
+						w.log.Errorf("Failed to sync WAL: %v", err)
+					}
+				case <-w.done:
+					return
+				}
+			}
+		}()
+	}
+
+	return &w, nil
+}
+
+func (w *WriteAheadLog) segmentPath(segment uint64) string {
+	return fmt.Sprintf("%v.%v", w.path, segment)
+}
+
+// segments returns sorted numbers of existing segments.
+func (w *WriteAheadLog) segments() ([]uint64, error) {
+	dir := filepath.Dir(w.path)
+	prefix := filepath.Base(w.path) + "."
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read WAL dir: %w", err)
+	}
+
+	res := make([]uint64, 0)
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasPrefix(e.Name(), prefix) {
+			continue
+		}
+		n, err := strconv.ParseUint(strings.TrimPrefix(e.Name(), prefix), 10, 64)
+		if err != nil {
+			continue
+		}
+		res = append(res, n)
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i] < res[j] })
+
+	return res, nil
+}
+
+func (w *WriteAheadLog) openSegment(segment uint64) error {
+	const perms = 0644
+	f, err := os.OpenFile(w.segmentPath(segment), os.O_CREATE|os.O_WRONLY|os.O_APPEND, perms)
+	if err != nil {
+		return fmt.Errorf("failed to open WAL segment: %w", err)
+	}
+	w.file = f
+	w.segment = segment
+	return nil
+}
+
+// WAL record operations. Updates are logged as plain array of metrics,
+// other operations as walRecord.
+const (
+	// walOpRestore metrics are stored replacing existing ones with the same IDs.
+	walOpRestore = "restore"
+	// walOpReplace all stored metrics are replaced.
+	walOpReplace = "replace"
+)
+
+// walRecord logged operation, Op is empty for updates.
+type walRecord struct {
+	Op      string           `json:"op"`
+	Metrics []metrics.Metric `json:"metrics"`
+}
+
+func encodeWALRecord(data walRecord) ([]byte, error) {
+	var payload []byte
+	var err error
+	if len(data.Op) == 0 {
+		payload, err = json.Marshal(data.Metrics)
+	} else {
+		payload, err = json.Marshal(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	record := make([]byte, 0, len(payload)+10)
+	record = append(record, fmt.Sprintf("%08x ", crc32.ChecksumIEEE(payload))...)
+	record = append(record, payload...)
+	record = append(record, '\n')
+
+	return record, nil
+}
+
+func decodeWALRecord(line []byte) (walRecord, error) {
+	const crcLen = 8
+	if len(line) < crcLen+1 || line[crcLen] != ' ' {
+		return walRecord{}, errors.New("malformed WAL record")
+	}
+
+	crc, err := strconv.ParseUint(string(line[:crcLen]), 16, 32)
+	if err != nil {
+		return walRecord{}, fmt.Errorf("malformed WAL record checksum: %w", err)
+	}
+
+	payload := line[crcLen+1:]
+	if crc32.ChecksumIEEE(payload) != uint32(crc) {
+		return walRecord{}, errors.New("WAL record checksum mismatch")
+	}
+
+	var res walRecord
+	if len(payload) > 0 && payload[0] == '[' {
+		err = json.Unmarshal(payload, &res.Metrics)
+	} else {
+		err = json.Unmarshal(payload, &res)
+	}
+	if err != nil {
+		return walRecord{}, fmt.Errorf("malformed WAL record data: %w", err)
+	}
+
+	return res, nil
+}
+
+// Append appends record of accepted metrics to the log.
+func (w *WriteAheadLog) Append(data []metrics.Metric) error {
+	return w.appendRecord(walRecord{Metrics: data})
+}
+
+// AppendOp appends record of operation op on metrics data to the log.
+func (w *WriteAheadLog) AppendOp(op string, data []metrics.Metric) error {
+	return w.appendRecord(walRecord{Op: op, Metrics: data})
+}
+
+func (w *WriteAheadLog) appendRecord(data walRecord) error {
+	record, err := encodeWALRecord(data)
+	if err != nil {
+		return fmt.Errorf("failed to encode WAL record: %w", err)
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if _, err = w.file.Write(record); err != nil {
+		return fmt.Errorf("failed to write WAL record: %w", err)
+	}
+
+	if w.policy == WALSyncAlways {
+		return w.file.Sync()
+	}
+
+	w.dirty = true
+
+	return nil
+}
+
+// Sync flushes appended records to stable storage.
+func (w *WriteAheadLog) Sync() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if !w.dirty {
+		return nil
+	}
+	w.dirty = false
+
+	return w.file.Sync()
+}
+
+// Rotate starts new segment. Records appended after rotation are needed to replay
+// updates on top of snapshot taken at rotation.
+func (w *WriteAheadLog) Rotate() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if err := w.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync WAL segment: %w", err)
+	}
+
+	if err := w.file.Close(); err != nil {
+		return fmt.Errorf("failed to close WAL segment: %w", err)
+	}
+
+	w.dirty = false
+	w.commitBefore = w.segment + 1
+
+	return w.openSegment(w.segment + 1)
+}
+
+// Truncate must be called once snapshot taken at the last rotation is durably stored.
+// Segments are kept back to the oldest of kept last committed snapshots,
+// so updates can be replayed on top of any of them.
+func (w *WriteAheadLog) Truncate(kept int) error {
+	if kept < 1 {
+		kept = 1
+	}
+
+	w.lock.Lock()
+	if len(w.commits) == 0 || w.commits[len(w.commits)-1] != w.commitBefore {
+		w.commits = append(w.commits, w.commitBefore)
+	}
+	if len(w.commits) > kept {
+		w.commits = w.commits[len(w.commits)-kept:]
+	}
+	// Positions of snapshots committed before start are unknown, so their segments are kept.
+	ready := len(w.commits) == kept
+	before := w.commits[0]
+	w.lock.Unlock()
+
+	if !ready {
+		return nil
+	}
+
+	return w.removeBefore(before)
+}
+
+func (w *WriteAheadLog) removeBefore(before uint64) error {
+	segments, err := w.segments()
+	if err != nil {
+		return err
+	}
+
+	for _, s := range segments {
+		if s >= before {
+			break
+		}
+		if err = os.Remove(w.segmentPath(s)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove WAL segment: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Position returns segment started by the last rotation.
+func (w *WriteAheadLog) Position() uint64 {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.commitBefore
+}
+
+// Replay applies logged records of segments starting from segment from to repository in order.
+// Replay stops at the first damaged record, which is expected
+// to be the last one, partially written before a crash.
+func (w *WriteAheadLog) Replay(repo Repository, from uint64) (int, error) {
+	segments, err := w.segments()
+	if err != nil {
+		return 0, err
+	}
+
+	if len(segments) > 0 && segments[0] > from {
+		w.log.Warnf("WAL segments %v-%v are missing, updates since snapshot may be lost", from, segments[0]-1)
+	}
+
+	applied := 0
+	for _, s := range segments {
+		if s < from {
+			continue
+		}
+
+		f, err := os.Open(w.segmentPath(s))
+		if err != nil {
+			return applied, fmt.Errorf("failed to open WAL segment: %w", err)
+		}
+
+		reader := bufio.NewReader(f)
+		for {
+			line, err := reader.ReadBytes('\n')
+			if errors.Is(err, io.EOF) && len(line) == 0 {
+				break
+			}
+
+			var record walRecord
+			if err == nil {
+				record, err = decodeWALRecord(bytes.TrimSuffix(line, []byte{'\n'}))
+			}
+
+			if err != nil {
+				w.log.Warnf("Damaged WAL record in segment %v, dropping the rest of log: %v", s, err)
+				_ = f.Close()
+				return applied, nil
+			}
+
+			// Records rejected when they were logged are rejected again, since repository state is the same.
+			var badData errtypes.BadDataError
+			if err = applyWALRecord(repo, record); errors.As(err, &badData) {
+				w.log.Debugf("Skipping rejected WAL record in segment %v: %v", s, err)
+				continue
+			} else if err != nil {
+				_ = f.Close()
+				return applied, fmt.Errorf("failed to apply WAL record: %w", err)
+			}
+			applied++
+		}
+
+		if err = f.Close(); err != nil {
+			return applied, err
+		}
+	}
+
+	return applied, nil
+}
+
+func applyWALRecord(repo Repository, record walRecord) error {
+	switch record.Op {
+	case "":
+		return repo.AddMetricsBulk(record.Metrics)
+	case walOpRestore:
+		restorer, ok := repo.(MetricsRestorer)
+		if !ok {
+			return errors.New("repository doesn't support restore from snapshot records")
+		}
+		return restorer.RestoreMetrics(record.Metrics)
+	case walOpReplace:
+		replacer, ok := repo.(MetricsReplacer)
+		if !ok {
+			return errors.New("repository doesn't support replacing metrics")
+		}
+		return replacer.ReplaceMetrics(record.Metrics)
+	}
+
+	return fmt.Errorf("unknown WAL record operation '%v'", record.Op)
+}
+
+// Close syncs and closes the log.
+func (w *WriteAheadLog) Close() error {
+	close(w.done)
+	w.wg.Wait()
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+
+	return w.file.Close()
+}
+
+// WALRepository repository proxy which appends every applied update to write-ahead log.
+// Save takes consistent snapshot and starts new log segment, CommitSnapshot
+// removes segments covered by all kept snapshots.
+type WALRepository struct {
+	Repository
+	wal  *WriteAheadLog
+	lock sync.Mutex
+}
+
+func NewWALRepository(r Repository, wal *WriteAheadLog) *WALRepository {
+	return &WALRepository{Repository: r, wal: wal}
+}
+
+// AddOrUpdate logs update and applies it. Update is applied only after it's logged, so
+// accepted update is never lost. Update rejected by repository stays in the log, but it is rejected on replay too.
+func (r *WALRepository) AddOrUpdate(key string, val string, mtype string) (string, error) {
+	m, err := metrics.NewMetric(key, val, mtype)
+	if err != nil {
+		return "", errtypes.MakeBadDataError(err)
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if err = r.wal.Append([]metrics.Metric{m}); err != nil {
+		return "", errtypes.MakeServerError(err)
+	}
+
+	return r.Repository.AddOrUpdate(key, val, mtype)
+}
+
+// AddMetricsBulk logs update and applies it, see AddOrUpdate.
+func (r *WALRepository) AddMetricsBulk(metricsData []metrics.Metric) error {
+	bulkErr := BulkUpdateError{}
+	for i, m := range metricsData {
+		if _, err := m.GetData(); err != nil {
+			bulkErr.Add(i, m.ID, err)
+		}
+	}
+	if err := bulkErr.Err(); err != nil {
+		return err
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	// Repository sets applied values to metricsData, so received ones are logged before.
+	if err := r.wal.Append(metricsData); err != nil {
+		return errtypes.MakeServerError(err)
+	}
+
+	return r.Repository.AddMetricsBulk(metricsData)
+}
+
+// Save streams JSON snapshot of repository. Snapshot is taken and log is rotated atomically
+// with respect to updates, so records of new segment are exactly the updates missing from snapshot.
+func (r *WALRepository) Save(w io.Writer) error {
+	enc := newJSONEncoder(w)
+	if err := r.SnapshotMetrics(enc.Encode); err != nil {
+		return err
+	}
+
+	return enc.Flush()
+}
+
+// SnapshotMetrics passes stored metrics to fn and starts new log segment atomically with respect to updates.
+func (r *WALRepository) SnapshotMetrics(fn func(m metrics.Metric) error) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if err := snapshotMetrics(r.Repository, fn); err != nil {
+		return err
+	}
+
+	return r.wal.Rotate()
+}
+
+// RestoreMetrics restores metrics from snapshot bypassing the log.
+func (r *WALRepository) RestoreMetrics(data []metrics.Metric) error {
+	restorer, ok := r.Repository.(MetricsRestorer)
+	if !ok {
+		return errors.New("repository doesn't support restore from snapshot records")
+	}
+	return restorer.RestoreMetrics(data)
+}
+
+// ReplaceMetrics logs replacement and replaces all stored metrics.
+// Replacement is replayed like updates, so snapshots taken before it stay usable.
+func (r *WALRepository) ReplaceMetrics(data []metrics.Metric) error {
+	if _, ok := r.Repository.(MetricsReplacer); !ok {
+		return errors.New("repository doesn't support replacing metrics")
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.applyLogged(walRecord{Op: walOpReplace, Metrics: data})
+}
+
+// Load logs metrics in the same JSON format as CommonMetricsRepository read from reader
+// and stores them replacing existing values.
+func (r *WALRepository) Load(reader io.Reader) error {
+	if _, ok := r.Repository.(MetricsRestorer); !ok {
+		return errors.New("repository doesn't support restore from snapshot records")
+	}
+
+	data := make([]metrics.Metric, 0)
+	if err := json.NewDecoder(reader).Decode(&data); err != nil {
+		return errtypes.MakeBadDataError(fmt.Errorf("failed to decode metrics: %w", err))
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.applyLogged(walRecord{Op: walOpRestore, Metrics: data})
+}
+
+// applyLogged validates and logs record, then applies it. Must be called with lock held.
+func (r *WALRepository) applyLogged(record walRecord) error {
+	for _, m := range record.Metrics {
+		if _, err := m.GetData(); err != nil {
+			return errtypes.MakeBadDataError(err)
+		}
+	}
+
+	if err := r.wal.AppendOp(record.Op, record.Metrics); err != nil {
+		return errtypes.MakeServerError(err)
+	}
+
+	return applyWALRecord(r.Repository, record)
+}
+
+// CommitSnapshot truncates log after snapshot is durably stored.
+func (r *WALRepository) CommitSnapshot(kept int) error {
+	return r.wal.Truncate(kept)
+}
+
+// SnapshotPosition returns the first log segment not covered by the last snapshot.
+func (r *WALRepository) SnapshotPosition() uint64 {
+	return r.wal.Position()
+}
+
+// Replay replays updates logged since snapshot at position from on top of current repository state.
+func (r *WALRepository) Replay(from uint64) (int, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.wal.Replay(r.Repository, from)
+}
+
+// Reset logs that repository starts from empty state, so updates logged before
+// aren't replayed on top of the following ones.
+func (r *WALRepository) Reset() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.applyLogged(walRecord{Op: walOpReplace})
+}
+
+func (r *WALRepository) Close() error {
+	err := r.wal.Close()
+	if errc := r.Repository.Close(); errc != nil {
+		return errc
+	}
+	return err
+}
@@ -0,0 +1,252 @@
+package storage
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fuzzy-toozy/metrics-service/internal/log"
+	"github.com/fuzzy-toozy/metrics-service/internal/metrics"
+	"github.com/fuzzy-toozy/metrics-service/internal/server/errtypes"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestWALRepository(t *testing.T, path string) *WALRepository {
+	wal, err := NewWriteAheadLog(path, WALSyncAlways, time.Second, log.NewDummyLogger())
+	require.NoError(t, err)
+	return NewWALRepository(NewCommonMetricsRepository(), wal)
+}
+
+func getCounterValue(t *testing.T, r Repository, id string) int64 {
+	m, err := r.Get(id, metrics.CounterMetricType)
+	require.NoError(t, err)
+	require.NotNil(t, m.Delta)
+	return *m.Delta
+}
+
+func Test_WALReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.wal")
+
+	repo := newTestWALRepository(t, path)
+	_, err := repo.AddOrUpdate("c", "5", metrics.CounterMetricType)
+	require.NoError(t, err)
+	require.NoError(t, repo.AddMetricsBulk([]metrics.Metric{
+		metrics.NewCounterMetric("c", 2),
+		metrics.NewGaugeMetric("g", 1.5),
+	}))
+	require.NoError(t, repo.Close())
+
+	// Torn record at the end of log is dropped.
+	f, err := os.OpenFile(path+".0", os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = f.WriteString(`00000000 [{"id":"c"`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	repo = newTestWALRepository(t, path)
+	defer func() {
+		require.NoError(t, repo.Close())
+	}()
+
+	applied, err := repo.Replay(0)
+	require.NoError(t, err)
+	require.Equal(t, 2, applied)
+	require.Equal(t, int64(7), getCounterValue(t, repo, "c"))
+
+	m, err := repo.Get("g", metrics.GaugeMetricType)
+	require.NoError(t, err)
+	require.Equal(t, 1.5, *m.Value)
+}
+
+func Test_WALSnapshotTruncatesLog(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "metrics.wal")
+	snapshot := filepath.Join(dir, "metrics.json")
+
+	repo := newTestWALRepository(t, path)
+	saver := NewFileSaver(repo, snapshot, SnapshotOptions{Keep: 1}, log.NewDummyLogger())
+
+	_, err := repo.AddOrUpdate("c", "5", metrics.CounterMetricType)
+	require.NoError(t, err)
+	require.NoError(t, saver.Save())
+
+	_, err = repo.AddOrUpdate("c", "3", metrics.CounterMetricType)
+	require.NoError(t, err)
+	require.NoError(t, repo.Close())
+
+	_, err = os.Stat(path + ".0")
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	// Snapshot plus log replay restores state without double counting.
+	restored := newTestWALRepository(t, path)
+	defer func() {
+		require.NoError(t, restored.Close())
+	}()
+
+	pos, err := NewFileSaver(restored, snapshot, SnapshotOptions{Keep: 1}, log.NewDummyLogger()).Load()
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), pos)
+
+	applied, err := restored.Replay(pos)
+	require.NoError(t, err)
+	require.Equal(t, 1, applied)
+	require.Equal(t, int64(8), getCounterValue(t, restored, "c"))
+
+	// Reset is replayed on top of snapshot taken before it.
+	require.NoError(t, restored.Reset())
+	_, err = restored.AddOrUpdate("g", "1", metrics.GaugeMetricType)
+	require.NoError(t, err)
+	require.NoError(t, restored.Close())
+
+	restored = newTestWALRepository(t, path)
+	pos, err = NewFileSaver(restored, snapshot, SnapshotOptions{Keep: 1}, log.NewDummyLogger()).Load()
+	require.NoError(t, err)
+	applied, err = restored.Replay(pos)
+	require.NoError(t, err)
+	require.Equal(t, 3, applied)
+	_, err = restored.Get("c", metrics.CounterMetricType)
+	require.ErrorAs(t, err, &errtypes.NotFoundError{})
+	_, err = restored.Get("g", metrics.GaugeMetricType)
+	require.NoError(t, err)
+}
+
+func Test_WALReplaySkipsSnapshottedSegments(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.wal")
+
+	repo := newTestWALRepository(t, path)
+	_, err := repo.AddOrUpdate("c", "5", metrics.CounterMetricType)
+	require.NoError(t, err)
+
+	// Crash after snapshot is stored but before log is truncated.
+	require.NoError(t, repo.Save(io.Discard))
+	pos := repo.SnapshotPosition()
+	_, err = repo.AddOrUpdate("c", "3", metrics.CounterMetricType)
+	require.NoError(t, err)
+	require.NoError(t, repo.Close())
+
+	restored := newTestWALRepository(t, path)
+	defer func() {
+		require.NoError(t, restored.Close())
+	}()
+	// State restored from snapshot.
+	_, err = restored.Repository.AddOrUpdate("c", "5", metrics.CounterMetricType)
+	require.NoError(t, err)
+
+	applied, err := restored.Replay(pos)
+	require.NoError(t, err)
+	require.Equal(t, 1, applied)
+	require.Equal(t, int64(8), getCounterValue(t, restored, "c"))
+}
+
+func Test_WALAppendFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.wal")
+
+	repo := newTestWALRepository(t, path)
+	_, err := repo.AddOrUpdate("c", "5", metrics.CounterMetricType)
+	require.NoError(t, err)
+
+	// Update rejected by repository is logged, but skipped on replay.
+	_, err = repo.AddOrUpdate("c", "1.5", metrics.GaugeMetricType)
+	require.ErrorAs(t, err, &errtypes.BadDataError{})
+
+	// Update failed to log isn't applied.
+	require.NoError(t, repo.wal.file.Close())
+	_, err = repo.AddOrUpdate("c", "3", metrics.CounterMetricType)
+	require.ErrorAs(t, err, &errtypes.ServerError{})
+	err = repo.AddMetricsBulk([]metrics.Metric{metrics.NewCounterMetric("c", 3)})
+	require.ErrorAs(t, err, &errtypes.ServerError{})
+	require.Equal(t, int64(5), getCounterValue(t, repo, "c"))
+
+	restored := newTestWALRepository(t, path)
+	defer func() {
+		require.NoError(t, restored.Close())
+	}()
+
+	applied, err := restored.Replay(0)
+	require.NoError(t, err)
+	require.Equal(t, 1, applied)
+	require.Equal(t, int64(5), getCounterValue(t, restored, "c"))
+}
+
+func Test_WALKeepsSegmentsOfOlderSnapshots(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "metrics.wal")
+	snapshot := filepath.Join(dir, "metrics.json")
+	opts := SnapshotOptions{Keep: 2}
+
+	repo := newTestWALRepository(t, path)
+	saver := NewFileSaver(repo, snapshot, opts, log.NewDummyLogger())
+	for _, val := range []string{"5", "3"} {
+		_, err := repo.AddOrUpdate("c", val, metrics.CounterMetricType)
+		require.NoError(t, err)
+		require.NoError(t, saver.Save())
+	}
+	_, err := repo.AddOrUpdate("c", "2", metrics.CounterMetricType)
+	require.NoError(t, err)
+	require.NoError(t, repo.Close())
+
+	// Segment covered by both kept snapshots is removed.
+	_, err = os.Stat(path + ".0")
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	// Updates are replayed on top of older snapshot if the newest one is damaged.
+	require.NoError(t, os.WriteFile(snapshot, []byte("damaged"), 0644))
+
+	restored := newTestWALRepository(t, path)
+	defer func() {
+		require.NoError(t, restored.Close())
+	}()
+
+	pos, err := NewFileSaver(restored, snapshot, opts, log.NewDummyLogger()).Load()
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), pos)
+
+	applied, err := restored.Replay(pos)
+	require.NoError(t, err)
+	require.Equal(t, 2, applied)
+	require.Equal(t, int64(10), getCounterValue(t, restored, "c"))
+}
+
+func Test_WALLogsReplaceAndLoad(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "metrics.wal")
+	snapshot := filepath.Join(dir, "metrics.json")
+	opts := SnapshotOptions{Keep: 2}
+
+	repo := newTestWALRepository(t, path)
+	saver := NewFileSaver(repo, snapshot, opts, log.NewDummyLogger())
+	_, err := repo.AddOrUpdate("old", "1", metrics.CounterMetricType)
+	require.NoError(t, err)
+	require.NoError(t, saver.Save())
+
+	// Crash after replacement and import, before the next snapshot.
+	require.NoError(t, repo.ReplaceMetrics([]metrics.Metric{metrics.NewCounterMetric("c", 5)}))
+	require.NoError(t, repo.Load(strings.NewReader(`[{"id":"g","type":"gauge","value":2.5}]`)))
+	_, err = repo.AddOrUpdate("c", "3", metrics.CounterMetricType)
+	require.NoError(t, err)
+
+	err = repo.Load(strings.NewReader(`[{"id":"bad","type":"counter"}]`))
+	require.ErrorAs(t, err, &errtypes.BadDataError{})
+	require.NoError(t, repo.Close())
+
+	restored := newTestWALRepository(t, path)
+	defer func() {
+		require.NoError(t, restored.Close())
+	}()
+
+	pos, err := NewFileSaver(restored, snapshot, opts, log.NewDummyLogger()).Load()
+	require.NoError(t, err)
+	_, err = restored.Replay(pos)
+	require.NoError(t, err)
+
+	all, err := restored.GetAll()
+	require.NoError(t, err)
+	require.Len(t, all, 2)
+	require.Equal(t, int64(8), getCounterValue(t, restored, "c"))
+	m, err := restored.Get("g", metrics.GaugeMetricType)
+	require.NoError(t, err)
+	require.Equal(t, 2.5, *m.Value)
+}